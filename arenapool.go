@@ -0,0 +1,57 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// maxPooledBlocks is the number of free arena blocks of each size kept for reuse, which
+// bounds the memory the pool holds to a few megabytes.
+const maxPooledBlocks = 16
+
+// arenaPool keeps the arena blocks released by freeAllocations for the next cAlloc, so
+// that a steady stream of writes reuses the same few blocks instead of paying a malloc
+// and a free for each block of each write.
+var arenaPool struct {
+	mu     sync.Mutex
+	blocks map[uintptr][]unsafe.Pointer
+	// mallocs counts the blocks that had to be allocated because none was free.
+	mallocs atomic.Int64
+}
+
+// getArenaBlock returns an arena block of size bytes, reusing a free one if there is
+// one. It returns nil if malloc fails.
+func getArenaBlock(size uintptr) unsafe.Pointer {
+	arenaPool.mu.Lock()
+	if free := arenaPool.blocks[size]; len(free) > 0 {
+		ptr := free[len(free)-1]
+		arenaPool.blocks[size] = free[:len(free)-1]
+		arenaPool.mu.Unlock()
+		return ptr
+	}
+	arenaPool.mu.Unlock()
+	arenaPool.mallocs.Add(1)
+	return C.malloc(C.size_t(size))
+}
+
+// putArenaBlock releases an arena block of size bytes obtained from getArenaBlock,
+// keeping it for reuse unless enough blocks of its size are free already.
+func putArenaBlock(ptr unsafe.Pointer, size uintptr) {
+	arenaPool.mu.Lock()
+	defer arenaPool.mu.Unlock()
+	if len(arenaPool.blocks[size]) >= maxPooledBlocks {
+		C.free(ptr)
+		return
+	}
+	if arenaPool.blocks == nil {
+		arenaPool.blocks = make(map[uintptr][]unsafe.Pointer)
+	}
+	arenaPool.blocks[size] = append(arenaPool.blocks[size], ptr)
+}
@@ -0,0 +1,27 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+)
+
+func TestArenaPoolReusesBlocks(t *testing.T) {
+	value := nestedValue(4, 4)
+	build := func() {
+		var allocations []cAllocation
+		if _, err := buildYInputRecursive(value, &allocations); err != nil {
+			t.Fatalf("buildYInputRecursive failed: %v", err)
+		}
+		freeAllocations(allocations)
+	}
+
+	build()
+	before := arenaPool.mallocs.Load()
+	for i := 0; i < 10; i++ {
+		build()
+	}
+	if mallocs := arenaPool.mallocs.Load() - before; mallocs != 0 {
+		t.Errorf("expected the blocks of the first build to be reused, got %d new mallocs", mallocs)
+	}
+}
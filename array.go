@@ -0,0 +1,357 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+#include <string.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// resolveArray navigates to the YArray at path. The returned outputs back the branch
+// pointer and must be destroyed by the caller once the branch is no longer used.
+func resolveArray(txn *C.YTransaction, rootBranch *C.Branch, path string) (*C.Branch, []*C.YOutput, error) {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	branch, outputs, err := resolveBranch(txn, rootBranch, pathSegments)
+	if err != nil {
+		return nil, nil, err
+	}
+	if kind := C.ytype_kind(branch); kind != C.Y_ARRAY {
+		destroyOutputs(outputs)
+		return nil, nil, fmt.Errorf("value at '%s' is not an array (kind %d)", path, kind)
+	}
+	return branch, outputs, nil
+}
+
+// arrayElement reads the element at index, converted as by ToJSON.
+func arrayElement(txn *C.YTransaction, array *C.Branch, index C.uint32_t) (interface{}, error) {
+	output := C.yarray_get(array, txn, index)
+	if output == nil {
+		return nil, fmt.Errorf("failed to get array element %d", index)
+	}
+	defer C.youtput_destroy(output)
+
+	value, err := outputValue(txn, output, ReadOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("array element %d: %w", index, err)
+	}
+	return value, nil
+}
+
+// ArrayRemoveWhere removes every element of the array at path for which match returns
+// true, returning the number of elements removed. Elements are matched by value rather
+// than position, so the removal is unaffected by concurrent inserts that shift indices.
+// match is called once per element in ascending index order, before anything is removed,
+// with the element converted as by ToJSON. The Doc is locked for writing while match
+// runs, so match must not call the Doc's methods, which would deadlock.
+func (d *Doc) ArrayRemoveWhere(path string, match func(elem interface{}) bool) (int, error) {
+	removed := 0
+	err := d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		array, outputs, err := resolveArray(txn, rootBranch, path)
+		if err != nil {
+			return fmt.Errorf("ArrayRemoveWhere %s: %w", path, err)
+		}
+		defer destroyOutputs(outputs)
+
+		var matches []C.uint32_t
+		arrayLen := C.yarray_len(array)
+		for i := C.uint32_t(0); i < arrayLen; i++ {
+			elem, err := arrayElement(txn, array, i)
+			if err != nil {
+				return fmt.Errorf("ArrayRemoveWhere %s: %w", path, err)
+			}
+			if match(elem) {
+				matches = append(matches, i)
+			}
+		}
+
+		// Remove from the back so earlier indices stay valid.
+		for i := len(matches) - 1; i >= 0; i-- {
+			tracef("yarray_remove_range(%p, %d)", array, matches[i])
+			C.yarray_remove_range(array, txn, matches[i], 1)
+		}
+		removed = len(matches)
+		return nil
+	})
+	return removed, err
+}
+
+// buildYInputs converts values into a C array of YInputs suitable for
+// yarray_insert_range. Like buildYInputRecursive, every C allocation (including the
+// returned array) is recorded in allocations and must be freed by the caller.
+func buildYInputs(values []interface{}, allocations *[]cAllocation) (*C.YInput, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	goInputs := make([]C.YInput, len(values))
+	for i, value := range values {
+		input, err := buildYInputRecursive(value, allocations)
+		if err != nil {
+			return nil, fmt.Errorf("failed processing element %d: %w", i, err)
+		}
+		goInputs[i] = input
+	}
+
+	size := C.size_t(len(values)) * C.size_t(C.sizeof_YInput)
+	cArrayPtr := cAlloc(allocations, uintptr(size))
+	if cArrayPtr == nil {
+		return nil, errors.New("failed to allocate C array for YInputs")
+	}
+	C.memcpy(cArrayPtr, unsafe.Pointer(&goInputs[0]), size)
+
+	return (*C.YInput)(cArrayPtr), nil
+}
+
+// SetArray replaces the contents of the array at path with values in a single
+// transaction, removing the existing range and inserting the new elements. Unlike
+// UpdateToState, no element-level diff is computed: every element gets a new CRDT
+// identity, so concurrent edits to the old elements are discarded.
+func (d *Doc) SetArray(path string, values []interface{}) error {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return fmt.Errorf("SetArray %s: %w", path, err)
+	}
+	values, _ = d.encodeValues(values).([]interface{})
+	if err := d.checkInputLimits(path, values); err != nil {
+		return fmt.Errorf("SetArray %s: %w", path, err)
+	}
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		array, outputs, err := resolveArray(txn, rootBranch, path)
+		if err != nil {
+			return fmt.Errorf("SetArray %s: %w", path, err)
+		}
+		defer destroyOutputs(outputs)
+
+		// The array is stored whole, so only the new values count against its limit.
+		_, limited, err := d.limitWrite(txn, rootBranch, pathSegments, values, false)
+		if err != nil {
+			return fmt.Errorf("SetArray %s: %w", path, err)
+		}
+		values, _ := limited.([]interface{})
+
+		var allocations []cAllocation
+		defer func() { freeAllocations(allocations) }()
+
+		inputs, err := buildYInputs(values, &allocations)
+		if err != nil {
+			return fmt.Errorf("SetArray %s: %w", path, err)
+		}
+
+		if arrayLen := C.yarray_len(array); arrayLen > 0 {
+			tracef("yarray_remove_range(%p, 0, %d)", array, arrayLen)
+			C.yarray_remove_range(array, txn, 0, arrayLen)
+		}
+		if len(values) > 0 {
+			tracef("yarray_insert_range(%p, 0, %d)", array, len(values))
+			C.yarray_insert_range(array, txn, 0, inputs, C.uint32_t(len(values)))
+		}
+		return nil
+	})
+}
+
+// ArrayRange calls fn for each element of the array at path in index order, like
+// ArrayIterate, and stops early when fn returns false. fn runs inside a read transaction
+// and must not modify the document.
+func (d *Doc) ArrayRange(path string, fn func(index int, value interface{}) bool) error {
+	err := d.iterateArray(path, func(index int, value interface{}) error {
+		if !fn(index, value) {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopIteration) {
+		return fmt.Errorf("ArrayRange %s: %w", path, err)
+	}
+	return nil
+}
+
+// ArrayIterate calls fn for each element of the array at path in index order, walking
+// the array with a Yrs iterator so that only one element is held in memory at a time,
+// however long the array. Elements are read as by ToJSON, so integers are int64.
+// Iteration stops at the first error fn returns, which ArrayIterate returns wrapped. fn
+// runs inside a read transaction and must not modify the document.
+func (d *Doc) ArrayIterate(path string, fn func(index int, value interface{}) error) error {
+	if err := d.iterateArray(path, fn); err != nil {
+		return fmt.Errorf("ArrayIterate %s: %w", path, err)
+	}
+	return nil
+}
+
+// errStopIteration is returned by ArrayRange's callback to end iterateArray early.
+var errStopIteration = errors.New("stop iteration")
+
+// iterateArray implements ArrayIterate and ArrayRange, returning the first error fn
+// returns as is.
+func (d *Doc) iterateArray(path string, fn func(index int, value interface{}) error) error {
+	return d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		array, outputs, err := resolveArray(txn, rootBranch, path)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+
+		iter := C.yarray_iter(array, txn)
+		defer C.yarray_iter_destroy(iter)
+		for i := 0; ; i++ {
+			output := C.yarray_iter_next(iter)
+			if output == nil {
+				return nil
+			}
+			value, err := outputValue(txn, output, ReadOptions{})
+			C.youtput_destroy(output)
+			if err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+			if err := fn(i, value); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// ArrayLen returns the number of elements in the array at path without reading any of
+// them.
+func (d *Doc) ArrayLen(path string) (int, error) {
+	length := 0
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		array, outputs, err := resolveArray(txn, rootBranch, path)
+		if err != nil {
+			return fmt.Errorf("ArrayLen %s: %w", path, err)
+		}
+		defer destroyOutputs(outputs)
+
+		length = int(C.yarray_len(array))
+		return nil
+	})
+	return length, err
+}
+
+// ArrayTrim removes elements from the front of the array at path so that at most max
+// remain, in a single transaction. Arrays already within the limit are left untouched.
+// It is meant for capped lists where only the most recent entries are kept.
+func (d *Doc) ArrayTrim(path string, max int) error {
+	if max < 0 {
+		return fmt.Errorf("ArrayTrim %s: max must be non-negative, got %d", path, max)
+	}
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		array, outputs, err := resolveArray(txn, rootBranch, path)
+		if err != nil {
+			return fmt.Errorf("ArrayTrim %s: %w", path, err)
+		}
+		defer destroyOutputs(outputs)
+
+		arrayLen := int(C.yarray_len(array))
+		if arrayLen <= max {
+			return nil
+		}
+		excess := C.uint32_t(arrayLen - max)
+		tracef("yarray_remove_range(%p, 0, %d)", array, excess)
+		C.yarray_remove_range(array, txn, 0, excess)
+		return nil
+	})
+}
+
+// ArrayAppend appends values to the end of the array at path in a single transaction.
+func (d *Doc) ArrayAppend(path string, values ...interface{}) error {
+	if err := d.arrayInsert(path, -1, values); err != nil {
+		return fmt.Errorf("ArrayAppend %s: %w", path, err)
+	}
+	return nil
+}
+
+// ArrayInsert inserts values into the array at path in a single transaction, the first
+// at index, which may be anything from 0 to the array's length. The elements from index
+// on move up to make room.
+func (d *Doc) ArrayInsert(path string, index int, values ...interface{}) error {
+	if index < 0 {
+		return fmt.Errorf("ArrayInsert %s: index must be non-negative, got %d", path, index)
+	}
+	if err := d.arrayInsert(path, index, values); err != nil {
+		return fmt.Errorf("ArrayInsert %s: %w", path, err)
+	}
+	return nil
+}
+
+// arrayInsert implements ArrayAppend, for a negative index, and ArrayInsert.
+func (d *Doc) arrayInsert(path string, index int, values []interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return err
+	}
+	values, _ = d.encodeValues(values).([]interface{})
+	if err := d.checkInputLimits(path, values); err != nil {
+		return err
+	}
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		array, outputs, err := resolveArray(txn, rootBranch, path)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+
+		arrayLen := C.yarray_len(array)
+		at := arrayLen
+		if index >= 0 {
+			if index > int(arrayLen) {
+				return indexOutOfBounds(C.uint32_t(min(index, math.MaxUint32)), arrayLen)
+			}
+			at = C.uint32_t(index)
+		}
+		at, values, err := d.limitInsert(txn, array, pathSegments, at, values)
+		if err != nil {
+			return err
+		}
+
+		var allocations []cAllocation
+		defer func() { freeAllocations(allocations) }()
+
+		inputs, err := buildYInputs(values, &allocations)
+		if err != nil {
+			return err
+		}
+		tracef("yarray_insert_range(%p, %d, %d)", array, at, len(values))
+		C.yarray_insert_range(array, txn, at, inputs, C.uint32_t(len(values)))
+		return nil
+	})
+}
+
+// ArrayRemove removes count elements from the array at path, starting at index, in a
+// single transaction. The range must lie within the array.
+func (d *Doc) ArrayRemove(path string, index, count int) error {
+	if index < 0 || count < 0 {
+		return fmt.Errorf("ArrayRemove %s: index and count must be non-negative, got %d and %d", path, index, count)
+	}
+	if count == 0 {
+		return nil
+	}
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		array, outputs, err := resolveArray(txn, rootBranch, path)
+		if err != nil {
+			return fmt.Errorf("ArrayRemove %s: %w", path, err)
+		}
+		defer destroyOutputs(outputs)
+
+		// Compared without adding index and count, which could overflow.
+		arrayLen := int(C.yarray_len(array))
+		if index > arrayLen || count > arrayLen-index {
+			return fmt.Errorf("ArrayRemove %s: %d elements at index %d out of bounds (len %d)", path, count, index, arrayLen)
+		}
+		tracef("yarray_remove_range(%p, %d, %d)", array, index, count)
+		C.yarray_remove_range(array, txn, C.uint32_t(index), C.uint32_t(count))
+		return nil
+	})
+}
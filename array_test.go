@@ -0,0 +1,374 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestArrayRemoveWhere(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	_, err := doc.UpdateToState(map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"id": "a"},
+			map[string]interface{}{"id": "b"},
+			map[string]interface{}{"id": "c"},
+			map[string]interface{}{"id": "b"},
+		},
+		"name": "list",
+	})
+	if err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	removed, err := doc.ArrayRemoveWhere("/items", func(elem interface{}) bool {
+		m, ok := elem.(map[string]interface{})
+		return ok && m["id"] == "b"
+	})
+	if err != nil {
+		t.Fatalf("ArrayRemoveWhere failed: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 elements removed, got %d", removed)
+	}
+
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	items := state["items"].([]interface{})
+	if len(items) != 2 || items[0].(map[string]interface{})["id"] != "a" || items[1].(map[string]interface{})["id"] != "c" {
+		t.Errorf("unexpected items after removal: %v", items)
+	}
+
+	if _, err := doc.ArrayRemoveWhere("/name", func(interface{}) bool { return true }); err == nil {
+		t.Error("expected an error removing from a non-array path")
+	}
+}
+
+func TestArrayRemoveWhereTypedValues(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	// Matchers see elements as ToJSON returns them, not as decoded JSON.
+	big := int64(9007199254740993)
+	if err := doc.Set("/list", []interface{}{big, big - 1, []byte{1, 2, 3}, "x"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	removed, err := doc.ArrayRemoveWhere("/list", func(elem interface{}) bool {
+		if b, ok := elem.([]byte); ok {
+			return bytes.Equal(b, []byte{1, 2, 3})
+		}
+		return elem == big
+	})
+	if err != nil {
+		t.Fatalf("ArrayRemoveWhere failed: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 elements removed, got %d", removed)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := []interface{}{big - 1, "x"}; !reflect.DeepEqual(state["list"], expected) {
+		t.Errorf("expected %v, got %v", expected, state["list"])
+	}
+}
+
+func TestSetArray(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{"list": []interface{}{1, 2, 3}}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	if err := doc.SetArray("/list", []interface{}{"x", map[string]interface{}{"y": true}}); err != nil {
+		t.Fatalf("SetArray failed: %v", err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"list": []interface{}{"x", map[string]interface{}{"y": true}}}
+	if !compareMaps(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+
+	if err := doc.SetArray("/list", nil); err != nil {
+		t.Fatalf("SetArray with no values failed: %v", err)
+	}
+	state, _ = doc.ToJSON()
+	if items := state["list"].([]interface{}); len(items) != 0 {
+		t.Errorf("expected empty list, got %v", items)
+	}
+}
+
+func TestArrayRange(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{"list": []interface{}{"a", "b", "c", "d"}}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	var seen []interface{}
+	err := doc.ArrayRange("/list", func(index int, value interface{}) bool {
+		if index != len(seen) {
+			t.Errorf("expected index %d, got %d", len(seen), index)
+		}
+		seen = append(seen, value)
+		return index < 2
+	})
+	if err != nil {
+		t.Fatalf("ArrayRange failed: %v", err)
+	}
+	if len(seen) != 3 || seen[0] != "a" || seen[2] != "c" {
+		t.Errorf("expected iteration to stop after 3 elements, got %v", seen)
+	}
+
+	// Elements are read as ArrayIterate and ToJSON read them.
+	if err := doc.Set("/nums", []interface{}{int64(9007199254740993)}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	var got interface{}
+	if err := doc.ArrayRange("/nums", func(_ int, value interface{}) bool { got = value; return true }); err != nil {
+		t.Fatalf("ArrayRange failed: %v", err)
+	}
+	if got != int64(9007199254740993) {
+		t.Errorf("expected int64 9007199254740993, got %v (%T)", got, got)
+	}
+	if err := doc.ArrayRange("/missing", func(int, interface{}) bool { return true }); err == nil {
+		t.Error("expected an error ranging over a missing path")
+	}
+}
+
+func TestArrayIterate(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	list := make([]interface{}, 1000)
+	for i := range list {
+		list[i] = map[string]interface{}{"n": int64(i)}
+	}
+	if err := doc.Set("/list", list); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	count := 0
+	err := doc.ArrayIterate("/list", func(index int, value interface{}) error {
+		if expected := map[string]interface{}{"n": int64(index)}; !reflect.DeepEqual(value, expected) {
+			t.Errorf("index %d: expected %v, got %v", index, expected, value)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ArrayIterate failed: %v", err)
+	}
+	if count != len(list) {
+		t.Errorf("expected %d elements, got %d", len(list), count)
+	}
+
+	stop := errors.New("stop")
+	count = 0
+	err = doc.ArrayIterate("/list", func(index int, value interface{}) error {
+		count++
+		if index == 9 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 10 {
+		t.Errorf("expected iteration to stop with fn's error after 10 elements, got %d, %v", count, err)
+	}
+
+	if err := doc.Set("/scalar", "x"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	for _, path := range []string{"/scalar", "/missing"} {
+		if err := doc.ArrayIterate(path, func(int, interface{}) error { return nil }); err == nil {
+			t.Errorf("ArrayIterate(%q): expected an error", path)
+		}
+	}
+}
+
+func TestArrayLen(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{
+		"list":   []interface{}{1, 2, 3},
+		"nested": map[string]interface{}{"empty": []interface{}{}},
+		"name":   "x",
+	}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	if n, err := doc.ArrayLen("/list"); err != nil || n != 3 {
+		t.Errorf("expected length 3, got %d (err %v)", n, err)
+	}
+	if n, err := doc.ArrayLen("/nested/empty"); err != nil || n != 0 {
+		t.Errorf("expected length 0, got %d (err %v)", n, err)
+	}
+	if _, err := doc.ArrayLen("/name"); err == nil {
+		t.Error("expected an error for a non-array path")
+	}
+	if _, err := doc.ArrayLen("/missing"); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
+
+func TestArrayTrim(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"feed": []interface{}{1, 2, 3, 4, 5}})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	if err := doc.ArrayTrim("/feed", 3); err != nil {
+		t.Fatalf("ArrayTrim failed: %v", err)
+	}
+	state, _ := doc.ToJSON()
+	if !compareMaps(state, map[string]interface{}{"feed": []interface{}{3, 4, 5}}) {
+		t.Errorf("unexpected state after trim: %v", state)
+	}
+
+	if err := doc.ArrayTrim("/feed", 10); err != nil {
+		t.Fatalf("ArrayTrim within the limit failed: %v", err)
+	}
+	if n, _ := doc.ArrayLen("/feed"); n != 3 {
+		t.Errorf("expected 3 elements to remain, got %d", n)
+	}
+
+	if err := doc.ArrayTrim("/feed", 0); err != nil {
+		t.Fatalf("ArrayTrim to zero failed: %v", err)
+	}
+	if n, _ := doc.ArrayLen("/feed"); n != 0 {
+		t.Errorf("expected an empty array, got %d elements", n)
+	}
+	if err := doc.ArrayTrim("/feed", -1); err == nil {
+		t.Error("expected an error for a negative max")
+	}
+}
+
+func TestArrayDoc(t *testing.T) {
+	doc := NewArrayDoc()
+	defer doc.Destroy()
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/-", Value: map[string]interface{}{"type": "created"}},
+		{Operation: "add", Path: "/-", Value: map[string]interface{}{"type": "edited"}},
+		{Operation: "replace", Path: "/1/type", Value: "renamed"},
+		{Operation: "add", Path: "/0", Value: "start"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err != nil {
+		t.Fatalf("ApplyOperations failed: %v", err)
+	}
+	expected := []interface{}{
+		"start",
+		map[string]interface{}{"type": "created"},
+		map[string]interface{}{"type": "renamed"},
+	}
+	got, err := doc.ToJSONArray()
+	if err != nil {
+		t.Fatalf("ToJSONArray failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if _, err := doc.ToJSON(); err == nil {
+		t.Error("expected ToJSON to fail on an array document")
+	}
+
+	update, err := doc.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	peer := NewArrayDoc()
+	defer peer.Destroy()
+	if err := peer.ApplyStateVector(update); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	if got, err := peer.ToJSONArray(); err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected peer to hold %v, got %v (err %v)", expected, got, err)
+	}
+
+	replaceRoot, _ := NewPatchList([]jsonpatch.JSONPatch{{Operation: "replace", Path: "", Value: []interface{}{1.0}}})
+	if err := peer.ApplyOperations(replaceRoot); err != nil {
+		t.Fatalf("replacing the root array failed: %v", err)
+	}
+	if got, err := peer.ToJSONArray(); err != nil || !reflect.DeepEqual(got, []interface{}{1.0}) {
+		t.Errorf("expected [1] after replacing the root, got %v (err %v)", got, err)
+	}
+
+	mapDoc := NewDoc()
+	defer mapDoc.Destroy()
+	if _, err := mapDoc.ToJSONArray(); err == nil {
+		t.Error("expected ToJSONArray to fail on a map document")
+	}
+}
+
+func TestArrayAppendInsertRemove(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"items": []interface{}{"b"},
+		"name":  "x",
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	if err := doc.ArrayAppend("/items", "c", "d", map[string]interface{}{"e": 1.0}); err != nil {
+		t.Fatalf("ArrayAppend failed: %v", err)
+	}
+	if err := doc.ArrayInsert("/items", 0, "a"); err != nil {
+		t.Fatalf("ArrayInsert failed: %v", err)
+	}
+	if err := doc.ArrayInsert("/items", 2, "b2", "b3"); err != nil {
+		t.Fatalf("ArrayInsert failed: %v", err)
+	}
+	if err := doc.ArrayRemove("/items", 3, 2); err != nil {
+		t.Fatalf("ArrayRemove failed: %v", err)
+	}
+
+	got, err := doc.GetValueAtPath("/items")
+	if err != nil {
+		t.Fatalf("GetValueAtPath failed: %v", err)
+	}
+	expected := []interface{}{"a", "b", "b2", "d", map[string]interface{}{"e": 1.0}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	for name, err := range map[string]error{
+		"append to a string":             doc.ArrayAppend("/name", "y"),
+		"append to a missing":            doc.ArrayAppend("/missing", "y"),
+		"insert past the end":            doc.ArrayInsert("/items", 6, "y"),
+		"negative index":                 doc.ArrayInsert("/items", -1, "y"),
+		"remove past the end":            doc.ArrayRemove("/items", 4, 2),
+		"remove an overflowing count":    doc.ArrayRemove("/items", 1, math.MaxInt),
+		"remove at an overflowing index": doc.ArrayRemove("/items", math.MaxInt, 1),
+	} {
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	// Out-of-range inserts report the same error as other array index failures.
+	err = doc.ArrayInsert("/items", 6, "y")
+	if want := "ArrayInsert /items: array index 6 out of bounds (len 5)"; err == nil || err.Error() != want {
+		t.Errorf("expected %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,54 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// dryRun runs fn against a scratch copy of the document, as of the open transaction txn,
+// and returns its error. Yrs cannot abort a write transaction, so a multi-step write
+// that must apply all-or-nothing is tried here first and only repeated on the document
+// once it is known to succeed. The scratch copy is discarded afterwards. The caller must
+// hold d.mu.
+func (d *Doc) dryRun(txn *C.YTransaction, fn func(txn *C.YTransaction, rootBranch *C.Branch) error) error {
+	state, err := encodeStateDiffTxn(txn, nil)
+	if err != nil {
+		return err
+	}
+
+	scratch := C.ydoc_new()
+	if scratch == nil {
+		return errors.New("failed to create scratch document")
+	}
+	defer C.ydoc_destroy(scratch)
+
+	rootKey := C.CString(d.root())
+	defer C.free(unsafe.Pointer(rootKey))
+	if d.arrayRoot {
+		C.yarray(scratch, rootKey)
+	} else {
+		C.ymap(scratch, rootKey)
+	}
+	if err := applyUpdate(scratch, state, false); err != nil {
+		return err
+	}
+
+	scratchTxn := C.ydoc_write_transaction(scratch, 0, nil)
+	if scratchTxn == nil {
+		return fmt.Errorf("failed to create write transaction: %w", ErrTransactionInProgress)
+	}
+	defer commitTransaction(scratchTxn)
+	rootBranch := C.ytype_get(scratchTxn, rootKey)
+	if rootBranch == nil {
+		return errors.New("root map not found in scratch document")
+	}
+	return fn(scratchTxn, rootBranch)
+}
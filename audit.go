@@ -0,0 +1,27 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"github.com/snorwin/jsonpatch"
+)
+
+// AuditSink receives every patch list successfully applied through ApplyOperations or
+// UpdateToState, together with the origin of the transaction it was applied in. Local
+// transactions are currently untagged, so origin is empty.
+type AuditSink func(patch jsonpatch.JSONPatchList, origin string)
+
+// SetAuditSink registers sink to be called after each successful ApplyOperations or
+// UpdateToState, replacing any previous sink; nil disables auditing. The sink runs
+// synchronously once the transaction has been committed, so it only sees changes that
+// were actually applied. Empty patch lists are not reported.
+func (d *Doc) SetAuditSink(sink AuditSink) {
+	d.auditSink = sink
+}
+
+func (d *Doc) audit(patch jsonpatch.JSONPatchList, origin string) {
+	if d.auditSink == nil || patch.Empty() {
+		return
+	}
+	d.auditSink(patch, origin)
+}
@@ -0,0 +1,57 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestAuditSink(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	var recorded []jsonpatch.JSONPatchList
+	doc.SetAuditSink(func(patch jsonpatch.JSONPatchList, origin string) {
+		if origin != "" {
+			t.Errorf("expected an empty origin for local changes, got %q", origin)
+		}
+		recorded = append(recorded, patch)
+	})
+
+	applied, err := doc.UpdateToState(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if len(recorded) != 1 || recorded[0].String() != applied.String() {
+		t.Fatalf("expected the applied patch to be audited once, got %v", recorded)
+	}
+
+	// No changes, nothing to record.
+	if _, err := doc.UpdateToState(map[string]interface{}{"a": 1.0}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if len(recorded) != 1 {
+		t.Errorf("expected a no-op update not to be audited, got %d entries", len(recorded))
+	}
+
+	failing, err := jsonpatch.CreateJSONPatch(map[string]interface{}{}, map[string]interface{}{"missing": 1.0})
+	if err != nil {
+		t.Fatalf("CreateJSONPatch failed: %v", err)
+	}
+	if err := doc.ApplyOperations(failing); err == nil {
+		t.Fatal("expected removing a missing key to fail")
+	}
+	if len(recorded) != 1 {
+		t.Errorf("expected a failed ApplyOperations not to be audited, got %d entries", len(recorded))
+	}
+
+	doc.SetAuditSink(nil)
+	if _, err := doc.UpdateToState(map[string]interface{}{"a": 2.0}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if len(recorded) != 1 {
+		t.Errorf("expected no entries after clearing the sink, got %d", len(recorded))
+	}
+}
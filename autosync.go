@@ -21,20 +21,86 @@ package autosync
 */
 import "C"
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/snorwin/jsonpatch"
 )
 
+// Doc is a JSON document backed by a Yrs document. A Doc is safe for concurrent use by
+// multiple goroutines: reads such as ToJSON and the encoders share the document, while
+// writes such as ApplyOperations and ApplyUpdate take it exclusively.
 type Doc struct {
 	yDoc *C.YDoc
+	// mu lets any number of read transactions run at once while write transactions are
+	// exclusive. Yrs itself refuses to open a write transaction while a read is active
+	// (and vice versa), returning nil instead of waiting.
+	mu        sync.RWMutex
+	auditSink AuditSink
+	// watermark and emptiness are guarded by mu, but checked once it is released.
+	watermark sizeWatermark
+	emptiness emptyWatch
+	undoStack []jsonpatch.JSONPatchList
+	updates   *updateLog
+	// captureSub observes updates for ApplyOperationsCapture, which points capture at
+	// its result for the duration of its transaction.
+	captureSub *C.YSubscription
+	capture    *[]byte
+	resolvers  map[string]Resolver
+	// textFields holds the paths registered with RegisterTextField.
+	textFields map[string]bool
+	// defaults holds the keys set with SetDefaults.
+	defaults map[string]interface{}
+	// arrayLimits holds the limits set with SetArrayLimit, by JSON Pointer.
+	arrayLimits map[string]ArrayLimit
+	// inputLimits holds the limits set with SetInputLimits. It is read outside of mu, as
+	// values are checked before a write transaction is opened as well as inside one.
+	inputLimits atomic.Pointer[InputLimits]
+	frozen      atomic.Bool
+	dirty       dirtyTracker
+	// arrayRoot is set for documents created with NewArrayDoc, whose root is a YArray.
+	arrayRoot bool
+	// bare is set for documents created with NewBareDoc until an applied update has
+	// supplied their root.
+	bare bool
+	// rootName is the name of the root type for documents loaded with LoadYDoc; empty
+	// means "root".
+	rootName string
+	// collections holds the root collections defined with DefineMap and DefineArray.
+	collections map[string]Kind
+	// xmlFragments holds the names of the XML fragments defined with DefineXMLFragment.
+	xmlFragments map[string]struct{}
+	// bindMu guards bindings and serializes their refreshes; see Bind.
+	bindMu   sync.Mutex
+	bindings []*binding
+	// watchers holds the callbacks registered with WatchKey.
+	watchers keyWatchers
+	// batchers holds the callbacks registered with OnUpdateDebounced.
+	batchers updateBatchers
+	// ephemeral holds the presence data set with SetEphemeral and received with
+	// ApplyEphemeral, outside of the Yrs document.
+	ephemeral ephemeralState
+	// refs counts references taken with Acquire on top of the one held by the creator.
+	refs atomic.Int32
+	// timeEncoding holds the TimeEncoding set with SetTimeEncoding.
+	timeEncoding atomic.Int32
+	// largeUints holds the LargeUintEncoding set with SetLargeUintEncoding.
+	largeUints atomic.Int32
+	// wholeFloats is DocOptions.CoerceWholeFloatsToLong.
+	wholeFloats bool
 }
 
 func NewDoc() *Doc {
@@ -45,70 +111,627 @@ func NewDoc() *Doc {
 	defer C.free(unsafe.Pointer(rootKey))
 
 	C.ymap(d.yDoc, rootKey) // create root map
+	d.trackDirty()
+	d.guardLeak()
 	return d
 }
 
+// NewArrayDoc creates a Doc whose root, still named "root", is a YArray rather than a map,
+// for documents that are naturally a list such as an append-only event log. Read it with
+// ToJSONArray; ToJSON and the other methods that expect a map at the root return an
+// error. JSON Pointers start with an index into the root array ("/0/name", "/-"), and
+// the empty pointer addresses the whole array. Updates can only be exchanged with other
+// array documents, so load a stored one into a NewArrayDoc with ApplyUpdate rather
+// than with NewDocFromStateVector.
+func NewArrayDoc() *Doc {
+	d := &Doc{
+		yDoc:      C.ydoc_new(),
+		arrayRoot: true,
+	}
+	rootKey := C.CString("root")
+	defer C.free(unsafe.Pointer(rootKey))
+
+	C.yarray(d.yDoc, rootKey) // create root array
+	d.trackDirty()
+	d.guardLeak()
+	return d
+}
+
+// NewBareDoc creates a Doc without the "root" map that NewDoc adds, for loading a foreign
+// document with ApplyUpdate whose structure an extra empty "root" type would
+// pollute. Until an update defining "root" has been applied, only the encoding methods
+// (EncodeStateAsUpdate, EncodeFull, EncodeDiffs and the like) work; reading or writing the
+// content fails as there is no root to operate on. A "root" received from an update is
+// treated as a map.
+func NewBareDoc() *Doc {
+	d := &Doc{
+		yDoc: C.ydoc_new(),
+		bare: true,
+	}
+	d.trackDirty()
+	d.guardLeak()
+	return d
+}
+
+// adoptBareRoot types the root of a NewBareDoc as a map (an array for array documents
+// from LoadYDoc) once an update has supplied one. A root decoded from an update has no
+// type of its own until it is first accessed. The caller must hold d.mu for writing, with
+// no transaction open.
+func (d *Doc) adoptBareRoot() {
+	if !d.bare {
+		return
+	}
+	rootKey := C.CString(d.root())
+	defer C.free(unsafe.Pointer(rootKey))
+
+	txn := C.ydoc_read_transaction(d.yDoc)
+	if txn == nil {
+		return
+	}
+	found := C.ytype_get(txn, rootKey) != nil
+	commitTransaction(txn)
+
+	if found {
+		if d.arrayRoot {
+			C.yarray(d.yDoc, rootKey)
+		} else {
+			C.ymap(d.yDoc, rootKey)
+		}
+		d.bare = false
+	}
+}
+
+// root returns the name of the document's root type.
+func (d *Doc) root() string {
+	if d.rootName == "" {
+		return "root"
+	}
+	return d.rootName
+}
+
+// LoadYDoc loads a document saved by a Yjs or Yrs client, e.g. a snapshot exported from
+// a browser with Y.encodeStateAsUpdate. Unlike ApplyUpdate on a NewDoc, the root
+// type does not have to be a map named "root": the name is read from update, preferring
+// "root" if present, and the root may be a map or an array (read the latter with
+// ToJSONArray). Documents with several other root types, or whose root is a text, are
+// rejected as there is no single value to expose.
+func LoadYDoc(update []byte) (*Doc, error) {
+	decoded, err := decodeUpdateV1(update)
+	if err != nil {
+		return nil, fmt.Errorf("LoadYDoc: %w", err)
+	}
+	name, isArray, err := detectRoot(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("LoadYDoc: %w", err)
+	}
+
+	d := NewBareDoc()
+	d.rootName = name
+	d.arrayRoot = isArray
+	if err := d.ApplyUpdate(update); err != nil {
+		d.Destroy()
+		return nil, fmt.Errorf("LoadYDoc: %w", err)
+	}
+	return d, nil
+}
+
+// detectRoot picks the root type of a decoded update for LoadYDoc and reports whether it
+// is an array rather than a map.
+func detectRoot(decoded *decodedUpdate) (string, bool, error) {
+	type rootInfo struct{ isMap, isText bool }
+	roots := make(map[string]*rootInfo)
+	for _, blocks := range decoded.blocks {
+		for _, block := range blocks {
+			if block.parentName == "" {
+				continue
+			}
+			info := roots[block.parentName]
+			if info == nil {
+				info = &rootInfo{}
+				roots[block.parentName] = info
+			}
+			if block.parentSub != "" {
+				info.isMap = true
+			}
+			switch block.content {
+			case 4, 5, 6: // string, embed, format
+				info.isText = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(roots))
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var name string
+	switch {
+	case roots["root"] != nil:
+		name = "root"
+	case len(names) == 1:
+		name = names[0]
+	case len(names) == 0:
+		return "", false, errors.New("update contains no root type")
+	default:
+		return "", false, fmt.Errorf("update contains several root types (%s)", strings.Join(names, ", "))
+	}
+	info := roots[name]
+	if info.isText && !info.isMap {
+		return "", false, fmt.Errorf("root %q is a text, not a map or array", name)
+	}
+	return name, !info.isMap, nil
+}
+
 func NewDocFromStateVector(stateVector []byte) (*Doc, error) {
 	doc := NewDoc()
 
-	err := doc.ApplyStateVector(stateVector)
+	err := doc.ApplyUpdate(stateVector)
 	if err != nil {
 		return nil, err
 	}
+	// The document holds exactly what was stored, so there is nothing to save yet.
+	doc.MarkClean()
+
+	return doc, nil
+}
 
+// NewDocFromJSON creates a Doc holding state. The state is inserted directly in a single
+// transaction rather than computed as a diff against an empty document.
+func NewDocFromJSON(state map[string]interface{}) (*Doc, error) {
+	doc := NewDoc()
+	err := doc.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		return applyOp(txn, rootBranch, jsonpatch.JSONPatch{Operation: "add", Path: "", Value: state})
+	})
+	if err != nil {
+		doc.Destroy()
+		return nil, fmt.Errorf("NewDocFromJSON: %w", err)
+	}
 	return doc, nil
 }
 
+// WithDoc loads update (as from EncodeStateAsUpdate or EncodeFull; empty for a new document)
+// into a fresh Doc, calls fn with it and returns the document's full state encoded with
+// EncodeFull once fn returns. The Doc is destroyed before WithDoc returns, whatever
+// happens, so fn must not retain it. If fn fails, its error is returned and no update is
+// encoded.
+func WithDoc(update []byte, fn func(d *Doc) error) ([]byte, error) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if err := doc.ApplyUpdate(update); err != nil {
+		return nil, fmt.Errorf("WithDoc: %w", err)
+	}
+	if err := fn(doc); err != nil {
+		return nil, err
+	}
+	newUpdate, err := doc.EncodeFull()
+	if err != nil {
+		return nil, fmt.Errorf("WithDoc: %w", err)
+	}
+	return newUpdate, nil
+}
+
 // Destroy frees the underlying Yrs document. MUST be called when the Doc is no longer needed to prevent memory leaks.
+// A Doc collected without Destroy is freed by a finalizer as a safety net, but only once
+// the garbage collector gets to it, which may be much later. Calling Destroy more than
+// once is harmless.
 func (d *Doc) Destroy() {
 	// Do we need to call ydoc_clear as well?
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.yDoc == nil {
+		return // already destroyed
+	}
+	runtime.SetFinalizer(d, nil)
+	d.disableUpdateLog()
+	d.stopCapture()
+	d.stopWatching()
+	d.stopUpdateBatches()
+	d.stopDirtyTracking()
 	C.ydoc_destroy(d.yDoc)
+	d.yDoc = nil
+}
+
+// Acquire takes an additional reference to d for shared ownership. Each Acquire must be
+// balanced by a Release. The Doc returned by a constructor already holds one reference.
+func (d *Doc) Acquire() {
+	d.refs.Add(1)
+}
+
+// Release drops a reference to d, destroying it once the last reference is released.
+// Callers that share a Doc through Acquire/Release must not call Destroy themselves.
+func (d *Doc) Release() {
+	if d.refs.Add(-1) < 0 {
+		d.Destroy()
+	}
 }
 
-// ToJSON serializes the current state of the YDoc root map to a Go map.
+// ReadOptions controls how numbers are decoded when reading the document into Go values.
+// The zero value matches ToJSON: integers are int64 and floats are float64.
+type ReadOptions struct {
+	// UseNumber decodes numbers as json.Number instead of int64 and float64, holding
+	// their textual JSON representation.
+	UseNumber bool
+	// RoundFloats rounds every decoded float64 to FloatDecimals decimal places.
+	// It has no effect when UseNumber is set.
+	RoundFloats   bool
+	FloatDecimals int
+}
+
+// ToJSON returns the current state of the YDoc root map as a Go map. Values are read
+// straight from Yrs rather than through JSON, so numbers keep their stored type: integers
+// (as stored from Go integer types) are int64 and keep their full precision beyond 2^53,
+// while floats are float64, even when whole. Texts are strings and binary values []byte.
 func (d *Doc) ToJSON() (map[string]interface{}, error) {
-	txn := C.ydoc_read_transaction(d.yDoc)
-	if txn == nil {
-		return nil, errors.New("failed to create read transaction")
+	return d.ToJSONWithOptions(ReadOptions{})
+}
+
+// ToJSONWithOptions returns the current state of the YDoc root map as a Go map, like
+// ToJSON, decoding numbers according to opts.
+func (d *Doc) ToJSONWithOptions(opts ReadOptions) (map[string]interface{}, error) {
+	if d.arrayRoot {
+		return nil, errors.New("document root is an array, use ToJSONArray")
+	}
+	value, err := d.rootValue(opts)
+	if err != nil {
+		return nil, err
+	}
+	result, _ := value.(map[string]interface{})
+	if result == nil {
+		return make(map[string]interface{}), nil
 	}
-	defer C.ytransaction_commit(txn)
+	return result, nil
+}
 
-	rootKey := C.CString("root")
-	defer C.free(unsafe.Pointer(rootKey))
+// ToJSONArray returns the current state of a document created with NewArrayDoc as a Go
+// slice. Values are read as with ToJSON.
+func (d *Doc) ToJSONArray() ([]interface{}, error) {
+	if !d.arrayRoot {
+		return nil, errors.New("document root is a map, use ToJSON")
+	}
+	value, err := d.rootValue(ReadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	result, _ := value.([]interface{})
+	if result == nil {
+		return []interface{}{}, nil
+	}
+	return result, nil
+}
 
-	rootBranch := C.ytype_get(txn, rootKey)
-	if rootBranch == nil {
-		// This might happen if the root map wasn't created, though NewDoc ensures it.
-		return nil, errors.New("root map not found")
+// ToJSONBytes returns the current state of the YDoc root map as encoded JSON, without
+// decoding it into Go values first. The JSON rendered by Yrs is copied into Go memory
+// once, so serving a document this way allocates far less than ToJSON followed by
+// json.Marshal. To avoid even that copy, stream it with WriteJSON.
+func (d *Doc) ToJSONBytes() ([]byte, error) {
+	raw, err := d.rootJSON()
+	if err != nil {
+		return nil, fmt.Errorf("ToJSONBytes: %w", err)
 	}
+	return raw, nil
+}
 
-	cJsonString := C.ybranch_json(rootBranch, txn)
-	if cJsonString == nil {
-		// ybranch_json might return nil if the branch type can't be represented as JSON
-		// or if there's an internal error.
-		return nil, errors.New("failed to get JSON representation from ybranch_json")
+// writeJSONChunk is the largest slice of the JSON buffer passed to a single Write call.
+const writeJSONChunk = 32 << 10
+
+// WriteJSON streams the current state of the document, as returned by ToJSONBytes, to w.
+// The JSON is written straight from the buffer Yrs renders it into, in chunks, without
+// copying it into Go memory first. The document is only locked while the JSON is
+// rendered, so a slow writer doesn't hold up other writes.
+func (d *Doc) WriteJSON(w io.Writer) error {
+	var cJsonString *C.char
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		cJsonString = C.ybranch_json(rootBranch, txn)
+		if cJsonString == nil {
+			return errors.New("failed to get JSON representation from ybranch_json")
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("WriteJSON: %w", err)
 	}
 	defer C.ystring_destroy(cJsonString)
 
-	goJsonString := C.GoString(cJsonString)
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(cJsonString)), C.strlen(cJsonString))
+	for len(buf) > 0 {
+		chunk := buf[:min(len(buf), writeJSONChunk)]
+		if _, err := w.Write(chunk); err != nil {
+			return fmt.Errorf("WriteJSON: %w", err)
+		}
+		buf = buf[len(chunk):]
+	}
+	return nil
+}
 
-	var result map[string]interface{}
-	err := json.Unmarshal([]byte(goJsonString), &result)
+// ReadInto decodes the current state of d into a value of type T using encoding/json, so
+// T follows the usual struct tag and field matching rules. On error the zero value of T
+// is returned.
+func ReadInto[T any](d *Doc) (T, error) {
+	var result T
+	raw, err := d.ToJSONBytes()
 	if err != nil {
-		return nil, errors.New("failed to unmarshal JSON from YDoc: " + err.Error())
+		return result, err
 	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		var zero T
+		return zero, fmt.Errorf("ReadInto: failed to unmarshal JSON from YDoc into %T: %w", zero, err)
+	}
+	return result, nil
+}
 
-	if result == nil {
-		return make(map[string]interface{}), nil
+// rootJSON returns the JSON representation of the root map as produced by ybranch_json.
+func (d *Doc) rootJSON() ([]byte, error) {
+	var raw []byte
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		cJsonString := C.ybranch_json(rootBranch, txn)
+		if cJsonString == nil {
+			// ybranch_json might return nil if the branch type can't be represented as JSON
+			// or if there's an internal error.
+			return errors.New("failed to get JSON representation from ybranch_json")
+		}
+		defer C.ystring_destroy(cJsonString)
+
+		raw = C.GoBytes(unsafe.Pointer(cJsonString), C.int(C.strlen(cJsonString)))
+		return nil
+	})
+	return raw, err
+}
+
+// roundFloats rounds every float64 nested in value in place, using scale = 10^decimals.
+func roundFloats(value interface{}, scale float64) interface{} {
+	switch v := value.(type) {
+	case float64:
+		return math.Round(v*scale) / scale
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = roundFloats(elem, scale)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = roundFloats(elem, scale)
+		}
 	}
+	return value
+}
 
-	return result, nil
+// rootValue reads the root map (or array, see read) into Go values.
+func (d *Doc) rootValue(opts ReadOptions) (interface{}, error) {
+	var value interface{}
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		var err error
+		value, err = branchValue(txn, rootBranch, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.RoundFloats && !opts.UseNumber {
+		value = roundFloats(value, math.Pow(10, float64(opts.FloatDecimals)))
+	}
+	return value, nil
+}
+
+// branchValue reads the shared type branch into Go values, as described on ToJSON. Types
+// without a Go counterpart, such as XML, are decoded from their JSON representation.
+func branchValue(txn *C.YTransaction, branch *C.Branch, opts ReadOptions) (interface{}, error) {
+	switch C.ytype_kind(branch) {
+	case C.Y_MAP:
+		result := make(map[string]interface{}, int(C.ymap_len(branch, txn)))
+		iter := C.ymap_iter(branch, txn)
+		defer C.ymap_iter_destroy(iter)
+		for entry := C.ymap_iter_next(iter); entry != nil; entry = C.ymap_iter_next(iter) {
+			key := C.GoString(entry.key)
+			value, err := outputValue(txn, entry.value, opts)
+			C.ymap_entry_destroy(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", key, err)
+			}
+			result[key] = value
+		}
+		return result, nil
+	case C.Y_ARRAY:
+		n := C.yarray_len(branch)
+		result := make([]interface{}, n)
+		for i := C.uint32_t(0); i < n; i++ {
+			output := C.yarray_get(branch, txn, i)
+			if output == nil {
+				return nil, fmt.Errorf("failed to get element %d", i)
+			}
+			value, err := outputValue(txn, output, opts)
+			C.youtput_destroy(output)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			result[i] = value
+		}
+		return result, nil
+	case C.Y_TEXT:
+		return textString(txn, branch), nil
+	}
+
+	cJson := C.ybranch_json(branch, txn)
+	if cJson == nil {
+		return nil, errors.New("failed to get JSON representation from ybranch_json")
+	}
+	defer C.ystring_destroy(cJson)
+	decoder := json.NewDecoder(strings.NewReader(C.GoString(cJson)))
+	if opts.UseNumber {
+		decoder.UseNumber()
+	}
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, errors.New("failed to unmarshal JSON from YDoc: " + err.Error())
+	}
+	return value, nil
+}
+
+// outputValue reads output into Go values, as described on ToJSON. A subdocument is
+// read as an object holding its GUID, as Yrs renders it in JSON.
+func outputValue(txn *C.YTransaction, output *C.YOutput, opts ReadOptions) (interface{}, error) {
+	switch output.tag {
+	case C.Y_MAP:
+		return branchValue(txn, C.youtput_read_ymap(output), opts)
+	case C.Y_ARRAY:
+		return branchValue(txn, C.youtput_read_yarray(output), opts)
+	case C.Y_TEXT:
+		return branchValue(txn, C.youtput_read_ytext(output), opts)
+	case C.Y_XML_ELEM:
+		return branchValue(txn, C.youtput_read_yxmlelem(output), opts)
+	case C.Y_XML_TEXT:
+		return branchValue(txn, C.youtput_read_yxmltext(output), opts)
+	case C.Y_DOC:
+		guidC := C.ydoc_guid(C.youtput_read_ydoc(output))
+		defer C.ystring_destroy(guidC)
+		return map[string]interface{}{"guid": C.GoString(guidC)}, nil
+	case C.Y_JSON_MAP:
+		result := make(map[string]interface{}, int(output.len))
+		if output.len == 0 {
+			return result, nil
+		}
+		entries := unsafe.Slice(C.youtput_read_json_map(output), output.len)
+		for i := range entries {
+			key := C.GoString(entries[i].key)
+			value, err := outputValue(txn, entries[i].value, opts)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", key, err)
+			}
+			result[key] = value
+		}
+		return result, nil
+	case C.Y_JSON_ARR:
+		result := make([]interface{}, int(output.len))
+		if output.len == 0 {
+			return result, nil
+		}
+		elems := unsafe.Slice(C.youtput_read_json_array(output), output.len)
+		for i := range elems {
+			value, err := outputValue(txn, &elems[i], opts)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			result[i] = value
+		}
+		return result, nil
+	case C.Y_JSON_INT:
+		n := int64(*C.youtput_read_long(output))
+		if opts.UseNumber {
+			return json.Number(strconv.FormatInt(n, 10)), nil
+		}
+		return n, nil
+	case C.Y_JSON_NUM:
+		f := float64(*C.youtput_read_float(output))
+		if opts.UseNumber {
+			// Formatted as encoding/json would, so whole numbers have no exponent or point.
+			if raw, err := json.Marshal(f); err == nil {
+				return json.Number(raw), nil
+			}
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+		}
+		return f, nil
+	case C.Y_JSON_BOOL:
+		return *C.youtput_read_bool(output) != 0, nil
+	case C.Y_JSON_STR:
+		return C.GoString(C.youtput_read_string(output)), nil
+	case C.Y_JSON_BUF:
+		return C.GoBytes(unsafe.Pointer(C.youtput_read_binary(output)), C.int(output.len)), nil
+	case C.Y_JSON_NULL, C.Y_JSON_UNDEF:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported value type (tag: %d)", output.tag)
 }
 
 // Represents allocated C memory that needs to be freed later.
 type cAllocation struct {
 	ptr  unsafe.Pointer
-	kind string // "string", "byteArray", "inputArray", "keysArray" for debugging/clarity
+	kind string // "arena", "large", "string", "inputArray", ... for debugging/clarity
+	// size and used track how much of an "arena" block has been handed out.
+	size, used uintptr
+}
+
+const (
+	// Arena blocks start small, so one-off operations don't pay for a large malloc, and
+	// double up to maxArenaBlock as an input keeps growing.
+	minArenaBlock = 1 << 10
+	maxArenaBlock = 64 << 10
+	arenaAlign    = 8
+)
+
+// cAlloc returns size bytes of C memory that stay valid until allocations is passed to
+// freeAllocations. Small requests are carved out of shared arena blocks, so building a
+// large input costs a handful of mallocs and frees instead of one per string or array,
+// and the blocks are recycled through arenaPool for the writes that follow.
+// The current block is always kept last in allocations. It returns nil if malloc fails.
+func cAlloc(allocations *[]cAllocation, size uintptr) unsafe.Pointer {
+	if size == 0 {
+		size = 1
+	}
+	size = (size + arenaAlign - 1) &^ (arenaAlign - 1)
+
+	n := len(*allocations)
+	if n > 0 {
+		if block := &(*allocations)[n-1]; block.kind == "arena" && block.size-block.used >= size {
+			ptr := unsafe.Add(block.ptr, block.used)
+			block.used += size
+			return ptr
+		}
+	}
+
+	if size > maxArenaBlock/4 {
+		ptr := C.malloc(C.size_t(size))
+		if ptr == nil {
+			return nil
+		}
+		// Keep the current arena block last so it can still be carved from.
+		*allocations = append(*allocations, cAllocation{ptr: ptr, kind: "large"})
+		if n > 0 && (*allocations)[n-1].kind == "arena" {
+			(*allocations)[n-1], (*allocations)[n] = (*allocations)[n], (*allocations)[n-1]
+		}
+		return ptr
+	}
+
+	blockSize := uintptr(minArenaBlock)
+	for i := n - 1; i >= 0; i-- {
+		if (*allocations)[i].kind == "arena" {
+			blockSize = (*allocations)[i].size * 2
+			break
+		}
+	}
+	if blockSize > maxArenaBlock {
+		blockSize = maxArenaBlock
+	}
+	// A request too large for the next block in the sequence gets a block that fits it;
+	// requests up to maxArenaBlock/4 always fit a maxArenaBlock block.
+	for blockSize < size {
+		blockSize *= 2
+	}
+	ptr := getArenaBlock(blockSize)
+	if ptr == nil {
+		return nil
+	}
+	*allocations = append(*allocations, cAllocation{ptr: ptr, kind: "arena", size: blockSize, used: size})
+	return ptr
+}
+
+// cString copies s into NUL-terminated C memory obtained from cAlloc.
+func cString(allocations *[]cAllocation, s string) *C.char {
+	ptr := cAlloc(allocations, uintptr(len(s))+1)
+	if ptr == nil {
+		return nil
+	}
+	buf := unsafe.Slice((*byte)(ptr), len(s)+1)
+	copy(buf, s)
+	buf[len(s)] = 0
+	return (*C.char)(ptr)
+}
+
+// YMarshaler is implemented by types that control how they are stored in the document.
+// MarshalY returns the value to store in their place, which may be any value the package
+// accepts, including another YMarshaler. It is consulted before any other handling, so it
+// works for map values, array elements and patch values alike.
+type YMarshaler interface {
+	MarshalY() (interface{}, error)
 }
 
 // buildYInputRecursive converts a Go value into a C.YInput structure, suitable for use
@@ -122,7 +745,28 @@ func buildYInputRecursive(value interface{}, allocations *[]cAllocation) (C.YInp
 	}
 
 	val := reflect.ValueOf(value)
+	if m, ok := value.(YMarshaler); ok && !(val.Kind() == reflect.Ptr && val.IsNil()) {
+		stored, err := m.MarshalY()
+		if err != nil {
+			return C.YInput{}, fmt.Errorf("MarshalY for %T failed: %w", value, err)
+		}
+		return buildYInputRecursive(stored, allocations)
+	}
+	if s, ok := bigNumberString(value); ok {
+		return buildYInputRecursive(s, allocations)
+	}
+	if s, ok := timeString(value); ok {
+		return buildYInputRecursive(s, allocations)
+	}
 	switch val.Kind() {
+	case reflect.Invalid:
+		return C.yinput_null(), nil
+	case reflect.Ptr, reflect.Interface:
+		// A typed-nil pointer is stored as null; otherwise store what it points to.
+		if val.IsNil() {
+			return C.yinput_null(), nil
+		}
+		return buildYInputRecursive(val.Elem().Interface(), allocations)
 	case reflect.Bool:
 		b := val.Bool()
 		if b {
@@ -139,30 +783,52 @@ func buildYInputRecursive(value interface{}, allocations *[]cAllocation) (C.YInp
 		}
 		return C.yinput_long(C.int64_t(u)), nil
 	case reflect.Float32, reflect.Float64:
+		// Yrs would store them, but then render the document as invalid JSON.
+		if f := val.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return C.YInput{}, fmt.Errorf("%w: %v", ErrNonFiniteFloat, f)
+		}
 		return C.yinput_float(C.double(val.Float())), nil
 	case reflect.String:
 		goStr := val.String()
-		cStr := C.CString(goStr)
+		// The Yrs C API only accepts NUL-terminated strings, so anything after an
+		// embedded NUL would be silently dropped.
+		if strings.IndexByte(goStr, 0) >= 0 {
+			return C.YInput{}, errors.New("string value contains an embedded NUL byte, which cannot be stored")
+		}
+		cStr := cString(allocations, goStr)
 		if cStr == nil {
-			// CString can return nil if memory allocation fails
 			return C.YInput{}, errors.New("failed to allocate C string")
 		}
-		*allocations = append(*allocations, cAllocation{ptr: unsafe.Pointer(cStr), kind: "string"})
 		return C.yinput_string(cStr), nil
 	case reflect.Slice:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			// Byte slices are stored as a single binary value rather than an array of numbers.
+			data := val.Bytes()
+			buf := cAlloc(allocations, uintptr(max(len(data), 1)))
+			if buf == nil {
+				return C.YInput{}, fmt.Errorf("failed to allocate C buffer for %d bytes", len(data))
+			}
+			if len(data) > 0 {
+				C.memcpy(buf, unsafe.Pointer(&data[0]), C.size_t(len(data)))
+			}
+			return C.yinput_binary((*C.char)(buf), C.uint32_t(len(data))), nil
+		}
 		sliceLen := val.Len()
 		if sliceLen == 0 {
 			// Return YInput for empty YArray
 			// Allocate an empty C array pointer for consistency in freeing logic, though Yrs might handle nil.
 			// It's safer to provide a valid (even if zero-size allocated) pointer.
-			cArrayPtr := C.malloc(1) // Allocate minimal memory
+			cArrayPtr := cAlloc(allocations, 1) // Allocate minimal memory
 			if cArrayPtr == nil {
 				return C.YInput{}, errors.New("failed to allocate C array for empty slice")
 			}
-			*allocations = append(*allocations, cAllocation{ptr: cArrayPtr, kind: "inputArray"})
 			return C.yinput_yarray((*C.YInput)(cArrayPtr), 0), nil
 		}
 
+		if input, ok, err := buildScalarSlice(value, allocations); ok {
+			return input, err
+		}
+
 		// 1. Recursively build YInput for each element
 		goInputs := make([]C.YInput, sliceLen)
 		for i := 0; i < sliceLen; i++ {
@@ -174,12 +840,11 @@ func buildYInputRecursive(value interface{}, allocations *[]cAllocation) (C.YInp
 		}
 
 		// 2. Allocate C array and copy Go inputs into it
-		inputSize := C.sizeof_YInput                                    // Size of one YInput struct
-		cArrayPtr := C.malloc(C.size_t(sliceLen) * C.size_t(inputSize)) // Cast inputSize
+		inputSize := C.sizeof_YInput // Size of one YInput struct
+		cArrayPtr := cAlloc(allocations, uintptr(sliceLen)*uintptr(inputSize))
 		if cArrayPtr == nil {
 			return C.YInput{}, fmt.Errorf("failed to allocate C array for %d YInputs", sliceLen)
 		}
-		*allocations = append(*allocations, cAllocation{ptr: cArrayPtr, kind: "inputArray"})
 
 		// Copy memory - treat goInputs as a C array for memcpy
 		// Calculate the correct unsafe pointer to the start of the Go slice data
@@ -198,17 +863,15 @@ func buildYInputRecursive(value interface{}, allocations *[]cAllocation) (C.YInp
 		if mapLen == 0 {
 			// Return YInput for empty YMap
 			// Provide allocated (but empty) pointers for consistency
-			cKeysPtr := C.malloc(1)
+			cKeysPtr := cAlloc(allocations, 1)
 			if cKeysPtr == nil {
 				return C.YInput{}, errors.New("failed to allocate C array for empty map keys")
 			}
-			*allocations = append(*allocations, cAllocation{ptr: cKeysPtr, kind: "keysArray"})
 
-			cValuesPtr := C.malloc(1)
+			cValuesPtr := cAlloc(allocations, 1)
 			if cValuesPtr == nil {
 				return C.YInput{}, errors.New("failed to allocate C array for empty map values")
 			}
-			*allocations = append(*allocations, cAllocation{ptr: cValuesPtr, kind: "inputArray"})
 
 			return C.yinput_ymap((**C.char)(cKeysPtr), (*C.YInput)(cValuesPtr), 0), nil
 		}
@@ -221,13 +884,15 @@ func buildYInputRecursive(value interface{}, allocations *[]cAllocation) (C.YInp
 		for iter.Next() {
 			k := iter.Key().String()
 			v := iter.Value().Interface()
+			if strings.IndexByte(k, 0) >= 0 {
+				return C.YInput{}, fmt.Errorf("map key %q contains an embedded NUL byte, which cannot be stored", k)
+			}
 
 			// Allocate C string for key
-			cKey := C.CString(k)
+			cKey := cString(allocations, k)
 			if cKey == nil {
 				return C.YInput{}, fmt.Errorf("failed to allocate C string for map key '%s'", k)
 			}
-			*allocations = append(*allocations, cAllocation{ptr: unsafe.Pointer(cKey), kind: "string"})
 			goKeys[i] = cKey
 
 			// Recursively build value
@@ -243,21 +908,19 @@ func buildYInputRecursive(value interface{}, allocations *[]cAllocation) (C.YInp
 		// Correct size calculation for array of pointers (*C.char)
 		// Use unsafe.Sizeof on an element of the slice to get the pointer size
 		keyPtrSize := unsafe.Sizeof(goKeys[0])
-		cKeysPtr := C.malloc(C.size_t(mapLen) * C.size_t(keyPtrSize)) // Use C.size_t for multiplication result
+		cKeysPtr := cAlloc(allocations, uintptr(mapLen)*keyPtrSize)
 		if cKeysPtr == nil {
 			return C.YInput{}, fmt.Errorf("failed to allocate C array for %d map keys", mapLen)
 		}
-		*allocations = append(*allocations, cAllocation{ptr: cKeysPtr, kind: "keysArray"})
 		// Correct pointer for memcpy source (pointer to first element of Go slice)
 		goKeysPtr := unsafe.Pointer(&goKeys[0])
 		C.memcpy(cKeysPtr, goKeysPtr, C.size_t(mapLen)*C.size_t(keyPtrSize)) // Use C.size_t for multiplication result
 
-		valueSize := C.sizeof_YInput                                   // Size of one YInput struct
-		cValuesPtr := C.malloc(C.size_t(mapLen) * C.size_t(valueSize)) // Cast valueSize
+		valueSize := C.sizeof_YInput // Size of one YInput struct
+		cValuesPtr := cAlloc(allocations, uintptr(mapLen)*uintptr(valueSize))
 		if cValuesPtr == nil {
 			return C.YInput{}, fmt.Errorf("failed to allocate C array for %d map values", mapLen)
 		}
-		*allocations = append(*allocations, cAllocation{ptr: cValuesPtr, kind: "inputArray"})
 		// Correct pointer for memcpy source
 		goValuesPtr := unsafe.Pointer(&goValues[0])
 		C.memcpy(cValuesPtr, goValuesPtr, C.size_t(mapLen)*C.size_t(valueSize)) // Cast valueSize
@@ -275,13 +938,22 @@ func freeAllocations(allocations []cAllocation) {
 	for i := len(allocations) - 1; i >= 0; i-- { // Free in reverse order potentially?
 		alloc := allocations[i]
 		// fmt.Printf("  Freeing %s at %p\n", alloc.kind, alloc.ptr) // For debugging
+		if alloc.kind == "arena" {
+			putArenaBlock(alloc.ptr, alloc.size)
+			continue
+		}
 		C.free(alloc.ptr)
 	}
 }
 
 // Helper to navigate the YDoc structure based on JSON Pointer path segments.
 // Returns the parent Branch, the final key/index, and a slice of C.YOutput pointers
-// that were generated during navigation and need to be freed by the caller.
+// that were generated during navigation and need to be freed by the caller. The caller
+// must keep them until it is done with the parent Branch, which is read from the last
+// of them; destroying them afterwards, typically in a defer, is what every caller does.
+// How a segment is read depends only on the kind of container it is applied to, never
+// on what the segment looks like: in a map it is always a key, even a numeric one such
+// as "2024", and only in an array is it parsed as an index (or "-").
 func navigateToParent(txn *C.YTransaction, rootMap *C.Branch, pathSegments []string) (*C.Branch, interface{}, []*C.YOutput, error) {
 	parent := rootMap
 	if parent == nil {
@@ -323,14 +995,13 @@ func navigateToParent(txn *C.YTransaction, rootMap *C.Branch, pathSegments []str
 			}
 
 		} else if parentKind == C.Y_ARRAY {
-			index64, err := strconv.ParseUint(segmentStr, 10, 32)
+			index, err := parseArrayIndex(segmentStr)
 			if err != nil {
 				return cleanupOnError(fmt.Errorf("invalid array index '%s' in path: %w", segmentStr, err))
 			}
-			index := C.uint32_t(index64)
 			arrayLen := C.yarray_len(parent)
 			if index >= arrayLen {
-				return cleanupOnError(fmt.Errorf("array index %d out of bounds (len %d) for segment '%s'", index, arrayLen, segmentStr))
+				return cleanupOnError(fmt.Errorf("segment '%s': %w", segmentStr, indexOutOfBounds(index, arrayLen)))
 			}
 			nextParentOutput = C.yarray_get(parent, txn, index)
 
@@ -352,6 +1023,8 @@ func navigateToParent(txn *C.YTransaction, rootMap *C.Branch, pathSegments []str
 			nextParentBranch = C.youtput_read_ymap(nextParentOutput)
 		} else if outputTag == C.Y_ARRAY {
 			nextParentBranch = C.youtput_read_yarray(nextParentOutput)
+		} else if outputTag == C.Y_JSON_MAP || outputTag == C.Y_JSON_ARR {
+			return cleanupOnError(fmt.Errorf("path segment '%s': %w", segmentStr, ErrEmbeddedJSON))
 		} else {
 			return cleanupOnError(fmt.Errorf("path segment '%s' resolves to a non-container type (tag: %d)", segmentStr, outputTag))
 		}
@@ -366,25 +1039,189 @@ func navigateToParent(txn *C.YTransaction, rootMap *C.Branch, pathSegments []str
 	if parentKind == C.Y_MAP {
 		return parent, lastSegmentStr, outputsToFree, nil // Return string key
 	} else if parentKind == C.Y_ARRAY {
-		index64, err := strconv.ParseUint(lastSegmentStr, 10, 32)
+		// Check for '-' which is valid for append in JSON patch 'add' for arrays
+		if lastSegmentStr == "-" {
+			return parent, "-", outputsToFree, nil
+		}
+		index, err := parseArrayIndex(lastSegmentStr)
 		if err != nil {
-			// Check for '-' which is valid for append in JSON patch 'add' for arrays
-			if lastSegmentStr == "-" {
-				return parent, "-", outputsToFree, nil
-			}
 			return cleanupOnError(fmt.Errorf("invalid array index '%s' for final path segment: %w", lastSegmentStr, err))
 		}
-		return parent, C.uint32_t(index64), outputsToFree, nil
+		return parent, index, outputsToFree, nil
 	} else {
 		return cleanupOnError(fmt.Errorf("final parent navigated to is not a map or array (kind: %d)", parentKind))
 	}
 }
 
+// parseArrayIndex parses a JSON Pointer array index. Yrs addresses arrays with uint32
+// indices, so anything larger is rejected explicitly rather than being truncated.
+func parseArrayIndex(segment string) (C.uint32_t, error) {
+	index64, err := strconv.ParseUint(segment, 10, 64)
+	if errors.Is(err, strconv.ErrRange) || (err == nil && index64 > math.MaxUint32) {
+		return 0, fmt.Errorf("array index too large (max %d)", uint32(math.MaxUint32))
+	}
+	if err != nil {
+		return 0, errors.New("not a non-negative integer")
+	}
+	return C.uint32_t(index64), nil
+}
+
+// indexOutOfBounds reports an array index at or past length. Empty arrays get their own
+// wording, since a patch addressing "/items/0" against an empty array is a common sign
+// of having been generated from stale state.
+func indexOutOfBounds(index, length C.uint32_t) error {
+	if length == 0 {
+		return fmt.Errorf("array index %d out of bounds: array is empty", index)
+	}
+	return fmt.Errorf("array index %d out of bounds (len %d)", index, length)
+}
+
+// splitPath splits a JSON Pointer into its segments, unescaping "~1" to "/" and "~0" to
+// "~" in each. The empty pointer addresses the root and yields no segments.
+func splitPath(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path format '%s', must start with '/'", path)
+	}
+	pathSegments := strings.Split(path, "/")[1:]
+	for i, segment := range pathSegments {
+		pathSegments[i] = pointerUnescaper.Replace(segment)
+	}
+	return pathSegments, nil
+}
+
+// joinPath is the inverse of splitPath, escaping each segment.
+func joinPath(pathSegments []string) string {
+	var b strings.Builder
+	for _, segment := range pathSegments {
+		b.WriteByte('/')
+		b.WriteString(pointerEscaper.Replace(segment))
+	}
+	return b.String()
+}
+
+// destroyOutputs frees YOutputs collected during navigation. Only the outputs are freed:
+// the branches read from them belong to the document, so a branch stays valid for the
+// rest of its transaction, but callers still destroy the outputs last.
+func destroyOutputs(outputs []*C.YOutput) {
+	for _, outputPtr := range outputs {
+		if outputPtr != nil {
+			C.youtput_destroy(outputPtr)
+		}
+	}
+}
+
+// setAt stores input under keyOrIndex (as returned by navigateToParent) in parent. Map
+// keys are inserted or overwritten. Array indices are replaced in place, while "-" or an
+// index equal to the array length appends.
+func setAt(txn *C.YTransaction, parent *C.Branch, keyOrIndex interface{}, input *C.YInput) error {
+	switch key := keyOrIndex.(type) {
+	case string:
+		if C.ytype_kind(parent) == C.Y_MAP {
+			keyC := C.CString(key)
+			if keyC == nil {
+				return fmt.Errorf("failed to allocate C string for map key '%s'", key)
+			}
+			defer C.free(unsafe.Pointer(keyC))
+			tracef("ymap_insert(%p, %q)", parent, key)
+			C.ymap_insert(parent, txn, keyC, input)
+			return nil
+		}
+		// "-" addresses the end of an array.
+		tracef("yarray_insert_range(%p, end)", parent)
+		C.yarray_insert_range(parent, txn, C.yarray_len(parent), input, 1)
+		return nil
+	case C.uint32_t:
+		arrayLen := C.yarray_len(parent)
+		if key > arrayLen {
+			return indexOutOfBounds(key, arrayLen)
+		}
+		if key < arrayLen {
+			tracef("yarray_remove_range(%p, %d)", parent, key)
+			C.yarray_remove_range(parent, txn, key, 1)
+		}
+		tracef("yarray_insert_range(%p, %d)", parent, key)
+		C.yarray_insert_range(parent, txn, key, input, 1)
+		return nil
+	default:
+		return fmt.Errorf("unexpected key type %T", keyOrIndex)
+	}
+}
+
+// getOutput reads the value stored under keyOrIndex (as returned by navigateToParent) in
+// parent. The returned output must be destroyed by the caller.
+func getOutput(txn *C.YTransaction, parent *C.Branch, keyOrIndex interface{}, segment string) (*C.YOutput, error) {
+	var output *C.YOutput
+	switch key := keyOrIndex.(type) {
+	case string:
+		if C.ytype_kind(parent) != C.Y_MAP {
+			return nil, fmt.Errorf("path segment '%s' does not address an existing element", segment)
+		}
+		keyC := C.CString(key)
+		defer C.free(unsafe.Pointer(keyC))
+		output = C.ymap_get(parent, txn, keyC)
+	case C.uint32_t:
+		if arrayLen := C.yarray_len(parent); key >= arrayLen {
+			return nil, fmt.Errorf("segment '%s': %w", segment, indexOutOfBounds(key, arrayLen))
+		}
+		output = C.yarray_get(parent, txn, key)
+	}
+	if output == nil {
+		return nil, fmt.Errorf("path segment '%s' not found", segment)
+	}
+	return output, nil
+}
+
+// resolveBranch navigates to the map, array or text addressed by pathSegments. An empty
+// path resolves to rootMap itself. The returned outputs back the branch pointer and must be
+// destroyed by the caller once the branch is no longer used.
+func resolveBranch(txn *C.YTransaction, rootMap *C.Branch, pathSegments []string) (*C.Branch, []*C.YOutput, error) {
+	if len(pathSegments) == 0 {
+		if rootMap == nil {
+			return nil, nil, errors.New("resolveBranch received nil rootMap")
+		}
+		return rootMap, nil, nil
+	}
+
+	parent, keyOrIndex, outputs, err := navigateToParent(txn, rootMap, pathSegments)
+	if err != nil {
+		return nil, nil, err
+	}
+	lastSegment := pathSegments[len(pathSegments)-1]
+
+	output, err := getOutput(txn, parent, keyOrIndex, lastSegment)
+	if err != nil {
+		destroyOutputs(outputs)
+		return nil, nil, err
+	}
+	outputs = append(outputs, output)
+
+	var branch *C.Branch
+	switch output.tag {
+	case C.Y_MAP:
+		branch = C.youtput_read_ymap(output)
+	case C.Y_ARRAY:
+		branch = C.youtput_read_yarray(output)
+	case C.Y_TEXT:
+		branch = C.youtput_read_ytext(output)
+	}
+	if branch == nil {
+		destroyOutputs(outputs)
+		return nil, nil, fmt.Errorf("path segment '%s' resolves to a non-container type (tag: %d)", lastSegment, output.tag)
+	}
+	return branch, outputs, nil
+}
+
 func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch) error {
 	var allocations []cAllocation
 	defer func() { freeAllocations(allocations) }()
 
 	// --- Handle Root Operation ---
+	if op.Path == "" && C.ytype_kind(rootBranch) == C.Y_ARRAY {
+		return replaceRootArray(txn, rootBranch, op)
+	}
 	if op.Path == "" {
 		switch op.Operation {
 		case "replace":
@@ -393,11 +1230,12 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 				if op.Value == nil {
 					valuesToAdd = make(map[string]interface{})
 				} else {
-					return fmt.Errorf("operation (replace %s): value for root replacement must be a map (or nil), got %T", op.Path, op.Value)
+					return fmt.Errorf("operation (replace %s): %w", op.Path, checkRootObject(op.Value))
 				}
 			}
 
 			// Clear the existing root map
+			tracef("ymap_remove_all(%p)", rootBranch)
 			C.ymap_remove_all(rootBranch, txn)
 
 			// Insert new values
@@ -407,12 +1245,16 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 					return fmt.Errorf("operation (replace %s): failed to build YInput for key '%s': %w", op.Path, key, err)
 				}
 
+				if strings.IndexByte(key, 0) >= 0 {
+					return fmt.Errorf("operation (replace %s): map key %q contains an embedded NUL byte, which cannot be stored", op.Path, key)
+				}
 				keyC := C.CString(key)
 				if keyC == nil {
 					return fmt.Errorf("operation (replace %s): failed to allocate C string for map key '%s'", op.Path, key)
 				}
 				defer C.free(unsafe.Pointer(keyC))
 
+				tracef("ymap_insert(%p, %q)", rootBranch, key)
 				C.ymap_insert(rootBranch, txn, keyC, &yInput)
 			}
 			return nil // Root replacement successful
@@ -420,7 +1262,7 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 		case "add":
 			valuesToAdd, ok := op.Value.(map[string]interface{})
 			if !ok {
-				return fmt.Errorf("operation (add %s): value for root addition must be a map, got %T", op.Path, op.Value)
+				return fmt.Errorf("operation (add %s): %w", op.Path, checkRootObject(op.Value))
 			}
 
 			// Insert/Update values
@@ -430,12 +1272,16 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 					return fmt.Errorf("operation (add %s): failed to build YInput for key '%s': %w", op.Path, key, err)
 				}
 
+				if strings.IndexByte(key, 0) >= 0 {
+					return fmt.Errorf("operation (add %s): map key %q contains an embedded NUL byte, which cannot be stored", op.Path, key)
+				}
 				keyC := C.CString(key)
 				if keyC == nil {
 					return fmt.Errorf("operation (add %s): failed to allocate C string for map key '%s'", op.Path, key)
 				}
 				defer C.free(unsafe.Pointer(keyC))
 
+				tracef("ymap_insert(%p, %q)", rootBranch, key)
 				C.ymap_insert(rootBranch, txn, keyC, &yInput) // ymap_insert adds or updates
 			}
 			return nil // Root addition successful
@@ -446,13 +1292,9 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 	}
 
 	// --- Handle Non-Root Operation ---
-	// Pointer paths start with "/", split and remove the first empty element.
-	pathSegments := strings.Split(op.Path, "/")
-	if len(pathSegments) > 0 && pathSegments[0] == "" {
-		pathSegments = pathSegments[1:]
-	} else { // Handle non-empty paths that don't start with / (technically invalid JSON Pointer?)
-		// This case should ideally not happen if op.Path != "" but doesn't start with "/"
-		return fmt.Errorf("invalid path format '%s', must start with '/'", op.Path)
+	pathSegments, err := splitPath(op.Path)
+	if err != nil {
+		return err
 	}
 
 	// --- Navigate to Parent ---
@@ -461,13 +1303,8 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 		// navigateToParent already cleaned up its outputs on error
 		return fmt.Errorf("operation (%s %s): navigation failed: %w", op.Operation, op.Path, err)
 	}
-	defer func() {
-		for _, outputPtr := range navigationOutputsToDestroy {
-			if outputPtr != nil {
-				C.youtput_destroy(outputPtr)
-			}
-		}
-	}()
+	// Deferred, so the outputs backing parentBranch outlive the mutation below.
+	defer destroyOutputs(navigationOutputsToDestroy)
 
 	parentKind := C.ytype_kind(parentBranch)
 
@@ -489,6 +1326,7 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 			}
 			defer C.free(unsafe.Pointer(mapKeyC))
 
+			tracef("ymap_insert(%p, %q)", parentBranch, mapKey)
 			C.ymap_insert(parentBranch, txn, mapKeyC, &yInput)
 
 		} else if parentKind == C.Y_ARRAY {
@@ -513,9 +1351,10 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 			}
 
 			if targetIndex > arrayLen { // Add allows insertion at the end (index == len)
-				return fmt.Errorf("operation (add %s): index %d out of bounds for array insert (len %d)", op.Path, targetIndex, arrayLen)
+				return fmt.Errorf("operation (add %s): %w", op.Path, indexOutOfBounds(targetIndex, arrayLen))
 			}
 
+			tracef("yarray_insert_range(%p, %d)", parentBranch, targetIndex)
 			C.yarray_insert_range(parentBranch, txn, targetIndex, &yInput, 1)
 
 		} else {
@@ -532,6 +1371,7 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 			if mapKeyC == nil {
 				return fmt.Errorf("operation (remove %s): failed to allocate C string for map key '%s'", op.Path, mapKey)
 			}
+			tracef("ymap_remove(%p, %q)", parentBranch, mapKey)
 			removed := C.ymap_remove(parentBranch, txn, mapKeyC) // 0 if not found, 1 if found
 			defer C.free(unsafe.Pointer(mapKeyC))
 
@@ -545,8 +1385,9 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 			}
 			arrayLen := C.yarray_len(parentBranch)
 			if targetIndex >= arrayLen {
-				return fmt.Errorf("operation (remove %s): index %d out of bounds for array remove (len %d)", op.Path, targetIndex, arrayLen)
+				return fmt.Errorf("operation (remove %s): %w", op.Path, indexOutOfBounds(targetIndex, arrayLen))
 			}
+			tracef("yarray_remove_range(%p, %d)", parentBranch, targetIndex)
 			C.yarray_remove_range(parentBranch, txn, targetIndex, 1)
 		} else {
 			return fmt.Errorf("operation (remove %s): parent is not a map or array (kind %d)", op.Path, parentKind)
@@ -574,6 +1415,7 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 				return fmt.Errorf("operation (replace %s): key '%s' not found in map for replacement", op.Path, mapKey)
 			}
 			C.youtput_destroy(existingOutput) // Destroy the temporary output
+			tracef("ymap_insert(%p, %q)", parentBranch, mapKey)
 			C.ymap_insert(parentBranch, txn, mapKeyC, &yInput)
 
 		} else if parentKind == C.Y_ARRAY {
@@ -583,33 +1425,158 @@ func applyOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch)
 			}
 			arrayLen := C.yarray_len(parentBranch)
 			if targetIndex >= arrayLen {
-				return fmt.Errorf("operation (replace %s): index %d out of bounds for array replace (len %d)", op.Path, targetIndex, arrayLen)
+				return fmt.Errorf("operation (replace %s): %w", op.Path, indexOutOfBounds(targetIndex, arrayLen))
 			}
 			// Yjs doesn't have replace, so remove then insert
+			tracef("yarray_remove_range(%p, %d)", parentBranch, targetIndex)
 			C.yarray_remove_range(parentBranch, txn, targetIndex, 1)
+			tracef("yarray_insert_range(%p, %d)", parentBranch, targetIndex)
 			C.yarray_insert_range(parentBranch, txn, targetIndex, &yInput, 1)
 		} else {
 			return fmt.Errorf("operation (replace %s): parent is not a map or array (kind %d)", op.Path, parentKind)
 		}
 
+	case "move", "copy":
+		// jsonpatch.JSONPatch has no "from" member, so these cannot say what to move or copy.
+		return fmt.Errorf("operation (%s %s): unsupported operation type '%s': patches carry no \"from\" path; use Doc.Move or Doc.CopySubtree", op.Operation, op.Path, op.Operation)
 	default:
-		// move, copy, and test are not generated by jsonpatch, can ignore
+		// test is not generated by jsonpatch, can ignore
 		return fmt.Errorf("operation (%s %s): unsupported operation type '%s'", op.Operation, op.Path, op.Operation)
 	}
 
 	return nil
 }
 
-// ApplyOperations applies a list of JSON Patch operations to this document.
-func (d *Doc) ApplyOperations(patchList jsonpatch.JSONPatchList) error {
+// replaceRootArray applies a root operation to an array root. Both "add" and "replace"
+// replace the whole array, as "add" does for the root in RFC 6902.
+func replaceRootArray(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch) error {
+	if op.Operation != "add" && op.Operation != "replace" {
+		return fmt.Errorf("operation (%s %s): only 'replace' or 'add' operations are supported for the root array", op.Operation, op.Path)
+	}
+	values, ok := op.Value.([]interface{})
+	if !ok && op.Value != nil {
+		return fmt.Errorf("operation (%s %s): value for the root array must be a slice (or nil), got %T", op.Operation, op.Path, op.Value)
+	}
+
+	var allocations []cAllocation
+	defer func() { freeAllocations(allocations) }()
+
+	inputs, err := buildYInputs(values, &allocations)
+	if err != nil {
+		return fmt.Errorf("operation (%s %s): %w", op.Operation, op.Path, err)
+	}
+	if arrayLen := C.yarray_len(rootBranch); arrayLen > 0 {
+		tracef("yarray_remove_range(%p, 0, %d)", rootBranch, arrayLen)
+		C.yarray_remove_range(rootBranch, txn, 0, arrayLen)
+	}
+	if len(values) > 0 {
+		tracef("yarray_insert_range(%p, 0, %d)", rootBranch, len(values))
+		C.yarray_insert_range(rootBranch, txn, 0, inputs, C.uint32_t(len(values)))
+	}
+	return nil
+}
+
+// ErrTransactionInProgress is returned when Yrs refuses to open a transaction because a
+// conflicting one is still open on the same document. Doc methods hold the Doc's lock
+// around every transaction, so concurrent calls wait for each other instead; this error
+// means a transaction was opened on the document outside of that lock. It may be retried
+// once the other transaction has been committed.
+var ErrTransactionInProgress = errors.New("another transaction is in progress")
+
+// ErrNonFiniteFloat is returned when writing a NaN or infinite float, which JSON cannot
+// represent.
+var ErrNonFiniteFloat = errors.New("float is NaN or infinite")
+
+// ErrEmbeddedJSON is returned when a write addresses a value inside a map or array that is
+// stored as a plain JSON value rather than as a shared type, such as an object a Yjs client
+// pushed into a Y.Array. Such values can be read but not edited in place; replace the
+// whole value instead.
+var ErrEmbeddedJSON = errors.New("value is embedded JSON, which cannot be edited in place")
+
+// ErrRootNotObject is returned when a state or a root replacement that must become the
+// document's root map is not a JSON object.
+var ErrRootNotObject = errors.New("document root must be a JSON object")
+
+// checkRootObject returns an error wrapping ErrRootNotObject, naming the JSON kind of
+// state, unless state is a JSON object.
+func checkRootObject(state interface{}) error {
+	if _, ok := state.(map[string]interface{}); !ok {
+		return fmt.Errorf("%w, got %s", ErrRootNotObject, jsonKind(state))
+	}
+	return nil
+}
+
+// read runs fn inside a read transaction with the root map (the root array for documents
+// created with NewArrayDoc). The transaction is committed once fn returns.
+func (d *Doc) read(fn func(txn *C.YTransaction, rootBranch *C.Branch) error) error {
+	return d.readTxn(func(txn *C.YTransaction) error {
+		rootKey := C.CString(d.root())
+		defer C.free(unsafe.Pointer(rootKey))
+
+		rootBranch := C.ytype_get(txn, rootKey)
+		if rootBranch == nil {
+			// Only a NewBareDoc that hasn't received a root yet has none.
+			return errors.New("root map not found")
+		}
+
+		return fn(txn, rootBranch)
+	})
+}
+
+// readTxn runs fn inside a read transaction, for operations on the whole document that
+// don't need its root. The transaction is committed once fn returns.
+func (d *Doc) readTxn(fn func(txn *C.YTransaction) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	txn := C.ydoc_read_transaction(d.yDoc)
+	if txn == nil {
+		return fmt.Errorf("failed to create read transaction: %w", ErrTransactionInProgress)
+	}
+	tracef("ydoc_read_transaction() = %p", txn)
+	defer commitTransaction(txn)
+
+	return fn(txn)
+}
+
+// commitTransaction commits txn, tracing the call when a Logger is set.
+func commitTransaction(txn *C.YTransaction) {
+	tracef("ytransaction_commit(%p)", txn)
+	C.ytransaction_commit(txn)
+}
+
+// write runs fn inside a write transaction with the root map (or array, see read). The
+// transaction is committed once fn returns, even if fn fails, as Yrs has no way to abort
+// it.
+func (d *Doc) write(fn func(txn *C.YTransaction, rootBranch *C.Branch) error) error {
+	if d.frozen.Load() {
+		return ErrFrozen
+	}
+	// Deferred first so they run after the commit below and once the lock is released.
+	defer d.refreshBindings()
+	defer d.checkSizeWatermark()
+	defer d.checkEmptyState()
+	var changes txnChanges
+	defer func() { d.notifyKeyWatchers(changes) }()
+	defer func() { d.notifyObservers(changes) }()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Runs once the transaction below has been committed, before the lock is released.
+	defer func() { changes = d.takeChanges() }()
+	// Runs after the commit below, which is when a capture set by fn receives the update.
+	defer func() { d.capture = nil }()
+
+	d.setOrigin(nil)
 	txn := C.ydoc_write_transaction(d.yDoc, 0, nil)
 	if txn == nil {
-		return errors.New("failed to create write transaction")
+		return fmt.Errorf("failed to create write transaction: %w", ErrTransactionInProgress)
 	}
+	tracef("ydoc_write_transaction() = %p", txn)
 	// We must commit, even if errors occur mid-way, to avoid transaction leaks in Yrs.
-	defer C.ytransaction_commit(txn)
+	defer commitTransaction(txn)
 
-	rootKeyC := C.CString("root")
+	rootKeyC := C.CString(d.root())
 	if rootKeyC == nil {
 		return errors.New("failed to allocate C string for root key")
 	}
@@ -620,32 +1587,176 @@ func (d *Doc) ApplyOperations(patchList jsonpatch.JSONPatchList) error {
 		// This shouldn't happen if NewDoc worked correctly.
 		return errors.New("root map not found in YDoc")
 	}
-	if C.ytype_kind(rootBranch) != C.Y_MAP {
+	if d.arrayRoot {
+		if C.ytype_kind(rootBranch) != C.Y_ARRAY {
+			return errors.New("root Yrs object is not an array")
+		}
+	} else if C.ytype_kind(rootBranch) != C.Y_MAP {
 		return errors.New("root Yrs object is not a map")
 	}
 
+	return fn(txn, rootBranch)
+}
+
+// ApplyOperations applies a list of JSON Patch operations to this document, in order.
+// Each operation sees the document as left by the previous one, so array indices must
+// account for earlier inserts and removals; see SortOperations for hand-built patches
+// whose indices all refer to the original state. Path segments addressing a map are
+// keys even when numeric, so "/years/2024" sets key "2024" of a "years" map; segments
+// are only parsed as indices where they address an array. Keys containing "/" or "~" are
+// written "~1" and "~0", as RFC 6901 specifies.
+//
+// The patch applies all-or-nothing: if any operation fails, the document is left as it
+// was and the error is returned. Yrs cannot roll back a transaction, so the patch is
+// first tried on a scratch copy of the document, which costs a full encode and decode.
+func (d *Doc) ApplyOperations(patchList jsonpatch.JSONPatchList) error {
+	return d.ApplyOperationsWithOptions(patchList, ApplyOptions{})
+}
+
+// ApplyOptions relaxes how ApplyOperationsWithOptions interprets operations. The zero
+// value follows RFC 6902 strictly, matching ApplyOperations.
+type ApplyOptions struct {
+	// AllowReplaceAppend treats a "replace" at an array index equal to the array's length
+	// as an append instead of failing with an out-of-bounds error, for clients whose patch
+	// generators are off by one.
+	AllowReplaceAppend bool
+	// CreatePaths makes an "add" whose parent does not exist yet create the missing
+	// levels as empty maps, for bulk imports, so adding at "/a/b/c" works without "/a" or
+	// "/a/b". Only object levels are created: if a missing level is followed by an array
+	// index or "-", the operation fails, as the level could be meant to be an array.
+	CreatePaths bool
+}
+
+// ApplyOperationsWithOptions applies patchList like ApplyOperations, as adjusted by opts.
+func (d *Doc) ApplyOperationsWithOptions(patchList jsonpatch.JSONPatchList, opts ApplyOptions) error {
+	return d.applyOperations(context.Background(), patchList, opts, nil)
+}
+
+// applyOperations implements ApplyOperationsWithOptions and ApplyOperationsContext. If
+// capture is non-nil, the update committed by the transaction is stored in it (see
+// ApplyOperationsCapture).
+func (d *Doc) applyOperations(ctx context.Context, patchList jsonpatch.JSONPatchList, opts ApplyOptions, capture *[]byte) error {
+	err := d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		d.capture = capture
+		return d.applyOperationsTxn(ctx, txn, rootBranch, patchList, opts)
+	})
+	if err != nil {
+		return err
+	}
+	d.audit(patchList, "")
+	return nil
+}
+
+// applyOperationsTxn applies patchList within txn, all-or-nothing, for
+// ApplyOperationsWithOptions and Txn.ApplyOperations. ctx is checked before each
+// operation of the dry run and once more before the patch is applied for real, after
+// which it must be applied in full. The caller must hold d.mu for writing.
+func (d *Doc) applyOperationsTxn(ctx context.Context, txn *C.YTransaction, rootBranch *C.Branch, patchList jsonpatch.JSONPatchList, opts ApplyOptions) error {
+	if patchList.Empty() {
+		return nil
+	}
+	err := d.dryRun(txn, func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		for _, op := range patchList.List() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := d.applyPatchOp(txn, rootBranch, op, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.applyOpList(txn, rootBranch, patchList, opts)
+}
+
+// applyOpList applies the operations of patchList in order, stopping at the first that
+// fails. The caller must hold d.mu for writing.
+func (d *Doc) applyOpList(txn *C.YTransaction, rootBranch *C.Branch, patchList jsonpatch.JSONPatchList, opts ApplyOptions) error {
 	for _, op := range patchList.List() {
-		err := applyOp(txn, rootBranch, op)
-		if err != nil {
+		if err := d.applyPatchOp(txn, rootBranch, op, opts); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
+// applyPatchOp applies op as part of ApplyOperationsWithOptions, honouring the array
+// limits and text fields configured on the document.
+func (d *Doc) applyPatchOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch, opts ApplyOptions) error {
+	op.Value = d.encodeValues(op.Value)
+	if opts.AllowReplaceAppend && op.Operation == "replace" && isArrayEnd(txn, rootBranch, op.Path) {
+		op.Operation = "add"
+	}
+	if err := d.checkInputLimits(op.Path, op.Value); err != nil {
+		return fmt.Errorf("operation (%s %s): %w", op.Operation, op.Path, err)
+	}
+	if opts.CreatePaths && op.Operation == "add" {
+		if err := createParents(txn, rootBranch, op.Path); err != nil {
+			return fmt.Errorf("operation (add %s): %w", op.Path, err)
+		}
+	}
+	op, err := d.limitOp(txn, rootBranch, op)
+	if err != nil {
+		return err
+	}
+	handled, err := d.editTextField(txn, rootBranch, op)
+	if err != nil || handled {
+		return err
+	}
+	handled, err = replaceInPlace(txn, rootBranch, op)
+	if err != nil {
+		return err
+	}
+	if !handled {
+		if err := applyOp(txn, rootBranch, op); err != nil {
+			return err
+		}
+	}
+	d.promoteTextFields(txn, rootBranch, op.Path)
 	return nil
 }
 
+// isArrayEnd reports whether path addresses the position just past the end of an array.
+func isArrayEnd(txn *C.YTransaction, rootBranch *C.Branch, path string) bool {
+	pathSegments, err := splitPath(path)
+	if err != nil || len(pathSegments) == 0 {
+		return false
+	}
+	parent, keyOrIndex, outputs, err := navigateToParent(txn, rootBranch, pathSegments)
+	if err != nil {
+		return false
+	}
+	defer destroyOutputs(outputs)
+	index, ok := keyOrIndex.(C.uint32_t)
+	return ok && C.ytype_kind(parent) == C.Y_ARRAY && index == C.yarray_len(parent)
+}
+
 func (d *Doc) GetState() (map[string]interface{}, error) {
 	return d.ToJSON()
 }
 
 // UpdateToState synchronizes the document to match newState, returning the applied patches.
+// newState must remain a JSON object once the value encoders have run; otherwise an error
+// wrapping ErrRootNotObject is returned before anything is written.
 func (d *Doc) UpdateToState(newState map[string]interface{}) (jsonpatch.JSONPatchList, error) {
+	encoded := d.encodeValues(newState)
+	if err := checkRootObject(encoded); err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("UpdateToState: %w", err)
+	}
+	newState = encoded.(map[string]interface{})
+
 	currentState, err := d.GetState()
 	if err != nil {
 		return jsonpatch.JSONPatchList{}, fmt.Errorf("failed to get current state: %w", err)
 	}
 
-	patch, err := jsonpatch.CreateJSONPatch(newState, currentState)
+	patch, err := diffStates(newState, currentState)
 	if err != nil {
 		return jsonpatch.JSONPatchList{}, fmt.Errorf("failed to create JSON patch: %w", err)
 	}
@@ -656,59 +1767,234 @@ func (d *Doc) UpdateToState(newState map[string]interface{}) (jsonpatch.JSONPatc
 		return jsonpatch.JSONPatchList{}, fmt.Errorf("failed to apply JSON patch operations: %w", err)
 	}
 
+	added, err := d.applyDefaults()
+	if err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("failed to apply defaults: %w", err)
+	}
+	if added.Len() > 0 {
+		patch, err = NewPatchList(append(patch.List(), added.List()...))
+		if err != nil {
+			return jsonpatch.JSONPatchList{}, fmt.Errorf("failed to apply defaults: %w", err)
+		}
+	}
+
 	return patch, nil
 }
 
-// GetStateVector serializes the entire document state into a byte slice using Yrs update format v1.
-// This byte slice can be used later with ApplyStateVector to restore the document.
+// UpdateToStateJSON is UpdateToState with newState given as an encoded JSON object, for
+// states that arrive as raw JSON. As with Encode, numbers in target are decoded as int64
+// when they are integers that fit and float64 otherwise, so integers keep their full
+// precision and are stored as integers.
+func (d *Doc) UpdateToStateJSON(target []byte) (jsonpatch.JSONPatchList, error) {
+	decoder := json.NewDecoder(bytes.NewReader(target))
+	decoder.UseNumber()
+	var newState interface{}
+	if err := decoder.Decode(&newState); err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("UpdateToStateJSON: %w", err)
+	}
+	// Decode stops after the first value, where json.Unmarshal would reject what follows.
+	if _, err := decoder.Token(); err != io.EOF {
+		return jsonpatch.JSONPatchList{}, errors.New("UpdateToStateJSON: unexpected data after the JSON object")
+	}
+	newState = resolveNumbers(newState)
+	if err := checkRootObject(newState); err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("UpdateToStateJSON: %w", err)
+	}
+	return d.UpdateToState(newState.(map[string]interface{}))
+}
+
+// EncodeStateAsUpdate serializes the entire document state into a byte slice using Yrs
+// update format v1. This byte slice can be used later with ApplyUpdate to restore
+// the document. As it is the encoding used to save a document, it also marks the
+// document clean (see Dirty). For the compact summary of what the document holds, which
+// peers exchange to request only what they are missing, see EncodeStateVector.
+func (d *Doc) EncodeStateAsUpdate() ([]byte, error) {
+	// Cleared before encoding, so a write racing with the encoding leaves the doc dirty.
+	wasDirty := d.swapDirty(false)
+	update, err := d.encodeStateDiff(nil)
+	if err != nil {
+		if wasDirty {
+			d.swapDirty(true)
+		}
+		return nil, fmt.Errorf("EncodeStateAsUpdate: %w", err)
+	}
+	return update, nil
+}
+
+// GetStateVector is EncodeStateAsUpdate. Despite its name it returns a full update, not
+// a state vector.
+//
+// Deprecated: use EncodeStateAsUpdate, or EncodeStateVector for an actual state vector.
 func (d *Doc) GetStateVector() ([]byte, error) {
-	txn := C.ydoc_read_transaction(d.yDoc)
-	if txn == nil {
-		return nil, errors.New("GetStateVector: failed to create read transaction")
+	return d.EncodeStateAsUpdate()
+}
+
+// minUpdateLen is the size of the smallest well-formed v1 update: an empty list of client
+// structs followed by an empty delete set, one varint each.
+const minUpdateLen = 2
+
+// ApplyUpdate merges update, in Yrs update format v1, into the document. Updates are
+// merged by the CRDT rules rather than replacing the current content, so updates from
+// several peers can be applied in any order, and more than once, and every document that
+// has applied the same updates ends up in the same state. Loading a saved state (as from
+// EncodeStateAsUpdate) into a new document is the special case of merging into nothing.
+// Empty input is treated as a no-op, so a missing storage row can be passed through as is.
+// Updates encoded by Yjs clients (e.g. browsers using y-protocols) are accepted as well,
+// provided the Yjs document keeps its state in the top-level map named "root".
+func (d *Doc) ApplyUpdate(update []byte) error {
+	return d.ApplyUpdateWithOrigin(update, nil)
+}
+
+// ApplyUpdateWithOrigin is ApplyUpdate, tagging the transaction that merges update with
+// origin, which is passed to the callbacks registered with ObserveWithOrigin so they can
+// tell where a change came from, e.g. to avoid echoing it back to the peer that sent it.
+// An empty origin is replaced by "autosync/remote", the origin of updates applied with
+// ApplyUpdate; local writes have none. Changes merged with an origin are never recorded
+// by an UndoManager.
+func (d *Doc) ApplyUpdateWithOrigin(update, origin []byte) error {
+	if err := d.mergeUpdate(update, origin, false); err != nil {
+		return fmt.Errorf("ApplyUpdate: %w", err)
 	}
-	defer C.ytransaction_commit(txn) // Must commit even read transactions
+	return nil
+}
 
-	var updateLen C.uint32_t
-	// Passing nil state vector encodes the whole document
-	updateDataC := C.ytransaction_state_diff_v1(txn, nil, 0, &updateLen)
-	if updateDataC == nil {
-		return nil, errors.New("GetStateVector: ytransaction_state_diff_v1 returned nil")
+// ApplyUpdates merges updates, in Yrs update format v1, in order in a single write
+// transaction, e.g. the backlog a peer sends on reconnecting. Observers and the other
+// write hooks run once for the whole batch, and peers receive it as one update. Yrs
+// cannot roll back a transaction, so if an update fails to apply, the ones before it
+// stay applied; the error names the index of the failing update. Empty updates are
+// skipped, as with ApplyUpdate.
+func (d *Doc) ApplyUpdates(updates [][]byte) error {
+	if err := d.mergeUpdates(updates, nil, false); err != nil {
+		return fmt.Errorf("ApplyUpdates: %w", err)
 	}
-	defer C.ybinary_destroy(updateDataC, updateLen)
+	return nil
+}
 
-	if updateLen == 0 {
-		return []byte{}, nil
+// mergeUpdate implements ApplyUpdateWithOrigin and ApplyUpdateV2, decoding update as v2
+// if v2 is set and as v1 otherwise.
+func (d *Doc) mergeUpdate(update, origin []byte, v2 bool) error {
+	return d.mergeUpdates([][]byte{update}, origin, v2)
+}
+
+// mergeUpdates merges updates in a single write transaction, for mergeUpdate and
+// ApplyUpdates. Errors name the index of the failing update if there are several.
+func (d *Doc) mergeUpdates(updates [][]byte, origin []byte, v2 bool) error {
+	nonEmpty := make([][]byte, 0, len(updates))
+	indices := make([]int, 0, len(updates))
+	for i, update := range updates {
+		if len(update) == 0 {
+			continue
+		}
+		if len(update) < minUpdateLen {
+			return updateIndexError(len(updates), i, fmt.Errorf("update is truncated: %d bytes, expected at least %d", len(update), minUpdateLen))
+		}
+		nonEmpty = append(nonEmpty, update)
+		indices = append(indices, i)
+	}
+	if len(nonEmpty) == 0 {
+		return nil
 	}
 
-	// Copy the C data into a Go byte slice, can destroy binary after this
-	goData := C.GoBytes(unsafe.Pointer(updateDataC), C.int(updateLen))
+	before := d.resolverValues()
+	if len(origin) == 0 {
+		origin = []byte(remoteOrigin)
+	}
+	if failed, err := d.applyUpdates(nonEmpty, origin, v2); err != nil {
+		return updateIndexError(len(updates), indices[failed], err)
+	}
+	if err := d.runResolvers(before); err != nil {
+		return err
+	}
+	if _, err := d.applyDefaults(); err != nil {
+		return fmt.Errorf("defaults: %w", err)
+	}
+	return nil
+}
 
-	return goData, nil
+// updateIndexError prefixes err with the index of the update it is about, if there are
+// several.
+func updateIndexError(count, index int, err error) error {
+	if count == 1 {
+		return err
+	}
+	return fmt.Errorf("update %d: %w", index, err)
 }
 
-// ApplyStateVector applies a previously saved state (obtained via GetStateVector) to the document,
-// overwriting its current content. It uses Yrs update format v1.
+// ApplyStateVector is ApplyUpdate. Despite its name it takes an update, not a state
+// vector.
+//
+// Deprecated: use ApplyUpdate.
 func (d *Doc) ApplyStateVector(stateData []byte) error {
-	txn := C.ydoc_write_transaction(d.yDoc, 0, nil)
+	return d.ApplyUpdate(stateData)
+}
+
+// applyUpdates applies updates, v2 updates if v2 is set and v1 updates otherwise, in
+// order in a single write transaction, whose origin is origin. It stops at the first
+// update that fails to apply and returns its index with the error; the updates before it
+// stay applied.
+func (d *Doc) applyUpdates(updates [][]byte, origin []byte, v2 bool) (int, error) {
+	if d.frozen.Load() {
+		return 0, ErrFrozen
+	}
+	defer d.refreshBindings()
+	defer d.checkSizeWatermark()
+	defer d.checkEmptyState()
+	var changes txnChanges
+	defer func() { d.notifyKeyWatchers(changes) }()
+	defer func() { d.notifyObservers(changes) }()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Runs once the transaction below has been committed, before the lock is released.
+	defer func() { changes = d.takeChanges() }()
+	// Runs after the transaction below is committed.
+	defer d.adoptBareRoot()
+
+	// The origin keeps an UndoManager from recording changes received from other peers.
+	d.setOrigin(origin)
+	originC := C.CBytes(origin)
+	defer C.free(originC)
+	txn := C.ydoc_write_transaction(d.yDoc, C.uint32_t(len(origin)), (*C.char)(originC))
 	if txn == nil {
-		return errors.New("ApplyStateVector: failed to create write transaction")
+		return 0, fmt.Errorf("failed to create write transaction: %w", ErrTransactionInProgress)
 	}
+	tracef("ydoc_write_transaction() = %p", txn)
 	// Must commit to apply changes and avoid leaks, even if apply fails midway.
-	defer C.ytransaction_commit(txn)
+	defer commitTransaction(txn)
 
-	stateDataC := C.CBytes(stateData)
-	if stateDataC == nil {
-		return errors.New("ApplyStateVector: failed to allocate C memory for state data")
+	for i, update := range updates {
+		if err := applyUpdateTxn(txn, update, v2); err != nil {
+			return i, err
+		}
 	}
-	defer C.free(stateDataC)
+	return 0, nil
+}
 
-	stateDataLen := C.uint32_t(len(stateData))
+// applyUpdateTxn applies update, a v2 update if v2 is set and a v1 update otherwise,
+// within txn.
+func applyUpdateTxn(txn *C.YTransaction, update []byte, v2 bool) error {
+	updateC := C.CBytes(update)
+	if updateC == nil {
+		return errors.New("failed to allocate C memory for state data")
+	}
+	defer C.free(updateC)
 
-	errorCode := C.ytransaction_apply(txn, (*C.char)(stateDataC), stateDataLen)
+	applyName := "ytransaction_apply"
+	if v2 {
+		applyName = "ytransaction_apply_v2"
+	}
+	updateLen := C.uint32_t(len(update))
+	tracef("%s(%p, %d bytes)", applyName, txn, updateLen)
+	var errorCode C.uint8_t
+	if v2 {
+		errorCode = C.ytransaction_apply_v2(txn, (*C.char)(updateC), updateLen)
+	} else {
+		errorCode = C.ytransaction_apply(txn, (*C.char)(updateC), updateLen)
+	}
 
 	if errorCode != 0 {
-		return fmt.Errorf("ApplyStateVector: ytransaction_apply failed with error code %d", errorCode)
+		return fmt.Errorf("%s failed with error code %d", applyName, errorCode)
 	}
-
 	return nil
 }
@@ -3,12 +3,23 @@
 package autosync
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
+	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
+
+	"github.com/snorwin/jsonpatch"
 )
 
 // Helper function to generate somewhat complex nested data
@@ -140,6 +151,143 @@ func TestMemoryLeakStress(t *testing.T) {
 	// Significant growth could indicate a Go leak, but C leaks MUST be checked externally.
 }
 
+// TestDeepPathMutationStress writes through a five-level path thousands of times, mixing
+// map and array parents, so that a branch used after the navigation outputs backing it
+// were freed shows up as corruption (or, under -race or valgrind, as a report).
+func TestDeepPathMutationStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": []interface{}{map[string]interface{}{
+				"c": map[string]interface{}{"d": []interface{}{}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	const iterations = 5000
+	for i := 0; i < iterations; i++ {
+		// ApplyOperations dry-runs every patch against a copy of the whole document, so
+		// it runs less often to keep the test quick.
+		if i%100 == 0 {
+			patch, err := NewPatchList([]jsonpatch.JSONPatch{
+				{Operation: "add", Path: "/a/b/0/c/d/-", Value: map[string]interface{}{"i": i}},
+			})
+			if err != nil {
+				t.Fatalf("NewPatchList failed: %v", err)
+			}
+			if err := doc.ApplyOperations(patch); err != nil {
+				t.Fatalf("iteration %d: ApplyOperations failed: %v", i, err)
+			}
+		} else if err := doc.ArrayAppend("/a/b/0/c/d", map[string]interface{}{"i": i}); err != nil {
+			t.Fatalf("iteration %d: ArrayAppend failed: %v", i, err)
+		}
+		if err := doc.Set("/a/b/0/c/last", i); err != nil {
+			t.Fatalf("iteration %d: Set failed: %v", i, err)
+		}
+		if err := doc.Set("/a/b/0/c/d/0/i", i); err != nil {
+			t.Fatalf("iteration %d: Set failed: %v", i, err)
+		}
+	}
+
+	n, err := doc.ArrayLen("/a/b/0/c/d")
+	if err != nil {
+		t.Fatalf("ArrayLen failed: %v", err)
+	}
+	if n != iterations {
+		t.Errorf("expected %d elements, got %d", iterations, n)
+	}
+	for path, expected := range map[string]interface{}{
+		"/a/b/0/c/last":   int64(iterations - 1),
+		"/a/b/0/c/d/0/i":  int64(iterations - 1),
+		"/a/b/0/c/d/42/i": int64(42),
+	} {
+		got, err := doc.GetValueAtPath(path)
+		if err != nil {
+			t.Fatalf("GetValueAtPath %s failed: %v", path, err)
+		}
+		if got != expected {
+			t.Errorf("%s: expected %v, got %v", path, expected, got)
+		}
+	}
+}
+
+// TestLongLivedDocStress hammers a single long-lived document with apply-then-serialize
+// cycles, as a server holding a room open does, to catch per-operation leaks that
+// TestMemoryLeakStress misses by destroying its documents every iteration.
+func TestLongLivedDocStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+	const iterations = 20000
+	const warmup = 1000
+
+	doc := NewDoc()
+	defer doc.Destroy()
+	writer := NewDoc()
+	defer writer.Destroy()
+
+	var baseline runtime.MemStats
+	for i := 0; i < iterations; i++ {
+		if i == warmup {
+			runtime.GC()
+			runtime.ReadMemStats(&baseline)
+		}
+
+		if err := writer.Set("/counter", float64(i)); err != nil {
+			t.Fatalf("Iteration %d: Set failed: %v", i, err)
+		}
+		if err := writer.Set(fmt.Sprintf("/k%d", i%32), strings.Repeat("x", i%64)); err != nil {
+			t.Fatalf("Iteration %d: Set failed: %v", i, err)
+		}
+		sv, err := doc.stateVector()
+		if err != nil {
+			t.Fatalf("Iteration %d: stateVector failed: %v", i, err)
+		}
+		update, err := writer.encodeStateDiff(sv)
+		if err != nil {
+			t.Fatalf("Iteration %d: encodeStateDiff failed: %v", i, err)
+		}
+
+		if err := doc.ApplyStateVector(update); err != nil {
+			t.Fatalf("Iteration %d: ApplyStateVector failed: %v", i, err)
+		}
+		state, err := doc.ToJSON()
+		if err != nil {
+			t.Fatalf("Iteration %d: ToJSON failed: %v", i, err)
+		}
+		if state["counter"] != float64(i) {
+			t.Fatalf("Iteration %d: expected counter %d, got %v", i, i, state["counter"])
+		}
+		// Full encodings grow with the history, so only save now and then.
+		if i%100 == 0 {
+			if _, err := doc.GetStateVector(); err != nil {
+				t.Fatalf("Iteration %d: GetStateVector failed: %v", i, err)
+			}
+		}
+	}
+
+	// A transaction left open by any cycle would make this write fail.
+	if err := doc.Set("/done", true); err != nil {
+		t.Fatalf("Set after the stress loop failed: %v", err)
+	}
+
+	runtime.GC()
+	var final runtime.MemStats
+	runtime.ReadMemStats(&final)
+	t.Logf("HeapAlloc after warm-up: %v KiB, at the end: %v KiB", baseline.HeapAlloc/1024, final.HeapAlloc/1024)
+	// The document's history lives in C memory, so the Go heap should not grow with the
+	// number of cycles.
+	if final.HeapAlloc > baseline.HeapAlloc+4<<20 {
+		t.Errorf("Go heap grew from %v KiB to %v KiB over %d cycles", baseline.HeapAlloc/1024, final.HeapAlloc/1024, iterations-warmup)
+	}
+}
+
 // compareMaps recursively compares two maps.
 // Note: This is a basic comparison and might need to be more robust for complex cases
 // (e.g., order of elements in slices if that matters, deeper type checks).
@@ -274,7 +422,22 @@ func TestBuildYInputRecursiveCoverage(t *testing.T) {
 		{"nestedMap", map[string]interface{}{"key1": map[string]interface{}{"subkey1": 100}, "key2": "value2"}, false},
 		{"mapInSlice", []interface{}{map[string]interface{}{"foo": "bar"}}, false},
 		{"sliceInMap", map[string]interface{}{"list": []interface{}{10, 20}}, false},
+		{"nilIntPointer", (*int)(nil), false},
+		{"intPointer", func() *int { v := 7; return &v }(), false},
+		{"mapWithNilPointer", map[string]interface{}{"p": (*int)(nil), "i": nil}, false},
 		{"mapWithNonStringKeyType", map[int]interface{}{1: "one"}, true}, // This should fail reflect.String check
+		// Structs are never converted field by field (implement YMarshaler instead), so
+		// fields sharing a json tag can't silently overwrite each other.
+		// The type is built at runtime because vet rejects duplicate tags in a literal.
+		{"structWithDuplicateTags", func() interface{} {
+			v := reflect.New(reflect.StructOf([]reflect.StructField{
+				{Name: "A", Type: reflect.TypeOf(""), Tag: `json:"x"`},
+				{Name: "B", Type: reflect.TypeOf(""), Tag: `json:"x"`},
+			})).Elem()
+			v.Field(0).SetString("a")
+			v.Field(1).SetString("b")
+			return v.Interface()
+		}(), true},
 		{"complexNested", map[string]interface{}{
 			"level1_string": "string_l1",
 			"level1_map": map[string]interface{}{
@@ -305,3 +468,1353 @@ func TestBuildYInputRecursiveCoverage(t *testing.T) {
 		})
 	}
 }
+
+func TestToJSONWithOptions(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{"pi": 3.14159, "big": int64(9007199254740993)}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	numbers, err := doc.ToJSONWithOptions(ReadOptions{UseNumber: true})
+	if err != nil {
+		t.Fatalf("ToJSONWithOptions(UseNumber) failed: %v", err)
+	}
+	if n, ok := numbers["big"].(json.Number); !ok || n.String() != "9007199254740993" {
+		t.Errorf("expected json.Number 9007199254740993, got %#v", numbers["big"])
+	}
+
+	rounded, err := doc.ToJSONWithOptions(ReadOptions{RoundFloats: true, FloatDecimals: 2})
+	if err != nil {
+		t.Fatalf("ToJSONWithOptions(RoundFloats) failed: %v", err)
+	}
+	if rounded["pi"] != 3.14 {
+		t.Errorf("expected pi rounded to 3.14, got %v", rounded["pi"])
+	}
+
+	plain, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if plain["pi"] != 3.14159 {
+		t.Errorf("expected default ToJSON to leave floats untouched, got %v", plain["pi"])
+	}
+	// Above 2^53, so a float64 would round it to 9007199254740992.
+	if plain["big"] != int64(9007199254740993) {
+		t.Errorf("expected int64 9007199254740993, got %#v", plain["big"])
+	}
+}
+
+func TestToJSONEmptyRoot(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	// A fresh document, one emptied again, and one inside a batch all read as a
+	// non-nil map that is safe to write to.
+	check := func(name string, state map[string]interface{}, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		if state == nil || len(state) != 0 {
+			t.Fatalf("%s: expected an empty non-nil map, got %#v", name, state)
+		}
+		state["written"] = true
+	}
+	state, err := doc.ToJSON()
+	check("ToJSON", state, err)
+
+	if err := doc.Set("/a", 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	state, err = doc.ToJSON()
+	check("ToJSON after Clear", state, err)
+
+	err = doc.Batch(func(tx *Txn) error {
+		state, err := tx.ToJSON()
+		check("Txn.ToJSON", state, err)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+}
+
+func TestToJSONKeepsNumberTypes(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if err := doc.Set("/id", int64(9007199254740993)); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.Set("/whole", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.Set("/nested", map[string]interface{}{"list": []interface{}{1, 1.5}}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"id":     int64(9007199254740993),
+		"whole":  2.0,
+		"nested": map[string]interface{}{"list": []interface{}{int64(1), 1.5}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+
+	numbers, err := doc.ToJSONWithOptions(ReadOptions{UseNumber: true})
+	if err != nil {
+		t.Fatalf("ToJSONWithOptions failed: %v", err)
+	}
+	if numbers["whole"] != json.Number("2") || numbers["id"] != json.Number("9007199254740993") {
+		t.Errorf("expected json.Numbers 2 and 9007199254740993, got %#v and %#v", numbers["whole"], numbers["id"])
+	}
+}
+
+func TestUnicodeAndEmbeddedNUL(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	state := map[string]interface{}{
+		"emoji":   "family: 👨‍👩‍👧‍👦, face: 😀",
+		"astral":  "𝄞 𐍈 𠜎",
+		"mixed":   "héllo wörld – ✓",
+		"🔑 emoji": "key with astral characters",
+	}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(got, state) {
+		t.Errorf("unicode round-trip mismatch: expected %v, got %v", state, got)
+	}
+
+	if _, err := doc.UpdateToState(map[string]interface{}{"nul": "before\x00after"}); err == nil {
+		t.Error("expected an error storing a string with an embedded NUL")
+	}
+	if _, err := doc.UpdateToState(map[string]interface{}{"nested": map[string]interface{}{"a\x00b": 1.0}}); err == nil {
+		t.Error("expected an error storing a map key with an embedded NUL")
+	}
+	after, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if _, ok := after["nul"]; ok {
+		t.Error("a truncated string was stored despite the embedded NUL")
+	}
+}
+
+// chunkRecorder is an io.Writer that records the size of every Write.
+type chunkRecorder struct {
+	bytes.Buffer
+	sizes []int
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	c.sizes = append(c.sizes, len(p))
+	return c.Buffer.Write(p)
+}
+
+func TestWriteJSON(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"big":   strings.Repeat("x", 3*writeJSONChunk),
+		"small": 1.0,
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	var out chunkRecorder
+	if err := doc.WriteJSON(&out); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	expected, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("WriteJSON wrote invalid JSON: %v", err)
+	}
+	if !compareMaps(got, expected) {
+		t.Error("expected WriteJSON to write the document state")
+	}
+	for _, size := range out.sizes {
+		if size > writeJSONChunk {
+			t.Errorf("expected writes of at most %d bytes, got %d", writeJSONChunk, size)
+		}
+	}
+	if len(out.sizes) < 4 {
+		t.Errorf("expected the JSON to be written in several chunks, got %d", len(out.sizes))
+	}
+
+	raw, err := doc.ToJSONBytes()
+	if err != nil {
+		t.Fatalf("ToJSONBytes failed: %v", err)
+	}
+	// Map keys come out in no particular order, so compare the decoded values.
+	var fromBytes map[string]interface{}
+	if err := json.Unmarshal(raw, &fromBytes); err != nil {
+		t.Fatalf("ToJSONBytes returned invalid JSON: %v", err)
+	}
+	if !compareMaps(fromBytes, got) {
+		t.Error("expected ToJSONBytes to return what WriteJSON writes")
+	}
+
+	errWrite := errors.New("connection reset")
+	if err := doc.WriteJSON(failingWriter{errWrite}); !errors.Is(err, errWrite) {
+		t.Errorf("expected the writer's error, got %v", err)
+	}
+}
+
+type failingWriter struct{ err error }
+
+func (f failingWriter) Write(p []byte) (int, error) { return 0, f.err }
+
+func TestReadInto(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	state := map[string]interface{}{
+		"name":  "widget",
+		"count": 3.0,
+		"tags":  []interface{}{"a", "b"},
+	}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	type widget struct {
+		Name  string   `json:"name"`
+		Count int      `json:"count"`
+		Tags  []string `json:"tags"`
+	}
+	got, err := ReadInto[widget](doc)
+	if err != nil {
+		t.Fatalf("ReadInto failed: %v", err)
+	}
+	if got.Name != "widget" || got.Count != 3 || len(got.Tags) != 2 || got.Tags[1] != "b" {
+		t.Errorf("unexpected decoded value: %+v", got)
+	}
+
+	type mismatched struct {
+		Name int `json:"name"`
+	}
+	bad, err := ReadInto[mismatched](doc)
+	if err == nil {
+		t.Error("expected an error decoding a string into an int field")
+	}
+	if bad != (mismatched{}) {
+		t.Errorf("expected the zero value on error, got %+v", bad)
+	}
+}
+
+func TestNilPointersStoredAsNull(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	var nilInt *int
+	var nilIface interface{}
+	count := 5
+	if _, err := doc.UpdateToState(map[string]interface{}{
+		"ptr":   nilInt,
+		"iface": nilIface,
+		"count": &count,
+		"list":  []interface{}{nilInt, &count},
+	}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"ptr":   nil,
+		"iface": nil,
+		"count": 5.0,
+		"list":  []interface{}{nil, 5.0},
+	}
+	if !compareMaps(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+}
+
+func TestArrayIndexBounds(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{
+		"arr": []interface{}{[]interface{}{"x"}},
+	}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	maxIndex := strconv.FormatUint(math.MaxUint32, 10)
+	beyond := strconv.FormatUint(math.MaxUint32+1, 10)
+	testCases := []struct {
+		name         string
+		path         string
+		wantTooLarge bool
+	}{
+		{"finalAtMax", "/arr/" + maxIndex, false},
+		{"finalBeyondMax", "/arr/" + beyond, true},
+		{"finalBeyondUint64", "/arr/99999999999999999999999", true},
+		{"intermediateAtMax", "/arr/" + maxIndex + "/0", false},
+		{"intermediateBeyondMax", "/arr/" + beyond + "/0", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := doc.ReplaceSubtree(tc.path, map[string]interface{}{})
+			if err == nil {
+				t.Fatalf("expected an error for %s", tc.path)
+			}
+			if got := strings.Contains(err.Error(), "array index too large"); got != tc.wantTooLarge {
+				t.Errorf("unexpected error for %s: %v", tc.path, err)
+			}
+		})
+	}
+}
+
+func TestEmptyArrayErrors(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"items": []interface{}{},
+		"a": map[string]interface{}{
+			"items": []interface{}{},
+			"b":     map[string]interface{}{"items": []interface{}{}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	for _, prefix := range []string{"/items", "/a/items", "/a/b/items"} {
+		testCases := []struct {
+			name  string
+			apply func() error
+		}{
+			{"replace", func() error {
+				return applyPatch(doc, jsonpatch.JSONPatch{Operation: "replace", Path: prefix + "/0", Value: 1.0})
+			}},
+			{"remove", func() error {
+				return applyPatch(doc, jsonpatch.JSONPatch{Operation: "remove", Path: prefix + "/0"})
+			}},
+			{"addPastEnd", func() error {
+				return applyPatch(doc, jsonpatch.JSONPatch{Operation: "add", Path: prefix + "/1", Value: 1.0})
+			}},
+			{"intermediate", func() error {
+				return applyPatch(doc, jsonpatch.JSONPatch{Operation: "add", Path: prefix + "/0/x", Value: 1.0})
+			}},
+			{"set", func() error { return doc.Set(prefix+"/1", 1) }},
+			{"get", func() error { _, err := doc.GetInt64(prefix + "/0"); return err }},
+		}
+		for _, tc := range testCases {
+			t.Run(prefix+"/"+tc.name, func(t *testing.T) {
+				err := tc.apply()
+				if err == nil {
+					t.Fatal("expected an error addressing an empty array")
+				}
+				if !strings.Contains(err.Error(), "array is empty") {
+					t.Errorf("expected the error to say the array is empty, got: %v", err)
+				}
+			})
+		}
+	}
+
+	// Index 0 is still a valid insertion point for add.
+	if err := applyPatch(doc, jsonpatch.JSONPatch{Operation: "add", Path: "/a/b/items/0", Value: "x"}); err != nil {
+		t.Fatalf("add at index 0 of an empty array failed: %v", err)
+	}
+	err = applyPatch(doc, jsonpatch.JSONPatch{Operation: "replace", Path: "/a/b/items/1", Value: "y"})
+	if err == nil || !strings.Contains(err.Error(), "(len 1)") {
+		t.Errorf("expected an out of bounds error with the length, got: %v", err)
+	}
+}
+
+// applyPatch applies a single JSON Patch operation to doc.
+func applyPatch(doc *Doc, op jsonpatch.JSONPatch) error {
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{op})
+	if err != nil {
+		return err
+	}
+	return doc.ApplyOperations(patch)
+}
+
+func TestNumericMapKeys(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"years": map[string]interface{}{"2024": map[string]interface{}{"0": "zero"}},
+		"list":  []interface{}{"a"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/years/2025", Value: 1.0},
+		{Operation: "replace", Path: "/years/2024/0", Value: "first"},
+		{Operation: "add", Path: "/years/2024/-", Value: "dash"},
+		{Operation: "add", Path: "/years/07", Value: true},
+		{Operation: "add", Path: "/list/1", Value: "b"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err != nil {
+		t.Fatalf("ApplyOperations failed: %v", err)
+	}
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"years": map[string]interface{}{
+			"2024": map[string]interface{}{"0": "first", "-": "dash"},
+			"2025": 1.0,
+			"07":   true,
+		},
+		"list": []interface{}{"a", "b"},
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// In an array the same kind of segment is an index, and a key-like one is invalid.
+	bad, _ := NewPatchList([]jsonpatch.JSONPatch{{Operation: "add", Path: "/list/name", Value: "x"}})
+	if err := doc.ApplyOperations(bad); err == nil {
+		t.Error("expected an error using a non-numeric segment in an array")
+	}
+}
+
+func TestEscapedPointerKeys(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"a/b":    map[string]interface{}{"config~default": "x"},
+		"routes": map[string]interface{}{},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "replace", Path: "/a~1b/config~0default", Value: "y"},
+		{Operation: "add", Path: "/routes/~1api~1v1", Value: "svc"},
+		{Operation: "add", Path: "/routes/~01", Value: "tilde-one"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err != nil {
+		t.Fatalf("ApplyOperations failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"a/b":    map[string]interface{}{"config~default": "y"},
+		"routes": map[string]interface{}{"/api/v1": "svc", "~1": "tilde-one"},
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	newState := map[string]interface{}{
+		"a/b":    map[string]interface{}{"config~default": "z", "x/~y": 1.0},
+		"routes": map[string]interface{}{"/api/v1": "svc2"},
+		"~":      []interface{}{"t"},
+	}
+	if _, err := doc.UpdateToState(newState); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	got, err = doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(got, newState) {
+		t.Errorf("expected %v, got %v", newState, got)
+	}
+
+	value, err := doc.GetValueAtPath("/a~1b/x~1~0y")
+	if err != nil {
+		t.Fatalf("GetValueAtPath failed: %v", err)
+	}
+	if value != 1.0 {
+		t.Errorf("expected 1, got %v", value)
+	}
+}
+
+func TestReplaceChangesValueType(t *testing.T) {
+	testCases := []struct {
+		name   string
+		before interface{}
+		after  interface{}
+	}{
+		{"scalarToMap", "scalar", map[string]interface{}{"nested": "value"}},
+		{"mapToScalar", map[string]interface{}{"nested": "value"}, 12.5},
+		{"arrayToMap", []interface{}{"a", "b"}, map[string]interface{}{"nested": []interface{}{1.0}}},
+		{"mapToArray", map[string]interface{}{"nested": "value"}, []interface{}{"a", map[string]interface{}{"b": true}}},
+		{"arrayToScalar", []interface{}{"a"}, nil},
+		{"scalarToArray", true, []interface{}{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := NewDoc()
+			defer doc.Destroy()
+
+			if _, err := doc.UpdateToState(map[string]interface{}{"key": tc.before, "other": "kept"}); err != nil {
+				t.Fatalf("initial UpdateToState failed: %v", err)
+			}
+			expected := map[string]interface{}{"key": tc.after, "other": "kept"}
+			if _, err := doc.UpdateToState(expected); err != nil {
+				t.Fatalf("UpdateToState failed: %v", err)
+			}
+			state, err := doc.ToJSON()
+			if err != nil {
+				t.Fatalf("ToJSON failed: %v", err)
+			}
+			if !compareMaps(state, expected) {
+				t.Errorf("expected %v, got %v", expected, state)
+			}
+
+			// The old branch must be gone from the document, not just hidden behind the
+			// new value: a peer replaying the full state sees the same result.
+			full, err := doc.EncodeFull()
+			if err != nil {
+				t.Fatalf("EncodeFull failed: %v", err)
+			}
+			peer, err := NewDocFromStateVector(full)
+			if err != nil {
+				t.Fatalf("NewDocFromStateVector failed: %v", err)
+			}
+			defer peer.Destroy()
+			peerState, err := peer.ToJSON()
+			if err != nil {
+				t.Fatalf("peer ToJSON failed: %v", err)
+			}
+			if !compareMaps(peerState, expected) {
+				t.Errorf("peer expected %v, got %v", expected, peerState)
+			}
+
+			// Swapping back must supersede the new value just as cleanly.
+			if err := doc.Set("/key", tc.before); err != nil {
+				t.Fatalf("Set back failed: %v", err)
+			}
+			state, err = doc.ToJSON()
+			if err != nil {
+				t.Fatalf("ToJSON failed: %v", err)
+			}
+			if restored := map[string]interface{}{"key": tc.before, "other": "kept"}; !compareMaps(state, restored) {
+				t.Errorf("expected %v after swapping back, got %v", restored, state)
+			}
+		})
+	}
+}
+
+func TestReplaceLargeIntegerOfOtherType(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	// 2^53 and 2^53+1 are distinct integers but the same float64.
+	if _, err := doc.UpdateToState(map[string]interface{}{"n": int64(1 << 53)}); err != nil {
+		t.Fatalf("initial UpdateToState failed: %v", err)
+	}
+	if _, err := doc.UpdateToState(map[string]interface{}{"n": 1<<53 + 1}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if state["n"] != int64(1<<53+1) {
+		t.Errorf("expected %d, got %v (%T)", int64(1<<53+1), state["n"], state["n"])
+	}
+}
+
+func TestBinaryValues(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	thumb := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	state := map[string]interface{}{"thumb": thumb, "empty": []byte{}, "list": []interface{}{[]byte{1}}}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if kind, err := doc.KindAt("/thumb"); err != nil || kind != KindBinary {
+		t.Errorf("expected /thumb to be stored as binary, got %v (err %v)", kind, err)
+	}
+
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+	got, err := peer.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"thumb": thumb, "empty": []byte{}, "list": []interface{}{[]byte{1}}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+
+	patch, err := doc.UpdateToState(state)
+	if err != nil {
+		t.Fatalf("UpdateToState with unchanged bytes failed: %v", err)
+	}
+	if !patch.Empty() {
+		t.Errorf("expected no operations for unchanged bytes, got %s", patch.String())
+	}
+	patch, err = doc.UpdateToState(map[string]interface{}{"thumb": []byte{1, 2}, "empty": []byte{}, "list": []interface{}{[]byte{1}}})
+	if err != nil {
+		t.Fatalf("UpdateToState with changed bytes failed: %v", err)
+	}
+	if patch.Len() != 1 || patch.List()[0].Operation != "replace" || patch.List()[0].Path != "/thumb" {
+		t.Errorf("expected a single replace of /thumb, got %s", patch.String())
+	}
+	got, _ = doc.ToJSON()
+	if !bytes.Equal(got["thumb"].([]byte), []byte{1, 2}) {
+		t.Errorf("expected thumb [1 2], got %#v", got["thumb"])
+	}
+}
+
+func TestUpdateToStateMixedNumberTypes(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{"count": 1, "ratio": 0.5}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	// ToJSON reads the stored integers back as int64, so the next diff compares int with
+	// int64. Equal values must not produce operations.
+	patch, err := doc.UpdateToState(map[string]interface{}{"count": 1, "ratio": 0.5})
+	if err != nil {
+		t.Fatalf("UpdateToState with unchanged ints failed: %v", err)
+	}
+	if !patch.Empty() {
+		t.Errorf("expected no operations for unchanged values, got %s", patch.String())
+	}
+
+	patch, err = doc.UpdateToState(map[string]interface{}{"count": 2, "ratio": 0.5})
+	if err != nil {
+		t.Fatalf("UpdateToState with a changed int failed: %v", err)
+	}
+	if patch.Len() != 1 || patch.List()[0].Operation != "replace" || patch.List()[0].Path != "/count" {
+		t.Errorf("expected a single replace of /count, got %s", patch.String())
+	}
+	state, _ := doc.ToJSON()
+	if state["count"] != int64(2) {
+		t.Errorf("expected count 2, got %v", state["count"])
+	}
+}
+
+func TestUpdateToStateBetweenPopulatedStates(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"title":  "draft",
+		"tags":   []interface{}{"a", "b", "c"},
+		"meta":   map[string]interface{}{"owner": "kim", "rev": 1.0},
+		"remove": true,
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	target := map[string]interface{}{
+		"title": "final",
+		"tags":  []interface{}{"b", "d"},
+		"meta":  map[string]interface{}{"rev": 2.0, "editor": "lee"},
+		"added": []interface{}{1.0},
+	}
+	patch, err := doc.UpdateToState(target)
+	if err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(got, target) {
+		t.Errorf("expected %v, got %v (patch %v)", target, got, patch.List())
+	}
+}
+
+func TestNewDocFromJSON(t *testing.T) {
+	state := map[string]interface{}{
+		"name":   "initial",
+		"nested": map[string]interface{}{"list": []interface{}{1.0, "two", nil}},
+	}
+	doc, err := NewDocFromJSON(state)
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(got, state) {
+		t.Errorf("expected %v, got %v", state, got)
+	}
+
+	if _, err := NewDocFromJSON(map[string]interface{}{"bad": make(chan int)}); err == nil {
+		t.Error("expected an error for an unsupported value")
+	}
+}
+
+func TestWithDoc(t *testing.T) {
+	update, err := WithDoc(nil, func(d *Doc) error {
+		return d.Set("/count", 1.0)
+	})
+	if err != nil {
+		t.Fatalf("WithDoc failed on a new document: %v", err)
+	}
+	update, err = WithDoc(update, func(d *Doc) error {
+		_, err := d.UpdateToState(map[string]interface{}{"count": 2.0, "name": "x"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithDoc failed: %v", err)
+	}
+
+	doc, err := NewDocFromStateVector(update)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer doc.Destroy()
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"count": 2.0, "name": "x"}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	errHandler := errors.New("handler failed")
+	if out, err := WithDoc(update, func(d *Doc) error { return errHandler }); !errors.Is(err, errHandler) || out != nil {
+		t.Errorf("expected the callback's error and no update, got %v, %d bytes", err, len(out))
+	}
+}
+
+func TestUpdateToStateJSON(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": 1.0, "b": "x"})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	patch, err := doc.UpdateToStateJSON([]byte(`{"a": 2, "c": [true]}`))
+	if err != nil {
+		t.Fatalf("UpdateToStateJSON failed: %v", err)
+	}
+	if patch.Len() != 3 {
+		t.Errorf("expected 3 operations, got %s", patch.String())
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"a": int64(2), "c": []interface{}{true}}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// 2^53+1 can't be held by a float64, and 0.5 must stay a float.
+	if _, err := doc.UpdateToStateJSON([]byte(`{"big": 9007199254740993, "f": 0.5}`)); err != nil {
+		t.Fatalf("UpdateToStateJSON failed: %v", err)
+	}
+	got, err = doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if got["big"] != int64(9007199254740993) || got["f"] != 0.5 {
+		t.Errorf("expected big=9007199254740993 (int64) and f=0.5, got %#v and %#v", got["big"], got["f"])
+	}
+
+	for _, target := range []string{`[1]`, `null`, `{"a":`, `{"a": 1} {}`} {
+		if _, err := doc.UpdateToStateJSON([]byte(target)); err == nil {
+			t.Errorf("expected an error for %s", target)
+		}
+	}
+}
+
+func TestUpdateToStateNonObjectRoot(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	for target, kind := range map[string]string{
+		`[1, 2]`: "array",
+		`42`:     "number",
+		`"text"`: "string",
+		`true`:   "bool",
+		`null`:   "null",
+	} {
+		_, err := doc.UpdateToStateJSON([]byte(target))
+		if !errors.Is(err, ErrRootNotObject) {
+			t.Errorf("%s: expected ErrRootNotObject, got %v", target, err)
+			continue
+		}
+		if want := "document root must be a JSON object, got " + kind; !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected the error to contain %q, got %q", target, want, err)
+		}
+	}
+
+	for _, value := range []interface{}{[]interface{}{1.0}, "text", 42.0} {
+		for _, operation := range []string{"replace", "add"} {
+			patch, err := NewPatchList([]jsonpatch.JSONPatch{{Operation: operation, Path: "", Value: value}})
+			if err != nil {
+				t.Fatalf("NewPatchList failed: %v", err)
+			}
+			if err := doc.ApplyOperations(patch); !errors.Is(err, ErrRootNotObject) {
+				t.Errorf("%s of the root with %v: expected ErrRootNotObject, got %v", operation, value, err)
+			}
+		}
+	}
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"a": 1.0}; !compareMaps(got, expected) {
+		t.Errorf("expected the document to be unchanged, got %v", got)
+	}
+}
+
+func TestNewBareDoc(t *testing.T) {
+	source, err := NewDocFromJSON(map[string]interface{}{"name": "x", "list": []interface{}{1.0}})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer source.Destroy()
+
+	doc := NewBareDoc()
+	defer doc.Destroy()
+	if _, err := doc.ToJSON(); err == nil {
+		t.Error("expected ToJSON to fail before a root was applied")
+	}
+	if _, err := doc.EncodeFull(); err != nil {
+		t.Errorf("EncodeFull failed on a bare document: %v", err)
+	}
+
+	if err := doc.ApplyStateVector(mustEncodeFull(t, source)); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	if err := doc.Set("/name", "y"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"name": "y", "list": []interface{}{1.0}}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if err := source.ApplyStateVector(mustEncodeFull(t, doc)); err != nil {
+		t.Fatalf("ApplyStateVector back to the source failed: %v", err)
+	}
+	if got, err := source.ToJSON(); err != nil || !compareMaps(got, expected) {
+		t.Errorf("expected the source to converge to %v, got %v (err %v)", expected, got, err)
+	}
+}
+
+func TestAcquireRelease(t *testing.T) {
+	doc := NewDoc()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		doc.Acquire()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer doc.Release()
+		}()
+	}
+	wg.Wait()
+
+	// Only the creator's reference is left, so the document is still alive.
+	if _, err := doc.UpdateToState(map[string]interface{}{"alive": true}); err != nil {
+		t.Fatalf("UpdateToState failed after balanced Acquire/Release: %v", err)
+	}
+	if refs := doc.refs.Load(); refs != 0 {
+		t.Fatalf("expected no extra references, got %d", refs)
+	}
+
+	doc.Release()
+	if refs := doc.refs.Load(); refs != -1 {
+		t.Errorf("expected the final Release to destroy the document, refs = %d", refs)
+	}
+}
+
+func TestArenaAllocations(t *testing.T) {
+	var allocations []cAllocation
+	small := cString(&allocations, "abc")
+	large := cAlloc(&allocations, maxArenaBlock)
+	next := cString(&allocations, "def")
+	defer func() { freeAllocations(allocations) }()
+
+	if small == nil || large == nil || next == nil {
+		t.Fatal("allocation failed")
+	}
+	if len(allocations) != 2 || allocations[1].kind != "arena" {
+		t.Fatalf("expected a large allocation followed by the arena block, got %+v", allocations)
+	}
+	if got := unsafe.Slice((*byte)(unsafe.Pointer(small)), 4); string(got) != "abc\x00" {
+		t.Errorf("unexpected C string %q", got)
+	}
+	if got := unsafe.Slice((*byte)(unsafe.Pointer(next)), 4); string(got) != "def\x00" {
+		t.Errorf("unexpected C string %q", got)
+	}
+
+	// A YInput array larger than the current block gets a block that holds it.
+	var fresh []cAllocation
+	if cString(&fresh, "abc") == nil {
+		t.Fatal("allocation failed")
+	}
+	items := make([]interface{}, 200)
+	for i := range items {
+		items[i] = i
+	}
+	if _, err := buildYInputRecursive(items, &fresh); err != nil {
+		t.Fatalf("buildYInputRecursive failed: %v", err)
+	}
+	for _, block := range fresh {
+		if block.kind == "arena" && block.used > block.size {
+			t.Errorf("arena block of %d bytes handed out %d", block.size, block.used)
+		}
+	}
+	freeAllocations(fresh)
+
+	// Values spanning several arena blocks, plus an oversized string, round-trip intact.
+	big := strings.Repeat("x", maxArenaBlock)
+	state := map[string]interface{}{"big": big}
+	for i := 0; i < 5000; i++ {
+		state["k"+strconv.Itoa(i)] = []interface{}{i, "v" + strconv.Itoa(i), map[string]interface{}{"n": i}}
+	}
+	doc, err := NewDocFromJSON(state)
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(got, state) {
+		t.Error("document does not match the input state")
+	}
+}
+
+func TestApplyStateVectorEmptyAndTruncated(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": "b"})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	if err := doc.ApplyStateVector(nil); err != nil {
+		t.Errorf("expected nil input to be a no-op, got %v", err)
+	}
+	if err := doc.ApplyStateVector([]byte{}); err != nil {
+		t.Errorf("expected empty input to be a no-op, got %v", err)
+	}
+	err = doc.ApplyStateVector([]byte{0})
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("expected a truncation error, got %v", err)
+	}
+
+	state, _ := doc.ToJSON()
+	if !compareMaps(state, map[string]interface{}{"a": "b"}) {
+		t.Errorf("document changed: %v", state)
+	}
+}
+
+func TestApplyUpdateMerges(t *testing.T) {
+	first, err := NewDocFromJSON(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer first.Destroy()
+	second, err := NewDocFromJSON(map[string]interface{}{"b": 2.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer second.Destroy()
+
+	doc := NewDoc()
+	defer doc.Destroy()
+	for _, update := range [][]byte{mustEncodeFull(t, first), mustEncodeFull(t, second)} {
+		if err := doc.ApplyUpdate(update); err != nil {
+			t.Fatalf("ApplyUpdate failed: %v", err)
+		}
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"a": 1.0, "b": 2.0}; !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestConcurrentReadersAndWriter(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"counter": 0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	const readers, iterations = 8, 200
+	var wg sync.WaitGroup
+	errs := make(chan error, readers+1)
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				if _, err := doc.ToJSON(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= iterations; i++ {
+			if err := doc.Set("/counter", i); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	state, _ := doc.ToJSON()
+	if state["counter"] != int64(iterations) {
+		t.Errorf("expected counter %d, got %v", iterations, state["counter"])
+	}
+}
+
+func TestConcurrentMixedAccess(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	peer, err := NewDocFromJSON(map[string]interface{}{"peer": "hello"})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer peer.Destroy()
+	update := mustEncodeFull(t, peer)
+
+	const goroutines, iterations = 50, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				var err error
+				switch (g + i) % 5 {
+				case 0:
+					var patch jsonpatch.JSONPatchList
+					patch, err = NewPatchList([]jsonpatch.JSONPatch{{Operation: "add", Path: fmt.Sprintf("/g%d", g), Value: i}})
+					if err == nil {
+						err = doc.ApplyOperations(patch)
+					}
+				case 1:
+					err = doc.ApplyUpdate(update)
+				case 2:
+					_, err = doc.ToJSON()
+				case 3:
+					_, err = doc.EncodeStateAsUpdate()
+				case 4:
+					_, err = doc.EncodeStateVector()
+				}
+				if err != nil {
+					errs <- fmt.Errorf("goroutine %d, iteration %d: %w", g, i, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if state["peer"] != "hello" {
+		t.Errorf("expected the peer's update to be merged, got %v", state)
+	}
+}
+
+func TestConcurrentSettersAndWrites(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"list": []interface{}{}})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	// Run with -race: the setters must not race with the checks done by writes.
+	const goroutines, iterations = 8, 20
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				switch (g + i) % 5 {
+				case 0:
+					doc.SetArrayLimit("/list", ArrayLimit{Max: 10, DropOldest: true})
+				case 1:
+					doc.SetSizeWatermark(1<<20, func(int) {})
+				case 2:
+					doc.SetInputLimits(InputLimits{MaxDepth: 8})
+				case 3:
+					doc.OnEmptyStateChange(func(bool) {})
+				case 4:
+					if err := doc.Set("/list/-", i); err != nil {
+						t.Errorf("Set failed: %v", err)
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestApplyOperationsAtomic(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"name":  "a",
+		"items": []interface{}{"x"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	before, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	sv, err := doc.EncodeStateVector()
+	if err != nil {
+		t.Fatalf("EncodeStateVector failed: %v", err)
+	}
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "replace", Path: "/name", Value: "b"},
+		{Operation: "add", Path: "/items/1", Value: "y"},
+		{Operation: "remove", Path: "/items/5"},
+		{Operation: "add", Path: "/extra", Value: true},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err == nil {
+		t.Fatal("expected an error removing an out-of-bounds index")
+	}
+
+	after, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(after, before) {
+		t.Errorf("expected the document to be unchanged as %v, got %v", before, after)
+	}
+	svAfter, err := doc.EncodeStateVector()
+	if err != nil {
+		t.Fatalf("EncodeStateVector failed: %v", err)
+	}
+	if !bytes.Equal(sv, svAfter) {
+		t.Error("expected a failed patch to record no changes")
+	}
+}
+
+func TestAllowReplaceAppend(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"list": []interface{}{"a", "b"}})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{{Operation: "replace", Path: "/list/2", Value: "c"}})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+
+	if err := doc.ApplyOperations(patch); err == nil {
+		t.Error("expected strict ApplyOperations to reject replace at the array length")
+	}
+	if err := doc.ApplyOperationsWithOptions(patch, ApplyOptions{AllowReplaceAppend: true}); err != nil {
+		t.Fatalf("ApplyOperationsWithOptions failed: %v", err)
+	}
+	state, _ := doc.ToJSON()
+	if !compareMaps(state, map[string]interface{}{"list": []interface{}{"a", "b", "c"}}) {
+		t.Errorf("unexpected state %v", state)
+	}
+
+	beyond, _ := NewPatchList([]jsonpatch.JSONPatch{{Operation: "replace", Path: "/list/4", Value: "x"}})
+	if err := doc.ApplyOperationsWithOptions(beyond, ApplyOptions{AllowReplaceAppend: true}); err == nil {
+		t.Error("expected replace past the array length to still fail")
+	}
+}
+
+type testMoney struct {
+	cents    int64
+	currency string
+}
+
+func (m testMoney) MarshalY() (interface{}, error) {
+	if m.currency == "" {
+		return nil, fmt.Errorf("missing currency")
+	}
+	return map[string]interface{}{"cents": m.cents, "currency": m.currency}, nil
+}
+
+func TestYMarshaler(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	state := map[string]interface{}{
+		"price":   testMoney{cents: 1999, currency: "EUR"},
+		"history": []interface{}{&testMoney{cents: 5, currency: "USD"}},
+	}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	got, _ := doc.ToJSON()
+	expected := map[string]interface{}{
+		"price":   map[string]interface{}{"cents": 1999, "currency": "EUR"},
+		"history": []interface{}{map[string]interface{}{"cents": 5, "currency": "USD"}},
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Diffing against the marshaled form means an unchanged state needs no operations.
+	patch, err := doc.UpdateToState(state)
+	if err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if patch.Len() != 0 {
+		t.Errorf("expected no operations for an unchanged state, got %v", patch.List())
+	}
+
+	if err := doc.Set("/bad", testMoney{cents: 1}); err == nil || !strings.Contains(err.Error(), "missing currency") {
+		t.Errorf("expected the MarshalY error, got %v", err)
+	}
+}
+
+func TestNonFiniteFloats(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	for _, value := range []interface{}{math.NaN(), math.Inf(1), float32(math.Inf(-1))} {
+		err := doc.Set("/nested", map[string]interface{}{"x": []interface{}{value}})
+		if !errors.Is(err, ErrNonFiniteFloat) {
+			t.Errorf("Set with %v: expected ErrNonFiniteFloat, got %v", value, err)
+		} else if !strings.Contains(err.Error(), "/nested") {
+			t.Errorf("expected the error to name the path, got %v", err)
+		}
+		if err := doc.Set("/a", value); !errors.Is(err, ErrNonFiniteFloat) {
+			t.Errorf("Set with %v: expected ErrNonFiniteFloat, got %v", value, err)
+		}
+		if _, err := NewDocFromJSON(map[string]interface{}{"b": value}); !errors.Is(err, ErrNonFiniteFloat) {
+			t.Errorf("NewDocFromJSON with %v: expected ErrNonFiniteFloat, got %v", value, err)
+		}
+	}
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"a": 1.0}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestApplyUpdates(t *testing.T) {
+	peer := NewDoc()
+	defer peer.Destroy()
+	var updates [][]byte
+	for _, key := range []string{"/a", "/b", "/c"} {
+		sv, err := peer.EncodeStateVector()
+		if err != nil {
+			t.Fatalf("EncodeStateVector failed: %v", err)
+		}
+		if err := peer.Set(key, key); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		update, err := peer.EncodeDiff(sv)
+		if err != nil {
+			t.Fatalf("EncodeDiff failed: %v", err)
+		}
+		updates = append(updates, update)
+	}
+
+	doc := NewDoc()
+	defer doc.Destroy()
+	var calls [][]string
+	stop, err := doc.Observe(func(changedPaths []string) {
+		sort.Strings(changedPaths)
+		calls = append(calls, changedPaths)
+	})
+	if err != nil {
+		t.Fatalf("Observe failed: %v", err)
+	}
+	defer stop()
+
+	if err := doc.ApplyUpdates(append(updates, nil)); err != nil {
+		t.Fatalf("ApplyUpdates failed: %v", err)
+	}
+	if expected := [][]string{{"/a", "/b", "/c"}}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected one coalesced change %v, got %v", expected, calls)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"a": "/a", "b": "/b", "c": "/c"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	err = doc.ApplyUpdates([][]byte{updates[0], {0xff, 0xff, 0xff}, updates[2]})
+	if err == nil || !strings.Contains(err.Error(), "update 1:") {
+		t.Errorf("expected an error naming update 1, got %v", err)
+	}
+}
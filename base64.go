@@ -0,0 +1,34 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// ExportUpdateBase64 returns the document as a full v1 update, as EncodeFull does, encoded
+// as standard base64 with padding. It is the textual form JavaScript clients decode with
+// lib0's fromBase64 before passing the bytes to Y.applyUpdate, e.g. to seed a document
+// that a browser persists with y-indexeddb.
+func (d *Doc) ExportUpdateBase64() (string, error) {
+	update, err := d.encodeStateDiff(nil)
+	if err != nil {
+		return "", fmt.Errorf("ExportUpdateBase64: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(update), nil
+}
+
+// ImportUpdateBase64 creates a Doc from s, a v1 update in standard base64 as returned by
+// ExportUpdateBase64. Malformed base64 is rejected before anything is passed to Yrs.
+func ImportUpdateBase64(s string) (*Doc, error) {
+	update, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("ImportUpdateBase64: invalid base64: %w", err)
+	}
+	doc, err := NewDocFromStateVector(update)
+	if err != nil {
+		return nil, fmt.Errorf("ImportUpdateBase64: %w", err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,57 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateBase64RoundTrip(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"title": "hello",
+		"tags":  []interface{}{"a", "b"},
+		"meta":  map[string]interface{}{"n": 1.0},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	encoded, err := doc.ExportUpdateBase64()
+	if err != nil {
+		t.Fatalf("ExportUpdateBase64 failed: %v", err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("DecodeString failed: %v", err)
+	}
+	if !bytes.Equal(decoded, mustEncodeFull(t, doc)) {
+		t.Error("expected the base64 to hold the bytes of EncodeFull")
+	}
+
+	imported, err := ImportUpdateBase64(encoded)
+	if err != nil {
+		t.Fatalf("ImportUpdateBase64 failed: %v", err)
+	}
+	defer imported.Destroy()
+	if !bytes.Equal(mustEncodeFull(t, imported), decoded) {
+		t.Error("expected the imported document to encode to the same bytes")
+	}
+	want, _ := doc.ToJSON()
+	got, err := imported.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, s := range []string{"not base64!", encoded[:len(encoded)-1]} {
+		if _, err := ImportUpdateBase64(s); err == nil {
+			t.Errorf("ImportUpdateBase64(%q): expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// errTxnClosed is returned by the methods of a Txn used after its batch returned.
+var errTxnClosed = errors.New("transaction is closed: Txn used outside of its Batch")
+
+// Txn is the write transaction of a Batch. Its methods work like the Doc methods of the
+// same names, but their changes are committed together when the batch ends. A Txn is
+// only valid inside the function passed to Batch and must not be shared with other
+// goroutines.
+type Txn struct {
+	doc        *Doc
+	txn        *C.YTransaction
+	rootBranch *C.Branch
+	// applied lists the patches applied by ApplyOperations and UpdateToState, which are
+	// passed to the audit sink once the batch is committed.
+	applied []jsonpatch.JSONPatchList
+	// updated is set by UpdateToState, after which the defaults are reapplied.
+	updated bool
+}
+
+// Batch calls fn with a write transaction, committing everything fn writes through it as
+// a single transaction once fn returns: peers receive one update, and observers,
+// watchers and the other write hooks run once, for the batch as a whole. Writing many
+// values this way is also much cheaper than making a separate call, and so a separate
+// transaction, for each.
+//
+// The Doc is locked for writing while fn runs, so fn must write through tx and must not
+// call the Doc's own methods, which would deadlock. Each ApplyOperations on tx is
+// all-or-nothing as usual, but Yrs cannot roll back a transaction, so if fn returns an
+// error, the changes it made before are committed all the same; the error is returned.
+func (d *Doc) Batch(fn func(tx *Txn) error) error {
+	tx := &Txn{doc: d}
+	err := d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		tx.txn, tx.rootBranch = txn, rootBranch
+		defer func() { tx.txn, tx.rootBranch = nil, nil }()
+		return fn(tx)
+	})
+	for _, patch := range tx.applied {
+		d.audit(patch, "")
+	}
+	if err != nil {
+		return fmt.Errorf("Batch: %w", err)
+	}
+	if tx.updated {
+		if _, err := d.applyDefaults(); err != nil {
+			return fmt.Errorf("Batch: defaults: %w", err)
+		}
+	}
+	return nil
+}
+
+// Set stores value at path, as Doc.Set does.
+func (tx *Txn) Set(path string, value interface{}) error {
+	if tx.txn == nil {
+		return fmt.Errorf("Set: %w", errTxnClosed)
+	}
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+	if err := tx.doc.setTxn(tx.txn, tx.rootBranch, pathSegments, tx.doc.encodeValues(value)); err != nil {
+		return fmt.Errorf("Set %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyOperations applies patchList, as Doc.ApplyOperations does.
+func (tx *Txn) ApplyOperations(patchList jsonpatch.JSONPatchList) error {
+	if tx.txn == nil {
+		return fmt.Errorf("ApplyOperations: %w", errTxnClosed)
+	}
+	if err := tx.doc.applyOperationsTxn(context.Background(), tx.txn, tx.rootBranch, patchList, ApplyOptions{}); err != nil {
+		return err
+	}
+	tx.applied = append(tx.applied, patchList)
+	return nil
+}
+
+// UpdateToState brings the document to newState, as Doc.UpdateToState does, diffing
+// against the document as left by the earlier writes of the batch. The defaults set with
+// SetDefaults are reapplied once the batch is committed, in a transaction of their own.
+func (tx *Txn) UpdateToState(newState map[string]interface{}) (jsonpatch.JSONPatchList, error) {
+	if tx.txn == nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("UpdateToState: %w", errTxnClosed)
+	}
+	encoded := tx.doc.encodeValues(newState)
+	if err := checkRootObject(encoded); err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("UpdateToState: %w", err)
+	}
+	newState = encoded.(map[string]interface{})
+
+	currentState, err := tx.ToJSON()
+	if err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("failed to get current state: %w", err)
+	}
+
+	patch, err := diffStates(newState, currentState)
+	if err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("failed to create JSON patch: %w", err)
+	}
+	if err := tx.doc.applyOperationsTxn(context.Background(), tx.txn, tx.rootBranch, patch, ApplyOptions{}); err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("failed to apply JSON patch operations: %w", err)
+	}
+	tx.applied = append(tx.applied, patch)
+	tx.updated = true
+	return patch, nil
+}
+
+// ToJSON returns the document as left by the writes of the batch so far, as Doc.ToJSON
+// does.
+func (tx *Txn) ToJSON() (map[string]interface{}, error) {
+	if tx.txn == nil {
+		return nil, fmt.Errorf("ToJSON: %w", errTxnClosed)
+	}
+	if tx.doc.arrayRoot {
+		return nil, errors.New("document root is an array")
+	}
+	value, err := branchValue(tx.txn, tx.rootBranch, ReadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	result, _ := value.(map[string]interface{})
+	if result == nil {
+		return make(map[string]interface{}), nil
+	}
+	return result, nil
+}
@@ -0,0 +1,95 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestBatch(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	calls := 0
+	stop, err := doc.Observe(func([]string) { calls++ })
+	if err != nil {
+		t.Fatalf("Observe failed: %v", err)
+	}
+	defer stop()
+
+	var leaked *Txn
+	err = doc.Batch(func(tx *Txn) error {
+		leaked = tx
+		if err := tx.Set("/list", []interface{}{}); err != nil {
+			return err
+		}
+		// Later writes see the earlier ones.
+		patch, err := NewPatchList([]jsonpatch.JSONPatch{
+			{Operation: "add", Path: "/list/-", Value: "x"},
+			{Operation: "add", Path: "/list/-", Value: "y"},
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.ApplyOperations(patch); err != nil {
+			return err
+		}
+		state, err := tx.ToJSON()
+		if err != nil {
+			return err
+		}
+		state["b"] = true
+		delete(state, "a")
+		_, err = tx.UpdateToState(state)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"list": []interface{}{"x", "y"}, "b": true}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if calls != 1 {
+		t.Errorf("expected the batch to be observed once, got %d calls", calls)
+	}
+	if err := leaked.Set("/c", 1.0); err == nil {
+		t.Error("expected an error using a Txn after its batch")
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	failure := errors.New("stop")
+	err := doc.Batch(func(tx *Txn) error {
+		if err := tx.Set("/a", 1.0); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+	// Yrs cannot roll back, so earlier writes are committed.
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"a": 1.0}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
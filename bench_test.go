@@ -0,0 +1,204 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func BenchmarkMapInsert100k(b *testing.B) {
+	state := make(map[string]interface{}, 100000)
+	for i := 0; i < 100000; i++ {
+		state[fmt.Sprintf("key_%d", i)] = fmt.Sprintf("value %d", i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		doc, err := NewDocFromJSON(map[string]interface{}{"bulk": state})
+		if err != nil {
+			b.Fatalf("NewDocFromJSON failed: %v", err)
+		}
+		doc.Destroy()
+	}
+}
+
+func BenchmarkBuildYInput100k(b *testing.B) {
+	state := make(map[string]interface{}, 100000)
+	for i := 0; i < 100000; i++ {
+		state[fmt.Sprintf("key_%d", i)] = fmt.Sprintf("value %d", i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var allocations []cAllocation
+		if _, err := buildYInputRecursive(state, &allocations); err != nil {
+			b.Fatalf("buildYInputRecursive failed: %v", err)
+		}
+		freeAllocations(allocations)
+	}
+}
+
+// nestedValue returns a tree of maps depth levels deep, each with fanout children and a
+// short array, for benchmarking inputs with many small nodes.
+func nestedValue(depth, fanout int) interface{} {
+	if depth == 0 {
+		return "leaf"
+	}
+	m := map[string]interface{}{"list": []interface{}{1, "two", 3.0}}
+	for i := 0; i < fanout; i++ {
+		m[fmt.Sprintf("child_%d", i)] = nestedValue(depth-1, fanout)
+	}
+	return m
+}
+
+// BenchmarkBuildYInputNested builds a deeply nested input with thousands of maps,
+// arrays and keys, each of which needs C memory. mallocs/op counts the arena blocks
+// actually allocated: once the pool is warm, the blocks of the previous iteration are
+// reused and it drops to zero.
+func BenchmarkBuildYInputNested(b *testing.B) {
+	value := nestedValue(6, 4)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	before := arenaPool.mallocs.Load()
+	for i := 0; i < b.N; i++ {
+		var allocations []cAllocation
+		if _, err := buildYInputRecursive(value, &allocations); err != nil {
+			b.Fatalf("buildYInputRecursive failed: %v", err)
+		}
+		freeAllocations(allocations)
+	}
+	b.ReportMetric(float64(arenaPool.mallocs.Load()-before)/float64(b.N), "mallocs/op")
+}
+
+// BenchmarkBuildScalarSlice compares building the YInput for slices of scalars through
+// the general path, with every element boxed in an interface{}, against the typed
+// slices that buildScalarSlice handles.
+func BenchmarkBuildScalarSlice(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		ints := make([]int64, n)
+		strs := make([]string, n)
+		floats := make([]float64, n)
+		boxedInts := make([]interface{}, n)
+		boxedStrs := make([]interface{}, n)
+		boxedFloats := make([]interface{}, n)
+		for i := 0; i < n; i++ {
+			ints[i] = int64(i)
+			strs[i] = fmt.Sprintf("value %d", i)
+			floats[i] = float64(i) / 3
+			boxedInts[i], boxedStrs[i], boxedFloats[i] = ints[i], strs[i], floats[i]
+		}
+		for _, c := range []struct {
+			name  string
+			value interface{}
+		}{
+			{"generic/int64", boxedInts},
+			{"typed/int64", ints},
+			{"generic/string", boxedStrs},
+			{"typed/string", strs},
+			{"generic/float64", boxedFloats},
+			{"typed/float64", floats},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", c.name, n), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					var allocations []cAllocation
+					if _, err := buildYInputRecursive(c.value, &allocations); err != nil {
+						b.Fatalf("buildYInputRecursive failed: %v", err)
+					}
+					freeAllocations(allocations)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkConcurrentReaders runs ToJSON from GOMAXPROCS goroutines while one writer
+// updates the document every millisecond.
+func BenchmarkConcurrentReaders(b *testing.B) {
+	state := make(map[string]interface{}, 1000)
+	for i := 0; i < 1000; i++ {
+		state[fmt.Sprintf("key_%d", i)] = fmt.Sprintf("value %d", i)
+	}
+	doc, err := NewDocFromJSON(state)
+	if err != nil {
+		b.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	var stop atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; !stop.Load(); i++ {
+			if err := doc.Set("/counter", i); err != nil {
+				b.Errorf("Set failed: %v", err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := doc.ToJSON(); err != nil {
+				b.Errorf("ToJSON failed: %v", err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	stop.Store(true)
+	<-done
+}
+
+// BenchmarkSerialize compares the ways of turning a document of about 10MB into JSON
+// bytes for a response.
+func BenchmarkSerialize(b *testing.B) {
+	state := make(map[string]interface{}, 100000)
+	for i := 0; i < 100000; i++ {
+		state[fmt.Sprintf("key_%d", i)] = fmt.Sprintf("value %d with some padding to grow the document %d", i, i)
+	}
+	doc, err := NewDocFromJSON(state)
+	if err != nil {
+		b.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	b.Run("ToJSON+Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			value, err := doc.ToJSON()
+			if err != nil {
+				b.Fatalf("ToJSON failed: %v", err)
+			}
+			if _, err := json.Marshal(value); err != nil {
+				b.Fatalf("Marshal failed: %v", err)
+			}
+		}
+	})
+	b.Run("ToJSONBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := doc.ToJSONBytes(); err != nil {
+				b.Fatalf("ToJSONBytes failed: %v", err)
+			}
+		}
+	})
+	b.Run("WriteJSON", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := doc.WriteJSON(io.Discard); err != nil {
+				b.Fatalf("WriteJSON failed: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,120 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"fmt"
+	"math/big"
+)
+
+// bigNumberString returns the decimal string a big.Int or big.Float (or a non-nil pointer
+// to one) is stored as. ok is false for any other value.
+func bigNumberString(value interface{}) (s string, ok bool) {
+	switch v := value.(type) {
+	case *big.Int:
+		if v != nil {
+			return v.String(), true
+		}
+	case big.Int:
+		return v.String(), true
+	case *big.Float:
+		if v != nil {
+			return bigFloatString(v), true
+		}
+	case big.Float:
+		return bigFloatString(&v), true
+	}
+	return "", false
+}
+
+// bigFloatString formats x as a decimal with exactly as many fraction digits as its
+// binary mantissa needs, so unlike Text('g', -1) the string denotes x exactly rather
+// than just the shortest decimal that rounds back to x at x's precision.
+func bigFloatString(x *big.Float) string {
+	if x.IsInf() || x.Sign() == 0 {
+		return x.Text('f', 0)
+	}
+	// x = m * 2^shift for an odd integer m.
+	shift := x.MantExp(nil) - int(x.MinPrec())
+	if shift >= 0 {
+		return x.Text('f', 0)
+	}
+	// 2^-n has exactly n fraction digits in decimal.
+	return x.Text('f', -shift)
+}
+
+// outputString reads a string from output.
+func outputString(output *C.YOutput) (string, error) {
+	if output.tag != C.Y_JSON_STR {
+		return "", fmt.Errorf("value is not a string (tag: %d)", output.tag)
+	}
+	return C.GoString(C.youtput_read_string(output)), nil
+}
+
+// GetBigInt reads the big.Int stored at path. big.Int values are stored as their
+// decimal string, which GetBigInt parses back; integers stored as int64 are accepted as
+// well.
+func (d *Doc) GetBigInt(path string) (*big.Int, error) {
+	var value *big.Int
+	err := d.readOutput(path, func(txn *C.YTransaction, output *C.YOutput) error {
+		if output.tag == C.Y_JSON_INT {
+			value = big.NewInt(int64(*C.youtput_read_long(output)))
+			return nil
+		}
+		s, err := outputString(output)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if value, ok = new(big.Int).SetString(s, 10); !ok {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetBigInt %s: %w", path, err)
+	}
+	return value, nil
+}
+
+// GetBigFloat reads the big.Float stored at path. big.Float values are stored as an
+// exact decimal string, so the value read back is exactly the one stored. Its precision
+// is the larger of 64 and the bits needed to hold the value, which may differ from the
+// precision of the stored big.Float. Numbers stored as int64 or float64 are accepted as
+// well.
+func (d *Doc) GetBigFloat(path string) (*big.Float, error) {
+	var value *big.Float
+	err := d.readOutput(path, func(txn *C.YTransaction, output *C.YOutput) error {
+		switch output.tag {
+		case C.Y_JSON_INT:
+			value = new(big.Float).SetInt64(int64(*C.youtput_read_long(output)))
+			return nil
+		case C.Y_JSON_NUM:
+			value = big.NewFloat(float64(*C.youtput_read_float(output)))
+			return nil
+		}
+		s, err := outputString(output)
+		if err != nil {
+			return err
+		}
+		// Each decimal digit needs under 4 bits, so this precision holds s exactly.
+		value, _, err = big.ParseFloat(s, 10, uint(4*len(s)+64), big.ToNearestEven)
+		if err != nil {
+			return err
+		}
+		if prec := value.MinPrec(); prec > 64 {
+			value.SetPrec(prec)
+		} else {
+			value.SetPrec(64)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetBigFloat %s: %w", path, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,59 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestBigNumbersRoundTrip(t *testing.T) {
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890123456789", 10)
+	third := new(big.Float).SetPrec(200).Quo(big.NewFloat(1), big.NewFloat(3))
+	tenth := new(big.Float).SetPrec(53).SetFloat64(0.1)
+	large := new(big.Float).SetPrec(100).SetMantExp(big.NewFloat(1.5), 300)
+	inf := new(big.Float).SetInf(true)
+
+	doc := NewDoc()
+	defer doc.Destroy()
+	state := map[string]interface{}{
+		"int":   huge,
+		"third": third,
+		"tenth": *tenth,
+		"large": large,
+		"inf":   inf,
+		"long":  int64(42),
+	}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if patch, err := doc.UpdateToState(state); err != nil || !patch.Empty() {
+		t.Errorf("expected no changes when updating to the same state, got %v (err %v)", patch, err)
+	}
+
+	gotInt, err := doc.GetBigInt("/int")
+	if err != nil {
+		t.Fatalf("GetBigInt failed: %v", err)
+	}
+	if gotInt.Cmp(huge) != 0 {
+		t.Errorf("expected %v, got %v", huge, gotInt)
+	}
+	if got, err := doc.GetBigInt("/long"); err != nil || got.Int64() != 42 {
+		t.Errorf("expected 42 from an int64, got %v (err %v)", got, err)
+	}
+
+	for path, want := range map[string]*big.Float{"/third": third, "/tenth": tenth, "/large": large, "/inf": inf} {
+		got, err := doc.GetBigFloat(path)
+		if err != nil {
+			t.Errorf("GetBigFloat %s failed: %v", path, err)
+			continue
+		}
+		if got.Cmp(want) != 0 {
+			t.Errorf("GetBigFloat %s: expected %s, got %s", path, want.Text('g', -1), got.Text('g', -1))
+		}
+	}
+
+	if _, err := doc.GetBigInt("/third"); err == nil {
+		t.Error("expected an error reading a fraction as a big.Int")
+	}
+}
@@ -0,0 +1,81 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+)
+
+// binding is a value kept in sync with the document by Bind.
+type binding struct {
+	target reflect.Value
+}
+
+// Bind populates v, which must be a non-nil pointer, from the document and keeps it up
+// to date: after every write to the document, local or applied with ApplyUpdate,
+// the current state is decoded into v with encoding/json, as by ReadInto. The returned
+// stop ends the binding; calling it more than once is harmless.
+//
+// v is refreshed synchronously by the goroutine that made the write, after the write has
+// been committed and before the write method returns, and refreshes of all bindings of a
+// Doc are serialized. v is only written through a complete decode: if the state cannot
+// be decoded into v's type, v keeps its previous value. Reading v is therefore safe on
+// the goroutine that writes the document; readers on any other goroutine must hold a
+// lock of their own around both their reads of v and the document writes.
+func (d *Doc) Bind(v interface{}) (stop func()) {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		panic("autosync: Bind requires a non-nil pointer")
+	}
+	b := &binding{target: target}
+
+	d.bindMu.Lock()
+	d.bindings = append(d.bindings, b)
+	if raw, err := d.ToJSONBytes(); err == nil {
+		b.refresh(raw)
+	}
+	d.bindMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.bindMu.Lock()
+			defer d.bindMu.Unlock()
+			for i, other := range d.bindings {
+				if other == b {
+					d.bindings = append(d.bindings[:i], d.bindings[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+}
+
+// refreshBindings decodes the current state into every bound value. It must be called
+// without holding d.mu.
+func (d *Doc) refreshBindings() {
+	d.bindMu.Lock()
+	defer d.bindMu.Unlock()
+	if len(d.bindings) == 0 {
+		return
+	}
+	raw, err := d.ToJSONBytes()
+	if err != nil {
+		return
+	}
+	for _, b := range d.bindings {
+		b.refresh(raw)
+	}
+}
+
+// refresh decodes raw into a new value of the bound type and, if that succeeds, stores
+// it in the target.
+func (b *binding) refresh(raw []byte) {
+	fresh := reflect.New(b.target.Elem().Type())
+	if err := json.Unmarshal(raw, fresh.Interface()); err != nil {
+		return
+	}
+	b.target.Elem().Set(fresh.Elem())
+}
@@ -0,0 +1,65 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+)
+
+func TestBind(t *testing.T) {
+	type settings struct {
+		Theme string `json:"theme"`
+		Size  int    `json:"size"`
+	}
+
+	doc, err := NewDocFromJSON(map[string]interface{}{"theme": "dark", "size": int64(12)})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	var view settings
+	stop := doc.Bind(&view)
+	if view != (settings{Theme: "dark", Size: 12}) {
+		t.Fatalf("expected Bind to populate the initial state, got %+v", view)
+	}
+
+	if err := doc.Set("/size", int64(14)); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if view.Size != 14 {
+		t.Errorf("expected a local write to refresh the view, got %+v", view)
+	}
+
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+	if err := peer.Set("/theme", "light"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.ApplyStateVector(mustEncodeFull(t, peer)); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	if view.Theme != "light" {
+		t.Errorf("expected a merged update to refresh the view, got %+v", view)
+	}
+
+	// A state that doesn't decode into the view leaves it unchanged.
+	if err := doc.Set("/size", "large"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if view != (settings{Theme: "light", Size: 14}) {
+		t.Errorf("expected an undecodable state to leave the view as is, got %+v", view)
+	}
+
+	stop()
+	stop()
+	if err := doc.Set("/theme", "blue"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if view.Theme != "light" {
+		t.Errorf("expected no refresh after stop, got %+v", view)
+	}
+}
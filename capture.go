@@ -0,0 +1,84 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdint.h>
+
+extern void goCaptureCallback(void*, uint32_t, char*);
+*/
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// captureDocs maps the *C.YDoc passed to goCaptureCallback back to its Doc, like
+// updateLogs does for the update log.
+var captureDocs sync.Map
+
+// ApplyOperationsCapture applies patchList like ApplyOperations and returns the v1 update
+// its transaction committed, ready to broadcast to peers, who apply it with
+// ApplyUpdate. The update is taken from Yrs as the transaction commits, so no
+// state vector diff or second encoding is needed. A patch list that changes nothing
+// yields a nil update.
+func (d *Doc) ApplyOperationsCapture(patchList jsonpatch.JSONPatchList) ([]byte, error) {
+	if err := d.startCapture(); err != nil {
+		return nil, fmt.Errorf("ApplyOperationsCapture: %w", err)
+	}
+	var update []byte
+	if err := d.applyOperations(context.Background(), patchList, ApplyOptions{}, &update); err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// startCapture subscribes to the document's updates on first use. The subscription stays
+// in place until Destroy and only records updates while d.capture is set.
+func (d *Doc) startCapture() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.captureSub != nil {
+		return nil
+	}
+	sub := C.ydoc_observe_updates_v1(d.yDoc, unsafe.Pointer(d.yDoc), (*[0]byte)(C.goCaptureCallback))
+	if sub == nil {
+		return errors.New("ydoc_observe_updates_v1 returned nil")
+	}
+	d.captureSub = sub
+	captureDocs.Store(unsafe.Pointer(d.yDoc), d)
+	return nil
+}
+
+// stopCapture removes the subscription made by startCapture. The caller must hold d.mu.
+func (d *Doc) stopCapture() {
+	if d.captureSub == nil {
+		return
+	}
+	C.yunobserve(d.captureSub)
+	captureDocs.Delete(unsafe.Pointer(d.yDoc))
+	d.captureSub = nil
+}
+
+// goCaptureCallback is called by Yrs while a transaction commits, which only happens
+// while the committing goroutine holds d.mu for writing.
+//
+//export goCaptureCallback
+func goCaptureCallback(state unsafe.Pointer, length C.uint32_t, data *C.char) {
+	value, ok := captureDocs.Load(state)
+	if !ok {
+		return
+	}
+	d := value.(*Doc)
+	if d.capture == nil {
+		return
+	}
+	*d.capture = C.GoBytes(unsafe.Pointer(data), C.int(length))
+}
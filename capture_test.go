@@ -0,0 +1,61 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestApplyOperationsCapture(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "replace", Path: "/a", Value: 2.0},
+		{Operation: "add", Path: "/b", Value: []interface{}{"x"}},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	update, err := doc.ApplyOperationsCapture(patch)
+	if err != nil {
+		t.Fatalf("ApplyOperationsCapture failed: %v", err)
+	}
+	if len(update) == 0 {
+		t.Fatal("expected a non-empty update")
+	}
+
+	if err := peer.ApplyStateVector(update); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	got, err := peer.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"a": 2.0, "b": []interface{}{"x"}}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected the peer to reach %v, got %v", expected, got)
+	}
+
+	// Later writes made without capturing must not leak into the next capture.
+	if err := doc.Set("/c", true); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	update, err = doc.ApplyOperationsCapture(jsonpatch.JSONPatchList{})
+	if err != nil {
+		t.Fatalf("ApplyOperationsCapture failed: %v", err)
+	}
+	if update != nil {
+		t.Errorf("expected no update for an empty patch list, got %d bytes", len(update))
+	}
+}
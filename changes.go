@@ -0,0 +1,44 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ChangedKeysSince returns, in sorted order, the top-level keys whose values differ
+// between checkpoint and the current document. checkpoint is a full-state encoding as
+// returned by EncodeFull or EncodeStateAsUpdate. Keys added or removed since the checkpoint
+// are included.
+func (d *Doc) ChangedKeysSince(checkpoint []byte) ([]string, error) {
+	previous, err := NewDocFromStateVector(checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("ChangedKeysSince: failed to load checkpoint: %w", err)
+	}
+	defer previous.Destroy()
+
+	before, err := previous.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("ChangedKeysSince: failed to read checkpoint: %w", err)
+	}
+	after, err := d.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("ChangedKeysSince: %w", err)
+	}
+
+	changed := []string{}
+	for key, value := range after {
+		if old, ok := before[key]; !ok || !reflect.DeepEqual(old, value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
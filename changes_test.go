@@ -0,0 +1,48 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangedKeysSince(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{
+		"title":   "draft",
+		"body":    map[string]interface{}{"text": "hello"},
+		"footer":  "same",
+		"removed": true,
+	}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	checkpoint, err := doc.EncodeFull()
+	if err != nil {
+		t.Fatalf("EncodeFull failed: %v", err)
+	}
+
+	if changed, err := doc.ChangedKeysSince(checkpoint); err != nil || len(changed) != 0 {
+		t.Errorf("expected no changes right after the checkpoint, got %v (err %v)", changed, err)
+	}
+
+	if _, err := doc.UpdateToState(map[string]interface{}{
+		"title":  "final",
+		"body":   map[string]interface{}{"text": "hello, world"},
+		"footer": "same",
+		"added":  1.0,
+	}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	changed, err := doc.ChangedKeysSince(checkpoint)
+	if err != nil {
+		t.Fatalf("ChangedKeysSince failed: %v", err)
+	}
+	expected := []string{"added", "body", "removed", "title"}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("expected %v, got %v", expected, changed)
+	}
+}
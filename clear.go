@@ -0,0 +1,68 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+)
+
+// Clear removes every key from the root map (every element from the root array, for
+// NewArrayDoc) in a single write transaction, without computing a diff as
+// UpdateToState with an empty state would. The root itself stays in place, so the
+// document can be written again right away. Clearing an empty document does nothing.
+func (d *Doc) Clear() error {
+	err := d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		return removeAll(txn, rootBranch)
+	})
+	if err != nil {
+		return fmt.Errorf("Clear: %w", err)
+	}
+	return nil
+}
+
+// RemoveAll removes every key from the map, or every element from the array, at path in
+// a single write transaction, leaving the emptied map or array in place. The empty path
+// addresses the root, as with Clear. A path to any other value is an error.
+func (d *Doc) RemoveAll(path string) error {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return fmt.Errorf("RemoveAll: %w", err)
+	}
+	err = d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		branch, outputs, err := resolveBranch(txn, rootBranch, pathSegments)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+		return removeAll(txn, branch)
+	})
+	if err != nil {
+		return fmt.Errorf("RemoveAll %s: %w", path, err)
+	}
+	return nil
+}
+
+// removeAll empties branch, a map or an array. An empty branch is left untouched, so no
+// update is produced.
+func removeAll(txn *C.YTransaction, branch *C.Branch) error {
+	switch C.ytype_kind(branch) {
+	case C.Y_ARRAY:
+		if n := C.yarray_len(branch); n > 0 {
+			tracef("yarray_remove_range(%p, 0, %d)", branch, n)
+			C.yarray_remove_range(branch, txn, 0, n)
+		}
+	case C.Y_MAP:
+		if C.ymap_len(branch, txn) > 0 {
+			tracef("ymap_remove_all(%p)", branch)
+			C.ymap_remove_all(branch, txn)
+		}
+	default:
+		return errors.New("value is not a map or an array")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClear(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"title": "draft",
+		"items": []interface{}{1.0, 2.0},
+		"meta":  map[string]interface{}{"a": true},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	if err := doc.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected an empty document, got %v", got)
+	}
+
+	doc.MarkClean()
+	if err := doc.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if doc.Dirty() {
+		t.Error("expected clearing an empty document to change nothing")
+	}
+
+	if err := doc.Set("/title", "again"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if got, err = doc.ToJSON(); err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"title": "again"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestClearArrayDoc(t *testing.T) {
+	doc := NewArrayDoc()
+	defer doc.Destroy()
+	if err := doc.SetArray("", []interface{}{"a", "b"}); err != nil {
+		t.Fatalf("SetArray failed: %v", err)
+	}
+
+	if err := doc.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	got, err := doc.ToJSONArray()
+	if err != nil {
+		t.Fatalf("ToJSONArray failed: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected an empty array, got %v", got)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	items := make([]interface{}, 100)
+	for i := range items {
+		items[i] = float64(i)
+	}
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"items": items,
+		"meta":  map[string]interface{}{"a": true, "b": "x"},
+		"title": "draft",
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	if err := doc.RemoveAll("/items"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if err := doc.RemoveAll("/meta"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"items": []interface{}{},
+		"meta":  map[string]interface{}{},
+		"title": "draft",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	for _, path := range []string{"/title", "/missing", "items"} {
+		if err := doc.RemoveAll(path); err == nil {
+			t.Errorf("RemoveAll(%q): expected an error", path)
+		}
+	}
+}
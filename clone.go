@@ -0,0 +1,72 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"maps"
+	"unsafe"
+)
+
+// Clone returns an independent copy of the document, with its own Yrs document, for
+// running speculative changes without touching d. The copy has the same content, root
+// layout, collections and XML fragments, and the text fields and array limits that shape
+// how ApplyOperations writes; callbacks, watchers and sinks are not copied. It has a
+// client ID of its own, so its changes can later be merged back into d with
+// ApplyUpdate, or simply discarded by destroying it.
+func (d *Doc) Clone() (*Doc, error) {
+	clone := NewBareDoc()
+	var update []byte
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		var err error
+		update, err = encodeStateDiffTxn(txn, nil)
+		clone.rootName = d.rootName
+		clone.arrayRoot = d.arrayRoot
+		clone.bare = d.bare
+		clone.collections = maps.Clone(d.collections)
+		clone.xmlFragments = maps.Clone(d.xmlFragments)
+		clone.textFields = maps.Clone(d.textFields)
+		clone.arrayLimits = maps.Clone(d.arrayLimits)
+		return err
+	})
+	if err != nil {
+		clone.Destroy()
+		return nil, fmt.Errorf("Clone: %w", err)
+	}
+
+	if !clone.bare {
+		clone.defineRoot(clone.root(), clone.arrayRoot)
+	}
+	for name, kind := range clone.collections {
+		clone.defineRoot(name, kind == KindArray)
+	}
+	for name := range clone.xmlFragments {
+		nameC := C.CString(name)
+		C.yxmlfragment(clone.yDoc, nameC)
+		C.free(unsafe.Pointer(nameC))
+	}
+	if err := clone.ApplyUpdate(update); err != nil {
+		clone.Destroy()
+		return nil, fmt.Errorf("Clone: %w", err)
+	}
+	clone.MarkClean()
+	return clone, nil
+}
+
+// defineRoot creates the root type called name, as an array if isArray and otherwise a
+// map. The caller must hold d.mu for writing, or own d exclusively, with no transaction
+// open.
+func (d *Doc) defineRoot(name string, isArray bool) {
+	nameC := C.CString(name)
+	defer C.free(unsafe.Pointer(nameC))
+	if isArray {
+		C.yarray(d.yDoc, nameC)
+	} else {
+		C.ymap(d.yDoc, nameC)
+	}
+}
@@ -0,0 +1,68 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"title": "draft",
+		"tags":  []interface{}{"a"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	if err := doc.DefineArray("log"); err != nil {
+		t.Fatalf("DefineArray failed: %v", err)
+	}
+	before, err := doc.CollectionsToJSON()
+	if err != nil {
+		t.Fatalf("CollectionsToJSON failed: %v", err)
+	}
+
+	clone, err := doc.Clone()
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	defer clone.Destroy()
+	if clone.yDoc == doc.yDoc || clone.ClientID() == doc.ClientID() {
+		t.Fatal("expected the clone to have its own Yrs document and client ID")
+	}
+	got, err := clone.CollectionsToJSON()
+	if err != nil {
+		t.Fatalf("CollectionsToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, before) {
+		t.Errorf("expected the clone to hold %v, got %v", before, got)
+	}
+
+	if err := clone.Set("/title", "speculative"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := clone.SetArray("/tags", nil); err != nil {
+		t.Fatalf("SetArray failed: %v", err)
+	}
+	after, err := doc.CollectionsToJSON()
+	if err != nil {
+		t.Fatalf("CollectionsToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("expected the source to be unchanged as %v, got %v", before, after)
+	}
+
+	// The clone's changes can be merged back.
+	if err := doc.ApplyUpdate(mustEncodeFull(t, clone)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if state["title"] != "speculative" || len(state["tags"].([]interface{})) != 0 {
+		t.Errorf("expected the clone's changes after merging, got %v", state)
+	}
+}
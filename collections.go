@@ -0,0 +1,155 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// ErrUnknownCollection is returned for a collection name that was not defined with
+// DefineMap or DefineArray.
+var ErrUnknownCollection = errors.New("unknown collection")
+
+// DefineMap defines a root collection called name holding a map, alongside the
+// document's main root, so that unrelated data can live in one document without being
+// nested under a single map. Collections are read with CollectionsToJSON and written with
+// ApplyCollectionOperations; ToJSON and ApplyOperations only see the main root. Every
+// peer must define the same collections, with the same kinds, before reading them: a
+// collection received in an update has no type until it is defined. Defining a
+// collection again with the same kind does nothing.
+func (d *Doc) DefineMap(name string) error {
+	if err := d.defineCollection(name, KindMap); err != nil {
+		return fmt.Errorf("DefineMap %q: %w", name, err)
+	}
+	return nil
+}
+
+// DefineArray defines a root collection called name holding an array. See DefineMap.
+func (d *Doc) DefineArray(name string) error {
+	if err := d.defineCollection(name, KindArray); err != nil {
+		return fmt.Errorf("DefineArray %q: %w", name, err)
+	}
+	return nil
+}
+
+// defineCollection implements DefineMap and DefineArray.
+func (d *Doc) defineCollection(name string, kind Kind) error {
+	if name == "" || strings.IndexByte(name, 0) >= 0 {
+		return errors.New("collection name must be non-empty and free of NUL bytes")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if name == d.root() {
+		return errors.New("name is taken by the document's main root")
+	}
+	if _, ok := d.xmlFragments[name]; ok {
+		return errors.New("already defined as an XML fragment")
+	}
+	if existing, ok := d.collections[name]; ok {
+		if existing != kind {
+			return fmt.Errorf("already defined as %s", existing)
+		}
+		return nil
+	}
+
+	d.defineRoot(name, kind == KindArray)
+	if d.collections == nil {
+		d.collections = make(map[string]Kind)
+	}
+	d.collections[name] = kind
+	return nil
+}
+
+// CollectionsToJSON returns the document's main root and every collection defined with
+// DefineMap or DefineArray, keyed by name. The main root is listed under "root" (or the
+// root name of a document loaded with LoadYDoc). Values are read as by ToJSON.
+func (d *Doc) CollectionsToJSON() (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		names := []string{d.root()}
+		for name := range d.collections {
+			names = append(names, name)
+		}
+		for _, name := range names {
+			branch := collectionBranch(txn, name)
+			if branch == nil {
+				return fmt.Errorf("collection %q not found", name)
+			}
+			value, err := branchValue(txn, branch, ReadOptions{})
+			if err != nil {
+				return fmt.Errorf("collection %q: %w", name, err)
+			}
+			result[name] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CollectionsToJSON: %w", err)
+	}
+	return result, nil
+}
+
+// ApplyCollectionOperations applies patchList in a single transaction like
+// ApplyOperations, except that the first segment of every path names the collection it
+// applies to: "/users/alice" addresses key "alice" of the "users" collection, and
+// "/root/title" the main root's "title". Text fields, array limits and the other
+// behaviours configured on the main root are not applied.
+func (d *Doc) ApplyCollectionOperations(patchList jsonpatch.JSONPatchList) error {
+	err := d.write(func(txn *C.YTransaction, _ *C.Branch) error {
+		for _, op := range patchList.List() {
+			name, path, err := splitCollectionPath(op.Path)
+			if err != nil {
+				return fmt.Errorf("operation (%s %s): %w", op.Operation, op.Path, err)
+			}
+			if _, ok := d.collections[name]; !ok && name != d.root() {
+				return fmt.Errorf("operation (%s %s): %w %q", op.Operation, op.Path, ErrUnknownCollection, name)
+			}
+			branch := collectionBranch(txn, name)
+			if branch == nil {
+				return fmt.Errorf("operation (%s %s): collection %q not found", op.Operation, op.Path, name)
+			}
+			op.Path = path
+			op.Value = d.encodeValues(op.Value)
+			if err := applyOp(txn, branch, op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ApplyCollectionOperations: %w", err)
+	}
+	d.audit(patchList, "")
+	return nil
+}
+
+// splitCollectionPath splits a path used with ApplyCollectionOperations into the
+// collection name and the path within that collection.
+func splitCollectionPath(path string) (name, rest string, err error) {
+	if !strings.HasPrefix(path, "/") || len(path) == 1 {
+		return "", "", fmt.Errorf("path %q does not name a collection", path)
+	}
+	name, rest, found := strings.Cut(path[1:], "/")
+	if found {
+		rest = "/" + rest
+	}
+	return name, rest, nil
+}
+
+// collectionBranch returns the root type called name, or nil if the document has none.
+func collectionBranch(txn *C.YTransaction, name string) *C.Branch {
+	nameC := C.CString(name)
+	defer C.free(unsafe.Pointer(nameC))
+	return C.ytype_get(txn, nameC)
+}
@@ -0,0 +1,97 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestCollections(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"title": "board"})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	if err := doc.DefineMap("users"); err != nil {
+		t.Fatalf("DefineMap failed: %v", err)
+	}
+	if err := doc.DefineArray("log"); err != nil {
+		t.Fatalf("DefineArray failed: %v", err)
+	}
+	if err := doc.DefineMap("users"); err != nil {
+		t.Errorf("redefining with the same kind failed: %v", err)
+	}
+	if err := doc.DefineArray("users"); err == nil {
+		t.Error("expected an error redefining a map as an array")
+	}
+	if err := doc.DefineMap("root"); err == nil {
+		t.Error("expected an error defining the main root")
+	}
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/users/alice", Value: map[string]interface{}{"age": 30.0}},
+		{Operation: "add", Path: "/log/0", Value: "created"},
+		{Operation: "add", Path: "/log/1", Value: "joined"},
+		{Operation: "replace", Path: "/root/title", Value: "renamed"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyCollectionOperations(patch); err != nil {
+		t.Fatalf("ApplyCollectionOperations failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"root":  map[string]interface{}{"title": "renamed"},
+		"users": map[string]interface{}{"alice": map[string]interface{}{"age": 30.0}},
+		"log":   []interface{}{"created", "joined"},
+	}
+	got, err := doc.CollectionsToJSON()
+	if err != nil {
+		t.Fatalf("CollectionsToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(state, expected["root"]) {
+		t.Errorf("expected ToJSON to see only the main root, got %v", state)
+	}
+
+	peer := NewDoc()
+	defer peer.Destroy()
+	if err := peer.DefineMap("users"); err != nil {
+		t.Fatalf("DefineMap failed: %v", err)
+	}
+	if err := peer.DefineArray("log"); err != nil {
+		t.Fatalf("DefineArray failed: %v", err)
+	}
+	if err := peer.ApplyUpdate(mustEncodeFull(t, doc)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	got, err = peer.CollectionsToJSON()
+	if err != nil {
+		t.Fatalf("CollectionsToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected peer to hold %v, got %v", expected, got)
+	}
+
+	patch, err = NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/missing/x", Value: 1.0},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyCollectionOperations(patch); !errors.Is(err, ErrUnknownCollection) {
+		t.Errorf("expected ErrUnknownCollection, got %v", err)
+	}
+}
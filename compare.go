@@ -0,0 +1,64 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Equal reports whether d and other hold the same content, as compared by DiffPaths.
+func (d *Doc) Equal(other *Doc) (bool, error) {
+	paths, err := d.DiffPaths(other)
+	if err != nil {
+		return false, fmt.Errorf("Equal: %w", err)
+	}
+	return len(paths) == 0, nil
+}
+
+// DiffPaths returns, sorted, the JSON Pointers of the leaves (see Flatten) that differ
+// between d and other: those present in only one of them and those whose values differ.
+// Where one document holds a value and the other a container at the same pointer, both
+// the pointer and the leaves below it are listed. Numbers are compared by value, so an
+// integer equals a float that is the same number, and a YText equals a string with the
+// same text, matching how the documents read as JSON.
+func (d *Doc) DiffPaths(other *Doc) ([]string, error) {
+	mine, err := d.Flatten()
+	if err != nil {
+		return nil, fmt.Errorf("DiffPaths: %w", err)
+	}
+	theirs, err := other.Flatten()
+	if err != nil {
+		return nil, fmt.Errorf("DiffPaths: %w", err)
+	}
+
+	var paths []string
+	for path, value := range mine {
+		if otherValue, ok := theirs[path]; !ok || !leavesEqual(value, otherValue) {
+			paths = append(paths, path)
+		}
+	}
+	for path := range theirs {
+		if _, ok := mine[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// leavesEqual compares two values returned by Flatten.
+func leavesEqual(a, b interface{}) bool {
+	ai, aIsInt := a.(int64)
+	bi, bIsInt := b.(int64)
+	if aIsInt && bIsInt {
+		return ai == bi
+	}
+	af, aIsNum := toFloat(a).(float64)
+	bf, bIsNum := toFloat(b).(float64)
+	if aIsNum && bIsNum {
+		return af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,64 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEqualAndDiffPaths(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"title": "draft",
+		"count": int64(2),
+		"tags":  []interface{}{"a", "b"},
+		"meta":  map[string]interface{}{"owner": "kim"},
+		"blob":  []byte{1, 2},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+
+	if equal, err := doc.Equal(peer); err != nil || !equal {
+		t.Fatalf("expected synced documents to be equal, got %v, %v", equal, err)
+	}
+
+	// A whole float matches the same integer.
+	if err := peer.Set("/count", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if equal, err := doc.Equal(peer); err != nil || !equal {
+		t.Errorf("expected 2 and 2.0 to compare equal, got %v, %v", equal, err)
+	}
+
+	if err := peer.Set("/title", "final"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := peer.SetArray("/tags", []interface{}{"a"}); err != nil {
+		t.Fatalf("SetArray failed: %v", err)
+	}
+	if err := peer.Set("/meta/owner", map[string]interface{}{"name": "kim"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := peer.Set("/blob", []byte{1, 3}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	paths, err := doc.DiffPaths(peer)
+	if err != nil {
+		t.Fatalf("DiffPaths failed: %v", err)
+	}
+	expected := []string{"/blob", "/meta/owner", "/meta/owner/name", "/tags/1", "/title"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+	if equal, err := doc.Equal(peer); err != nil || equal {
+		t.Errorf("expected the documents to differ, got %v, %v", equal, err)
+	}
+}
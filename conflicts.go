@@ -0,0 +1,121 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// DetectConflicts reports where two concurrent updates made on top of the same base
+// disagree. base, a and b are v1 updates (as from EncodeStateAsUpdate or EncodeFull); a and
+// b are each applied to a separate copy of base and the results compared leaf by leaf
+// (see Flatten). A JSON Pointer is reported when both updates changed it, including by
+// deleting it or replacing a container above it, but left it with different values. The
+// paths are sorted. Changes only one side made, or identical changes on both sides, are
+// not conflicts.
+func DetectConflicts(base, a, b []byte) ([]string, error) {
+	baseFlat, err := flattenUpdates(base)
+	if err != nil {
+		return nil, fmt.Errorf("DetectConflicts: failed to load base: %w", err)
+	}
+	aFlat, err := flattenUpdates(base, a)
+	if err != nil {
+		return nil, fmt.Errorf("DetectConflicts: failed to apply a: %w", err)
+	}
+	bFlat, err := flattenUpdates(base, b)
+	if err != nil {
+		return nil, fmt.Errorf("DetectConflicts: failed to apply b: %w", err)
+	}
+
+	aChanged := changedLeaves(baseFlat, aFlat)
+	conflicts := []string{}
+	for pointer := range changedLeaves(baseFlat, bFlat) {
+		if !aChanged[pointer] {
+			continue
+		}
+		aValue, inA := aFlat[pointer]
+		bValue, inB := bFlat[pointer]
+		if inA != inB || !reflect.DeepEqual(aValue, bValue) {
+			conflicts = append(conflicts, pointer)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts, nil
+}
+
+// ConflictEntry is a value of the document that an update merged with MergeWithReport
+// overwrote or removed.
+type ConflictEntry struct {
+	// Path is the JSON Pointer of the leaf, as reported by Flatten.
+	Path string
+	// LocalValue is the value the leaf had before the merge.
+	LocalValue interface{}
+	// MergedValue is the value the leaf has after the merge, nil if Removed.
+	MergedValue interface{}
+	// Removed is set when the merge deleted the leaf, or replaced a container above it
+	// with a scalar.
+	Removed bool
+}
+
+// MergeWithReport applies update like ApplyUpdate and reports the local values it
+// changed, sorted by path, so that an application can tell its user which fields another
+// peer overwrote. The document is compared leaf by leaf (see Flatten) before and after the
+// merge; keys the update only adds are not reported. As the update does not tell which
+// local values its author had already seen, every changed value is reported, whether the
+// CRDT resolved a concurrent write in the remote peer's favour or the peer edited it
+// later. Writes made by other goroutines while MergeWithReport runs are reported too.
+func (d *Doc) MergeWithReport(update []byte) ([]ConflictEntry, error) {
+	before, err := d.Flatten()
+	if err != nil {
+		return nil, fmt.Errorf("MergeWithReport: %w", err)
+	}
+	if err := d.ApplyUpdate(update); err != nil {
+		return nil, fmt.Errorf("MergeWithReport: %w", err)
+	}
+	after, err := d.Flatten()
+	if err != nil {
+		return nil, fmt.Errorf("MergeWithReport: %w", err)
+	}
+
+	conflicts := []ConflictEntry{}
+	for pointer, local := range before {
+		merged, ok := after[pointer]
+		if ok && reflect.DeepEqual(local, merged) {
+			continue
+		}
+		conflicts = append(conflicts, ConflictEntry{Path: pointer, LocalValue: local, MergedValue: merged, Removed: !ok})
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	return conflicts, nil
+}
+
+// flattenUpdates applies updates in order to a new document and returns it flattened.
+func flattenUpdates(updates ...[]byte) (map[string]interface{}, error) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	for _, update := range updates {
+		if err := doc.ApplyUpdate(update); err != nil {
+			return nil, err
+		}
+	}
+	return doc.Flatten()
+}
+
+// changedLeaves returns the pointers that were added, removed or modified from before to
+// after.
+func changedLeaves(before, after map[string]interface{}) map[string]bool {
+	changed := make(map[string]bool)
+	for pointer, value := range after {
+		if old, ok := before[pointer]; !ok || !reflect.DeepEqual(old, value) {
+			changed[pointer] = true
+		}
+	}
+	for pointer := range before {
+		if _, ok := after[pointer]; !ok {
+			changed[pointer] = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,138 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectConflicts(t *testing.T) {
+	baseDoc, err := NewDocFromJSON(map[string]interface{}{
+		"title":  "draft",
+		"body":   "text",
+		"meta":   map[string]interface{}{"owner": "a"},
+		"shared": "same",
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer baseDoc.Destroy()
+	base, _ := baseDoc.GetStateVector()
+
+	edit := func(fn func(doc *Doc) error) []byte {
+		doc, err := NewDocFromStateVector(base)
+		if err != nil {
+			t.Fatalf("NewDocFromStateVector failed: %v", err)
+		}
+		defer doc.Destroy()
+		sv, _ := doc.stateVector()
+		if err := fn(doc); err != nil {
+			t.Fatalf("edit failed: %v", err)
+		}
+		update, err := doc.encodeStateDiff(sv)
+		if err != nil {
+			t.Fatalf("encodeStateDiff failed: %v", err)
+		}
+		return update
+	}
+
+	a := edit(func(doc *Doc) error {
+		if err := doc.Set("/title", "from a"); err != nil {
+			return err
+		}
+		if err := doc.Set("/shared", "both"); err != nil {
+			return err
+		}
+		return doc.Set("/meta", "flattened")
+	})
+	b := edit(func(doc *Doc) error {
+		if err := doc.Set("/title", "from b"); err != nil {
+			return err
+		}
+		if err := doc.Set("/shared", "both"); err != nil {
+			return err
+		}
+		if err := doc.Set("/body", "only b"); err != nil {
+			return err
+		}
+		return doc.Set("/meta/owner", "b")
+	})
+
+	conflicts, err := DetectConflicts(base, a, b)
+	if err != nil {
+		t.Fatalf("DetectConflicts failed: %v", err)
+	}
+	expected := []string{"/meta/owner", "/title"}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("expected conflicts %v, got %v", expected, conflicts)
+	}
+
+	none, err := DetectConflicts(base, a, a)
+	if err != nil {
+		t.Fatalf("DetectConflicts failed: %v", err)
+	}
+	if len(none) != 0 {
+		t.Errorf("expected identical updates not to conflict, got %v", none)
+	}
+}
+
+func TestMergeWithReport(t *testing.T) {
+	base, err := NewDocFromJSON(map[string]interface{}{"title": "draft", "old": "x", "keep": "y"})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer base.Destroy()
+
+	local, err := NewDocFromStateVector(mustEncodeFull(t, base))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer local.Destroy()
+	remote, err := NewDocFromStateVector(mustEncodeFull(t, base))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer remote.Destroy()
+
+	if err := local.Set("/title", "local"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	sv, err := remote.stateVector()
+	if err != nil {
+		t.Fatalf("stateVector failed: %v", err)
+	}
+	if _, err := remote.UpdateToState(map[string]interface{}{"title": "remote", "keep": "y", "added": true}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	update, err := remote.encodeStateDiff(sv)
+	if err != nil {
+		t.Fatalf("encodeStateDiff failed: %v", err)
+	}
+
+	conflicts, err := local.MergeWithReport(update)
+	if err != nil {
+		t.Fatalf("MergeWithReport failed: %v", err)
+	}
+	merged, err := local.GetValueAtPath("/title")
+	if err != nil {
+		t.Fatalf("GetValueAtPath failed: %v", err)
+	}
+	expected := []ConflictEntry{{Path: "/old", LocalValue: "x", Removed: true}}
+	if merged != "local" {
+		// The CRDT resolved the concurrent writes to /title in the remote's favour.
+		expected = append(expected, ConflictEntry{Path: "/title", LocalValue: "local", MergedValue: "remote"})
+	}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("expected %+v, got %+v", expected, conflicts)
+	}
+
+	// Merging the same update again changes nothing.
+	conflicts, err = local.MergeWithReport(update)
+	if err != nil {
+		t.Fatalf("MergeWithReport failed: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("expected no conflicts on a repeated merge, got %+v", conflicts)
+	}
+}
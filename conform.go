@@ -0,0 +1,189 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ErrSchemaMismatch is returned by ConformsTo when the document doesn't match the
+// expected shape.
+var ErrSchemaMismatch = errors.New("document does not match schema")
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// ConformsTo checks the document's top-level keys against the fields of the struct v (or
+// the struct v points to), named as encoding/json names them. Every field must have its
+// key, unless it is tagged omitempty, and the value must be of a JSON type the field
+// can be decoded from: numbers must be integral for integer fields and non-negative for
+// unsigned ones, and null is only accepted for pointers, interfaces, slices and maps.
+// Values of types implementing json.Unmarshaler are not checked, nor is anything nested
+// below the top-level values. Keys without a field are ignored, as ReadInto ignores
+// them. All mismatches are reported in one error wrapping ErrSchemaMismatch.
+func (d *Doc) ConformsTo(v interface{}) error {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Errorf("ConformsTo: expected a struct, got %T", v)
+	}
+	state, err := d.ToJSONWithOptions(ReadOptions{UseNumber: true})
+	if err != nil {
+		return fmt.Errorf("ConformsTo: %w", err)
+	}
+
+	var mismatches []string
+	for _, field := range jsonFields(t) {
+		value, ok := state[field.name]
+		if !ok {
+			if !field.omitEmpty {
+				mismatches = append(mismatches, fmt.Sprintf("missing key %q", field.name))
+			}
+			continue
+		}
+		if problem := jsonTypeMismatch(value, field.typ); problem != "" {
+			mismatches = append(mismatches, fmt.Sprintf("key %q: %s", field.name, problem))
+		}
+	}
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("ConformsTo: %w: %s", ErrSchemaMismatch, strings.Join(mismatches, "; "))
+	}
+	return nil
+}
+
+// jsonField is a struct field as encoding/json sees it.
+type jsonField struct {
+	name      string
+	typ       reflect.Type
+	omitEmpty bool
+	// goName is the field's Go name, qualified by the embedded structs it is promoted
+	// from, and depth the number of those structs.
+	goName string
+	depth  int
+	tagged bool
+}
+
+// jsonFields returns the fields encoding/json would decode into t, including those
+// promoted from untagged embedded structs.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := sf.Type
+		if sf.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for _, field := range jsonFields(ft) {
+					field.goName = sf.Name + "." + field.goName
+					field.depth++
+					fields = append(fields, field)
+				}
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		tagged := name != ""
+		if !tagged {
+			name = sf.Name
+		}
+		fields = append(fields, jsonField{
+			name:      name,
+			typ:       sf.Type,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+			goName:    sf.Name,
+			tagged:    tagged,
+		})
+	}
+	return fields
+}
+
+// jsonTypeMismatch describes why value, as decoded with UseNumber, can't be decoded into
+// a t, or returns "" if it can.
+func jsonTypeMismatch(value interface{}, t reflect.Type) string {
+	if t.Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return ""
+	}
+	if value == nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			return ""
+		}
+		return fmt.Sprintf("expected %s, got null", t)
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	got := jsonTypeName(value)
+	ok := false
+	switch t.Kind() {
+	case reflect.Interface:
+		ok = t.NumMethod() == 0
+	case reflect.Bool:
+		ok = got == "bool"
+	case reflect.String:
+		ok = got == "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, isNumber := value.(json.Number); isNumber {
+			_, err := n.Int64()
+			ok = err == nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, isNumber := value.(json.Number); isNumber {
+			i, err := n.Int64()
+			ok = err == nil && i >= 0
+		}
+	case reflect.Float32, reflect.Float64:
+		ok = got == "number"
+	case reflect.Slice:
+		// encoding/json decodes []byte from a base64 string.
+		ok = got == "array" || ((got == "string" || got == "binary") && t.Elem().Kind() == reflect.Uint8)
+	case reflect.Array:
+		ok = got == "array"
+	case reflect.Map, reflect.Struct:
+		ok = got == "object"
+	}
+	if ok {
+		return ""
+	}
+	if got == "array" || got == "object" {
+		return fmt.Sprintf("expected %s, got %s", t, got)
+	}
+	return fmt.Sprintf("expected %s, got %s %v", t, got, value)
+}
+
+// jsonTypeName returns the JSON type of value, as decoded with UseNumber.
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case bool:
+		return "bool"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []byte:
+		return "binary"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type conformBase struct {
+	ID int64 `json:"id"`
+}
+
+type conformDoc struct {
+	conformBase
+	Name     string            `json:"name"`
+	Count    uint              `json:"count"`
+	Ratio    float64           `json:"ratio"`
+	Tags     []string          `json:"tags"`
+	Meta     map[string]string `json:"meta"`
+	Nickname *string           `json:"nickname"`
+	Note     string            `json:"note,omitempty"`
+	At       time.Time         `json:"at"`
+	Ignored  bool              `json:"-"`
+	hidden   int
+}
+
+func TestConformsTo(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"id":       7,
+		"name":     "n",
+		"count":    3,
+		"ratio":    2,
+		"tags":     []interface{}{"a"},
+		"meta":     map[string]interface{}{"k": "v"},
+		"nickname": nil,
+		"at":       "2024-01-01T00:00:00Z",
+		"extra":    true,
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	if err := doc.ConformsTo(&conformDoc{}); err != nil {
+		t.Errorf("expected the document to conform, got %v", err)
+	}
+	if err := doc.ConformsTo(42); err == nil || errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("expected a usage error for a non-struct, got %v", err)
+	}
+
+	if _, err := doc.UpdateToState(map[string]interface{}{
+		"id":    1.5,
+		"count": -1,
+		"ratio": "high",
+		"tags":  map[string]interface{}{},
+		"meta":  nil,
+		"at":    "2024-01-01T00:00:00Z",
+	}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	err = doc.ConformsTo(conformDoc{})
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("expected ErrSchemaMismatch, got %v", err)
+	}
+	for _, want := range []string{
+		`key "id": expected int64, got number 1.5`,
+		`key "count": expected uint, got number -1`,
+		`key "ratio": expected float64, got string high`,
+		`key "tags": expected []string, got object`,
+		`missing key "name"`,
+		`missing key "nickname"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to contain %q, got: %v", want, err)
+		}
+	}
+	for _, unwanted := range []string{`"meta"`, `"note"`, `"at"`} {
+		if strings.Contains(err.Error(), unwanted) {
+			t.Errorf("unexpected mismatch for %s: %v", unwanted, err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// ApplyOperationsContext applies patchList like ApplyOperations, giving up with ctx's
+// error if ctx is done before the patch has been applied. ctx is checked between the
+// operations of the trial run on a scratch copy, which is where a long patch spends most
+// of its time, and once more before the patch is applied to the document. From then on
+// the patch is applied in full, so it stays all-or-nothing: a cancelled call leaves the
+// document as it was.
+func (d *Doc) ApplyOperationsContext(ctx context.Context, patchList jsonpatch.JSONPatchList) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("ApplyOperationsContext: %w", err)
+	}
+	if err := d.applyOperations(ctx, patchList, ApplyOptions{}, nil); err != nil {
+		return fmt.Errorf("ApplyOperationsContext: %w", err)
+	}
+	return nil
+}
+
+// ApplyUpdateContext merges update like ApplyUpdate, unless ctx is done before it starts,
+// in which case it returns ctx's error. Yrs applies an update in a single call that
+// cannot be interrupted, so once the merge has started it runs to completion.
+func (d *Doc) ApplyUpdateContext(ctx context.Context, update []byte) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("ApplyUpdateContext: %w", err)
+	}
+	if err := d.mergeUpdate(update, nil, false); err != nil {
+		return fmt.Errorf("ApplyUpdateContext: %w", err)
+	}
+	return nil
+}
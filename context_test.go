@@ -0,0 +1,89 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// cancellingValue cancels a context when buildYInputRecursive stores it.
+type cancellingValue struct{ cancel context.CancelFunc }
+
+func (v cancellingValue) MarshalY() (interface{}, error) {
+	v.cancel()
+	return "cancelled", nil
+}
+
+func TestApplyOperationsContext(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	// The context is cancelled while the third of five operations is applied.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ops := make([]jsonpatch.JSONPatch, 5)
+	for i := range ops {
+		ops[i] = jsonpatch.JSONPatch{Operation: "add", Path: fmt.Sprintf("/k%d", i), Value: float64(i)}
+	}
+	ops[2].Value = cancellingValue{cancel: cancel}
+	patch, err := NewPatchList(ops)
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperationsContext(ctx, patch); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"a": 1.0}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected a cancelled patch to change nothing, got %v", got)
+	}
+
+	if err := doc.ApplyOperationsContext(context.Background(), patch); err != nil {
+		t.Fatalf("ApplyOperationsContext failed: %v", err)
+	}
+	if got, err = doc.ToJSON(); err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if len(got) != 6 || got["k2"] != "cancelled" {
+		t.Errorf("expected the patch to apply, got %v", got)
+	}
+}
+
+func TestApplyUpdateContext(t *testing.T) {
+	source, err := NewDocFromJSON(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer source.Destroy()
+	update := mustEncodeFull(t, source)
+
+	doc := NewDoc()
+	defer doc.Destroy()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := doc.ApplyUpdateContext(ctx, update); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if err := doc.ApplyUpdateContext(context.Background(), update); err != nil {
+		t.Fatalf("ApplyUpdateContext failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"a": 1.0}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,78 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// createParents inserts an empty map for every missing map key among the parents of
+// path, for ApplyOptions.CreatePaths. A missing level followed by an array index or "-"
+// is an error, as the level could as well be meant to be an array. Anything else that
+// stops navigation, like a missing array element or a scalar, is left for applyOp to
+// report.
+func createParents(txn *C.YTransaction, rootBranch *C.Branch, path string) error {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return nil // reported by applyOp
+	}
+
+	var outputs []*C.YOutput
+	defer func() { destroyOutputs(outputs) }()
+
+	branch := rootBranch
+	for i := 0; i+1 < len(pathSegments); i++ {
+		segment := pathSegments[i]
+		var output *C.YOutput
+		switch C.ytype_kind(branch) {
+		case C.Y_MAP:
+			segmentC := C.CString(segment)
+			output = C.ymap_get(branch, txn, segmentC)
+			if output == nil {
+				next := pathSegments[i+1]
+				if _, err := parseArrayIndex(next); err == nil || next == "-" {
+					C.free(unsafe.Pointer(segmentC))
+					return fmt.Errorf("cannot create %s: segment %q after it would need an array", joinPath(pathSegments[:i+1]), next)
+				}
+				var allocations []cAllocation
+				input, err := buildYInputRecursive(map[string]interface{}{}, &allocations)
+				if err != nil {
+					C.free(unsafe.Pointer(segmentC))
+					freeAllocations(allocations)
+					return err
+				}
+				tracef("ymap_insert(%p, %q)", branch, segment)
+				C.ymap_insert(branch, txn, segmentC, &input)
+				freeAllocations(allocations)
+				output = C.ymap_get(branch, txn, segmentC)
+			}
+			C.free(unsafe.Pointer(segmentC))
+		case C.Y_ARRAY:
+			index, err := parseArrayIndex(segment)
+			if err != nil || index >= C.yarray_len(branch) {
+				return nil
+			}
+			output = C.yarray_get(branch, txn, index)
+		}
+		if output == nil {
+			return nil
+		}
+		outputs = append(outputs, output)
+
+		switch output.tag {
+		case C.Y_MAP:
+			branch = C.youtput_read_ymap(output)
+		case C.Y_ARRAY:
+			branch = C.youtput_read_yarray(output)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestApplyOperationsCreatePaths(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"list": []interface{}{map[string]interface{}{}},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/a/b/c", Value: 1.0},
+		{Operation: "add", Path: "/a/b/d", Value: 2.0},
+		{Operation: "add", Path: "/list/0/x/y", Value: "z"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err == nil {
+		t.Fatal("expected ApplyOperations to fail without CreatePaths")
+	}
+	if err := doc.ApplyOperationsWithOptions(patch, ApplyOptions{CreatePaths: true}); err != nil {
+		t.Fatalf("ApplyOperationsWithOptions failed: %v", err)
+	}
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"a":    map[string]interface{}{"b": map[string]interface{}{"c": 1.0, "d": 2.0}},
+		"list": []interface{}{map[string]interface{}{"x": map[string]interface{}{"y": "z"}}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// A missing level that would have to be an array is not created, and the failed
+	// patch leaves nothing behind.
+	for _, path := range []string{"/items/0/name", "/items/-", "/a/new/0"} {
+		patch, err := NewPatchList([]jsonpatch.JSONPatch{
+			{Operation: "add", Path: "/created", Value: true},
+			{Operation: "add", Path: path, Value: "x"},
+		})
+		if err != nil {
+			t.Fatalf("NewPatchList failed: %v", err)
+		}
+		if err := doc.ApplyOperationsWithOptions(patch, ApplyOptions{CreatePaths: true}); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+	after, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(after, expected) {
+		t.Errorf("expected failed patches to change nothing, got %v", after)
+	}
+}
@@ -0,0 +1,188 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdint.h>
+
+extern void goDebounceCallback(void*, uint32_t, char*);
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// debounceDocs maps the *C.YDoc passed to goDebounceCallback back to its Doc, like
+// updateLogs does for the update log.
+var debounceDocs sync.Map
+
+// updateBatcher is a callback registered with OnUpdateDebounced.
+type updateBatcher struct {
+	window time.Duration
+	fn     func(update []byte)
+	// flushMu serializes the batcher's flushes, so since only moves forward.
+	flushMu sync.Mutex
+	// since is the state vector the next batch is encoded against, and timer is set
+	// while a batch is pending. Both are guarded by updateBatchers.mu.
+	since []byte
+	timer *time.Timer
+}
+
+// updateBatchers holds the state for OnUpdateDebounced. closed is set by Destroy, after
+// which pending batches are dropped.
+type updateBatchers struct {
+	mu       sync.Mutex
+	sub      *C.YSubscription
+	batchers []*updateBatcher
+	closed   bool
+}
+
+// OnUpdateDebounced registers fn to receive the document's updates in batches: the
+// first update committed after a delivery, local or applied with ApplyUpdate,
+// opens a window of the given length, and once it has passed fn is called with a
+// single v1 update covering everything committed since the previous delivery. Peers
+// apply it with ApplyUpdate like any other update. The window is not extended by
+// further updates, so a steady stream of edits is still delivered every window.
+//
+// fn runs on its own goroutine, without holding the Doc's lock, so it may use the Doc.
+// Deliveries to the same fn never overlap. The returned stop unregisters fn and drops a
+// pending batch; calling it more than once is harmless. Batches still pending when the
+// Doc is destroyed are dropped too.
+func (d *Doc) OnUpdateDebounced(window time.Duration, fn func(update []byte)) (stop func(), err error) {
+	if err := d.observeUpdateBatches(); err != nil {
+		return nil, fmt.Errorf("OnUpdateDebounced: %w", err)
+	}
+	since, err := d.stateVector()
+	if err != nil {
+		return nil, fmt.Errorf("OnUpdateDebounced: %w", err)
+	}
+	b := &updateBatcher{window: window, fn: fn, since: since}
+	d.batchers.mu.Lock()
+	d.batchers.batchers = append(d.batchers.batchers, b)
+	d.batchers.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.batchers.mu.Lock()
+			defer d.batchers.mu.Unlock()
+			for i, other := range d.batchers.batchers {
+				if other == b {
+					d.batchers.batchers = append(d.batchers.batchers[:i], d.batchers.batchers[i+1:]...)
+					break
+				}
+			}
+			if b.timer != nil {
+				b.timer.Stop()
+				b.timer = nil
+			}
+		})
+	}, nil
+}
+
+// observeUpdateBatches subscribes to the document's updates on first use. The
+// subscription stays in place until Destroy.
+func (d *Doc) observeUpdateBatches() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.batchers.sub != nil {
+		return nil
+	}
+	sub := C.ydoc_observe_updates_v1(d.yDoc, unsafe.Pointer(d.yDoc), (*[0]byte)(C.goDebounceCallback))
+	if sub == nil {
+		return errors.New("ydoc_observe_updates_v1 returned nil")
+	}
+	d.batchers.sub = sub
+	debounceDocs.Store(unsafe.Pointer(d.yDoc), d)
+	return nil
+}
+
+// stopUpdateBatches removes the subscription made by observeUpdateBatches and drops the
+// pending batches. The caller must hold d.mu.
+func (d *Doc) stopUpdateBatches() {
+	d.batchers.mu.Lock()
+	defer d.batchers.mu.Unlock()
+	d.batchers.closed = true
+	for _, b := range d.batchers.batchers {
+		if b.timer != nil {
+			b.timer.Stop()
+			b.timer = nil
+		}
+	}
+	if d.batchers.sub == nil {
+		return
+	}
+	C.yunobserve(d.batchers.sub)
+	debounceDocs.Delete(unsafe.Pointer(d.yDoc))
+	d.batchers.sub = nil
+}
+
+// flushUpdateBatch delivers b's pending batch: the diff between b.since and the current
+// state, read in the same transaction as the state vector that replaces b.since, so no
+// update falls in between.
+func (d *Doc) flushUpdateBatch(b *updateBatcher) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	// Checked under d.mu, which Destroy holds while setting closed, so the Yrs document
+	// is still alive if closed is not set.
+	d.mu.RLock()
+	d.batchers.mu.Lock()
+	live := !d.batchers.closed && b.timer != nil
+	b.timer = nil
+	since := b.since
+	d.batchers.mu.Unlock()
+	if !live {
+		d.mu.RUnlock()
+		return
+	}
+
+	txn := C.ydoc_read_transaction(d.yDoc)
+	if txn == nil {
+		d.mu.RUnlock()
+		return
+	}
+	tracef("ydoc_read_transaction() = %p", txn)
+	var next []byte
+	update, err := encodeStateDiffTxn(txn, since)
+	if err == nil {
+		next, err = stateVectorTxn(txn)
+	}
+	commitTransaction(txn)
+	d.mu.RUnlock()
+	if err != nil {
+		return
+	}
+
+	d.batchers.mu.Lock()
+	b.since = next
+	d.batchers.mu.Unlock()
+	b.fn(update)
+}
+
+// goDebounceCallback is called by Yrs while a transaction commits, which only happens
+// while the committing goroutine holds d.mu for writing. It opens a window for every
+// batcher that has none pending.
+//
+//export goDebounceCallback
+func goDebounceCallback(state unsafe.Pointer, length C.uint32_t, data *C.char) {
+	value, ok := debounceDocs.Load(state)
+	if !ok {
+		return
+	}
+	d := value.(*Doc)
+
+	d.batchers.mu.Lock()
+	defer d.batchers.mu.Unlock()
+	for _, b := range d.batchers.batchers {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.window, func() { d.flushUpdateBatch(b) })
+		}
+	}
+}
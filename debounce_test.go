@@ -0,0 +1,103 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnUpdateDebounced(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	batches := make(chan []byte, 10)
+	stop, err := doc.OnUpdateDebounced(50*time.Millisecond, func(update []byte) {
+		batches <- update
+	})
+	if err != nil {
+		t.Fatalf("OnUpdateDebounced failed: %v", err)
+	}
+
+	for i, key := range []string{"/a", "/b", "/c"} {
+		if err := doc.Set(key, i); err != nil {
+			t.Fatalf("Set %s failed: %v", key, err)
+		}
+	}
+
+	var update []byte
+	select {
+	case update = <-batches:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no batch delivered")
+	}
+	select {
+	case extra := <-batches:
+		t.Fatalf("expected a single batch, got another of %d bytes", len(extra))
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	peer := NewDoc()
+	defer peer.Destroy()
+	if err := peer.ApplyStateVector(update); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	got, err := peer.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"a": 0.0, "b": 1.0, "c": 2.0}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// The next batch covers what changed since the previous one.
+	if err := doc.Set("/d", "x"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	select {
+	case update = <-batches:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no second batch delivered")
+	}
+	if err := peer.ApplyStateVector(update); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	got, err = peer.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected["d"] = "x"
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	stop()
+	stop()
+	if err := doc.Set("/e", true); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	select {
+	case <-batches:
+		t.Error("batch delivered after stop")
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestOnUpdateDebouncedDestroyDropsPending(t *testing.T) {
+	doc := NewDoc()
+	delivered := make(chan struct{}, 1)
+	if _, err := doc.OnUpdateDebounced(20*time.Millisecond, func([]byte) { delivered <- struct{}{} }); err != nil {
+		t.Fatalf("OnUpdateDebounced failed: %v", err)
+	}
+	if err := doc.Set("/a", 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	doc.Destroy()
+
+	select {
+	case <-delivered:
+		t.Error("batch delivered after Destroy")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,97 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"unsafe"
+
+	jsonpatch "github.com/snorwin/jsonpatch"
+)
+
+// SetDefaults registers top-level keys that must always be present, replacing any
+// previous defaults; nil removes them. After every UpdateToState and ApplyUpdate,
+// each default key missing from the document is inserted with its default value, so
+// neither a peer nor a target state can remove it for good. Keys that are present are
+// left alone, whatever their value. The re-insertion is a local change, which must be
+// sent to peers like any other. Values may be anything buildYInputRecursive accepts.
+func (d *Doc) SetDefaults(defaults map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(defaults) == 0 {
+		d.defaults = nil
+		return
+	}
+	d.defaults = make(map[string]interface{}, len(defaults))
+	for key, value := range defaults {
+		d.defaults[key] = value
+	}
+}
+
+// applyDefaults inserts the missing default keys in a single write transaction and
+// returns the add operations it applied, in key order.
+func (d *Doc) applyDefaults() (jsonpatch.JSONPatchList, error) {
+	d.mu.RLock()
+	keys := make([]string, 0, len(d.defaults))
+	for key := range d.defaults {
+		keys = append(keys, key)
+	}
+	d.mu.RUnlock()
+	if len(keys) == 0 {
+		return jsonpatch.JSONPatchList{}, nil
+	}
+	sort.Strings(keys)
+
+	var added []jsonpatch.JSONPatch
+	err := d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		if C.ytype_kind(rootBranch) != C.Y_MAP {
+			return errors.New("defaults require a map root")
+		}
+		for _, key := range keys {
+			inserted, err := insertIfMissing(txn, rootBranch, key, d.encodeValues(d.defaults[key]))
+			if err != nil {
+				return fmt.Errorf("default for '%s': %w", key, err)
+			}
+			if inserted {
+				added = append(added, jsonpatch.JSONPatch{
+					Operation: "add",
+					Path:      "/" + pointerEscaper.Replace(key),
+					Value:     d.defaults[key],
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return jsonpatch.JSONPatchList{}, err
+	}
+	return NewPatchList(added)
+}
+
+// insertIfMissing stores value under key in branch unless the key is already present.
+func insertIfMissing(txn *C.YTransaction, branch *C.Branch, key string, value interface{}) (bool, error) {
+	keyC := C.CString(key)
+	defer C.free(unsafe.Pointer(keyC))
+	if existing := C.ymap_get(branch, txn, keyC); existing != nil {
+		C.youtput_destroy(existing)
+		return false, nil
+	}
+
+	var allocations []cAllocation
+	defer func() { freeAllocations(allocations) }()
+
+	input, err := buildYInputRecursive(value, &allocations)
+	if err != nil {
+		return false, err
+	}
+	tracef("ymap_insert(%p, %q)", branch, key)
+	C.ymap_insert(branch, txn, keyC, &input)
+	return true, nil
+}
@@ -0,0 +1,86 @@
+//go:build cgo
+
+package autosync
+
+import "testing"
+
+func TestSetDefaults(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"name": "a"})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	doc.SetDefaults(map[string]interface{}{
+		"version":  1,
+		"settings": map[string]interface{}{"theme": "dark"},
+	})
+
+	// A target state without the default keys gets them back, and the returned patch
+	// says so.
+	patch, err := doc.UpdateToState(map[string]interface{}{"name": "b", "version": 2.0})
+	if err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"name":     "b",
+		"version":  2.0,
+		"settings": map[string]interface{}{"theme": "dark"},
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	last := patch.List()[patch.Len()-1]
+	if last.Operation != "add" || last.Path != "/settings" {
+		t.Errorf("expected the patch to end with the re-inserted default, got %v", patch)
+	}
+
+	// A peer removing a default key loses it on the next merge.
+	peer := NewDoc()
+	defer peer.Destroy()
+	update, err := doc.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	if err := peer.ApplyStateVector(update); err != nil {
+		t.Fatalf("peer ApplyStateVector failed: %v", err)
+	}
+	if _, err := peer.UpdateToState(map[string]interface{}{"name": "b"}); err != nil {
+		t.Fatalf("peer UpdateToState failed: %v", err)
+	}
+	update, err = peer.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	if err := doc.ApplyStateVector(update); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	got, err = doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected = map[string]interface{}{
+		"name":     "b",
+		"version":  1.0,
+		"settings": map[string]interface{}{"theme": "dark"},
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Clearing the defaults stops the re-insertion.
+	doc.SetDefaults(nil)
+	if _, err := doc.UpdateToState(map[string]interface{}{}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	got, err = doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected an empty document, got %v", got)
+	}
+}
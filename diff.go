@@ -0,0 +1,253 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// jsonKind classifies v by the JSON type it is stored as: "null", "bool", "number",
+// "string", "array" or "object". Pointers and interfaces are looked through. Values that
+// have no JSON representation are reported by their reflect kind.
+func jsonKind(v interface{}) string {
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return "null"
+		}
+		val = val.Elem()
+	}
+	switch val.Kind() {
+	case reflect.Invalid:
+		return "null"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map:
+		return "object"
+	default:
+		return val.Kind().String()
+	}
+}
+
+// toGeneric rewrites v so that every container is a map[string]interface{} or an
+// []interface{} and every pointer is dereferenced, which is the shape jsonpatch needs to
+// diff it against a state read back from the document. Scalars keep their Go type, and
+// values toGeneric does not understand (such as maps with non-string keys) are returned
+// unchanged for buildYInputRecursive to reject. YMarshalers are replaced by what they
+// marshal to, unless MarshalY fails, in which case buildYInputRecursive reports the error,
+// and big.Int and big.Float values by the strings they are stored as.
+func toGeneric(v interface{}) interface{} {
+	val := reflect.ValueOf(v)
+	if m, ok := v.(YMarshaler); ok && !(val.Kind() == reflect.Ptr && val.IsNil()) {
+		stored, err := m.MarshalY()
+		if err != nil {
+			return v
+		}
+		return toGeneric(stored)
+	}
+	if s, ok := bigNumberString(v); ok {
+		return s
+	}
+	if s, ok := timeString(v); ok {
+		return s
+	}
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	switch val.Kind() {
+	case reflect.Map:
+		if val.Type().Key().Kind() != reflect.String {
+			return v
+		}
+		result := make(map[string]interface{}, val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			result[iter.Key().String()] = toGeneric(iter.Value().Interface())
+		}
+		return result
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8 {
+			// Stored as a single binary value, so it is compared as a whole.
+			return append([]byte(nil), val.Bytes()...)
+		}
+		result := make([]interface{}, val.Len())
+		for i := range result {
+			result[i] = toGeneric(val.Index(i).Interface())
+		}
+		return result
+	case reflect.Invalid:
+		return nil
+	default:
+		return val.Interface()
+	}
+}
+
+// alignKinds prepares current for diffing against modified (both in toGeneric form).
+// jsonpatch refuses to diff values of different kinds, so wherever the two disagree (a
+// scalar becoming a map, an int becoming a float64, a value becoming null, ...) a
+// "replace" with the modified value is appended to ops and the returned copy of current
+// holds the modified value there, leaving nothing for jsonpatch to compare.
+func alignKinds(modified, current interface{}, pointer string, ops *[]jsonpatch.JSONPatch) interface{} {
+	modifiedBytes, isBinary := modified.([]byte)
+	currentBytes, wasBinary := current.([]byte)
+	if isBinary || wasBinary {
+		// Binary values are leaves: replaced as a whole rather than diffed byte by byte.
+		if !isBinary || !wasBinary || !bytes.Equal(modifiedBytes, currentBytes) {
+			*ops = append(*ops, jsonpatch.JSONPatch{Operation: "replace", Path: pointer, Value: modified})
+		}
+		return modified
+	}
+	if jsonKind(modified) != jsonKind(current) {
+		*ops = append(*ops, jsonpatch.JSONPatch{Operation: "replace", Path: pointer, Value: modified})
+		return modified
+	}
+
+	switch m := modified.(type) {
+	case map[string]interface{}:
+		c, ok := current.(map[string]interface{})
+		if !ok {
+			return current
+		}
+		aligned := make(map[string]interface{}, len(c))
+		for key, value := range c {
+			if modifiedValue, ok := m[key]; ok {
+				value = alignKinds(modifiedValue, value, pointer+"/"+pointerEscaper.Replace(key), ops)
+			}
+			aligned[key] = value
+		}
+		return aligned
+	case []interface{}:
+		c, ok := current.([]interface{})
+		if !ok {
+			return current
+		}
+		aligned := make([]interface{}, len(c))
+		copy(aligned, c)
+		for i := 0; i < len(m) && i < len(c); i++ {
+			aligned[i] = alignKinds(m[i], c[i], pointer+"/"+strconv.Itoa(i), ops)
+		}
+		return aligned
+	}
+
+	if reflect.TypeOf(modified) != reflect.TypeOf(current) {
+		// Same JSON kind but different Go types, e.g. int and float64.
+		if !numbersEqual(modified, current) {
+			*ops = append(*ops, jsonpatch.JSONPatch{Operation: "replace", Path: pointer, Value: modified})
+		}
+		return modified
+	}
+	return current
+}
+
+// numbersEqual compares two values of possibly different Go types. Integers are compared
+// exactly, so that values beyond 2^53 that share a float64 stay distinct; other numbers
+// are compared as float64, and non-numbers must be deeply equal.
+func numbersEqual(a, b interface{}) bool {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch {
+	case isSigned(av) && isSigned(bv):
+		return av.Int() == bv.Int()
+	case isUnsigned(av) && isUnsigned(bv):
+		return av.Uint() == bv.Uint()
+	case isSigned(av) && isUnsigned(bv):
+		return av.Int() >= 0 && uint64(av.Int()) == bv.Uint()
+	case isUnsigned(av) && isSigned(bv):
+		return bv.Int() >= 0 && uint64(bv.Int()) == av.Uint()
+	}
+	return reflect.DeepEqual(toFloat(a), toFloat(b))
+}
+
+func isSigned(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUnsigned(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// toFloat converts a number to float64 for comparison, returning any other value as is.
+func toFloat(v interface{}) interface{} {
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint())
+	case reflect.Float32, reflect.Float64:
+		return val.Float()
+	}
+	return v
+}
+
+// diffStates returns the patch that turns current, as read by ToJSON, into modified.
+// jsonpatch can't diff values whose kinds differ, so those become explicit replaces
+// applied ahead of the rest of the patch.
+func diffStates(modified, current map[string]interface{}) (jsonpatch.JSONPatchList, error) {
+	generic := toGeneric(modified)
+	var ops []jsonpatch.JSONPatch
+	aligned := alignKinds(generic, current, "", &ops)
+
+	// CreateJSONPatch takes the target first: the patch turns aligned into generic.
+	diff, err := jsonpatch.CreateJSONPatch(generic, aligned)
+	if err != nil || len(ops) == 0 {
+		return diff, err
+	}
+	return NewPatchList(append(ops, diff.List()...))
+}
+
+// patchListHandler is a jsonpatch.Handler that emits a fixed list of operations for the
+// first add it is asked to generate. See NewPatchList.
+type patchListHandler struct {
+	ops []jsonpatch.JSONPatch
+}
+
+func (h *patchListHandler) Add(jsonpatch.JSONPointer, interface{}) []jsonpatch.JSONPatch {
+	ops := h.ops
+	h.ops = nil
+	return ops
+}
+
+func (h *patchListHandler) Remove(jsonpatch.JSONPointer, interface{}) []jsonpatch.JSONPatch {
+	return nil
+}
+
+func (h *patchListHandler) Replace(jsonpatch.JSONPointer, interface{}, interface{}) []jsonpatch.JSONPatch {
+	return nil
+}
+
+// NewPatchList builds a jsonpatch.JSONPatchList holding ops. The library only constructs
+// lists through CreateJSONPatch, so this diffs a one-key object against an empty one,
+// which produces exactly one add, and has the handler substitute ops for it.
+func NewPatchList(ops []jsonpatch.JSONPatch) (jsonpatch.JSONPatchList, error) {
+	if len(ops) == 0 {
+		return jsonpatch.JSONPatchList{}, nil
+	}
+	return jsonpatch.CreateJSONPatch(
+		map[string]interface{}{"": true},
+		map[string]interface{}{},
+		jsonpatch.WithHandler(&patchListHandler{ops: ops}),
+	)
+}
@@ -0,0 +1,86 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+// dirtyObserver runs inside Yrs as each transaction that changed the document commits;
+// Yrs skips update observers for transactions without changes.
+static void dirtyObserver(void* state, uint32_t len, const char* update) {
+	__atomic_store_n((uint8_t*)state, 1, __ATOMIC_SEQ_CST);
+}
+
+static YSubscription* observeDirty(YDoc* doc, uint8_t* flag) {
+	return ydoc_observe_updates_v1(doc, flag, dirtyObserver);
+}
+
+static uint8_t swapDirty(uint8_t* flag, uint8_t value) {
+	return __atomic_exchange_n(flag, value, __ATOMIC_SEQ_CST);
+}
+
+static uint8_t loadDirty(uint8_t* flag) {
+	return __atomic_load_n(flag, __ATOMIC_SEQ_CST);
+}
+*/
+import "C"
+import (
+	"unsafe"
+)
+
+// dirtyTracker records whether a Doc changed since it was last marked clean. The flag
+// lives in C memory so that it can be set from Yrs without calling back into Go.
+type dirtyTracker struct {
+	flag *C.uint8_t
+	sub  *C.YSubscription
+}
+
+// trackDirty starts tracking changes to d. It is called by the constructors.
+func (d *Doc) trackDirty() {
+	flag := (*C.uint8_t)(C.calloc(1, 1))
+	if flag == nil {
+		return
+	}
+	d.dirty = dirtyTracker{flag: flag, sub: C.observeDirty(d.yDoc, flag)}
+}
+
+// stopDirtyTracking releases the tracker. The caller must hold d.mu.
+func (d *Doc) stopDirtyTracking() {
+	if d.dirty.sub != nil {
+		C.yunobserve(d.dirty.sub)
+	}
+	if d.dirty.flag != nil {
+		C.free(unsafe.Pointer(d.dirty.flag))
+	}
+	d.dirty = dirtyTracker{}
+}
+
+// Dirty reports whether the document has changed since it was created, loaded with
+// NewDocFromStateVector, encoded with EncodeStateAsUpdate or marked clean with MarkClean,
+// whichever happened last. Only writes that actually change the document count: an
+// UpdateToState to the current state or a merge of an update the document already holds
+// leaves it clean. A document created with NewDoc or NewDocFromJSON starts out dirty only
+// if something was written to it.
+func (d *Doc) Dirty() bool {
+	return d.dirty.flag != nil && C.loadDirty(d.dirty.flag) != 0
+}
+
+// MarkClean resets Dirty, e.g. after the document was saved by other means than
+// EncodeStateAsUpdate.
+func (d *Doc) MarkClean() {
+	d.swapDirty(false)
+}
+
+// swapDirty sets the dirty flag to dirty and returns its previous value.
+func (d *Doc) swapDirty(dirty bool) bool {
+	if d.dirty.flag == nil {
+		return false
+	}
+	value := C.uint8_t(0)
+	if dirty {
+		value = 1
+	}
+	return C.swapDirty(d.dirty.flag, value) != 0
+}
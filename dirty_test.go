@@ -0,0 +1,62 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+)
+
+func TestDirty(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	if doc.Dirty() {
+		t.Error("expected a new document to be clean")
+	}
+
+	state := map[string]interface{}{"a": 1.0}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if !doc.Dirty() {
+		t.Error("expected a write to make the document dirty")
+	}
+
+	update, err := doc.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	if doc.Dirty() {
+		t.Error("expected GetStateVector to mark the document clean")
+	}
+
+	// Writes and merges that change nothing keep it clean.
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if err := doc.ApplyStateVector(update); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	if doc.Dirty() {
+		t.Error("expected no-op writes to leave the document clean")
+	}
+
+	if err := doc.Set("/b", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if !doc.Dirty() {
+		t.Error("expected Set to make the document dirty")
+	}
+	doc.MarkClean()
+	if doc.Dirty() {
+		t.Error("expected MarkClean to reset Dirty")
+	}
+
+	loaded, err := NewDocFromStateVector(update)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer loaded.Destroy()
+	if loaded.Dirty() {
+		t.Error("expected a freshly loaded document to be clean")
+	}
+}
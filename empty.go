@@ -0,0 +1,66 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+
+// emptyWatch holds the state for OnEmptyStateChange.
+type emptyWatch struct {
+	fn    func(empty bool)
+	empty bool
+}
+
+// OnEmptyStateChange registers fn to be called after a committed write, local or applied
+// with ApplyUpdate, that makes the document empty or non-empty: fn(false) when the
+// root gains its first key (or element, for NewArrayDoc) and fn(true) when it loses its
+// last one. The check reads only the length of the root, so it is cheap enough to run
+// on every write. fn is not called for the state at registration time. Passing nil
+// removes the callback; registering a new one replaces the previous.
+func (d *Doc) OnEmptyStateChange(fn func(empty bool)) {
+	empty, err := d.rootEmpty()
+	if err != nil {
+		empty = true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.emptiness = emptyWatch{fn: fn, empty: empty}
+}
+
+func (d *Doc) checkEmptyState() {
+	d.mu.RLock()
+	set := d.emptiness.fn != nil
+	d.mu.RUnlock()
+	if !set {
+		return
+	}
+	empty, err := d.rootEmpty()
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	fn := d.emptiness.fn
+	changed := empty != d.emptiness.empty
+	d.emptiness.empty = empty
+	d.mu.Unlock()
+	if changed && fn != nil {
+		fn(empty)
+	}
+}
+
+// rootEmpty reports whether the root map or array has no entries.
+func (d *Doc) rootEmpty() (bool, error) {
+	var n C.uint32_t
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		if C.ytype_kind(rootBranch) == C.Y_ARRAY {
+			n = C.yarray_len(rootBranch)
+		} else {
+			n = C.ymap_len(rootBranch, txn)
+		}
+		return nil
+	})
+	return n == 0, err
+}
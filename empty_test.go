@@ -0,0 +1,50 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOnEmptyStateChange(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	var events []bool
+	doc.OnEmptyStateChange(func(empty bool) {
+		events = append(events, empty)
+	})
+
+	if err := doc.Set("/a", 1.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.Set("/b", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := doc.UpdateToState(map[string]interface{}{}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	peer, err := NewDocFromJSON(map[string]interface{}{"c": "x"})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer peer.Destroy()
+	if err := doc.ApplyStateVector(mustEncodeFull(t, peer)); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+
+	expected := []bool{false, true, false}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected transitions %v, got %v", expected, events)
+	}
+
+	doc.OnEmptyStateChange(nil)
+	if _, err := doc.UpdateToState(map[string]interface{}{}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if len(events) != len(expected) {
+		t.Errorf("expected no calls after removing the callback, got %v", events)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// encodeValues returns value with the values that a Doc stores according to its
+// settings replaced by what they are stored as: time.Time values as chosen with
+// SetTimeEncoding, uint64s above math.MaxInt64 as chosen with SetLargeUintEncoding and
+// whole floats as integers if DocOptions.CoerceWholeFloatsToLong is set.
+// buildYInputRecursive handles the default encodings by itself, so with those value is
+// returned unchanged. Otherwise maps and slices are copied, as by toGeneric, down to the
+// values they hold.
+func (d *Doc) encodeValues(value interface{}) interface{} {
+	times := TimeEncoding(d.timeEncoding.Load())
+	uints := LargeUintEncoding(d.largeUints.Load())
+	if times != TimeUnixMillis && uints == LargeUintError && !d.wholeFloats {
+		return value
+	}
+	return replaceLeaves(value, func(leaf interface{}) (interface{}, bool) {
+		switch t := leaf.(type) {
+		case time.Time:
+			if times == TimeUnixMillis {
+				return t.UnixMilli(), true
+			}
+		case *time.Time:
+			if t != nil && times == TimeUnixMillis {
+				return t.UnixMilli(), true
+			}
+		}
+		val := reflect.ValueOf(leaf)
+		if (val.Kind() == reflect.Uint || val.Kind() == reflect.Uint64) && val.Uint() > math.MaxInt64 {
+			switch uints {
+			case LargeUintSigned:
+				return int64(val.Uint()), true
+			case LargeUintString:
+				return strconv.FormatUint(val.Uint(), 10), true
+			}
+		}
+		if d.wholeFloats && (val.Kind() == reflect.Float32 || val.Kind() == reflect.Float64) {
+			// -2^63 and 2^63 are exact as floats; 2^63 itself doesn't fit an int64.
+			if f := val.Float(); math.Trunc(f) == f && f >= math.MinInt64 && f < math.MaxInt64 {
+				return int64(f), true
+			}
+		}
+		return nil, false
+	})
+}
+
+// replaceLeaves returns value with every value for which fn reports true replaced by
+// fn's result. fn is tried on every value, containers included, before descending into
+// maps and slices.
+func replaceLeaves(value interface{}, fn func(interface{}) (interface{}, bool)) interface{} {
+	if replaced, ok := fn(value); ok {
+		return replaced
+	}
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Map:
+		if val.Type().Key().Kind() != reflect.String {
+			return value
+		}
+		result := make(map[string]interface{}, val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			result[iter.Key().String()] = replaceLeaves(iter.Value().Interface(), fn)
+		}
+		return result
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8 {
+			return value
+		}
+		result := make([]interface{}, val.Len())
+		for i := range result {
+			result[i] = replaceLeaves(val.Index(i).Interface(), fn)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,356 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// encodeStateDiff encodes, in Yrs update format v1, everything in the document that is
+// not covered by stateVector. A nil stateVector encodes the whole document.
+func (d *Doc) encodeStateDiff(stateVector []byte) ([]byte, error) {
+	var update []byte
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		var err error
+		update, err = encodeStateDiffTxn(txn, stateVector)
+		return err
+	})
+	return update, err
+}
+
+// encodeStateDiffTxn is encodeStateDiff within an already open transaction.
+func encodeStateDiffTxn(txn *C.YTransaction, stateVector []byte) ([]byte, error) {
+	return stateDiffTxn(txn, stateVector, false)
+}
+
+// stateDiffTxn encodes, in Yrs update format v2 if v2 is set and v1 otherwise, everything
+// in the document of txn that is not covered by stateVector, a v1 state vector.
+func stateDiffTxn(txn *C.YTransaction, stateVector []byte, v2 bool) ([]byte, error) {
+	var svC *C.char
+	if len(stateVector) > 0 {
+		svC = (*C.char)(C.CBytes(stateVector))
+		if svC == nil {
+			return nil, errors.New("failed to allocate C memory for state vector")
+		}
+		defer C.free(unsafe.Pointer(svC))
+	}
+
+	var updateLen C.uint32_t
+	var updateDataC *C.char
+	if v2 {
+		updateDataC = C.ytransaction_state_diff_v2(txn, svC, C.uint32_t(len(stateVector)), &updateLen)
+		if updateDataC == nil {
+			return nil, errors.New("ytransaction_state_diff_v2 returned nil")
+		}
+	} else {
+		updateDataC = C.ytransaction_state_diff_v1(txn, svC, C.uint32_t(len(stateVector)), &updateLen)
+		if updateDataC == nil {
+			return nil, errors.New("ytransaction_state_diff_v1 returned nil")
+		}
+	}
+	defer C.ybinary_destroy(updateDataC, updateLen)
+
+	if updateLen == 0 {
+		return []byte{}, nil
+	}
+	return C.GoBytes(unsafe.Pointer(updateDataC), C.int(updateLen)), nil
+}
+
+// EncodeFull encodes the entire document as a single update in Yrs update format v1,
+// suitable for bootstrapping a peer that has no prior state. Apply it with
+// ApplyUpdate.
+func (d *Doc) EncodeFull() ([]byte, error) {
+	update, err := d.encodeStateDiff(nil)
+	if err != nil {
+		return nil, fmt.Errorf("EncodeFull: %w", err)
+	}
+	return update, nil
+}
+
+// EncodeStateVector encodes the document's state vector in Yrs format v1: for each
+// client, how many of its changes the document has seen. It is much smaller than the
+// document itself. In a sync handshake each peer sends its state vector, and the other
+// answers with EncodeDiff for it, which holds only the changes the sender is missing.
+func (d *Doc) EncodeStateVector() ([]byte, error) {
+	sv, err := d.stateVector()
+	if err != nil {
+		return nil, fmt.Errorf("EncodeStateVector: %w", err)
+	}
+	return sv, nil
+}
+
+// EncodeDiff encodes, as a v1 update, everything in the document that the peer whose
+// state vector (see EncodeStateVector) is sv is missing. A nil or empty sv yields the
+// full document, as with EncodeFull. To answer many peers at once, use EncodeDiffs. Apply
+// the result with ApplyUpdate; EncodeDiffV2 encodes the same diff in the v2 format.
+func (d *Doc) EncodeDiff(sv []byte) ([]byte, error) {
+	update, err := d.encodeStateDiff(sv)
+	if err != nil {
+		return nil, fmt.Errorf("EncodeDiff: %w", err)
+	}
+	return update, nil
+}
+
+// EncodeDiffV2 is EncodeDiff, encoding the update in Yrs update format v2, which is
+// considerably more compact for large documents. The two formats are not
+// interchangeable: apply the result with ApplyUpdateV2, not ApplyUpdate, and on Yjs
+// clients with Y.applyUpdateV2. sv is the same v1 state vector as for EncodeDiff, as Yrs
+// has a single state vector format; pass the one from EncodeStateVector.
+func (d *Doc) EncodeDiffV2(sv []byte) ([]byte, error) {
+	var update []byte
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		var err error
+		update, err = stateDiffTxn(txn, sv, true)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("EncodeDiffV2: %w", err)
+	}
+	return update, nil
+}
+
+// ApplyUpdateV2 is ApplyUpdate for an update in Yrs update format v2, as encoded by
+// EncodeDiffV2 or ConvertUpdateV1ToV2. A v1 update must be applied with ApplyUpdate
+// instead. The v2 decoder in Yrs panics, aborting the process, on some truncated input,
+// so only pass updates that were produced by a v2 encoder.
+func (d *Doc) ApplyUpdateV2(update []byte) error {
+	if err := d.mergeUpdate(update, nil, true); err != nil {
+		return fmt.Errorf("ApplyUpdateV2: %w", err)
+	}
+	return nil
+}
+
+// EncodeDiffs encodes, for each state vector in svs, everything in the document that the
+// peer at that state vector is missing, as a v1 update. All diffs are computed within a
+// single read transaction, so they reflect the same document state and a hub serving
+// many peers opens the document once instead of once per peer. A nil or empty state
+// vector yields the full document, as with EncodeFull.
+func (d *Doc) EncodeDiffs(svs [][]byte) ([][]byte, error) {
+	diffs := make([][]byte, len(svs))
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		for i, sv := range svs {
+			diff, err := encodeStateDiffTxn(txn, sv)
+			if err != nil {
+				return fmt.Errorf("state vector %d: %w", i, err)
+			}
+			diffs[i] = diff
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("EncodeDiffs: %w", err)
+	}
+	return diffs, nil
+}
+
+// EncodePaths encodes, as a v1 update, a standalone document holding only the values
+// at paths, laid out as by ToJSONMasked. The update is not a delta of this document: it
+// is built from a new document with its own history, so it is meant for a peer that
+// only ever receives these paths, and applying it to a replica of this document would
+// merge in a second, unrelated copy of the values. Values are copied as read by
+// ToJSONMasked, so numbers become float64 and texts become plain strings.
+func (d *Doc) EncodePaths(paths []string) ([]byte, error) {
+	state, err := d.ToJSONMasked(paths)
+	if err != nil {
+		return nil, fmt.Errorf("EncodePaths: %w", err)
+	}
+	partial, err := NewDocFromJSON(state)
+	if err != nil {
+		return nil, fmt.Errorf("EncodePaths: %w", err)
+	}
+	defer partial.Destroy()
+
+	update, err := partial.EncodeFull()
+	if err != nil {
+		return nil, fmt.Errorf("EncodePaths: %w", err)
+	}
+	return update, nil
+}
+
+// ConvertUpdateV1ToV2 re-encodes an update from Yrs update format v1 into v2 by
+// applying it to a throwaway document and encoding that document's full state.
+func ConvertUpdateV1ToV2(v1 []byte) ([]byte, error) {
+	update, err := convertUpdate(v1, false)
+	if err != nil {
+		return nil, fmt.Errorf("ConvertUpdateV1ToV2: %w", err)
+	}
+	return update, nil
+}
+
+// ConvertUpdateV2ToV1 re-encodes an update from Yrs update format v2 into v1. See
+// ConvertUpdateV1ToV2. The v2 decoder in Yrs panics, aborting the process, on some
+// truncated input, so only pass updates that were produced by a v2 encoder.
+func ConvertUpdateV2ToV1(v2 []byte) ([]byte, error) {
+	update, err := convertUpdate(v2, true)
+	if err != nil {
+		return nil, fmt.Errorf("ConvertUpdateV2ToV1: %w", err)
+	}
+	return update, nil
+}
+
+// MergeUpdates combines v1 updates into a single v1 update holding all of their changes,
+// e.g. to compact the updates relayed for a document before storing them. Applying the
+// result has the same effect as applying the updates one by one. libyrs has no function
+// merging updates directly, so they are applied to a throwaway bare document, whose
+// state is then encoded; no Doc is created. Changes whose dependencies are in none of
+// the updates can't be integrated and are left out, so pass complete runs of updates.
+// Empty updates are skipped; with a single update, it is returned as is.
+func MergeUpdates(updates [][]byte) ([]byte, error) {
+	var nonEmpty [][]byte
+	for _, update := range updates {
+		if len(update) > 0 {
+			nonEmpty = append(nonEmpty, update)
+		}
+	}
+	switch len(nonEmpty) {
+	case 0:
+		return nil, nil
+	case 1:
+		return nonEmpty[0], nil
+	}
+
+	yDoc := C.ydoc_new()
+	if yDoc == nil {
+		return nil, errors.New("MergeUpdates: failed to create temporary document")
+	}
+	defer C.ydoc_destroy(yDoc)
+	for i, update := range nonEmpty {
+		if err := applyUpdate(yDoc, update, false); err != nil {
+			return nil, fmt.Errorf("MergeUpdates: update %d: %w", i, err)
+		}
+	}
+
+	txn := C.ydoc_read_transaction(yDoc)
+	if txn == nil {
+		return nil, fmt.Errorf("MergeUpdates: failed to create read transaction: %w", ErrTransactionInProgress)
+	}
+	tracef("ydoc_read_transaction() = %p", txn)
+	defer commitTransaction(txn)
+	merged, err := encodeStateDiffTxn(txn, nil)
+	if err != nil {
+		return nil, fmt.Errorf("MergeUpdates: %w", err)
+	}
+	return merged, nil
+}
+
+// convertUpdate applies update (v2 if fromV2, otherwise v1) to a new bare YDoc and encodes
+// its state in the other format. No root map is created, so the result contains exactly
+// the types present in update.
+func convertUpdate(update []byte, fromV2 bool) ([]byte, error) {
+	yDoc := C.ydoc_new()
+	if yDoc == nil {
+		return nil, errors.New("failed to create temporary document")
+	}
+	defer C.ydoc_destroy(yDoc)
+
+	if err := applyUpdate(yDoc, update, fromV2); err != nil {
+		return nil, err
+	}
+
+	txn := C.ydoc_read_transaction(yDoc)
+	if txn == nil {
+		return nil, fmt.Errorf("failed to create read transaction: %w", ErrTransactionInProgress)
+	}
+	tracef("ydoc_read_transaction() = %p", txn)
+	defer commitTransaction(txn)
+
+	return stateDiffTxn(txn, nil, !fromV2)
+}
+
+// applyUpdate applies update to yDoc in its own write transaction, using
+// ytransaction_apply_v2 if v2 is set and ytransaction_apply otherwise.
+func applyUpdate(yDoc *C.YDoc, update []byte, v2 bool) error {
+	txn := C.ydoc_write_transaction(yDoc, 0, nil)
+	if txn == nil {
+		return fmt.Errorf("failed to create write transaction: %w", ErrTransactionInProgress)
+	}
+	tracef("ydoc_write_transaction() = %p", txn)
+	defer commitTransaction(txn)
+
+	updateC := C.CBytes(update)
+	if updateC == nil {
+		return errors.New("failed to allocate C memory for update")
+	}
+	defer C.free(updateC)
+
+	var errorCode C.uint8_t
+	if v2 {
+		tracef("ytransaction_apply_v2(%p, %d bytes)", txn, len(update))
+		errorCode = C.ytransaction_apply_v2(txn, (*C.char)(updateC), C.uint32_t(len(update)))
+	} else {
+		tracef("ytransaction_apply(%p, %d bytes)", txn, len(update))
+		errorCode = C.ytransaction_apply(txn, (*C.char)(updateC), C.uint32_t(len(update)))
+	}
+	if errorCode != 0 {
+		return fmt.Errorf("failed to apply update: error code %d", errorCode)
+	}
+	return nil
+}
+
+// stateVector returns the document's state vector encoded in Yrs format v1.
+func (d *Doc) stateVector() ([]byte, error) {
+	var sv []byte
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		var err error
+		sv, err = stateVectorTxn(txn)
+		return err
+	})
+	return sv, err
+}
+
+// stateVectorTxn is stateVector within an already open transaction.
+func stateVectorTxn(txn *C.YTransaction) ([]byte, error) {
+	var svLen C.uint32_t
+	svC := C.ytransaction_state_vector_v1(txn, &svLen)
+	if svC == nil {
+		return nil, errors.New("ytransaction_state_vector_v1 returned nil")
+	}
+	defer C.ybinary_destroy(svC, svLen)
+	return C.GoBytes(unsafe.Pointer(svC), C.int(svLen)), nil
+}
+
+// HasUpdatesSince reports whether the document holds anything the peer whose state
+// vector is remoteSV is missing, i.e. whether EncodeDiff(remoteSV) would be more than an
+// empty update, without copying the diff into Go memory. Use it to skip sending a diff
+// to a peer that is already up to date. A state vector doesn't record deletions, so the
+// diff carries every deletion the document has seen, and a document with deletions
+// reports true even to a peer that has them all; that errs on the side of sending.
+func (d *Doc) HasUpdatesSince(remoteSV []byte) (bool, error) {
+	var updateLen int
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		var err error
+		updateLen, err = stateDiffLenTxn(txn, remoteSV)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("HasUpdatesSince: %w", err)
+	}
+	return updateLen > minUpdateLen, nil
+}
+
+// stateDiffLenTxn returns the length of the v1 update encodeStateDiffTxn would return for
+// stateVector, without copying the update into Go memory.
+func stateDiffLenTxn(txn *C.YTransaction, stateVector []byte) (int, error) {
+	var svC *C.char
+	if len(stateVector) > 0 {
+		svC = (*C.char)(C.CBytes(stateVector))
+		if svC == nil {
+			return 0, errors.New("failed to allocate C memory for state vector")
+		}
+		defer C.free(unsafe.Pointer(svC))
+	}
+	var updateLen C.uint32_t
+	updateC := C.ytransaction_state_diff_v1(txn, svC, C.uint32_t(len(stateVector)), &updateLen)
+	if updateC == nil {
+		return 0, errors.New("ytransaction_state_diff_v1 returned nil")
+	}
+	C.ybinary_destroy(updateC, updateLen)
+	return int(updateLen), nil
+}
@@ -0,0 +1,586 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeFull(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	state := map[string]interface{}{
+		"title": "doc",
+		"items": []interface{}{1.0, map[string]interface{}{"k": "v"}},
+	}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	full, err := doc.EncodeFull()
+	if err != nil {
+		t.Fatalf("EncodeFull failed: %v", err)
+	}
+	legacy, err := doc.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	if !bytes.Equal(full, legacy) {
+		t.Error("expected EncodeFull to match GetStateVector")
+	}
+
+	peer, err := NewDocFromStateVector(full)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+	got, err := peer.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(got, state) {
+		t.Errorf("expected %v, got %v", state, got)
+	}
+}
+
+func TestConvertUpdateFormats(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	state := map[string]interface{}{
+		"name": "migrate",
+		"list": []interface{}{1.0, 2.0, "three"},
+	}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	v1, err := doc.EncodeFull()
+	if err != nil {
+		t.Fatalf("EncodeFull failed: %v", err)
+	}
+
+	v2, err := ConvertUpdateV1ToV2(v1)
+	if err != nil {
+		t.Fatalf("ConvertUpdateV1ToV2 failed: %v", err)
+	}
+	if bytes.Equal(v1, v2) {
+		t.Error("expected the v2 encoding to differ from v1")
+	}
+
+	back, err := ConvertUpdateV2ToV1(v2)
+	if err != nil {
+		t.Fatalf("ConvertUpdateV2ToV1 failed: %v", err)
+	}
+	peer, err := NewDocFromStateVector(back)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+	got, err := peer.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(got, state) {
+		t.Errorf("expected %v after a v1 -> v2 -> v1 round trip, got %v", state, got)
+	}
+}
+
+func TestEncodeDiffV2(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	state := make(map[string]interface{}, 1000)
+	for i := 0; i < 1000; i++ {
+		state[fmt.Sprintf("key%04d", i)] = float64(i)
+	}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	v1, err := doc.EncodeDiff(nil)
+	if err != nil {
+		t.Fatalf("EncodeDiff failed: %v", err)
+	}
+	v2, err := doc.EncodeDiffV2(nil)
+	if err != nil {
+		t.Fatalf("EncodeDiffV2 failed: %v", err)
+	}
+	if len(v2) >= len(v1) {
+		t.Errorf("expected the v2 update (%d bytes) to be smaller than v1 (%d bytes)", len(v2), len(v1))
+	}
+
+	peer := NewDoc()
+	defer peer.Destroy()
+	if err := peer.ApplyUpdateV2(v2); err != nil {
+		t.Fatalf("ApplyUpdateV2 failed: %v", err)
+	}
+	got, err := peer.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(got, state) {
+		t.Error("expected the peer to converge after applying the v2 update")
+	}
+
+	// A diff against the peer's state vector holds only what it is missing.
+	if err := doc.Set("/extra", "x"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	sv, err := peer.EncodeStateVector()
+	if err != nil {
+		t.Fatalf("EncodeStateVector failed: %v", err)
+	}
+	diff, err := doc.EncodeDiffV2(sv)
+	if err != nil {
+		t.Fatalf("EncodeDiffV2 failed: %v", err)
+	}
+	if len(diff) >= len(v2) {
+		t.Errorf("expected the diff (%d bytes) to be smaller than the full update (%d bytes)", len(diff), len(v2))
+	}
+	if err := peer.ApplyUpdateV2(diff); err != nil {
+		t.Fatalf("ApplyUpdateV2 failed: %v", err)
+	}
+	state["extra"] = "x"
+	if got, err = peer.ToJSON(); err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !compareMaps(got, state) {
+		t.Error("expected the peer to converge after applying the v2 diff")
+	}
+}
+
+func TestMergeUpdates(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	var updates [][]byte
+	for _, step := range []func() error{
+		func() error { return doc.Set("/title", "draft") },
+		func() error { return doc.Set("/items", []interface{}{"a", "b"}) },
+		func() error { return doc.Set("/title", "final") },
+	} {
+		sv, err := doc.EncodeStateVector()
+		if err != nil {
+			t.Fatalf("EncodeStateVector failed: %v", err)
+		}
+		if err := step(); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		update, err := doc.EncodeDiff(sv)
+		if err != nil {
+			t.Fatalf("EncodeDiff failed: %v", err)
+		}
+		updates = append(updates, update)
+	}
+
+	merged, err := MergeUpdates(append(updates, nil))
+	if err != nil {
+		t.Fatalf("MergeUpdates failed: %v", err)
+	}
+	oneByOne := NewDoc()
+	defer oneByOne.Destroy()
+	for _, update := range updates {
+		if err := oneByOne.ApplyUpdate(update); err != nil {
+			t.Fatalf("ApplyUpdate failed: %v", err)
+		}
+	}
+	fromMerged := NewDoc()
+	defer fromMerged.Destroy()
+	if err := fromMerged.ApplyUpdate(merged); err != nil {
+		t.Fatalf("ApplyUpdate of the merged update failed: %v", err)
+	}
+	expected, err := oneByOne.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	got, err := fromMerged.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if merged, err := MergeUpdates(nil); err != nil || merged != nil {
+		t.Errorf("expected nil for no updates, got %v (err %v)", merged, err)
+	}
+	if single, err := MergeUpdates([][]byte{updates[0]}); err != nil || !bytes.Equal(single, updates[0]) {
+		t.Errorf("expected a single update to be returned as is, got %v (err %v)", single, err)
+	}
+}
+
+func TestEncodeDiffs(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	stale := NewDoc()
+	defer stale.Destroy()
+	staleSV, err := stale.stateVector()
+	if err != nil {
+		t.Fatalf("stateVector failed: %v", err)
+	}
+	current, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer current.Destroy()
+	currentSV, err := current.stateVector()
+	if err != nil {
+		t.Fatalf("stateVector failed: %v", err)
+	}
+
+	if err := doc.Set("/b", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	diffs, err := doc.EncodeDiffs([][]byte{staleSV, currentSV, nil})
+	if err != nil {
+		t.Fatalf("EncodeDiffs failed: %v", err)
+	}
+	if len(diffs) != 3 {
+		t.Fatalf("expected 3 diffs, got %d", len(diffs))
+	}
+	if !bytes.Equal(diffs[2], mustEncodeFull(t, doc)) {
+		t.Error("expected a nil state vector to yield the full document")
+	}
+	if len(diffs[1]) >= len(diffs[0]) {
+		t.Errorf("expected the up-to-date peer's diff (%d bytes) to be smaller than the stale peer's (%d bytes)", len(diffs[1]), len(diffs[0]))
+	}
+
+	expected := map[string]interface{}{"a": 1.0, "b": 2.0}
+	for _, peer := range []struct {
+		doc  *Doc
+		diff []byte
+	}{{stale, diffs[0]}, {current, diffs[1]}} {
+		if err := peer.doc.ApplyStateVector(peer.diff); err != nil {
+			t.Fatalf("ApplyStateVector failed: %v", err)
+		}
+		got, err := peer.doc.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON failed: %v", err)
+		}
+		if !compareMaps(got, expected) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestEncodeStateVector(t *testing.T) {
+	a, err := NewDocFromJSON(map[string]interface{}{"title": "a fairly long title", "n": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer a.Destroy()
+	b, err := NewDocFromStateVector(mustEncodeFull(t, a))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer b.Destroy()
+	if err := a.Set("/n", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	sv, err := b.EncodeStateVector()
+	if err != nil {
+		t.Fatalf("EncodeStateVector failed: %v", err)
+	}
+	full, err := b.EncodeStateAsUpdate()
+	if err != nil {
+		t.Fatalf("EncodeStateAsUpdate failed: %v", err)
+	}
+	if len(sv) >= len(full) {
+		t.Errorf("expected the state vector (%d bytes) to be smaller than the full update (%d bytes)", len(sv), len(full))
+	}
+
+	// b sends its state vector and applies the reply, which holds only what it lacks.
+	diff, err := a.EncodeDiff(sv)
+	if err != nil {
+		t.Fatalf("EncodeDiff failed: %v", err)
+	}
+	if len(diff) >= len(mustEncodeFull(t, a)) {
+		t.Errorf("expected the diff (%d bytes) to be smaller than the full document", len(diff))
+	}
+	if err := b.ApplyStateVector(diff); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	got, err := b.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"title": "a fairly long title", "n": 2.0}; !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	legacy, err := b.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	if current := mustEncodeFull(t, b); !bytes.Equal(legacy, current) {
+		t.Error("expected GetStateVector to still return the full update")
+	}
+}
+
+func TestEncodeDiffBothWays(t *testing.T) {
+	a, err := NewDocFromJSON(map[string]interface{}{"shared": "base"})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer a.Destroy()
+	b, err := NewDocFromStateVector(mustEncodeFull(t, a))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer b.Destroy()
+	if err := a.Set("/fromA", 1.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := b.Set("/fromB", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	svA, err := a.EncodeStateVector()
+	if err != nil {
+		t.Fatalf("EncodeStateVector failed: %v", err)
+	}
+	svB, err := b.EncodeStateVector()
+	if err != nil {
+		t.Fatalf("EncodeStateVector failed: %v", err)
+	}
+	forA, err := b.EncodeDiff(svA)
+	if err != nil {
+		t.Fatalf("EncodeDiff failed: %v", err)
+	}
+	forB, err := a.EncodeDiff(svB)
+	if err != nil {
+		t.Fatalf("EncodeDiff failed: %v", err)
+	}
+	if err := a.ApplyStateVector(forA); err != nil {
+		t.Fatalf("ApplyStateVector on a failed: %v", err)
+	}
+	if err := b.ApplyStateVector(forB); err != nil {
+		t.Fatalf("ApplyStateVector on b failed: %v", err)
+	}
+
+	expected := map[string]interface{}{"shared": "base", "fromA": 1.0, "fromB": 2.0}
+	for name, doc := range map[string]*Doc{"a": a, "b": b} {
+		got, err := doc.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON on %s failed: %v", name, err)
+		}
+		if !compareMaps(got, expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+	}
+
+	full, err := a.EncodeDiff(nil)
+	if err != nil {
+		t.Fatalf("EncodeDiff(nil) failed: %v", err)
+	}
+	if !bytes.Equal(full, mustEncodeFull(t, a)) {
+		t.Error("expected a nil state vector to yield the full document")
+	}
+}
+
+func TestEncodePaths(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"public":  map[string]interface{}{"title": "t", "tags": []interface{}{"a"}},
+		"private": map[string]interface{}{"secret": "s"},
+		"items":   []interface{}{map[string]interface{}{"name": "first", "cost": 3.0}},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	update, err := doc.EncodePaths([]string{"/public", "/items/0/name", "/missing"})
+	if err != nil {
+		t.Fatalf("EncodePaths failed: %v", err)
+	}
+	peer, err := NewDocFromStateVector(update)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+	got, err := peer.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"public": map[string]interface{}{"title": "t", "tags": []interface{}{"a"}},
+		"items":  map[string]interface{}{"0": map[string]interface{}{"name": "first"}},
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func mustEncodeFull(t *testing.T, doc *Doc) []byte {
+	t.Helper()
+	update, err := doc.EncodeFull()
+	if err != nil {
+		t.Fatalf("EncodeFull failed: %v", err)
+	}
+	return update
+}
+
+// yjsUpdate is the v1 update, byte for byte as Yjs lays it out, of client 1234 running:
+//
+//	const root = doc.getMap("root")
+//	root.set("title", "hello")
+//	const body = new Y.Text(); root.set("body", body); body.insert(0, "hé😀")
+//	const meta = new Y.Map(); root.set("meta", meta); meta.set("n", 1)
+//	body.insert(4, "!")
+var yjsUpdate = []byte{
+	0x01, 0x06, 0xd2, 0x09, 0x00, // 1 client, 6 structs, client 1234, starting at clock 0
+	0x28, 0x01, 0x04, 'r', 'o', 'o', 't', 0x05, 't', 'i', 't', 'l', 'e', 0x01, 0x77, 0x05, 'h', 'e', 'l', 'l', 'o',
+	0x27, 0x01, 0x04, 'r', 'o', 'o', 't', 0x04, 'b', 'o', 'd', 'y', 0x02, // YText at clock 1
+	0x04, 0x00, 0xd2, 0x09, 0x01, 0x07, 'h', 0xc3, 0xa9, 0xf0, 0x9f, 0x98, 0x80, // 4 UTF-16 units at clock 2
+	0x27, 0x01, 0x04, 'r', 'o', 'o', 't', 0x04, 'm', 'e', 't', 'a', 0x01, // YMap at clock 6
+	0x28, 0x00, 0xd2, 0x09, 0x06, 0x01, 'n', 0x01, 0x7d, 0x01,
+	0x84, 0xd2, 0x09, 0x05, 0x01, '!', // origin is the last unit of "hé😀"
+	0x00, // empty delete set
+}
+
+// yjsContentUpdate is the v1 update, laid out as Yjs writes it, of client 1234 running:
+//
+//	doc.getMap("content").set("title", "hello")
+var yjsContentUpdate = []byte{
+	0x01, 0x01, 0xd2, 0x09, 0x00, // 1 client, 1 struct, client 1234, starting at clock 0
+	0x28, 0x01, 0x07, 'c', 'o', 'n', 't', 'e', 'n', 't', 0x05, 't', 'i', 't', 'l', 'e', 0x01, 0x77, 0x05, 'h', 'e', 'l', 'l', 'o',
+	0x00, // empty delete set
+}
+
+func TestLoadYDoc(t *testing.T) {
+	doc, err := LoadYDoc(yjsContentUpdate)
+	if err != nil {
+		t.Fatalf("LoadYDoc failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"title": "hello"}; !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Edits land in the "content" map, so the result can go back to the browser.
+	if err := doc.Set("/done", true); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	reloaded, err := LoadYDoc(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("LoadYDoc of the re-encoded document failed: %v", err)
+	}
+	defer reloaded.Destroy()
+	if got, err := reloaded.ToJSON(); err != nil || !compareMaps(got, map[string]interface{}{"title": "hello", "done": true}) {
+		t.Errorf("unexpected round-tripped state %v (err %v)", got, err)
+	}
+	if bytes.Contains(mustEncodeFull(t, doc), []byte("root")) {
+		t.Error("expected no \"root\" type to be added to the document")
+	}
+
+	// Documents using "root" load as before.
+	standard, err := LoadYDoc(yjsUpdate)
+	if err != nil {
+		t.Fatalf("LoadYDoc failed: %v", err)
+	}
+	defer standard.Destroy()
+	if got, err := standard.ToJSON(); err != nil || got["title"] != "hello" {
+		t.Errorf("unexpected state %v (err %v)", got, err)
+	}
+
+	// doc.getArray("items").push([1]), with the root detected as an array.
+	items, err := LoadYDoc([]byte{0x01, 0x01, 0xd2, 0x09, 0x00, 0x08, 0x01, 0x05, 'i', 't', 'e', 'm', 's', 0x01, 0x7d, 0x01, 0x00})
+	if err != nil {
+		t.Fatalf("LoadYDoc of an array root failed: %v", err)
+	}
+	defer items.Destroy()
+	if got, err := items.ToJSONArray(); err != nil || !reflect.DeepEqual(got, []interface{}{1.0}) {
+		t.Errorf("expected [1], got %v (err %v)", got, err)
+	}
+
+	if _, err := LoadYDoc([]byte{0x00, 0x00}); err == nil {
+		t.Error("expected an error for an update without a root type")
+	}
+}
+
+func TestApplyStateVectorFromYjs(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if err := doc.ApplyStateVector(yjsUpdate); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"title": "hello",
+		"body":  "hé😀!",
+		"meta":  map[string]interface{}{"n": 1.0},
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Re-encoding must reproduce the Yjs bytes, so Yjs peers can read our updates too.
+	full, err := doc.EncodeFull()
+	if err != nil {
+		t.Fatalf("EncodeFull failed: %v", err)
+	}
+	if !bytes.Equal(full, yjsUpdate) {
+		t.Errorf("expected re-encoded update to match Yjs:\n% x\ngot:\n% x", yjsUpdate, full)
+	}
+}
+
+func TestHasUpdatesSince(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	if err := doc.Set("/a", 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	peer := NewDoc()
+	defer peer.Destroy()
+
+	check := func(name string, expected bool) {
+		t.Helper()
+		sv, err := peer.EncodeStateVector()
+		if err != nil {
+			t.Fatalf("EncodeStateVector failed: %v", err)
+		}
+		has, err := doc.HasUpdatesSince(sv)
+		if err != nil {
+			t.Fatalf("HasUpdatesSince failed: %v", err)
+		}
+		if has != expected {
+			t.Errorf("%s: expected %v, got %v", name, expected, has)
+		}
+	}
+
+	check("new peer", true)
+	if err := peer.ApplyUpdate(mustEncodeFull(t, doc)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	check("synced peer", false)
+	if err := doc.Set("/b", 2); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	check("after a local write", true)
+
+	// Deletions are always part of the diff, so they are reported even once synced.
+	if err := doc.ApplyMergePatch([]byte(`{"a": null}`)); err != nil {
+		t.Fatalf("ApplyMergePatch failed: %v", err)
+	}
+	if err := peer.ApplyUpdate(mustEncodeFull(t, doc)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	check("synced peer after a deletion", true)
+}
@@ -0,0 +1,170 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ephemeralEntry is the latest value of one ephemeral key. Entries are ordered by Clock,
+// then by Client, so every peer keeps the same one.
+type ephemeralEntry struct {
+	Clock  uint64          `json:"clock"`
+	Client uint64          `json:"client"`
+	Value  json.RawMessage `json:"value"`
+}
+
+func (e ephemeralEntry) newerThan(other ephemeralEntry) bool {
+	if e.Clock != other.Clock {
+		return e.Clock > other.Clock
+	}
+	return e.Client > other.Client
+}
+
+type ephemeralObserver struct {
+	fn func(key string, value interface{})
+}
+
+type ephemeralState struct {
+	mu        sync.Mutex
+	clock     uint64
+	entries   map[string]ephemeralEntry
+	observers []*ephemeralObserver
+}
+
+// SetEphemeral sets key to value in the document's ephemeral side channel, for presence
+// data such as cursors or typing indicators. Ephemeral data is not part of the Yrs
+// document: it is never included in EncodeStateAsUpdate, EncodeFull or any other update, and
+// is lost when the Doc is destroyed. Broadcast it to peers with EncodeEphemeral and
+// ApplyEphemeral instead. value must be encodable with encoding/json; set it to nil to
+// clear a key. Observers registered with ObserveEphemeral are notified before
+// SetEphemeral returns.
+func (d *Doc) SetEphemeral(key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("SetEphemeral %q: %w", key, err)
+	}
+
+	d.ephemeral.mu.Lock()
+	d.ephemeral.clock++
+	entry := ephemeralEntry{Clock: d.ephemeral.clock, Client: d.ClientID(), Value: raw}
+	if d.ephemeral.entries == nil {
+		d.ephemeral.entries = make(map[string]ephemeralEntry)
+	}
+	d.ephemeral.entries[key] = entry
+	observers := append([]*ephemeralObserver(nil), d.ephemeral.observers...)
+	d.ephemeral.mu.Unlock()
+
+	notifyEphemeral(observers, map[string]ephemeralEntry{key: entry})
+	return nil
+}
+
+// Ephemeral returns the current value of an ephemeral key, decoded as by ToJSON, and
+// whether it has been set locally or received from a peer.
+func (d *Doc) Ephemeral(key string) (interface{}, bool) {
+	d.ephemeral.mu.Lock()
+	entry, ok := d.ephemeral.entries[key]
+	d.ephemeral.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	var value interface{}
+	if err := json.Unmarshal(entry.Value, &value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// EncodeEphemeral returns all ephemeral data known to d, as a message to broadcast to
+// peers, which load it with ApplyEphemeral. The message is JSON and unrelated to the Yrs
+// update format.
+func (d *Doc) EncodeEphemeral() ([]byte, error) {
+	d.ephemeral.mu.Lock()
+	defer d.ephemeral.mu.Unlock()
+	data, err := json.Marshal(d.ephemeral.entries)
+	if err != nil {
+		return nil, fmt.Errorf("EncodeEphemeral: %w", err)
+	}
+	return data, nil
+}
+
+// ApplyEphemeral merges a message produced by EncodeEphemeral on a peer. For each key the
+// most recently set value wins, whichever order messages arrive in, so messages may be
+// delivered more than once or out of order. Observers are notified of the keys whose
+// value changed.
+func (d *Doc) ApplyEphemeral(data []byte) error {
+	var incoming map[string]ephemeralEntry
+	if err := json.Unmarshal(data, &incoming); err != nil {
+		return fmt.Errorf("ApplyEphemeral: %w", err)
+	}
+
+	d.ephemeral.mu.Lock()
+	changed := make(map[string]ephemeralEntry)
+	for key, entry := range incoming {
+		if current, ok := d.ephemeral.entries[key]; ok && !entry.newerThan(current) {
+			continue
+		}
+		if d.ephemeral.entries == nil {
+			d.ephemeral.entries = make(map[string]ephemeralEntry)
+		}
+		d.ephemeral.entries[key] = entry
+		changed[key] = entry
+		// Keep local clocks ahead of everything seen, so the next SetEphemeral wins.
+		if entry.Clock > d.ephemeral.clock {
+			d.ephemeral.clock = entry.Clock
+		}
+	}
+	observers := append([]*ephemeralObserver(nil), d.ephemeral.observers...)
+	d.ephemeral.mu.Unlock()
+
+	notifyEphemeral(observers, changed)
+	return nil
+}
+
+// ObserveEphemeral registers fn to be called with every ephemeral key whose value
+// changes, whether set locally or applied from a peer. fn runs synchronously on the
+// goroutine that made the change. The returned stop unregisters it; calling it more than
+// once is harmless.
+func (d *Doc) ObserveEphemeral(fn func(key string, value interface{})) (stop func()) {
+	o := &ephemeralObserver{fn: fn}
+	d.ephemeral.mu.Lock()
+	d.ephemeral.observers = append(d.ephemeral.observers, o)
+	d.ephemeral.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.ephemeral.mu.Lock()
+			defer d.ephemeral.mu.Unlock()
+			for i, other := range d.ephemeral.observers {
+				if other == o {
+					d.ephemeral.observers = append(d.ephemeral.observers[:i], d.ephemeral.observers[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+}
+
+// notifyEphemeral calls every observer with each changed entry. It must be called without
+// holding the ephemeral lock, so observers may use the Doc.
+func notifyEphemeral(observers []*ephemeralObserver, changed map[string]ephemeralEntry) {
+	if len(observers) == 0 {
+		return
+	}
+	for key, entry := range changed {
+		var value interface{}
+		if err := json.Unmarshal(entry.Value, &value); err != nil {
+			continue
+		}
+		for _, o := range observers {
+			o.fn(key, value)
+		}
+	}
+}
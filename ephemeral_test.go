@@ -0,0 +1,89 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEphemeral(t *testing.T) {
+	a := NewDoc()
+	defer a.Destroy()
+	b := NewDoc()
+	defer b.Destroy()
+
+	before := mustEncodeFull(t, a)
+
+	observed := make(map[string]interface{})
+	stop := b.ObserveEphemeral(func(key string, value interface{}) {
+		observed[key] = value
+	})
+	defer stop()
+
+	if err := a.SetEphemeral("typing", true); err != nil {
+		t.Fatalf("SetEphemeral failed: %v", err)
+	}
+	msg, err := a.EncodeEphemeral()
+	if err != nil {
+		t.Fatalf("EncodeEphemeral failed: %v", err)
+	}
+	if err := b.ApplyEphemeral(msg); err != nil {
+		t.Fatalf("ApplyEphemeral failed: %v", err)
+	}
+	if v, ok := b.Ephemeral("typing"); !ok || v != true {
+		t.Errorf("expected typing=true on the peer, got %v (set %v)", v, ok)
+	}
+	if observed["typing"] != true {
+		t.Errorf("expected the observer to see typing=true, got %v", observed)
+	}
+
+	// A newer local value is not overwritten by a stale message arriving late.
+	if err := a.SetEphemeral("typing", false); err != nil {
+		t.Fatalf("SetEphemeral failed: %v", err)
+	}
+	if err := a.ApplyEphemeral(msg); err != nil {
+		t.Fatalf("ApplyEphemeral failed: %v", err)
+	}
+	if v, _ := a.Ephemeral("typing"); v != false {
+		t.Errorf("expected the stale message to be ignored, got typing=%v", v)
+	}
+
+	if after := mustEncodeFull(t, a); !bytes.Equal(before, after) {
+		t.Error("expected ephemeral data to be excluded from the document state")
+	}
+}
+
+func TestApplyEphemeralFromPeerWins(t *testing.T) {
+	a := NewDoc()
+	defer a.Destroy()
+	b := NewDoc()
+	defer b.Destroy()
+
+	for i := 0; i < 3; i++ {
+		if err := a.SetEphemeral("cursor", i); err != nil {
+			t.Fatalf("SetEphemeral failed: %v", err)
+		}
+	}
+	msg, err := a.EncodeEphemeral()
+	if err != nil {
+		t.Fatalf("EncodeEphemeral failed: %v", err)
+	}
+	if err := b.ApplyEphemeral(msg); err != nil {
+		t.Fatalf("ApplyEphemeral failed: %v", err)
+	}
+	// b has seen a's clock, so its own next value supersedes a's on both sides.
+	if err := b.SetEphemeral("cursor", 10); err != nil {
+		t.Fatalf("SetEphemeral failed: %v", err)
+	}
+	msg, err = b.EncodeEphemeral()
+	if err != nil {
+		t.Fatalf("EncodeEphemeral failed: %v", err)
+	}
+	if err := a.ApplyEphemeral(msg); err != nil {
+		t.Fatalf("ApplyEphemeral failed: %v", err)
+	}
+	if v, _ := a.Ephemeral("cursor"); v != 10.0 {
+		t.Errorf("expected cursor=10 after the exchange, got %v", v)
+	}
+}
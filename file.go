@@ -0,0 +1,63 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrInvalidFile is returned by LoadDocFromFile for a file that was not written by
+// SaveToFile, or by a version of it this package cannot read.
+var ErrInvalidFile = errors.New("not a document file")
+
+// fileMagic starts every file written by SaveToFile, followed by fileVersion and then the
+// document's full state as a v1 update.
+var fileMagic = []byte("YDOC")
+
+// fileVersion is the version of the file layout written by SaveToFile.
+const fileVersion byte = 1
+
+// SaveToFile writes the document's full state (see EncodeStateAsUpdate, which also marks
+// it clean) to the file at path, replacing it if it exists. The state is preceded by a
+// short header identifying the file, so LoadDocFromFile can reject anything else. Like
+// FileStore, it writes a temporary file and renames it into place.
+func (d *Doc) SaveToFile(path string) error {
+	update, err := d.EncodeStateAsUpdate()
+	if err != nil {
+		return fmt.Errorf("SaveToFile %s: %w", path, err)
+	}
+	data := make([]byte, 0, len(fileMagic)+1+len(update))
+	data = append(append(append(data, fileMagic...), fileVersion), update...)
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("SaveToFile %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadDocFromFile returns a new Doc holding the state saved to the file at path by
+// SaveToFile. A file without the header SaveToFile writes yields an error wrapping
+// ErrInvalidFile rather than being fed to Yrs. The loaded document matches the file, so
+// it starts out clean (see Dirty).
+func LoadDocFromFile(path string) (*Doc, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadDocFromFile: %w", err)
+	}
+	if !bytes.HasPrefix(data, fileMagic) || len(data) == len(fileMagic) {
+		return nil, fmt.Errorf("LoadDocFromFile %s: %w: missing header", path, ErrInvalidFile)
+	}
+	if version := data[len(fileMagic)]; version != fileVersion {
+		return nil, fmt.Errorf("LoadDocFromFile %s: %w: unsupported version %d", path, ErrInvalidFile, version)
+	}
+
+	doc := NewDoc()
+	if err := doc.ApplyUpdate(data[len(fileMagic)+1:]); err != nil {
+		doc.Destroy()
+		return nil, fmt.Errorf("LoadDocFromFile %s: %w", path, err)
+	}
+	doc.MarkClean()
+	return doc, nil
+}
@@ -0,0 +1,67 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.ydoc")
+	state := map[string]interface{}{
+		"title": "hello",
+		"items": []interface{}{1.0, "two"},
+	}
+	doc, err := NewDocFromJSON(state)
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	if err := doc.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile failed: %v", err)
+	}
+	if doc.Dirty() {
+		t.Error("expected SaveToFile to mark the document clean")
+	}
+	loaded, err := LoadDocFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadDocFromFile failed: %v", err)
+	}
+	defer loaded.Destroy()
+	got, err := loaded.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, state) {
+		t.Errorf("expected %v, got %v", state, got)
+	}
+	if loaded.Dirty() {
+		t.Error("expected the loaded document to be clean")
+	}
+
+	update, err := doc.EncodeFull()
+	if err != nil {
+		t.Fatalf("EncodeFull failed: %v", err)
+	}
+	for name, data := range map[string][]byte{
+		"raw update":  update,
+		"header only": []byte("YDOC"),
+		"version 9":   append([]byte("YDOC\x09"), update...),
+	} {
+		bad := filepath.Join(t.TempDir(), "bad.ydoc")
+		if err := os.WriteFile(bad, data, 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if _, err := LoadDocFromFile(bad); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("%s: expected ErrInvalidFile, got %v", name, err)
+		}
+	}
+	if _, err := LoadDocFromFile(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist for a missing file, got %v", err)
+	}
+}
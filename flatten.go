@@ -0,0 +1,133 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// pointerEscaper escapes a map key for use as a JSON Pointer segment (RFC 6901).
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// pointerUnescaper reverses pointerEscaper. A single left-to-right pass decodes "~01" to
+// "~1", as RFC 6901 requires, rather than to "/".
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// Flatten returns the document as a map from the JSON Pointer of every leaf to its value,
+// e.g. {"a": {"b": [1]}} becomes {"/a/b/0": 1}. Map keys are escaped per RFC 6901 and
+// array elements use their index as the segment. Empty maps and arrays have no leaves
+// below them, so they are reported as leaves themselves to keep them visible.
+//
+// Leaves are read straight from their Yrs values rather than through JSON, so they keep
+// their stored type: integers are int64 and floats are float64, even when a float is
+// whole. Strings and YText are string, binary values []byte, and null is nil.
+func (d *Doc) Flatten() (map[string]interface{}, error) {
+	flat := make(map[string]interface{})
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		return flattenBranch(txn, rootBranch, "", flat)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Flatten: %w", err)
+	}
+	return flat, nil
+}
+
+// flattenBranch adds the leaves below branch, which is at pointer, to flat. The root,
+// at the empty pointer, is never reported as a leaf itself.
+func flattenBranch(txn *C.YTransaction, branch *C.Branch, pointer string, flat map[string]interface{}) error {
+	switch C.ytype_kind(branch) {
+	case C.Y_MAP:
+		iter := C.ymap_iter(branch, txn)
+		defer C.ymap_iter_destroy(iter)
+		empty := true
+		for entry := C.ymap_iter_next(iter); entry != nil; entry = C.ymap_iter_next(iter) {
+			empty = false
+			err := flattenOutput(txn, entry.value, pointer+"/"+pointerEscaper.Replace(C.GoString(entry.key)), flat)
+			C.ymap_entry_destroy(entry)
+			if err != nil {
+				return err
+			}
+		}
+		if empty && pointer != "" {
+			flat[pointer] = map[string]interface{}{}
+		}
+	case C.Y_ARRAY:
+		n := C.yarray_len(branch)
+		for i := C.uint32_t(0); i < n; i++ {
+			output := C.yarray_get(branch, txn, i)
+			if output == nil {
+				return fmt.Errorf("failed to get element at %s/%d", pointer, i)
+			}
+			err := flattenOutput(txn, output, fmt.Sprintf("%s/%d", pointer, i), flat)
+			C.youtput_destroy(output)
+			if err != nil {
+				return err
+			}
+		}
+		if n == 0 && pointer != "" {
+			flat[pointer] = []interface{}{}
+		}
+	case C.Y_TEXT:
+		flat[pointer] = textString(txn, branch)
+	default:
+		return fmt.Errorf("%s: unsupported value type (kind: %d)", pointer, C.ytype_kind(branch))
+	}
+	return nil
+}
+
+// flattenOutput adds the leaves of output, which is at pointer, to flat.
+func flattenOutput(txn *C.YTransaction, output *C.YOutput, pointer string, flat map[string]interface{}) error {
+	switch output.tag {
+	case C.Y_MAP:
+		return flattenBranch(txn, C.youtput_read_ymap(output), pointer, flat)
+	case C.Y_ARRAY:
+		return flattenBranch(txn, C.youtput_read_yarray(output), pointer, flat)
+	case C.Y_TEXT:
+		return flattenBranch(txn, C.youtput_read_ytext(output), pointer, flat)
+	case C.Y_JSON_MAP:
+		if output.len == 0 {
+			flat[pointer] = map[string]interface{}{}
+			return nil
+		}
+		entries := unsafe.Slice(C.youtput_read_json_map(output), output.len)
+		for i := range entries {
+			key := C.GoString(entries[i].key)
+			if err := flattenOutput(txn, entries[i].value, pointer+"/"+pointerEscaper.Replace(key), flat); err != nil {
+				return err
+			}
+		}
+	case C.Y_JSON_ARR:
+		if output.len == 0 {
+			flat[pointer] = []interface{}{}
+			return nil
+		}
+		elems := unsafe.Slice(C.youtput_read_json_array(output), output.len)
+		for i := range elems {
+			if err := flattenOutput(txn, &elems[i], pointer+"/"+strconv.Itoa(i), flat); err != nil {
+				return err
+			}
+		}
+	case C.Y_JSON_INT:
+		flat[pointer] = int64(*C.youtput_read_long(output))
+	case C.Y_JSON_NUM:
+		flat[pointer] = float64(*C.youtput_read_float(output))
+	case C.Y_JSON_BOOL:
+		flat[pointer] = *C.youtput_read_bool(output) != 0
+	case C.Y_JSON_STR:
+		flat[pointer] = C.GoString(C.youtput_read_string(output))
+	case C.Y_JSON_BUF:
+		flat[pointer] = C.GoBytes(unsafe.Pointer(C.youtput_read_binary(output)), C.int(output.len))
+	case C.Y_JSON_NULL, C.Y_JSON_UNDEF:
+		flat[pointer] = nil
+	default:
+		return fmt.Errorf("%s: unsupported value type (tag: %d)", pointer, output.tag)
+	}
+	return nil
+}
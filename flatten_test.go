@@ -0,0 +1,78 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"a":     map[string]interface{}{"b": []interface{}{int64(1), map[string]interface{}{"c": "x"}}},
+		"k/~e":  true,
+		"empty": map[string]interface{}{},
+		"none":  []interface{}{},
+		"null":  nil,
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	flat, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Flatten failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"/a/b/0":   int64(1),
+		"/a/b/1/c": "x",
+		"/k~1~0e":  true,
+		"/empty":   map[string]interface{}{},
+		"/none":    []interface{}{},
+		"/null":    nil,
+	}
+	if len(flat) != len(expected) {
+		t.Fatalf("expected %d leaves, got %d: %v", len(expected), len(flat), flat)
+	}
+	for pointer, want := range expected {
+		got, ok := flat[pointer]
+		if !ok {
+			t.Errorf("missing leaf %q", pointer)
+			continue
+		}
+		if !reflect.DeepEqual(toFloat(got), toFloat(want)) {
+			t.Errorf("leaf %q: expected %#v, got %#v", pointer, want, got)
+		}
+	}
+}
+
+func TestFlattenKeepsNumberTypes(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"int":   5,
+		"float": 5.0,
+		"list":  []interface{}{int64(1) << 60, 0.5},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	if err := doc.SetText("/note", "hi"); err != nil {
+		t.Fatalf("SetText failed: %v", err)
+	}
+
+	flat, err := doc.Flatten()
+	if err != nil {
+		t.Fatalf("Flatten failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"/note":   "hi",
+		"/int":    int64(5),
+		"/float":  5.0,
+		"/list/0": int64(1) << 60,
+		"/list/1": 0.5,
+	}
+	if !reflect.DeepEqual(flat, expected) {
+		t.Errorf("expected %#v, got %#v", expected, flat)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+)
+
+// ErrFrozen is returned by every method that modifies a document after Freeze has been
+// called on it.
+var ErrFrozen = errors.New("document is frozen")
+
+// Freeze makes the document read-only: from then on every write, including
+// ApplyOperations, Set, ApplyUpdate and the array and text helpers, fails with an
+// error wrapping ErrFrozen, while reads and encodings keep working. Writes already in
+// progress complete. A frozen document cannot be unfrozen; load its encoded state into
+// a new Doc to edit it again.
+func (d *Doc) Freeze() {
+	d.frozen.Store(true)
+}
+
+// Frozen reports whether Freeze has been called on the document.
+func (d *Doc) Frozen() bool {
+	return d.frozen.Load()
+}
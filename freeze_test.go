@@ -0,0 +1,54 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestFreeze(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"list": []interface{}{1.0}})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	update, err := doc.EncodeFull()
+	if err != nil {
+		t.Fatalf("EncodeFull failed: %v", err)
+	}
+
+	doc.Freeze()
+	if !doc.Frozen() {
+		t.Fatal("expected Frozen to report true after Freeze")
+	}
+
+	writes := map[string]func() error{
+		"ApplyOperations": func() error {
+			return doc.ApplyOperations(jsonpatch.JSONPatchList{})
+		},
+		"Set":              func() error { return doc.Set("/k", "v") },
+		"ApplyStateVector": func() error { return doc.ApplyStateVector(update) },
+		"SetArray":         func() error { return doc.SetArray("/list", nil) },
+		"UpdateToState": func() error {
+			_, err := doc.UpdateToState(map[string]interface{}{})
+			return err
+		},
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrFrozen) {
+			t.Errorf("%s: expected ErrFrozen, got %v", name, err)
+		}
+	}
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed on a frozen document: %v", err)
+	}
+	expected := map[string]interface{}{"list": []interface{}{1.0}}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,361 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+// readOutput navigates to the value at path and passes its YOutput to fn inside a read
+// transaction. The output is destroyed once fn returns.
+func (d *Doc) readOutput(path string, fn func(txn *C.YTransaction, output *C.YOutput) error) error {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return err
+	}
+	if len(pathSegments) == 0 {
+		return errors.New("path must address a value below the root")
+	}
+	return d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		return readOutputTxn(txn, rootBranch, pathSegments, fn)
+	})
+}
+
+// readOutputTxn is readOutput within an already open transaction, for a non-empty path.
+func readOutputTxn(txn *C.YTransaction, rootBranch *C.Branch, pathSegments []string, fn func(txn *C.YTransaction, output *C.YOutput) error) error {
+	output, outputs, err := outputAtPath(txn, rootBranch, pathSegments)
+	if err != nil {
+		return err
+	}
+	defer destroyOutputs(outputs)
+	return fn(txn, output)
+}
+
+// outputAtPath returns the output of the value pathSegments address below rootBranch,
+// along with the outputs backing it, which the caller must destroy once done with it.
+// Unlike navigateToParent, it also descends into maps and arrays stored as embedded JSON
+// (see ErrEmbeddedJSON), whose values are borrowed from the output that holds them.
+func outputAtPath(txn *C.YTransaction, rootBranch *C.Branch, pathSegments []string) (*C.YOutput, []*C.YOutput, error) {
+	var outputs []*C.YOutput
+	branch := rootBranch
+	var output *C.YOutput
+	for i, segment := range pathSegments {
+		// Only a failure to look up the last segment itself is reported as is.
+		fail := func(err error) (*C.YOutput, []*C.YOutput, error) {
+			destroyOutputs(outputs)
+			if i < len(pathSegments)-1 {
+				err = fmt.Errorf("navigation failed: %w", err)
+			}
+			return nil, nil, err
+		}
+		if output != nil {
+			switch output.tag {
+			case C.Y_MAP:
+				branch = C.youtput_read_ymap(output)
+			case C.Y_ARRAY:
+				branch = C.youtput_read_yarray(output)
+			case C.Y_JSON_MAP, C.Y_JSON_ARR:
+				child, err := embeddedChild(output, segment)
+				if err != nil {
+					return fail(err)
+				}
+				if child == nil {
+					return fail(fmt.Errorf("path segment '%s' not found", segment))
+				}
+				output = child
+				continue
+			default:
+				destroyOutputs(outputs)
+				return nil, nil, fmt.Errorf("navigation failed: cannot navigate through non-container type at path segment '%s'", pathSegments[i-1])
+			}
+		}
+
+		var keyOrIndex interface{} = segment
+		switch C.ytype_kind(branch) {
+		case C.Y_MAP:
+		case C.Y_ARRAY:
+			index, err := parseArrayIndex(segment)
+			if err != nil {
+				return fail(fmt.Errorf("invalid array index '%s' in path: %w", segment, err))
+			}
+			keyOrIndex = index
+		default:
+			return fail(fmt.Errorf("cannot navigate through non-container type at path segment '%s'", segment))
+		}
+		child, err := getOutput(txn, branch, keyOrIndex, segment)
+		if err != nil {
+			return fail(err)
+		}
+		outputs = append(outputs, child)
+		output = child
+	}
+	return output, outputs, nil
+}
+
+// embeddedChild returns the value at segment of output, a map or array stored as embedded
+// JSON, or nil if there is none. The returned output is owned by output.
+func embeddedChild(output *C.YOutput, segment string) (*C.YOutput, error) {
+	if output.len == 0 {
+		if output.tag == C.Y_JSON_ARR {
+			if _, err := parseArrayIndex(segment); err != nil {
+				return nil, fmt.Errorf("invalid array index '%s' in path: %w", segment, err)
+			}
+		}
+		return nil, nil
+	}
+	if output.tag == C.Y_JSON_MAP {
+		entries := unsafe.Slice(C.youtput_read_json_map(output), output.len)
+		for i := range entries {
+			if C.GoString(entries[i].key) == segment {
+				return entries[i].value, nil
+			}
+		}
+		return nil, nil
+	}
+	index, err := parseArrayIndex(segment)
+	if err != nil {
+		return nil, fmt.Errorf("invalid array index '%s' in path: %w", segment, err)
+	}
+	if index >= C.uint32_t(output.len) {
+		return nil, nil
+	}
+	return &unsafe.Slice(C.youtput_read_json_array(output), output.len)[index], nil
+}
+
+// outputInt64 reads an integer from output. Integral floats are accepted as well, since
+// peers that round-trip through JSON may have stored whole numbers as floats.
+func outputInt64(output *C.YOutput) (int64, error) {
+	switch output.tag {
+	case C.Y_JSON_INT:
+		return int64(*C.youtput_read_long(output)), nil
+	case C.Y_JSON_NUM:
+		f := float64(*C.youtput_read_float(output))
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, fmt.Errorf("number %v is not an int64", f)
+		}
+		return int64(f), nil
+	default:
+		return 0, fmt.Errorf("value is not a number (tag: %d)", output.tag)
+	}
+}
+
+// GetValueAtPath reads the value at path, without serializing the rest of the document.
+// Scalars, maps and arrays are returned as ToJSON returns them; the empty path returns
+// the whole document. A missing key or an out-of-bounds index is an error.
+func (d *Doc) GetValueAtPath(path string) (interface{}, error) {
+	if path == "" {
+		value, err := d.rootValue(ReadOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("GetValueAtPath %s: %w", path, err)
+		}
+		return value, nil
+	}
+	var value interface{}
+	err := d.readOutput(path, func(txn *C.YTransaction, output *C.YOutput) error {
+		var err error
+		value, err = outputValue(txn, output, ReadOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetValueAtPath %s: %w", path, err)
+	}
+	return value, nil
+}
+
+// Exists reports whether path addresses a value, so callers can pick "add" or "replace"
+// without reading the document. A missing key or an out-of-range index anywhere along
+// path reports false; only a malformed path, an invalid array index or a path through a
+// scalar is an error. The empty path addresses the root, which always exists.
+func (d *Doc) Exists(path string) (bool, error) {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return false, fmt.Errorf("Exists: %w", err)
+	}
+	exists := false
+	err = d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		var err error
+		exists, err = branchHasPath(txn, rootBranch, pathSegments)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("Exists %s: %w", path, err)
+	}
+	return exists, nil
+}
+
+// branchHasPath reports whether pathSegments address a value below branch.
+func branchHasPath(txn *C.YTransaction, branch *C.Branch, pathSegments []string) (bool, error) {
+	var outputs []*C.YOutput
+	defer func() { destroyOutputs(outputs) }()
+
+	for i, segment := range pathSegments {
+		var output *C.YOutput
+		if C.ytype_kind(branch) == C.Y_MAP {
+			segmentC := C.CString(segment)
+			output = C.ymap_get(branch, txn, segmentC)
+			C.free(unsafe.Pointer(segmentC))
+		} else {
+			if segment == "-" {
+				return false, nil
+			}
+			index, err := parseArrayIndex(segment)
+			if err != nil {
+				return false, fmt.Errorf("invalid array index '%s' in path: %w", segment, err)
+			}
+			if index < C.yarray_len(branch) {
+				output = C.yarray_get(branch, txn, index)
+			}
+		}
+		if output == nil {
+			return false, nil
+		}
+		outputs = append(outputs, output)
+		if i == len(pathSegments)-1 {
+			break
+		}
+
+		switch output.tag {
+		case C.Y_MAP:
+			branch = C.youtput_read_ymap(output)
+		case C.Y_ARRAY:
+			branch = C.youtput_read_yarray(output)
+		case C.Y_JSON_MAP, C.Y_JSON_ARR:
+			return embeddedHasPath(output, segment, pathSegments[i+1:])
+		default:
+			return false, fmt.Errorf("cannot navigate through non-container type at path segment '%s'", segment)
+		}
+	}
+	return true, nil
+}
+
+// embeddedHasPath is branchHasPath below output, a value at segment stored as embedded
+// JSON.
+func embeddedHasPath(output *C.YOutput, segment string, pathSegments []string) (bool, error) {
+	for _, next := range pathSegments {
+		if output.tag != C.Y_JSON_MAP && output.tag != C.Y_JSON_ARR {
+			return false, fmt.Errorf("cannot navigate through non-container type at path segment '%s'", segment)
+		}
+		child, err := embeddedChild(output, next)
+		if err != nil || child == nil {
+			return false, err
+		}
+		output, segment = child, next
+	}
+	return true, nil
+}
+
+// Keys returns the keys of the map at path, sorted, without reading their values. The
+// empty path addresses the root map. A path to an array or a scalar is an error.
+func (d *Doc) Keys(path string) ([]string, error) {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("Keys: %w", err)
+	}
+	var keys []string
+	err = d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		var err error
+		keys, err = branchKeys(txn, rootBranch, pathSegments)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Keys %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// branchKeys returns the sorted keys of the map that pathSegments address below
+// rootBranch.
+func branchKeys(txn *C.YTransaction, rootBranch *C.Branch, pathSegments []string) ([]string, error) {
+	branch, outputs, err := resolveBranch(txn, rootBranch, pathSegments)
+	if err != nil {
+		return nil, err
+	}
+	defer destroyOutputs(outputs)
+	if C.ytype_kind(branch) != C.Y_MAP {
+		return nil, errors.New("value is not a map")
+	}
+	keys := make([]string, 0, int(C.ymap_len(branch, txn)))
+	iter := C.ymap_iter(branch, txn)
+	for entry := C.ymap_iter_next(iter); entry != nil; entry = C.ymap_iter_next(iter) {
+		keys = append(keys, C.GoString(entry.key))
+		C.ymap_entry_destroy(entry)
+	}
+	C.ymap_iter_destroy(iter)
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns the number of keys of the map, or elements of the array, at path. The
+// empty path addresses the root. A path to any other value is an error.
+func (d *Doc) Len(path string) (int, error) {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return 0, fmt.Errorf("Len: %w", err)
+	}
+	length := 0
+	err = d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		branch, outputs, err := resolveBranch(txn, rootBranch, pathSegments)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+		switch C.ytype_kind(branch) {
+		case C.Y_MAP:
+			length = int(C.ymap_len(branch, txn))
+		case C.Y_ARRAY:
+			length = int(C.yarray_len(branch))
+		default:
+			return errors.New("value is not a map or an array")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Len %s: %w", path, err)
+	}
+	return length, nil
+}
+
+// GetInt64 reads the integer stored at path straight from its Yrs value, so integers
+// stored as int64 come back exactly, even beyond 2^53 where a float64 loses precision.
+// Whole numbers stored as floats are accepted too; any other value is an error.
+func (d *Doc) GetInt64(path string) (int64, error) {
+	var value int64
+	err := d.readOutput(path, func(txn *C.YTransaction, output *C.YOutput) error {
+		var err error
+		value, err = outputInt64(output)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("GetInt64 %s: %w", path, err)
+	}
+	return value, nil
+}
+
+// GetDuration reads the time.Duration stored at path. A time.Duration is stored like
+// any other int64, as its length in nanoseconds, and reads back from ToJSON as a plain
+// number; GetDuration restores its type.
+func (d *Doc) GetDuration(path string) (time.Duration, error) {
+	var duration time.Duration
+	err := d.readOutput(path, func(txn *C.YTransaction, output *C.YOutput) error {
+		nanos, err := outputInt64(output)
+		if err != nil {
+			return err
+		}
+		duration = time.Duration(nanos)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("GetDuration %s: %w", path, err)
+	}
+	return duration, nil
+}
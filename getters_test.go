@@ -0,0 +1,271 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetDuration(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	timeout := 90*time.Second + 250*time.Millisecond
+	_, err := doc.UpdateToState(map[string]interface{}{
+		"config": map[string]interface{}{"timeout": timeout, "name": "svc"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	got, err := doc.GetDuration("/config/timeout")
+	if err != nil {
+		t.Fatalf("GetDuration failed: %v", err)
+	}
+	if got != timeout {
+		t.Errorf("expected %v, got %v", timeout, got)
+	}
+
+	if _, err := doc.GetDuration("/config/name"); err == nil {
+		t.Error("expected an error reading a string as a duration")
+	}
+	if _, err := doc.GetDuration("/config/missing"); err == nil {
+		t.Error("expected an error reading a missing key")
+	}
+}
+
+func TestGetInt64(t *testing.T) {
+	const big = int64(1)<<53 + 1
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"big":   big,
+		"neg":   int64(-7),
+		"whole": 3.0,
+		"frac":  2.5,
+		"name":  "x",
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	for path, want := range map[string]int64{"/big": big, "/neg": -7, "/whole": 3} {
+		got, err := doc.GetInt64(path)
+		if err != nil {
+			t.Errorf("GetInt64 %s failed: %v", path, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("GetInt64 %s: expected %d, got %d", path, want, got)
+		}
+	}
+	for _, path := range []string{"/frac", "/name", "/missing"} {
+		if _, err := doc.GetInt64(path); err == nil {
+			t.Errorf("expected an error reading %s as an int64", path)
+		}
+	}
+}
+
+func TestGetValueAtPath(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":   int64(9007199254740993),
+			"tags": []interface{}{"a", map[string]interface{}{"b": true}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	if err := doc.SetText("/user/bio", "hello"); err != nil {
+		t.Fatalf("SetText failed: %v", err)
+	}
+
+	for path, expected := range map[string]interface{}{
+		"/user/id":     int64(9007199254740993),
+		"/user/tags/1": map[string]interface{}{"b": true},
+		"/user/tags":   []interface{}{"a", map[string]interface{}{"b": true}},
+		"/user/bio":    "hello",
+	} {
+		got, err := doc.GetValueAtPath(path)
+		if err != nil {
+			t.Errorf("GetValueAtPath(%q) failed: %v", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("GetValueAtPath(%q): expected %#v, got %#v", path, expected, got)
+		}
+	}
+
+	whole, err := doc.GetValueAtPath("")
+	if err != nil {
+		t.Fatalf("GetValueAtPath(\"\") failed: %v", err)
+	}
+	if state, _ := doc.ToJSON(); !reflect.DeepEqual(whole, state) {
+		t.Errorf("expected the empty path to return the document, got %#v", whole)
+	}
+
+	for path, want := range map[string]string{
+		"/user/missing": "not found",
+		"/nope/deeper":  "navigation failed",
+		"/user/tags/5":  "out of bounds",
+		"user":          "must start with '/'",
+	} {
+		if _, err := doc.GetValueAtPath(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("GetValueAtPath(%q): expected an error containing %q, got %v", path, want, err)
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"user": map[string]interface{}{
+			"name": "alice",
+			"nick": nil,
+			"tags": []interface{}{"a", map[string]interface{}{"b": true}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	for path, expected := range map[string]bool{
+		"":                 true,
+		"/user":            true,
+		"/user/nick":       true,
+		"/user/tags/1/b":   true,
+		"/user/age":        false,
+		"/user/tags/2":     false,
+		"/user/tags/-":     false,
+		"/missing/deeper":  false,
+		"/user/tags/1/c/d": false,
+	} {
+		got, err := doc.Exists(path)
+		if err != nil {
+			t.Errorf("Exists(%q) failed: %v", path, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("Exists(%q): expected %v, got %v", path, expected, got)
+		}
+	}
+
+	for _, path := range []string{"user", "/user/tags/x", "/user/name/first"} {
+		if _, err := doc.Exists(path); err == nil {
+			t.Errorf("Exists(%q): expected an error", path)
+		}
+	}
+}
+
+func TestKeysAndLen(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"user": map[string]interface{}{"name": "alice", "age": 30.0},
+		"tags": []interface{}{"a", "b", "c"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	for path, expected := range map[string][]string{
+		"":      {"tags", "user"},
+		"/user": {"age", "name"},
+	} {
+		keys, err := doc.Keys(path)
+		if err != nil {
+			t.Fatalf("Keys(%q) failed: %v", path, err)
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("Keys(%q): expected %v, got %v", path, expected, keys)
+		}
+	}
+	for path, expected := range map[string]int{"": 2, "/user": 2, "/tags": 3} {
+		n, err := doc.Len(path)
+		if err != nil {
+			t.Fatalf("Len(%q) failed: %v", path, err)
+		}
+		if n != expected {
+			t.Errorf("Len(%q): expected %d, got %d", path, expected, n)
+		}
+	}
+
+	if _, err := doc.Keys("/tags"); err == nil {
+		t.Error("Keys of an array: expected an error")
+	}
+	for _, path := range []string{"/user/name", "/missing"} {
+		if _, err := doc.Keys(path); err == nil {
+			t.Errorf("Keys(%q): expected an error", path)
+		}
+		if _, err := doc.Len(path); err == nil {
+			t.Errorf("Len(%q): expected an error", path)
+		}
+	}
+}
+
+// embeddedJSONUpdate is a v1 update, as produced by Yrs, setting "list" of the root map to
+// an array whose single element is the plain JSON object
+// {"field": 1, "nested": {"deep": "x"}} rather than a shared map, which is how Yjs stores
+// objects pushed into a Y.Array.
+var embeddedJSONUpdate = []byte{
+	0x01, 0x02, 0xb2, 0xbe, 0xf1, 0xdb, 0x0a, 0x00, 0x27, 0x01, 0x04, 0x72, 0x6f, 0x6f, 0x74, 0x04,
+	0x6c, 0x69, 0x73, 0x74, 0x00, 0x08, 0x00, 0xb2, 0xbe, 0xf1, 0xdb, 0x0a, 0x00, 0x01, 0x76, 0x02,
+	0x06, 0x6e, 0x65, 0x73, 0x74, 0x65, 0x64, 0x76, 0x01, 0x04, 0x64, 0x65, 0x65, 0x70, 0x77, 0x01,
+	0x78, 0x05, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x7a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+	0x00,
+}
+
+func TestReadEmbeddedJSON(t *testing.T) {
+	doc, err := NewDocFromStateVector(embeddedJSONUpdate)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	for path, expected := range map[string]interface{}{
+		"/list/0/field":       int64(1),
+		"/list/0/nested/deep": "x",
+		"/list/0/nested":      map[string]interface{}{"deep": "x"},
+	} {
+		got, err := doc.GetValueAtPath(path)
+		if err != nil {
+			t.Errorf("GetValueAtPath(%q) failed: %v", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("GetValueAtPath(%q): expected %v, got %v", path, expected, got)
+		}
+	}
+	if n, err := doc.GetInt64("/list/0/field"); err != nil || n != 1 {
+		t.Errorf("GetInt64: expected 1, got %d, %v", n, err)
+	}
+	if _, err := doc.GetValueAtPath("/list/0/missing"); err == nil {
+		t.Error("expected an error for a missing key of an embedded object")
+	}
+
+	for path, expected := range map[string]bool{
+		"/list/0/nested/deep":   true,
+		"/list/0/nested/absent": false,
+		"/list/0/other/deeper":  false,
+	} {
+		got, err := doc.Exists(path)
+		if err != nil {
+			t.Errorf("Exists(%q) failed: %v", path, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("Exists(%q): expected %v, got %v", path, expected, got)
+		}
+	}
+	if _, err := doc.Exists("/list/0/field/x"); err == nil {
+		t.Error("Exists through an embedded scalar: expected an error")
+	}
+
+	// Embedded values can't be edited in place.
+	if err := doc.Set("/list/0/field", 2); !errors.Is(err, ErrEmbeddedJSON) {
+		t.Errorf("expected ErrEmbeddedJSON, got %v", err)
+	}
+}
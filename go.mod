@@ -2,6 +2,9 @@ module github.com/ProlificLabs/autosync
 
 go 1.22
 
-require github.com/snorwin/jsonpatch v1.5.0
+require (
+	github.com/snorwin/jsonpatch v1.5.0
+	google.golang.org/protobuf v1.36.6
+)
 
 require github.com/evanphx/json-patch/v5 v5.9.11 // indirect
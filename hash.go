@@ -0,0 +1,62 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// ContentHash returns the SHA-256 of the document's visible content in canonical JSON:
+// object keys sorted and no insignificant whitespace, with numbers kept exactly as Yrs
+// renders them. It depends only on the content, not on the history or client ids that
+// produced it, so documents with the same content hash equal whatever their histories.
+func (d *Doc) ContentHash() ([32]byte, error) {
+	raw, err := d.rootJSON()
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("ContentHash: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var content interface{}
+	if err := decoder.Decode(&content); err != nil {
+		return [32]byte{}, fmt.Errorf("ContentHash: failed to unmarshal JSON from YDoc: %w", err)
+	}
+	// encoding/json writes map keys in sorted order.
+	canonical, err := json.Marshal(content)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("ContentHash: %w", err)
+	}
+	return sha256.Sum256(canonical), nil
+}
+
+// ToCanonicalJSON returns the document's content as canonical JSON, for golden files or a
+// content hash to use as an ETag: object keys sorted, no insignificant whitespace and no
+// HTML escaping. Values are read as by ToJSON rather than re-parsed from the JSON Yrs
+// renders, so integers are written as exact digits and floats in the shortest form that
+// reads back to the same float64, whatever their magnitude.
+func (d *Doc) ToCanonicalJSON() ([]byte, error) {
+	value, err := d.rootValue(ReadOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("ToCanonicalJSON: %w", err)
+	}
+	if value == nil {
+		if d.arrayRoot {
+			value = []interface{}{}
+		} else {
+			value = map[string]interface{}{}
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	// encoding/json writes map keys in sorted order.
+	if err := encoder.Encode(value); err != nil {
+		return nil, fmt.Errorf("ToCanonicalJSON: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,85 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+)
+
+func TestContentHash(t *testing.T) {
+	state := map[string]interface{}{
+		"b": []interface{}{1.0, "x", nil},
+		"a": map[string]interface{}{"z": true, "y": 2.5},
+	}
+	direct, err := NewDocFromJSON(state)
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer direct.Destroy()
+
+	// Same content reached through a different history on a different client.
+	edited := NewDoc()
+	defer edited.Destroy()
+	if _, err := edited.UpdateToState(map[string]interface{}{"a": "old", "c": 1.0}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if _, err := edited.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	hashDirect, err := direct.ContentHash()
+	if err != nil {
+		t.Fatalf("ContentHash failed: %v", err)
+	}
+	hashEdited, err := edited.ContentHash()
+	if err != nil {
+		t.Fatalf("ContentHash failed: %v", err)
+	}
+	if hashDirect != hashEdited {
+		t.Errorf("expected equal content to hash equal, got %x and %x", hashDirect, hashEdited)
+	}
+
+	if err := edited.Set("/b/0", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	hashChanged, err := edited.ContentHash()
+	if err != nil {
+		t.Fatalf("ContentHash failed: %v", err)
+	}
+	if hashChanged == hashDirect {
+		t.Error("expected a content change to change the hash")
+	}
+}
+
+func TestToCanonicalJSON(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"b":    []interface{}{1.5, "<x>", nil},
+		"a":    map[string]interface{}{"z": true, "y": int64(9007199254740993)},
+		"tiny": 1e-7,
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	expected := `{"a":{"y":9007199254740993,"z":true},"b":[1.5,"<x>",null],"tiny":1e-7}`
+	for i := 0; i < 5; i++ {
+		got, err := doc.ToCanonicalJSON()
+		if err != nil {
+			t.Fatalf("ToCanonicalJSON failed: %v", err)
+		}
+		if string(got) != expected {
+			t.Fatalf("expected %s, got %s", expected, got)
+		}
+	}
+
+	empty := NewDoc()
+	defer empty.Destroy()
+	got, err := empty.ToCanonicalJSON()
+	if err != nil {
+		t.Fatalf("ToCanonicalJSON failed: %v", err)
+	}
+	if string(got) != "{}" {
+		t.Errorf("expected {}, got %s", got)
+	}
+}
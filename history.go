@@ -0,0 +1,103 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// ErrHistoryUnavailable is returned when a past state is asked of a document that
+// garbage collects deleted content, which the past state would need. See NewHistoryDoc.
+var ErrHistoryUnavailable = errors.New("document history is unavailable: garbage collection is enabled")
+
+// NewHistoryDoc creates a Doc like NewDoc, but with garbage collection of deleted content
+// turned off, so that past states can be rebuilt with StateAsOf. The price is that
+// everything ever written stays in the document and its encodings, deleted or not, so
+// use it only where time travel is needed. To keep the history across a reload, load a
+// saved history document with ApplyUpdate into another NewHistoryDoc; loading it
+// into a NewDoc collects the deleted content on the spot.
+func NewHistoryDoc() *Doc {
+	// Can't fail: only a client ID can be invalid.
+	d, _ := NewDocWithOptions(DocOptions{SkipGC: true})
+	return d
+}
+
+// Snapshot returns a checkpoint of the document's current state for StateAsOf. Unlike a
+// state vector, which only records which insertions a peer has seen, a snapshot also
+// records what had been deleted at the time, which a past state cannot be rebuilt
+// without. Snapshots are small, so they can be stored next to every update of an
+// event log.
+func (d *Doc) Snapshot() ([]byte, error) {
+	var snapshot []byte
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		var snapshotLen C.uint32_t
+		snapshotC := C.ytransaction_snapshot(txn, &snapshotLen)
+		if snapshotC == nil {
+			return errors.New("ytransaction_snapshot returned nil")
+		}
+		defer C.ybinary_destroy(snapshotC, snapshotLen)
+		snapshot = C.GoBytes(unsafe.Pointer(snapshotC), C.int(snapshotLen))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// StateAsOf returns a new Doc holding the document as it was when snapshot was taken with
+// Snapshot: only the content inserted before it, with the deletions made before it
+// applied. The document must have been created with NewHistoryDoc (or loaded into one
+// from an update saved by one), since garbage collection discards the deleted content
+// that past states still show; StateAsOf fails with ErrHistoryUnavailable otherwise.
+// The caller must Destroy the returned Doc.
+func (d *Doc) StateAsOf(snapshot []byte) (*Doc, error) {
+	if len(snapshot) == 0 {
+		return nil, errors.New("StateAsOf: empty snapshot")
+	}
+	var update []byte
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		snapshotC := C.CBytes(snapshot)
+		defer C.free(snapshotC)
+
+		var updateLen C.uint32_t
+		updateC := C.ytransaction_encode_state_from_snapshot_v1(txn, (*C.char)(snapshotC), C.uint32_t(len(snapshot)), &updateLen)
+		if updateC == nil {
+			return ErrHistoryUnavailable
+		}
+		defer C.ybinary_destroy(updateC, updateLen)
+		update = C.GoBytes(unsafe.Pointer(updateC), C.int(updateLen))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("StateAsOf: %w", err)
+	}
+	past, err := NewDocFromStateVector(update)
+	if err != nil {
+		return nil, fmt.Errorf("StateAsOf: %w", err)
+	}
+	return past, nil
+}
+
+// StateAtSnapshot returns the document as it was when snapshot was taken with Snapshot,
+// in the form ToJSON returns. Like StateAsOf, it fails with ErrHistoryUnavailable
+// unless the document keeps its history.
+func (d *Doc) StateAtSnapshot(snapshot []byte) (map[string]interface{}, error) {
+	past, err := d.StateAsOf(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("StateAtSnapshot: %w", err)
+	}
+	defer past.Destroy()
+	state, err := past.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("StateAtSnapshot: %w", err)
+	}
+	return state, nil
+}
@@ -0,0 +1,101 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStateAsOf(t *testing.T) {
+	doc := NewHistoryDoc()
+	defer doc.Destroy()
+
+	if err := doc.Set("/title", "v1"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.Set("/tags", []interface{}{"a", "b"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	checkpoint, err := doc.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	if _, err := doc.UpdateToState(map[string]interface{}{"title": "v2", "extra": true}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	past, err := doc.StateAsOf(checkpoint)
+	if err != nil {
+		t.Fatalf("StateAsOf failed: %v", err)
+	}
+	defer past.Destroy()
+	got, err := past.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"title": "v1", "tags": []interface{}{"a", "b"}}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected the state at the checkpoint %v, got %v", expected, got)
+	}
+
+	// History survives a reload into another history document.
+	reloaded := NewHistoryDoc()
+	defer reloaded.Destroy()
+	if err := reloaded.ApplyStateVector(mustEncodeFull(t, doc)); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	if past, err := reloaded.StateAsOf(checkpoint); err != nil {
+		t.Errorf("StateAsOf after a reload failed: %v", err)
+	} else {
+		past.Destroy()
+	}
+
+	plain := NewDoc()
+	defer plain.Destroy()
+	snapshot, err := plain.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if _, err := plain.StateAsOf(snapshot); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Errorf("expected ErrHistoryUnavailable on a garbage-collected document, got %v", err)
+	}
+}
+
+func TestStateAtSnapshot(t *testing.T) {
+	doc := NewHistoryDoc()
+	defer doc.Destroy()
+
+	if err := doc.Set("/count", int64(1)); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	first, err := doc.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if _, err := doc.UpdateToState(map[string]interface{}{"count": int64(2), "done": true}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	got, err := doc.StateAtSnapshot(first)
+	if err != nil {
+		t.Fatalf("StateAtSnapshot failed: %v", err)
+	}
+	if expected := map[string]interface{}{"count": int64(1)}; !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	plain := NewDoc()
+	defer plain.Destroy()
+	if err := plain.Set("/count", int64(1)); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	snapshot, err := plain.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if _, err := plain.StateAtSnapshot(snapshot); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Errorf("expected ErrHistoryUnavailable on a garbage-collected document, got %v", err)
+	}
+}
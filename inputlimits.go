@@ -0,0 +1,115 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// ErrInputTooLarge is returned when a value written to the document exceeds the
+// InputLimits set with SetInputLimits.
+var ErrInputTooLarge = errors.New("value exceeds the input limits")
+
+// InputLimits bounds the values written to a Doc; see SetInputLimits. Zero fields are
+// not enforced.
+type InputLimits struct {
+	// MaxDepth is the deepest nesting of maps and arrays a written value may have. A
+	// scalar has depth 0 and {"a": [1]} depth 2, wherever the value is written.
+	MaxDepth int
+	// MaxNodes is the largest number of values, counting every map, array and scalar, a
+	// written value may contain.
+	MaxNodes int
+}
+
+// SetInputLimits bounds the values that ApplyOperations (and so UpdateToState), Set,
+// SetPaths, ReplaceSubtree, SetArray, ArrayAppend, ArrayInsert, MergeState and
+// ApplyMergePatch write, so that a deeply nested or huge value from an untrusted client
+// fails with an error wrapping ErrInputTooLarge before anything is converted for Yrs.
+// Updates from peers applied with ApplyUpdate are not checked.
+func (d *Doc) SetInputLimits(limits InputLimits) {
+	d.inputLimits.Store(&limits)
+}
+
+// checkInputLimits checks value, about to be written at path, against d.inputLimits.
+func (d *Doc) checkInputLimits(path string, value interface{}) error {
+	limits := d.inputLimits.Load()
+	if limits == nil || (limits.MaxDepth <= 0 && limits.MaxNodes <= 0) {
+		return nil
+	}
+	c := inputChecker{limits: *limits}
+	if err := c.check(reflect.ValueOf(value), 0); err != nil {
+		return fmt.Errorf("%w: %v at %q", ErrInputTooLarge, err, path+joinPath(c.pathSegments))
+	}
+	return nil
+}
+
+// inputChecker walks a value the way buildYInputRecursive converts it, counting values
+// and tracking the path to the current one.
+type inputChecker struct {
+	limits       InputLimits
+	nodes        int
+	pathSegments []string
+}
+
+func (c *inputChecker) check(val reflect.Value, depth int) error {
+	c.nodes++
+	if c.limits.MaxNodes > 0 && c.nodes > c.limits.MaxNodes {
+		return fmt.Errorf("more than %d values", c.limits.MaxNodes)
+	}
+	for val.IsValid() {
+		if m, ok := valueInterface(val).(YMarshaler); ok && m != nil && !(val.Kind() == reflect.Ptr && val.IsNil()) {
+			return nil // checked as what MarshalY returns when it is converted
+		}
+		if val.Kind() != reflect.Ptr && val.Kind() != reflect.Interface {
+			break
+		}
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			return nil // stored as a single binary value
+		}
+	case reflect.Map:
+	default:
+		return nil
+	}
+	if c.limits.MaxDepth > 0 && depth+1 > c.limits.MaxDepth {
+		return fmt.Errorf("nesting deeper than %d levels", c.limits.MaxDepth)
+	}
+
+	if val.Kind() == reflect.Map {
+		iter := val.MapRange()
+		for iter.Next() {
+			c.pathSegments = append(c.pathSegments, iter.Key().String())
+			if err := c.check(iter.Value(), depth+1); err != nil {
+				return err
+			}
+			c.pathSegments = c.pathSegments[:len(c.pathSegments)-1]
+		}
+		return nil
+	}
+	for i := 0; i < val.Len(); i++ {
+		c.pathSegments = append(c.pathSegments, strconv.Itoa(i))
+		if err := c.check(val.Index(i), depth+1); err != nil {
+			return err
+		}
+		c.pathSegments = c.pathSegments[:len(c.pathSegments)-1]
+	}
+	return nil
+}
+
+// valueInterface returns the value held by val, or nil if it cannot be taken.
+func valueInterface(val reflect.Value) interface{} {
+	if !val.CanInterface() {
+		return nil
+	}
+	return val.Interface()
+}
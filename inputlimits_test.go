@@ -0,0 +1,98 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestInputLimits(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	doc.SetInputLimits(InputLimits{MaxDepth: 64, MaxNodes: 100000})
+
+	deep := map[string]interface{}{"leaf": true}
+	for i := 0; i < 10000; i++ {
+		deep = map[string]interface{}{"a": deep}
+	}
+	if err := doc.Set("/deep", deep); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("expected ErrInputTooLarge for a deeply nested map, got %v", err)
+	}
+
+	huge := make([]interface{}, 1000000)
+	for i := range huge {
+		huge[i] = i
+	}
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{{Operation: "add", Path: "/huge", Value: huge}})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("expected ErrInputTooLarge for a million-element array, got %v", err)
+	}
+	if err := doc.SetArray("/huge", huge); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("expected ErrInputTooLarge from SetArray, got %v", err)
+	}
+
+	// Nothing was written, and values within the limits still are.
+	if err := doc.Set("/ok", map[string]interface{}{"list": []interface{}{1, 2}}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"ok": map[string]interface{}{"list": []interface{}{int64(1), int64(2)}}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// The error names the limit and where it was exceeded.
+	doc.SetInputLimits(InputLimits{MaxDepth: 2})
+	err = doc.Set("/x", map[string]interface{}{"a": []interface{}{map[string]interface{}{}}})
+	if !errors.Is(err, ErrInputTooLarge) {
+		t.Fatalf("expected ErrInputTooLarge, got %v", err)
+	}
+	if want := `Set /x: value exceeds the input limits: nesting deeper than 2 levels at "/x/a/0"`; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+}
+
+func TestInputLimitsSetPathsAndReplaceSubtree(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"sub": map[string]interface{}{"k": "v"}})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	doc.SetInputLimits(InputLimits{MaxDepth: 1})
+
+	// One value over the limit fails the call before any path is written.
+	err = doc.SetPaths(map[string]interface{}{
+		"/a": "flat",
+		"/b": map[string]interface{}{"c": []interface{}{1}},
+	})
+	if !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("expected ErrInputTooLarge from SetPaths, got %v", err)
+	}
+	err = doc.ReplaceSubtree("/sub", map[string]interface{}{"c": []interface{}{1}})
+	if !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("expected ErrInputTooLarge from ReplaceSubtree, got %v", err)
+	}
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"sub": map[string]interface{}{"k": "v"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if err := doc.ReplaceSubtree("/sub", map[string]interface{}{"k": "w"}); err != nil {
+		t.Errorf("ReplaceSubtree within the limits failed: %v", err)
+	}
+}
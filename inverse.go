@@ -0,0 +1,133 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"strconv"
+	"unsafe"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// ApplyOperationsInverse applies patchList like ApplyOperations and returns the patch
+// that undoes it, e.g. to roll back an optimistic update the server rejected. Before each
+// operation is applied, the value it overwrites or removes is read from the document and
+// the operation is inverted: an "add" of a new key or array element yields a "remove",
+// an "add" over an existing key or a "replace" yields a "replace" with the previous
+// value, and a "remove" yields an "add" of the removed value. The inverse operations are
+// listed in reverse order, so applying the inverse right away restores the previous
+// state. Unlike ApplyOperationsUndoable, nothing is kept on the Doc, and only the touched
+// values are read rather than the whole document. Elements dropped by an array limit (see
+// SetArrayLimit) are not restored.
+func (d *Doc) ApplyOperationsInverse(patchList jsonpatch.JSONPatchList) (jsonpatch.JSONPatchList, error) {
+	ops := patchList.List()
+	inverse := make([]jsonpatch.JSONPatch, len(ops))
+	err := d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		if patchList.Empty() {
+			return nil
+		}
+		err := d.dryRun(txn, func(txn *C.YTransaction, rootBranch *C.Branch) error {
+			return d.applyOpList(txn, rootBranch, patchList, ApplyOptions{})
+		})
+		if err != nil {
+			return err
+		}
+		for i, op := range ops {
+			inv, err := inverseOperation(txn, rootBranch, op)
+			if err != nil {
+				return fmt.Errorf("operation (%s %s): failed to invert: %w", op.Operation, op.Path, err)
+			}
+			inverse[len(ops)-1-i] = inv
+			if err := d.applyPatchOp(txn, rootBranch, op, ApplyOptions{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("ApplyOperationsInverse: %w", err)
+	}
+	d.audit(patchList, "")
+
+	result, err := NewPatchList(inverse)
+	if err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("ApplyOperationsInverse: %w", err)
+	}
+	return result, nil
+}
+
+// inverseOperation returns the operation undoing op, which is about to be applied to the
+// document as it is in txn.
+func inverseOperation(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch) (jsonpatch.JSONPatch, error) {
+	if op.Path == "" {
+		old, err := branchValue(txn, rootBranch, ReadOptions{})
+		if err != nil {
+			return jsonpatch.JSONPatch{}, err
+		}
+		return jsonpatch.JSONPatch{Operation: "replace", Path: "", Value: old}, nil
+	}
+
+	pathSegments, err := splitPath(op.Path)
+	if err != nil {
+		return jsonpatch.JSONPatch{}, err
+	}
+	parent, keyOrIndex, outputs, err := navigateToParent(txn, rootBranch, pathSegments)
+	if err != nil {
+		return jsonpatch.JSONPatch{}, err
+	}
+	defer destroyOutputs(outputs)
+
+	// An element added to an array is removed again at the index it lands at.
+	if C.ytype_kind(parent) == C.Y_ARRAY && op.Operation == "add" {
+		index := C.yarray_len(parent)
+		if i, ok := keyOrIndex.(C.uint32_t); ok {
+			index = i
+		}
+		pathSegments[len(pathSegments)-1] = strconv.FormatUint(uint64(index), 10)
+		return jsonpatch.JSONPatch{Operation: "remove", Path: joinPath(pathSegments)}, nil
+	}
+
+	old, exists, err := previousValue(txn, parent, keyOrIndex, pathSegments[len(pathSegments)-1])
+	if err != nil {
+		return jsonpatch.JSONPatch{}, err
+	}
+	switch {
+	case op.Operation == "remove":
+		return jsonpatch.JSONPatch{Operation: "add", Path: op.Path, Value: old}, nil
+	case exists:
+		return jsonpatch.JSONPatch{Operation: "replace", Path: op.Path, Value: old}, nil
+	default:
+		return jsonpatch.JSONPatch{Operation: "remove", Path: op.Path}, nil
+	}
+}
+
+// previousValue returns the value at keyOrIndex of parent, as ToJSON reads it, and
+// whether there is one. A missing map key is not an error.
+func previousValue(txn *C.YTransaction, parent *C.Branch, keyOrIndex interface{}, segment string) (interface{}, bool, error) {
+	var output *C.YOutput
+	if key, ok := keyOrIndex.(string); ok && C.ytype_kind(parent) == C.Y_MAP {
+		keyC := C.CString(key)
+		output = C.ymap_get(parent, txn, keyC)
+		C.free(unsafe.Pointer(keyC))
+		if output == nil {
+			return nil, false, nil
+		}
+	} else {
+		var err error
+		if output, err = getOutput(txn, parent, keyOrIndex, segment); err != nil {
+			return nil, false, err
+		}
+	}
+	defer C.youtput_destroy(output)
+	value, err := outputValue(txn, output, ReadOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
@@ -0,0 +1,93 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestApplyOperationsInverse(t *testing.T) {
+	original := map[string]interface{}{
+		"a":    1.0,
+		"b":    map[string]interface{}{"c": "x"},
+		"list": []interface{}{"p", "q"},
+	}
+	doc, err := NewDocFromJSON(original)
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "replace", Path: "/a", Value: 2.0},
+		{Operation: "add", Path: "/b/d", Value: true},
+		{Operation: "add", Path: "/b/c", Value: "y"},
+		{Operation: "remove", Path: "/list/0"},
+		{Operation: "add", Path: "/list/-", Value: "r"},
+		{Operation: "add", Path: "/list/0", Value: "z"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	inverse, err := doc.ApplyOperationsInverse(patch)
+	if err != nil {
+		t.Fatalf("ApplyOperationsInverse failed: %v", err)
+	}
+	expectedInverse := []jsonpatch.JSONPatch{
+		{Operation: "remove", Path: "/list/0"},
+		{Operation: "remove", Path: "/list/1"},
+		{Operation: "add", Path: "/list/0", Value: "p"},
+		{Operation: "replace", Path: "/b/c", Value: "x"},
+		{Operation: "remove", Path: "/b/d"},
+		{Operation: "replace", Path: "/a", Value: 1.0},
+	}
+	if !reflect.DeepEqual(inverse.List(), expectedInverse) {
+		t.Errorf("expected inverse %v, got %v", expectedInverse, inverse.List())
+	}
+
+	if err := doc.ApplyOperations(inverse); err != nil {
+		t.Fatalf("ApplyOperations of the inverse failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, original) {
+		t.Errorf("expected the inverse to restore %v, got %v", original, got)
+	}
+
+	// Replacing the whole document is undone by replacing it back.
+	patch, err = NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "replace", Path: "", Value: map[string]interface{}{"other": 1.0}},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if inverse, err = doc.ApplyOperationsInverse(patch); err != nil {
+		t.Fatalf("ApplyOperationsInverse failed: %v", err)
+	}
+	if err := doc.ApplyOperations(inverse); err != nil {
+		t.Fatalf("ApplyOperations of the inverse failed: %v", err)
+	}
+	if got, err = doc.ToJSON(); err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, original) {
+		t.Errorf("expected the inverse to restore %v, got %v", original, got)
+	}
+
+	// A failing patch changes nothing and yields no inverse.
+	patch, err = NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "replace", Path: "/a", Value: 5.0},
+		{Operation: "remove", Path: "/missing"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if inverse, err = doc.ApplyOperationsInverse(patch); err == nil || !inverse.Empty() {
+		t.Errorf("expected an error and no inverse, got %v and %v", inverse.List(), err)
+	}
+}
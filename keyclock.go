@@ -0,0 +1,39 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"fmt"
+)
+
+// KeyClock returns the logical timestamp of the current value of the top-level key: the
+// client ID of the peer that wrote it and that peer's clock for the write. Clocks grow
+// with every change a client makes, so for two writes by the same client the later one
+// has the higher clock; across clients they only order writes that saw each other. The
+// timestamp is read from the document's encoded state, so it costs a full encoding.
+func (d *Doc) KeyClock(key string) (clientID uint64, clock uint32, err error) {
+	update, err := d.encodeStateDiff(nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("KeyClock %q: %w", key, err)
+	}
+	decoded, err := decodeUpdateV1(update)
+	if err != nil {
+		return 0, 0, fmt.Errorf("KeyClock %q: %w", key, err)
+	}
+
+	for _, blocks := range decoded.blocks {
+		for i := range blocks {
+			block := &blocks[i]
+			if !decoded.resolveParent(block) || block.parentName != d.root() || block.parentSub != key {
+				continue
+			}
+			// A map entry's value is the last element of its block; earlier ones were
+			// overwritten.
+			last := blockID{client: block.id.client, clock: block.id.clock + block.length - 1}
+			if !decoded.deleted(last) {
+				return last.client, last.clock, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("KeyClock %q: key not found", key)
+}
@@ -0,0 +1,64 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+)
+
+func TestKeyClock(t *testing.T) {
+	a, err := NewDocFromJSON(map[string]interface{}{"title": "x", "other": "y"})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer a.Destroy()
+	b := NewDoc()
+	defer b.Destroy()
+	syncDocs(t, a, b)
+
+	aClient, firstClock, err := a.KeyClock("title")
+	if err != nil {
+		t.Fatalf("KeyClock failed: %v", err)
+	}
+	if client, clock, err := b.KeyClock("title"); err != nil || client != aClient || clock != firstClock {
+		t.Errorf("expected the peer to report (%d, %d), got (%d, %d, %v)", aClient, firstClock, client, clock, err)
+	}
+
+	if err := a.Set("/title", "x2"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if client, clock, err := a.KeyClock("title"); err != nil || client != aClient || clock <= firstClock {
+		t.Errorf("expected a later clock from the same client after an overwrite, got (%d, %d, %v)", client, clock, err)
+	}
+
+	syncDocs(t, a, b)
+	if err := b.Set("/title", "from b"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	syncDocs(t, b, a)
+	bClient, _, err := a.KeyClock("title")
+	if err != nil {
+		t.Fatalf("KeyClock failed: %v", err)
+	}
+	if bClient == aClient {
+		t.Error("expected the last writer's client ID after b overwrote the key")
+	}
+	if client, _, err := a.KeyClock("other"); err != nil || client != aClient {
+		t.Errorf("expected an untouched key to keep its writer, got %d (%v)", client, err)
+	}
+
+	if _, _, err := a.KeyClock("missing"); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
+
+func TestKeyClockYjsUpdate(t *testing.T) {
+	doc, err := NewDocFromStateVector(yjsUpdate)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer doc.Destroy()
+	if client, _, err := doc.KeyClock("title"); err != nil || client != 1234 {
+		t.Errorf("expected the Yjs client 1234 to own title, got %d (%v)", client, err)
+	}
+}
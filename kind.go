@@ -0,0 +1,108 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"fmt"
+)
+
+// Kind identifies the type of a value stored in a Doc.
+type Kind int
+
+const (
+	KindNull Kind = iota
+	KindMap
+	KindArray
+	KindText
+	KindString
+	KindLong
+	KindFloat
+	KindBool
+	KindBinary
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNull:
+		return "null"
+	case KindMap:
+		return "map"
+	case KindArray:
+		return "array"
+	case KindText:
+		return "text"
+	case KindString:
+		return "string"
+	case KindLong:
+		return "long"
+	case KindFloat:
+		return "float"
+	case KindBool:
+		return "bool"
+	case KindBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// KindAt returns the kind of the value at path, so callers can decide how to handle a node
+// without inspecting the decoded JSON. KindText is a collaborative YText (see SetText),
+// while KindString is a plain string value. Maps and arrays stored as embedded JSON
+// rather than as Yrs types report KindMap and KindArray as well. The empty path
+// addresses the root.
+func (d *Doc) KindAt(path string) (Kind, error) {
+	if path == "" {
+		var kind Kind
+		err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+			var err error
+			kind, err = outputKind(C.ytype_kind(rootBranch))
+			return err
+		})
+		if err != nil {
+			return 0, fmt.Errorf("KindAt %q: %w", path, err)
+		}
+		return kind, nil
+	}
+
+	var kind Kind
+	err := d.readOutput(path, func(txn *C.YTransaction, output *C.YOutput) error {
+		var err error
+		kind, err = outputKind(output.tag)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("KindAt %q: %w", path, err)
+	}
+	return kind, nil
+}
+
+// outputKind maps a YOutput tag or ytype_kind result to a Kind.
+func outputKind(tag C.int8_t) (Kind, error) {
+	switch tag {
+	case C.Y_JSON_NULL, C.Y_JSON_UNDEF:
+		return KindNull, nil
+	case C.Y_MAP, C.Y_JSON_MAP:
+		return KindMap, nil
+	case C.Y_ARRAY, C.Y_JSON_ARR:
+		return KindArray, nil
+	case C.Y_TEXT:
+		return KindText, nil
+	case C.Y_JSON_STR:
+		return KindString, nil
+	case C.Y_JSON_INT:
+		return KindLong, nil
+	case C.Y_JSON_NUM:
+		return KindFloat, nil
+	case C.Y_JSON_BOOL:
+		return KindBool, nil
+	case C.Y_JSON_BUF:
+		return KindBinary, nil
+	default:
+		return 0, fmt.Errorf("unsupported value type (tag: %d)", tag)
+	}
+}
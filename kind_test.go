@@ -0,0 +1,53 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+)
+
+func TestKindAt(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"map":   map[string]interface{}{"a": 1},
+		"list":  []interface{}{"x"},
+		"str":   "s",
+		"long":  int64(3),
+		"float": 1.5,
+		"bool":  true,
+		"null":  nil,
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	if err := doc.SetText("/text", "hello"); err != nil {
+		t.Fatalf("SetText failed: %v", err)
+	}
+
+	cases := map[string]Kind{
+		"":        KindMap,
+		"/map":    KindMap,
+		"/list":   KindArray,
+		"/list/0": KindString,
+		"/text":   KindText,
+		"/str":    KindString,
+		"/long":   KindLong,
+		"/float":  KindFloat,
+		"/bool":   KindBool,
+		"/null":   KindNull,
+	}
+	for path, expected := range cases {
+		got, err := doc.KindAt(path)
+		if err != nil {
+			t.Errorf("KindAt(%q) failed: %v", path, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("KindAt(%q): expected %v, got %v", path, expected, got)
+		}
+	}
+
+	if _, err := doc.KindAt("/missing"); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
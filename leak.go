@@ -0,0 +1,27 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// leakedDocs counts the Docs destroyed by their finalizer rather than by Destroy.
+var leakedDocs atomic.Int64
+
+// guardLeak makes the garbage collector destroy d if it becomes unreachable without
+// Destroy having been called, so a forgotten Destroy doesn't leak the Yrs document. It is
+// called by the constructors. Destroy removes the finalizer. A Doc with active
+// subscriptions (WatchKey, Observe, OnUpdateDebounced and the like) stays reachable
+// through them, so only Destroy frees it.
+func (d *Doc) guardLeak() {
+	runtime.SetFinalizer(d, destroyLeaked)
+}
+
+// destroyLeaked is the finalizer set by guardLeak.
+func destroyLeaked(d *Doc) {
+	leakedDocs.Add(1)
+	tracef("Doc %p collected without Destroy", d)
+	d.Destroy()
+}
@@ -0,0 +1,54 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLeakedDocsAreDestroyed(t *testing.T) {
+	const docs = 2000
+	before := leakedDocs.Load()
+	for i := 0; i < docs; i++ {
+		// Deliberately not destroyed.
+		if _, err := NewDocFromJSON(map[string]interface{}{"i": i, "payload": "some content to allocate"}); err != nil {
+			t.Fatalf("NewDocFromJSON failed: %v", err)
+		}
+	}
+
+	// Finalizers run on their own goroutine after a collection, so give them time.
+	deadline := time.Now().Add(5 * time.Second)
+	for leakedDocs.Load()-before < docs/2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected most leaked docs to be finalized, got %d of %d", leakedDocs.Load()-before, docs)
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A second batch must not grow the Go side either once collected.
+	runtime.GC()
+	var baseline runtime.MemStats
+	runtime.ReadMemStats(&baseline)
+	for i := 0; i < docs; i++ {
+		NewDoc()
+	}
+	runtime.GC()
+	runtime.GC()
+	var final runtime.MemStats
+	runtime.ReadMemStats(&final)
+	if final.Sys > baseline.Sys+16<<20 {
+		t.Errorf("Sys grew from %v KiB to %v KiB", baseline.Sys/1024, final.Sys/1024)
+	}
+}
+
+func TestDestroyTwice(t *testing.T) {
+	doc := NewDoc()
+	if err := doc.Set("/a", 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	doc.Destroy()
+	doc.Destroy()
+}
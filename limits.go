@@ -0,0 +1,179 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// ErrArrayFull is returned when a write would grow an array beyond its ArrayLimit.
+var ErrArrayFull = errors.New("array is full")
+
+// ArrayLimit caps the number of elements of an array; see SetArrayLimit.
+type ArrayLimit struct {
+	// Max is the largest number of elements the array may hold.
+	Max int
+	// DropOldest turns the array into a ring buffer: instead of failing with
+	// ErrArrayFull, an insert into a full array first removes its first elements, and an
+	// array stored whole keeps only its last Max elements.
+	DropOldest bool
+}
+
+// SetArrayLimit caps the length of the array at path, a JSON Pointer. The empty path sets
+// the default for every array without a limit of its own. A limit with Max 0 removes it.
+//
+// Limits are enforced at write time by ApplyOperations (and so UpdateToState), Set,
+// SetPaths, SetArray, ArrayAppend and ArrayInsert: on "add" and "copy" operations, Set
+// appends and ArrayAppend and ArrayInsert calls into the array, and on arrays stored
+// whole at path. Arrays nested inside a larger stored value are not checked, and
+// neither are updates from peers applied with ApplyUpdate, so every peer writing
+// to a bounded array should set the same limits.
+func (d *Doc) SetArrayLimit(path string, limit ArrayLimit) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if limit.Max <= 0 {
+		delete(d.arrayLimits, path)
+		return
+	}
+	if d.arrayLimits == nil {
+		d.arrayLimits = make(map[string]ArrayLimit)
+	}
+	d.arrayLimits[path] = limit
+}
+
+func (d *Doc) arrayLimit(pathSegments []string) (ArrayLimit, bool) {
+	if len(d.arrayLimits) == 0 {
+		return ArrayLimit{}, false
+	}
+	if limit, ok := d.arrayLimits[joinPath(pathSegments)]; ok {
+		return limit, true
+	}
+	limit, ok := d.arrayLimits[""]
+	return limit, ok
+}
+
+// limitOp enforces array limits on op before it is applied; see limitWrite.
+func (d *Doc) limitOp(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch) (jsonpatch.JSONPatch, error) {
+	if len(d.arrayLimits) == 0 || op.Path == "" {
+		return op, nil
+	}
+	pathSegments, err := splitPath(op.Path)
+	if err != nil {
+		return op, nil // reported by applyOp
+	}
+	inserts := false
+	switch op.Operation {
+	case "add", "copy":
+		inserts = true
+	case "replace":
+	default:
+		return op, nil
+	}
+	pathSegments, value, err := d.limitWrite(txn, rootBranch, pathSegments, op.Value, inserts)
+	if err != nil {
+		return op, fmt.Errorf("operation (%s %s): %w", op.Operation, op.Path, err)
+	}
+	op.Path = joinPath(pathSegments)
+	op.Value = value
+	return op, nil
+}
+
+// limitWrite enforces array limits on value about to be stored at pathSegments, returning
+// the path and value to store instead. If inserts is set, storing into an array
+// inserts a new element; otherwise only appends ("-" or an index equal to the length)
+// do, as with Set. Making room in a DropOldest array shifts a numeric index down by the
+// number of elements dropped.
+func (d *Doc) limitWrite(txn *C.YTransaction, rootBranch *C.Branch, pathSegments []string, value interface{}, inserts bool) ([]string, interface{}, error) {
+	if len(d.arrayLimits) == 0 || len(pathSegments) == 0 {
+		return pathSegments, value, nil
+	}
+
+	if values, ok := value.([]interface{}); ok {
+		if limit, ok := d.arrayLimit(pathSegments); ok && len(values) > limit.Max {
+			if !limit.DropOldest {
+				return nil, nil, fmt.Errorf("%w: %d elements exceed the limit of %d", ErrArrayFull, len(values), limit.Max)
+			}
+			value = values[len(values)-limit.Max:]
+		}
+	}
+
+	parentSegments := pathSegments[:len(pathSegments)-1]
+	limit, ok := d.arrayLimit(parentSegments)
+	if !ok {
+		return pathSegments, value, nil
+	}
+	parent, outputs, err := resolveBranch(txn, rootBranch, parentSegments)
+	if err != nil {
+		return pathSegments, value, nil // reported when the write itself navigates
+	}
+	defer destroyOutputs(outputs)
+	if C.ytype_kind(parent) != C.Y_ARRAY {
+		return pathSegments, value, nil
+	}
+
+	length := int(C.yarray_len(parent))
+	last := pathSegments[len(pathSegments)-1]
+	index, indexErr := strconv.Atoi(last)
+	if !inserts && last != "-" && (indexErr != nil || index != length) {
+		return pathSegments, value, nil
+	}
+	if length < limit.Max {
+		return pathSegments, value, nil
+	}
+	if !limit.DropOldest {
+		return nil, nil, fmt.Errorf("%w: limit of %d reached", ErrArrayFull, limit.Max)
+	}
+
+	drop := length - limit.Max + 1
+	tracef("yarray_remove_range(%p, 0, %d)", parent, drop)
+	C.yarray_remove_range(parent, txn, 0, C.uint32_t(drop))
+	if indexErr == nil {
+		index -= drop
+		if index < 0 {
+			index = 0
+		}
+		shifted := append([]string(nil), pathSegments...)
+		shifted[len(shifted)-1] = strconv.Itoa(index)
+		pathSegments = shifted
+	}
+	return pathSegments, value, nil
+}
+
+// limitInsert enforces the limit of the array at pathSegments on inserting values into it
+// at index, returning the index and values to insert instead. Making room in a DropOldest
+// array shifts index down by the number of elements dropped; values beyond Max keep only
+// their last Max elements.
+func (d *Doc) limitInsert(txn *C.YTransaction, array *C.Branch, pathSegments []string, index C.uint32_t, values []interface{}) (C.uint32_t, []interface{}, error) {
+	limit, ok := d.arrayLimit(pathSegments)
+	if !ok {
+		return index, values, nil
+	}
+	length := int(C.yarray_len(array))
+	if length+len(values) <= limit.Max {
+		return index, values, nil
+	}
+	if !limit.DropOldest {
+		return 0, nil, fmt.Errorf("%w: %d elements exceed the limit of %d", ErrArrayFull, length+len(values), limit.Max)
+	}
+
+	if len(values) > limit.Max {
+		values = values[len(values)-limit.Max:]
+	}
+	drop := length + len(values) - limit.Max
+	if drop > 0 {
+		tracef("yarray_remove_range(%p, 0, %d)", array, drop)
+		C.yarray_remove_range(array, txn, 0, C.uint32_t(drop))
+	}
+	if int(index) < drop {
+		return 0, values, nil
+	}
+	return index - C.uint32_t(drop), values, nil
+}
@@ -0,0 +1,134 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestArrayLimit(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"events": []interface{}{}, "log": []interface{}{}})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	doc.SetArrayLimit("/events", ArrayLimit{Max: 2})
+	doc.SetArrayLimit("/log", ArrayLimit{Max: 2, DropOldest: true})
+
+	appendTo := func(path string, value interface{}) error {
+		patch, err := NewPatchList([]jsonpatch.JSONPatch{{Operation: "add", Path: path + "/-", Value: value}})
+		if err != nil {
+			t.Fatalf("NewPatchList failed: %v", err)
+		}
+		return doc.ApplyOperations(patch)
+	}
+	for _, v := range []string{"a", "b"} {
+		if err := appendTo("/events", v); err != nil {
+			t.Fatalf("append below the limit failed: %v", err)
+		}
+	}
+	if err := appendTo("/events", "c"); !errors.Is(err, ErrArrayFull) {
+		t.Errorf("expected ErrArrayFull, got %v", err)
+	}
+	if err := doc.Set("/events/-", "c"); !errors.Is(err, ErrArrayFull) {
+		t.Errorf("expected ErrArrayFull from Set, got %v", err)
+	}
+	if err := doc.Set("/events/0", "A"); err != nil {
+		t.Errorf("expected replacing an element of a full array to work, got %v", err)
+	}
+
+	for _, v := range []string{"1", "2", "3"} {
+		if err := appendTo("/log", v); err != nil {
+			t.Fatalf("append to a ring buffer failed: %v", err)
+		}
+	}
+	if err := doc.Set("/log/2", "4"); err != nil {
+		t.Fatalf("Set append to a ring buffer failed: %v", err)
+	}
+	if err := doc.Set("/events", []interface{}{1, 2, 3}); !errors.Is(err, ErrArrayFull) {
+		t.Errorf("expected ErrArrayFull for an oversized array, got %v", err)
+	}
+
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"events": []interface{}{"A", "b"},
+		"log":    []interface{}{"3", "4"},
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+
+	// A default limit applies to arrays without one of their own.
+	doc.SetArrayLimit("", ArrayLimit{Max: 1, DropOldest: true})
+	if err := doc.Set("/other", []interface{}{"x", "y"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if state, err = doc.ToJSON(); err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(state["other"], []interface{}{"y"}) {
+		t.Errorf("expected the default limit to keep only the last element, got %v", state["other"])
+	}
+}
+
+func TestArrayLimitArrayWrites(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"events": []interface{}{}, "log": []interface{}{}})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	doc.SetArrayLimit("/events", ArrayLimit{Max: 2})
+	doc.SetArrayLimit("/log", ArrayLimit{Max: 3, DropOldest: true})
+
+	if err := doc.ArrayAppend("/events", "a", "b"); err != nil {
+		t.Fatalf("ArrayAppend below the limit failed: %v", err)
+	}
+	if err := doc.ArrayAppend("/events", "c"); !errors.Is(err, ErrArrayFull) {
+		t.Errorf("expected ErrArrayFull from ArrayAppend, got %v", err)
+	}
+	if err := doc.ArrayInsert("/events", 0, "c"); !errors.Is(err, ErrArrayFull) {
+		t.Errorf("expected ErrArrayFull from ArrayInsert, got %v", err)
+	}
+	if err := doc.SetArray("/events", []interface{}{1, 2, 3}); !errors.Is(err, ErrArrayFull) {
+		t.Errorf("expected ErrArrayFull from SetArray, got %v", err)
+	}
+	if err := doc.SetArray("/events", []interface{}{"x", "y"}); err != nil {
+		t.Errorf("expected replacing a full array within the limit to work, got %v", err)
+	}
+
+	if err := doc.ArrayAppend("/log", "1", "2", "3", "4"); err != nil {
+		t.Fatalf("ArrayAppend to a ring buffer failed: %v", err)
+	}
+	// Inserting at 2 drops the oldest element first, so "5" lands before "4".
+	if err := doc.ArrayInsert("/log", 2, "5"); err != nil {
+		t.Fatalf("ArrayInsert into a ring buffer failed: %v", err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"events": []interface{}{"x", "y"},
+		"log":    []interface{}{"3", "5", "4"},
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+
+	if err := doc.SetArray("/log", []interface{}{"a", "b", "c", "d"}); err != nil {
+		t.Fatalf("SetArray into a ring buffer failed: %v", err)
+	}
+	if state, err = doc.ToJSON(); err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := []interface{}{"b", "c", "d"}; !reflect.DeepEqual(state["log"], expected) {
+		t.Errorf("expected %v, got %v", expected, state["log"])
+	}
+}
@@ -0,0 +1,24 @@
+package autosync
+
+// Logger receives trace output for calls across the cgo boundary into Yrs, such as
+// transaction open/commit and map/array inserts and removes.
+type Logger interface {
+	Tracef(format string, args ...interface{})
+}
+
+// logger is shared by every Doc. It is nil unless SetLogger was called, in which case
+// tracing is skipped entirely.
+var logger Logger
+
+// SetLogger installs l as the trace logger for every Doc, or disables tracing when l is
+// nil. It is not synchronized with document operations, so call it during
+// initialization, before any Doc is in use.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+func tracef(format string, args ...interface{}) {
+	if logger != nil {
+		logger.Tracef(format, args...)
+	}
+}
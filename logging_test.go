@@ -0,0 +1,46 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Tracef(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestSetLogger(t *testing.T) {
+	rec := &recordingLogger{}
+	SetLogger(rec)
+	defer SetLogger(nil)
+
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{"a": 1.0}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	trace := strings.Join(rec.lines, "\n")
+	for _, want := range []string{"ydoc_read_transaction", "ydoc_write_transaction", `ymap_insert(`, "ytransaction_commit"} {
+		if !strings.Contains(trace, want) {
+			t.Errorf("expected trace to mention %s, got:\n%s", want, trace)
+		}
+	}
+
+	SetLogger(nil)
+	n := len(rec.lines)
+	if _, err := doc.UpdateToState(map[string]interface{}{"a": 2.0}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if len(rec.lines) != n {
+		t.Errorf("expected no trace output after clearing the logger, got %d new lines", len(rec.lines)-n)
+	}
+}
@@ -0,0 +1,242 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrManagerClosed is returned by DocManager.Get after Close.
+var ErrManagerClosed = errors.New("document manager is closed")
+
+// DocManager keeps the documents of a Store open by id and shares them between callers.
+// Get loads a document on first use and takes a reference to it, and Release drops the
+// reference. A document without references is saved if dirty and destroyed once it has
+// stayed unreferenced for the idle timeout; a Get in the meantime keeps it open. A
+// DocManager is safe for concurrent use.
+//
+// The documents belong to the manager: callers must not Destroy or Release them
+// themselves, nor use them after releasing their reference.
+type DocManager struct {
+	store       Store
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	docs   map[string]*managedDoc
+	closed bool
+}
+
+// managedDoc is a document held by a DocManager. ready is closed once the document has
+// been loaded, after which doc and err no longer change. evicted is set while an idle
+// document is being saved and destroyed, and closed once it is gone. gen identifies the
+// current idle timer, so a timer that fires after a Get took a reference does nothing.
+type managedDoc struct {
+	ready   chan struct{}
+	doc     *Doc
+	err     error
+	refs    int
+	gen     int
+	evicted chan struct{}
+}
+
+// NewDocManager returns a DocManager loading documents from and saving them to store,
+// which evicts documents once they have had no references for idleTimeout.
+func NewDocManager(store Store, idleTimeout time.Duration) *DocManager {
+	return &DocManager{
+		store:       store,
+		idleTimeout: idleTimeout,
+		docs:        make(map[string]*managedDoc),
+	}
+}
+
+// Get returns the document with the given id, loading it from the store (see LoadDoc)
+// unless it is already open, and takes a reference to it, which must be dropped with
+// Release. Concurrent Gets of the same id share a single load.
+func (m *DocManager) Get(id string) (*Doc, error) {
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("Get %s: %w", id, ErrManagerClosed)
+		}
+		e, ok := m.docs[id]
+		if ok && e.evicted != nil {
+			// Wait until it has been saved, so the reload below sees the latest state.
+			evicted := e.evicted
+			m.mu.Unlock()
+			<-evicted
+			continue
+		}
+		if !ok {
+			e = &managedDoc{ready: make(chan struct{}), refs: 1}
+			m.docs[id] = e
+			m.mu.Unlock()
+
+			e.doc, e.err = LoadDoc(m.store, id)
+			if e.err != nil {
+				m.mu.Lock()
+				delete(m.docs, id)
+				m.mu.Unlock()
+			}
+			close(e.ready)
+			if e.err != nil {
+				return nil, fmt.Errorf("Get: %w", e.err)
+			}
+			return e.doc, nil
+		}
+		e.refs++
+		e.gen++
+		m.mu.Unlock()
+
+		<-e.ready
+		if e.err != nil {
+			return nil, fmt.Errorf("Get: %w", e.err)
+		}
+		return e.doc, nil
+	}
+}
+
+// Release drops a reference taken with Get. Releasing an id without a reference does
+// nothing.
+func (m *DocManager) Release(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.docs[id]; ok {
+		m.release(id, e)
+	}
+}
+
+// release drops a reference to e and starts its idle timer when it was the last one.
+// The caller must hold m.mu.
+func (m *DocManager) release(id string, e *managedDoc) {
+	if e.refs == 0 {
+		return
+	}
+	e.refs--
+	if e.refs > 0 || m.closed {
+		return
+	}
+	e.gen++
+	gen := e.gen
+	time.AfterFunc(m.idleTimeout, func() { m.evict(id, e, gen) })
+}
+
+// evict saves e if dirty and destroys it, unless it was referenced again since the idle
+// timer identified by gen was started. A document that fails to save stays open and is
+// retried after another idle timeout, unless the manager was closed meanwhile.
+func (m *DocManager) evict(id string, e *managedDoc, gen int) {
+	m.mu.Lock()
+	if m.closed || m.docs[id] != e || e.refs > 0 || e.gen != gen {
+		m.mu.Unlock()
+		return
+	}
+	evicted := make(chan struct{})
+	e.evicted = evicted
+	m.mu.Unlock()
+
+	var err error
+	if e.doc.Dirty() {
+		err = e.doc.Save(m.store, id)
+	}
+
+	m.mu.Lock()
+	keep := err != nil && !m.closed
+	if keep {
+		e.evicted = nil
+		e.gen++
+		gen := e.gen
+		time.AfterFunc(m.idleTimeout, func() { m.evict(id, e, gen) })
+	} else {
+		delete(m.docs, id)
+	}
+	close(evicted)
+	m.mu.Unlock()
+	if !keep {
+		e.doc.Destroy()
+	}
+}
+
+// Flush saves every open document that is dirty. All documents are attempted; the
+// returned error joins the failures.
+func (m *DocManager) Flush() error {
+	ids, entries := m.hold()
+	var errs []error
+	for i, e := range entries {
+		if e.doc.Dirty() {
+			if err := e.doc.Save(m.store, ids[i]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	for i, e := range entries {
+		m.release(ids[i], e)
+	}
+	m.mu.Unlock()
+	if len(errs) > 0 {
+		return fmt.Errorf("Flush: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// hold takes a reference to every loaded document that isn't being evicted, so that
+// none is destroyed while the caller uses it.
+func (m *DocManager) hold() ([]string, []*managedDoc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	var entries []*managedDoc
+	for id, e := range m.docs {
+		if e.evicted != nil {
+			continue
+		}
+		select {
+		case <-e.ready:
+		default:
+			continue // still loading, so nothing to save yet
+		}
+		if e.err != nil {
+			continue
+		}
+		e.refs++
+		e.gen++
+		ids = append(ids, id)
+		entries = append(entries, e)
+	}
+	return ids, entries
+}
+
+// Close saves every dirty document, as Flush does, and destroys all open documents,
+// whether or not they are still referenced. Gets fail with ErrManagerClosed afterwards.
+func (m *DocManager) Close() error {
+	err := m.Flush()
+
+	m.mu.Lock()
+	m.closed = true
+	var open []*managedDoc
+	var evicting []chan struct{}
+	for _, e := range m.docs {
+		if e.evicted != nil {
+			evicting = append(evicting, e.evicted)
+		} else {
+			open = append(open, e)
+		}
+	}
+	m.docs = make(map[string]*managedDoc)
+	m.mu.Unlock()
+
+	for _, evicted := range evicting {
+		<-evicted
+	}
+	for _, e := range open {
+		<-e.ready
+		if e.err == nil {
+			e.doc.Destroy()
+		}
+	}
+	return err
+}
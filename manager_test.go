@@ -0,0 +1,175 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is an in-memory Store counting its loads and saves.
+type memStore struct {
+	mu      sync.Mutex
+	updates map[string][]byte
+	loads   int
+	saves   int
+	failIDs map[string]bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{updates: make(map[string][]byte), failIDs: make(map[string]bool)}
+}
+
+func (s *memStore) LoadUpdate(id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loads++
+	if s.failIDs[id] {
+		return nil, errors.New("load failed")
+	}
+	update, ok := s.updates[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return update, nil
+}
+
+func (s *memStore) SaveUpdate(id string, update []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saves++
+	s.updates[id] = update
+	return nil
+}
+
+func (s *memStore) counts() (loads, saves int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loads, s.saves
+}
+
+func TestDocManagerSharesAndEvicts(t *testing.T) {
+	store := newMemStore()
+	m := NewDocManager(store, 30*time.Millisecond)
+	defer m.Close()
+
+	// Concurrent Gets share a single load.
+	docs := make([]*Doc, 8)
+	var wg sync.WaitGroup
+	for i := range docs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			doc, err := m.Get("room")
+			if err != nil {
+				t.Errorf("Get failed: %v", err)
+			}
+			docs[i] = doc
+		}(i)
+	}
+	wg.Wait()
+	for _, doc := range docs[1:] {
+		if doc != docs[0] {
+			t.Fatal("expected every Get to return the same Doc")
+		}
+	}
+	if loads, _ := store.counts(); loads != 1 {
+		t.Errorf("expected 1 load, got %d", loads)
+	}
+
+	if err := docs[0].Set("/title", "hello"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	for range docs[1:] {
+		m.Release("room")
+	}
+	// Still referenced once, so it outlives the idle timeout.
+	time.Sleep(60 * time.Millisecond)
+	if again, err := m.Get("room"); err != nil || again != docs[0] {
+		t.Fatalf("expected the referenced Doc to stay open, got %p (err %v)", again, err)
+	}
+	m.Release("room")
+	m.Release("room")
+
+	// A Get before the timeout keeps it open.
+	time.Sleep(10 * time.Millisecond)
+	if again, err := m.Get("room"); err != nil || again != docs[0] {
+		t.Fatalf("expected a Get within the timeout to keep the Doc, got %p (err %v)", again, err)
+	}
+	m.Release("room")
+
+	// Once idle for the timeout it is saved and destroyed.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, saves := store.counts(); saves == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle document was not saved")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	reloaded, err := m.Get("room")
+	if err != nil {
+		t.Fatalf("Get after eviction failed: %v", err)
+	}
+	defer m.Release("room")
+	got, err := reloaded.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"title": "hello"}; !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if loads, _ := store.counts(); loads != 2 {
+		t.Errorf("expected the evicted document to be loaded again, got %d loads", loads)
+	}
+}
+
+func TestDocManagerFlushAndClose(t *testing.T) {
+	store := newMemStore()
+	m := NewDocManager(store, time.Hour)
+
+	a, err := m.Get("a")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, err := m.Get("b"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := a.Set("/n", 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// Only the dirty document is saved, and only once.
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if _, saves := store.counts(); saves != 1 {
+		t.Errorf("expected 1 save, got %d", saves)
+	}
+
+	store.failIDs["broken"] = true
+	if _, err := m.Get("broken"); err == nil {
+		t.Error("expected a load error")
+	}
+
+	if err := a.Set("/n", 2); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, saves := store.counts(); saves != 2 {
+		t.Errorf("expected Close to save the dirty document, got %d saves", saves)
+	}
+	if _, err := m.Get("a"); !errors.Is(err, ErrManagerClosed) {
+		t.Errorf("expected ErrManagerClosed, got %v", err)
+	}
+}
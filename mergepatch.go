@@ -0,0 +1,167 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// ApplyMergePatch applies a JSON Merge Patch (RFC 7386) to the document in a single
+// transaction. A null member deletes the key, an object member is merged recursively into
+// an existing map, and any other value (including arrays) replaces the key outright.
+// Because the document root is a map, the patch itself must be a JSON object.
+func (d *Doc) ApplyMergePatch(raw []byte) error {
+	var patch interface{}
+	if err := json.Unmarshal(raw, &patch); err != nil {
+		return fmt.Errorf("ApplyMergePatch: failed to unmarshal patch: %w", err)
+	}
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("ApplyMergePatch: patch must be a JSON object, got %T", patch)
+	}
+	if err := d.checkInputLimits("", patchMap); err != nil {
+		return fmt.Errorf("ApplyMergePatch: %w", err)
+	}
+
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		if err := mergeIntoMap(txn, rootBranch, patchMap, ""); err != nil {
+			return fmt.Errorf("ApplyMergePatch: %w", err)
+		}
+		return nil
+	})
+}
+
+// mergeIntoMap applies patch to the map branch. path is the JSON Pointer of branch and is
+// only used for error messages.
+func mergeIntoMap(txn *C.YTransaction, branch *C.Branch, patch map[string]interface{}, path string) error {
+	for key, value := range patch {
+		if strings.IndexByte(key, 0) >= 0 {
+			return fmt.Errorf("map key %q at '%s' contains an embedded NUL byte, which cannot be stored", key, path)
+		}
+		if err := mergeKey(txn, branch, key, value, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeState merges partial into the document in a single transaction without removing
+// anything: every key in partial is set, nested maps are merged recursively into existing
+// maps, and keys absent from partial are left as they are. Unlike ApplyMergePatch, a nil
+// value stores null rather than deleting the key, and arrays replace the existing value
+// wholesale.
+func (d *Doc) MergeState(partial map[string]interface{}) error {
+	if err := d.checkInputLimits("", partial); err != nil {
+		return fmt.Errorf("MergeState: %w", err)
+	}
+	generic, _ := toGeneric(d.encodeValues(partial)).(map[string]interface{})
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		if err := mergeStateInto(txn, rootBranch, generic, ""); err != nil {
+			return fmt.Errorf("MergeState: %w", err)
+		}
+		return nil
+	})
+}
+
+func mergeStateInto(txn *C.YTransaction, branch *C.Branch, partial map[string]interface{}, path string) error {
+	for key, value := range partial {
+		if strings.IndexByte(key, 0) >= 0 {
+			return fmt.Errorf("map key %q at '%s' contains an embedded NUL byte, which cannot be stored", key, path)
+		}
+		if err := mergeStateKey(txn, branch, key, value, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mergeStateKey(txn *C.YTransaction, branch *C.Branch, key string, value interface{}, path string) error {
+	keyC := C.CString(key)
+	if keyC == nil {
+		return fmt.Errorf("failed to allocate C string for map key '%s'", key)
+	}
+	defer C.free(unsafe.Pointer(keyC))
+
+	childPath := path + "/" + pointerEscaper.Replace(key)
+	if nested, ok := value.(map[string]interface{}); ok {
+		if existing := C.ymap_get(branch, txn, keyC); existing != nil {
+			defer C.youtput_destroy(existing)
+			if existing.tag == C.Y_MAP {
+				return mergeStateInto(txn, C.youtput_read_ymap(existing), nested, childPath)
+			}
+		}
+	}
+
+	var allocations []cAllocation
+	defer func() { freeAllocations(allocations) }()
+
+	input, err := buildYInputRecursive(value, &allocations)
+	if err != nil {
+		return fmt.Errorf("failed to build YInput for '%s': %w", childPath, err)
+	}
+	tracef("ymap_insert(%p, %q)", branch, key)
+	C.ymap_insert(branch, txn, keyC, &input)
+	return nil
+}
+
+func mergeKey(txn *C.YTransaction, branch *C.Branch, key string, value interface{}, path string) error {
+	keyC := C.CString(key)
+	if keyC == nil {
+		return fmt.Errorf("failed to allocate C string for map key '%s'", key)
+	}
+	defer C.free(unsafe.Pointer(keyC))
+
+	if value == nil {
+		// Deleting a missing key is a no-op in merge patch semantics.
+		tracef("ymap_remove(%p, %q)", branch, key)
+		C.ymap_remove(branch, txn, keyC)
+		return nil
+	}
+
+	childPath := path + "/" + pointerEscaper.Replace(key)
+	if nested, ok := value.(map[string]interface{}); ok {
+		if existing := C.ymap_get(branch, txn, keyC); existing != nil {
+			defer C.youtput_destroy(existing)
+			if existing.tag == C.Y_MAP {
+				return mergeIntoMap(txn, C.youtput_read_ymap(existing), nested, childPath)
+			}
+		}
+		value = stripNulls(nested)
+	}
+
+	var allocations []cAllocation
+	defer func() { freeAllocations(allocations) }()
+
+	input, err := buildYInputRecursive(value, &allocations)
+	if err != nil {
+		return fmt.Errorf("failed to build YInput for '%s': %w", childPath, err)
+	}
+	tracef("ymap_insert(%p, %q)", branch, key)
+	C.ymap_insert(branch, txn, keyC, &input)
+	return nil
+}
+
+// stripNulls returns a copy of patch with null members removed at every level, which is
+// what merging patch into a missing or non-object target produces.
+func stripNulls(patch map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(patch))
+	for key, value := range patch {
+		switch v := value.(type) {
+		case nil:
+			continue
+		case map[string]interface{}:
+			result[key] = stripNulls(v)
+		default:
+			result[key] = value
+		}
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	_, err := doc.UpdateToState(map[string]interface{}{
+		"title": "Goodbye!",
+		"author": map[string]interface{}{
+			"givenName":  "John",
+			"familyName": "Doe",
+		},
+		"tags":    []interface{}{"example", "sample"},
+		"content": "This will be unchanged",
+	})
+	if err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	patch := []byte(`{
+		"title": "Hello!",
+		"phoneNumber": "+01-123-456-7890",
+		"author": {"familyName": null, "address": {"city": null, "zip": "12345"}},
+		"tags": ["example"],
+		"missing": null
+	}`)
+	if err := doc.ApplyMergePatch(patch); err != nil {
+		t.Fatalf("ApplyMergePatch failed: %v", err)
+	}
+
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"title":       "Hello!",
+		"phoneNumber": "+01-123-456-7890",
+		"author": map[string]interface{}{
+			"givenName": "John",
+			"address":   map[string]interface{}{"zip": "12345"},
+		},
+		"tags":    []interface{}{"example"},
+		"content": "This will be unchanged",
+	}
+	if !compareMaps(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+
+	if err := doc.ApplyMergePatch([]byte(`["not", "an", "object"]`)); err == nil {
+		t.Error("expected an error for a non-object patch")
+	}
+	if err := doc.ApplyMergePatch([]byte(`{`)); err == nil {
+		t.Error("expected an error for malformed JSON")
+	}
+}
+
+func TestMergeState(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"name":   "doc",
+		"meta":   map[string]interface{}{"owner": "a", "rev": 1},
+		"tags":   []interface{}{"x", "y"},
+		"scalar": "becomes a map",
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	err = doc.MergeState(map[string]interface{}{
+		"meta":   map[string]interface{}{"rev": 2, "extra": nil},
+		"tags":   []interface{}{"z"},
+		"scalar": map[string]string{"k": "v"},
+		"added":  true,
+	})
+	if err != nil {
+		t.Fatalf("MergeState failed: %v", err)
+	}
+
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"name":   "doc",
+		"meta":   map[string]interface{}{"owner": "a", "rev": 2, "extra": nil},
+		"tags":   []interface{}{"z"},
+		"scalar": map[string]interface{}{"k": "v"},
+		"added":  true,
+	}
+	if !compareMaps(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+}
@@ -0,0 +1,152 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ToMsgpack returns the current state of the document encoded as MessagePack. It is an
+// application-level serialization of the decoded state, not the Yrs wire format, and
+// cannot be applied to another Doc. Integers are written in their smallest integer form
+// and other numbers as float64, and binary values as bin. Map keys are written in sorted
+// order, so equal states encode to equal bytes.
+func (d *Doc) ToMsgpack() ([]byte, error) {
+	state, err := d.ToJSONWithOptions(ReadOptions{UseNumber: true})
+	if err != nil {
+		return nil, fmt.Errorf("ToMsgpack: %w", err)
+	}
+	buf, err := appendMsgpack(nil, state)
+	if err != nil {
+		return nil, fmt.Errorf("ToMsgpack: %w", err)
+	}
+	return buf, nil
+}
+
+// appendMsgpack appends the MessagePack encoding of value, which must be one of the types
+// returned by ToJSONWithOptions with UseNumber, to buf.
+func appendMsgpack(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(buf, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q: %w", v, err)
+		}
+		buf = append(buf, 0xcb)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(f)), nil
+	case string:
+		return appendMsgpackString(buf, v), nil
+	case []byte:
+		return appendMsgpackBinary(buf, v), nil
+	case []interface{}:
+		buf = appendMsgpackHeader(buf, len(v), 0x90, 0xdc, 0xdd)
+		var err error
+		for i, elem := range v {
+			if buf, err = appendMsgpack(buf, elem); err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf = appendMsgpackHeader(buf, len(v), 0x80, 0xde, 0xdf)
+		var err error
+		for _, key := range keys {
+			buf = appendMsgpackString(buf, key)
+			if buf, err = appendMsgpack(buf, v[key]); err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", value)
+	}
+}
+
+// appendMsgpackInt appends i using the smallest MessagePack integer format that holds it.
+func appendMsgpackInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(buf, byte(i))
+	case i < 0 && i >= -32:
+		return append(buf, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(buf, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(i))
+	case i >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(i))
+	case i >= math.MinInt8:
+		return append(buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i))
+	}
+}
+
+// appendMsgpackString appends s as a MessagePack str.
+func appendMsgpackString(buf []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+// appendMsgpackBinary appends b as a MessagePack bin.
+func appendMsgpackBinary(buf []byte, b []byte) []byte {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xc5), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xc6), uint32(n))
+	}
+	return append(buf, b...)
+}
+
+// appendMsgpackHeader appends an array or map header for n entries, using fix for the
+// fixarray/fixmap form and code16/code32 for the larger ones.
+func appendMsgpackHeader(buf []byte, n int, fix, code16, code32 byte) []byte {
+	switch {
+	case n <= 15:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, code16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, code32), uint32(n))
+	}
+}
@@ -0,0 +1,56 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestToMsgpack(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"b": []interface{}{int64(1), int64(-1), int64(300), 1.5, nil},
+		"a": map[string]interface{}{"t": true, "f": false},
+		"s": "hi",
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	got, err := doc.ToMsgpack()
+	if err != nil {
+		t.Fatalf("ToMsgpack failed: %v", err)
+	}
+	expected := []byte{
+		0x83,                                              // map of 3
+		0xa1, 'a', 0x82, 0xa1, 'f', 0xc2, 0xa1, 't', 0xc3, // "a": {"f": false, "t": true}
+		0xa1, 'b', 0x95, // "b": array of 5
+		0x01, 0xff, 0xcd, 0x01, 0x2c, // 1, -1, 300
+		0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, // 1.5
+		0xc0,                      // nil
+		0xa1, 's', 0xa2, 'h', 'i', // "s": "hi"
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("expected % x, got % x", expected, got)
+	}
+}
+
+func TestToMsgpackLongString(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	doc, err := NewDocFromJSON(map[string]interface{}{"k": long})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	got, err := doc.ToMsgpack()
+	if err != nil {
+		t.Fatalf("ToMsgpack failed: %v", err)
+	}
+	prefix := []byte{0x81, 0xa1, 'k', 0xda, 0x01, 0x2c}
+	if !bytes.HasPrefix(got, prefix) || len(got) != len(prefix)+len(long) {
+		t.Errorf("unexpected encoding of a 300-byte string: % x", got[:len(prefix)])
+	}
+}
@@ -0,0 +1,210 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+
+// Yrs returns a dangling, non-NULL pointer for empty results, which the Go runtime
+// rejects if it finds one on a goroutine stack, so these return NULL for them instead.
+// eventPath and mapEventKeys are also used by goWatchCallback in watch.go.
+YPathSegment* eventPath(YEvent* event, uint32_t* len) {
+	YPathSegment* path;
+	switch (event->tag) {
+	case Y_MAP:
+		path = ymap_event_path(&event->content.map, len);
+		break;
+	case Y_ARRAY:
+		path = yarray_event_path(&event->content.array, len);
+		break;
+	case Y_TEXT:
+		path = ytext_event_path(&event->content.text, len);
+		break;
+	default:
+		*len = 0;
+		return NULL;
+	}
+	if (*len == 0) {
+		ypath_destroy(path, 0);
+		return NULL;
+	}
+	return path;
+}
+
+YEventKeyChange* mapEventKeys(const YMapEvent* event, uint32_t* len) {
+	YEventKeyChange* keys = ymap_event_keys(event, len);
+	if (*len == 0) {
+		yevent_keys_destroy(keys, 0);
+		return NULL;
+	}
+	return keys;
+}
+
+static YEventChange* arrayEventDelta(const YArrayEvent* event, uint32_t* len) {
+	YEventChange* delta = yarray_event_delta(event, len);
+	if (*len == 0) {
+		yevent_delta_destroy(delta, 0);
+		return NULL;
+	}
+	return delta;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"unsafe"
+)
+
+// pathObserver is a callback registered with Observe.
+type pathObserver struct {
+	fn func(changedPaths []string, origin []byte)
+}
+
+// Observe registers fn to be called with the JSON Pointers of the values added, removed
+// or replaced by every committed write, local or applied with ApplyUpdate, so a caller
+// can forward just the touched fields instead of serializing the whole document. Paths
+// are sorted and listed once per write. A map key that was set or removed is reported as
+// the pointer to the key, and array elements that were inserted or removed as the
+// pointers to their indices: those of the array after the write for insertions and
+// before it for removals. An edit inside a text reports the pointer to the text. Values
+// nested inside an added or removed container are not listed separately.
+//
+// fn runs synchronously on the goroutine that made the write, once the write has been
+// committed and the Doc's lock released, so it may use the Doc. The returned stop
+// unregisters fn; calling it more than once is harmless.
+func (d *Doc) Observe(fn func(changedPaths []string)) (stop func(), err error) {
+	stop, err = d.observe(func(changedPaths []string, _ []byte) { fn(changedPaths) })
+	if err != nil {
+		return nil, fmt.Errorf("Observe: %w", err)
+	}
+	return stop, nil
+}
+
+// ObserveWithOrigin is Observe, also passing fn the origin of the transaction that made
+// the changes: the origin given to ApplyUpdateWithOrigin, "autosync/remote" for updates
+// applied with ApplyUpdate, and nil for local writes.
+func (d *Doc) ObserveWithOrigin(fn func(changedPaths []string, origin []byte)) (stop func(), err error) {
+	stop, err = d.observe(fn)
+	if err != nil {
+		return nil, fmt.Errorf("ObserveWithOrigin: %w", err)
+	}
+	return stop, nil
+}
+
+// observe implements Observe and ObserveWithOrigin.
+func (d *Doc) observe(fn func(changedPaths []string, origin []byte)) (stop func(), err error) {
+	if err := d.observeKeys(); err != nil {
+		return nil, err
+	}
+	o := &pathObserver{fn: fn}
+	d.watchers.mu.Lock()
+	d.watchers.observers = append(d.watchers.observers, o)
+	d.watchers.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.watchers.mu.Lock()
+			defer d.watchers.mu.Unlock()
+			for i, other := range d.watchers.observers {
+				if other == o {
+					d.watchers.observers = append(d.watchers.observers[:i], d.watchers.observers[i+1:]...)
+					break
+				}
+			}
+		})
+	}, nil
+}
+
+// notifyObservers calls the Observe callbacks with the paths in changes. It must be
+// called without holding d.mu.
+func (d *Doc) notifyObservers(changes txnChanges) {
+	changed, origin := changes.paths, changes.origin
+	d.watchers.mu.Lock()
+	observers := append([]*pathObserver(nil), d.watchers.observers...)
+	d.watchers.mu.Unlock()
+	if len(changed) == 0 || len(observers) == 0 {
+		return
+	}
+
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, o := range observers {
+		o.fn(append([]string(nil), paths...), append([]byte(nil), origin...))
+	}
+}
+
+// setOrigin records origin as that of the transaction about to be committed, for
+// takeChanges. The caller must hold d.mu for writing.
+func (d *Doc) setOrigin(origin []byte) {
+	d.watchers.mu.Lock()
+	d.watchers.origin = origin
+	d.watchers.mu.Unlock()
+}
+
+// collectPaths records the paths changed by event, which is of the given kind and whose
+// path from the root is path. The caller must hold w.mu.
+func (w *keyWatchers) collectPaths(kind C.int8_t, content unsafe.Pointer, path []C.YPathSegment) {
+	if len(w.observers) == 0 {
+		return
+	}
+	var base string
+	for i := range path {
+		segment := &path[i]
+		if segment.tag == C.Y_EVENT_PATH_KEY {
+			base += "/" + pointerEscaper.Replace(C.GoString(*(**C.char)(unsafe.Pointer(&segment.value))))
+		} else {
+			base += "/" + strconv.FormatUint(uint64(*(*C.uint32_t)(unsafe.Pointer(&segment.value))), 10)
+		}
+	}
+	if w.paths == nil {
+		w.paths = make(map[string]bool)
+	}
+
+	switch kind {
+	case C.Y_MAP:
+		var keysLen C.uint32_t
+		keys := C.mapEventKeys((*C.YMapEvent)(content), &keysLen)
+		for _, change := range unsafe.Slice(keys, keysLen) {
+			w.paths[base+"/"+pointerEscaper.Replace(C.GoString(change.key))] = true
+		}
+		if keys != nil {
+			C.yevent_keys_destroy(keys, keysLen)
+		}
+	case C.Y_ARRAY:
+		var deltaLen C.uint32_t
+		delta := C.arrayEventDelta((*C.YArrayEvent)(content), &deltaLen)
+		// index follows the array after the write; removed elements are reported at the
+		// indices they had before it, which are offset by the insertions so far.
+		index, offset := 0, 0
+		for _, change := range unsafe.Slice(delta, deltaLen) {
+			n := int(change.len)
+			switch change.tag {
+			case C.Y_EVENT_CHANGE_ADD:
+				for i := 0; i < n; i++ {
+					w.paths[base+"/"+strconv.Itoa(index+i)] = true
+				}
+				index += n
+				offset -= n
+			case C.Y_EVENT_CHANGE_DELETE:
+				for i := 0; i < n; i++ {
+					w.paths[base+"/"+strconv.Itoa(index+offset+i)] = true
+				}
+				offset += n
+			default:
+				index += n
+			}
+		}
+		if delta != nil {
+			C.yevent_delta_destroy(delta, deltaLen)
+		}
+	case C.Y_TEXT:
+		w.paths[base] = true
+	}
+}
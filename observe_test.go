@@ -0,0 +1,195 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestObserve(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"title": "draft",
+		"meta":  map[string]interface{}{"a": 1.0},
+		"items": []interface{}{"x", "y", "z"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	var got [][]string
+	stop, err := doc.Observe(func(paths []string) { got = append(got, paths) })
+	if err != nil {
+		t.Fatalf("Observe failed: %v", err)
+	}
+	if err := doc.Set("/meta/a", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "remove", Path: "/items/0"},
+		{Operation: "add", Path: "/items/2", Value: "w"},
+		{Operation: "add", Path: "/count", Value: 1.0},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err != nil {
+		t.Fatalf("ApplyOperations failed: %v", err)
+	}
+	if err := doc.SetText("/title", "final"); err != nil {
+		t.Fatalf("SetText failed: %v", err)
+	}
+	if err := doc.ApplyTextDelta("/title", []TextEdit{{Index: 5, Insert: "!"}}); err != nil {
+		t.Fatalf("ApplyTextDelta failed: %v", err)
+	}
+
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+	if err := peer.Set("/meta/c", true); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.ApplyUpdate(mustEncodeFull(t, peer)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+
+	expected := [][]string{
+		{"/meta/a"},
+		{"/count", "/items/0", "/items/2"},
+		{"/title"},
+		{"/title"},
+		{"/meta/c"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	stop()
+	stop()
+	if err := doc.Set("/count", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if len(got) != len(expected) {
+		t.Errorf("expected no calls after stop, got %v", got[len(expected):])
+	}
+}
+
+func TestObserveWithOrigin(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+
+	var origins []string
+	var local []bool
+	stop, err := doc.ObserveWithOrigin(func(paths []string, origin []byte) {
+		origins = append(origins, string(origin))
+		local = append(local, origin == nil)
+	})
+	if err != nil {
+		t.Fatalf("ObserveWithOrigin failed: %v", err)
+	}
+	defer stop()
+
+	if err := doc.Set("/a", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := peer.Set("/b", true); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.ApplyUpdateWithOrigin(mustEncodeFull(t, peer), []byte("peerB")); err != nil {
+		t.Fatalf("ApplyUpdateWithOrigin failed: %v", err)
+	}
+	if err := peer.Set("/c", true); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.ApplyUpdate(mustEncodeFull(t, peer)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+
+	if expected := []string{"", "peerB", "autosync/remote"}; !reflect.DeepEqual(origins, expected) {
+		t.Errorf("expected origins %q, got %q", expected, origins)
+	}
+	if expected := []bool{true, false, false}; !reflect.DeepEqual(local, expected) {
+		t.Errorf("expected local %v, got %v", expected, local)
+	}
+}
+
+func TestObserveConcurrentWriters(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	peer := NewDoc()
+	defer peer.Destroy()
+
+	// Each update holds the peer's whole state, so applying it only adds its last key.
+	const writes = 200
+	updates := make([][]byte, writes)
+	for i := range updates {
+		if err := peer.Set("/r"+strconv.Itoa(i), i); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		updates[i] = mustEncodeFull(t, peer)
+	}
+
+	var mu sync.Mutex
+	seen := 0
+	stop, err := doc.ObserveWithOrigin(func(changedPaths []string, origin []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		prefix := "/l"
+		if string(origin) == "peer" {
+			prefix = "/r"
+		} else if origin != nil {
+			t.Errorf("unexpected origin %q", origin)
+		}
+		for _, path := range changedPaths {
+			if !strings.HasPrefix(path, prefix) {
+				t.Errorf("path %s reported with origin %q", path, origin)
+			}
+		}
+		seen += len(changedPaths)
+	})
+	if err != nil {
+		t.Fatalf("ObserveWithOrigin failed: %v", err)
+	}
+	defer stop()
+
+	// Run with -race: local and remote writes must each be reported on their own.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writes; i++ {
+			if err := doc.Set("/l"+strconv.Itoa(i), i); err != nil {
+				t.Errorf("Set failed: %v", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for _, update := range updates {
+			if err := doc.ApplyUpdateWithOrigin(update, []byte("peer")); err != nil {
+				t.Errorf("ApplyUpdateWithOrigin failed: %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if seen != 2*writes {
+		t.Errorf("expected %d changed paths, got %d", 2*writes, seen)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"sort"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// ApplyOperationsWithResult applies patchList like ApplyOperations and returns the path
+// of every operation it applied, in order, e.g. to invalidate caches keyed by path. An
+// "add" or "replace" of the whole document is listed as the pointers to the top-level
+// keys it may have changed: those the document had before it and those it sets, sorted.
+// On error nothing is applied and no paths are returned.
+func (d *Doc) ApplyOperationsWithResult(patchList jsonpatch.JSONPatchList) ([]string, error) {
+	var paths []string
+	err := d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		if patchList.Empty() {
+			return nil
+		}
+		err := d.dryRun(txn, func(txn *C.YTransaction, rootBranch *C.Branch) error {
+			return d.applyOpList(txn, rootBranch, patchList, ApplyOptions{})
+		})
+		if err != nil {
+			return err
+		}
+		for _, op := range patchList.List() {
+			opPaths := operationPaths(txn, rootBranch, op)
+			if err := d.applyPatchOp(txn, rootBranch, op, ApplyOptions{}); err != nil {
+				return err
+			}
+			paths = append(paths, opPaths...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.audit(patchList, "")
+	return paths, nil
+}
+
+// operationPaths returns the paths op, about to be applied, changes: its own path, or
+// for an "add" or "replace" of the root map the top-level keys before and after it.
+func operationPaths(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch) []string {
+	if op.Path != "" || (op.Operation != "add" && op.Operation != "replace") || C.ytype_kind(rootBranch) != C.Y_MAP {
+		return []string{op.Path}
+	}
+	keys := make(map[string]bool)
+	iter := C.ymap_iter(rootBranch, txn)
+	for entry := C.ymap_iter_next(iter); entry != nil; entry = C.ymap_iter_next(iter) {
+		keys[C.GoString(entry.key)] = true
+		C.ymap_entry_destroy(entry)
+	}
+	C.ymap_iter_destroy(iter)
+	if value, ok := toGeneric(op.Value).(map[string]interface{}); ok {
+		for key := range value {
+			keys[key] = true
+		}
+	}
+
+	paths := make([]string, 0, len(keys))
+	for key := range keys {
+		paths = append(paths, "/"+pointerEscaper.Replace(key))
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,63 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestApplyOperationsWithResult(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"a":     1.0,
+		"b/c":   "x",
+		"items": []interface{}{"x"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "replace", Path: "/a", Value: 2.0},
+		{Operation: "add", Path: "/items/-", Value: "y"},
+		{Operation: "remove", Path: "/b~1c"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	paths, err := doc.ApplyOperationsWithResult(patch)
+	if err != nil {
+		t.Fatalf("ApplyOperationsWithResult failed: %v", err)
+	}
+	if expected := []string{"/a", "/items/-", "/b~1c"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+
+	patch, err = NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "replace", Path: "", Value: map[string]interface{}{"a": 3.0, "new": true}},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if paths, err = doc.ApplyOperationsWithResult(patch); err != nil {
+		t.Fatalf("ApplyOperationsWithResult failed: %v", err)
+	}
+	if expected := []string{"/a", "/items", "/new"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+
+	patch, err = NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/b", Value: 1.0},
+		{Operation: "remove", Path: "/missing"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	paths, err = doc.ApplyOperationsWithResult(patch)
+	if err == nil || paths != nil {
+		t.Errorf("expected an error and no paths, got %v and %v", paths, err)
+	}
+}
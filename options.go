@@ -0,0 +1,68 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// ErrInvalidClientID is returned by NewDocWithOptions for a client ID that doesn't fit
+// in 53 bits.
+var ErrInvalidClientID = errors.New("client ID must fit in 53 bits")
+
+// maxClientID is the largest client ID that Yjs peers, which store it in a JavaScript
+// number, can represent exactly.
+const maxClientID = 1<<53 - 1
+
+// DocOptions configures NewDocWithOptions. The zero value creates a Doc like NewDoc.
+type DocOptions struct {
+	// ClientID identifies this replica in the updates it produces; zero picks a random
+	// one. Two replicas editing with the same ID corrupt the document beyond repair, so
+	// a fixed ID is meant for tests and for peers that are assigned unique IDs.
+	ClientID uint64
+	// SkipGC keeps deleted content in the document instead of garbage collecting it, so
+	// it can still be referenced, e.g. to rebuild past states. See NewHistoryDoc.
+	SkipGC bool
+	// CoerceWholeFloatsToLong stores floats with a whole value that fits an int64, such as
+	// the 42.0 json.Unmarshal makes of 42, as integers, so peers that expect integers get
+	// them. A float meant as such, like 3.0, is stored as the integer 3 all the same, as
+	// the two can't be told apart; other floats, such as 3.5 or 1e20, are kept.
+	CoerceWholeFloatsToLong bool
+}
+
+// NewDocWithOptions creates a Doc with a root map, like NewDoc, configured by opts.
+func NewDocWithOptions(opts DocOptions) (*Doc, error) {
+	if opts.ClientID > maxClientID {
+		return nil, fmt.Errorf("NewDocWithOptions: %w: %d", ErrInvalidClientID, opts.ClientID)
+	}
+	yOpts := C.yoptions()
+	if opts.ClientID != 0 {
+		yOpts.id = C.uint64_t(opts.ClientID)
+	}
+	if opts.SkipGC {
+		yOpts.skip_gc = 1
+	}
+	d := &Doc{
+		yDoc:        C.ydoc_new_with_options(yOpts),
+		wholeFloats: opts.CoerceWholeFloatsToLong,
+	}
+	rootKey := C.CString("root")
+	defer C.free(unsafe.Pointer(rootKey))
+
+	C.ymap(d.yDoc, rootKey) // create root map
+	d.trackDirty()
+	d.guardLeak()
+	return d, nil
+}
+
+// ClientID returns the ID identifying this replica in the updates it produces.
+func (d *Doc) ClientID() uint64 {
+	return uint64(C.ydoc_id(d.yDoc))
+}
@@ -0,0 +1,96 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewDocWithOptions(t *testing.T) {
+	newDoc := func(opts DocOptions) *Doc {
+		t.Helper()
+		doc, err := NewDocWithOptions(opts)
+		if err != nil {
+			t.Fatalf("NewDocWithOptions failed: %v", err)
+		}
+		t.Cleanup(doc.Destroy)
+		return doc
+	}
+
+	// Replicas with the same client ID making the same edits produce identical updates.
+	a := newDoc(DocOptions{ClientID: 42})
+	b := newDoc(DocOptions{ClientID: 42})
+	if a.ClientID() != 42 {
+		t.Errorf("expected client ID 42, got %d", a.ClientID())
+	}
+	for _, doc := range []*Doc{a, b} {
+		if err := doc.Set("/title", "hello"); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if !bytes.Equal(mustEncodeFull(t, a), mustEncodeFull(t, b)) {
+		t.Error("expected identical updates from replicas with the same client ID")
+	}
+	if random := newDoc(DocOptions{}); random.ClientID() == 0 {
+		t.Error("expected a random client ID by default")
+	}
+
+	// Without GC the deleted text is kept in the encoding.
+	deleted := strings.Repeat("x", 1000)
+	sizes := map[bool]int{}
+	for _, skipGC := range []bool{false, true} {
+		doc := newDoc(DocOptions{SkipGC: skipGC})
+		if err := doc.Set("/big", deleted); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if err := doc.Set("/big", "small"); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		sizes[skipGC] = len(mustEncodeFull(t, doc))
+	}
+	if sizes[true] < len(deleted) || sizes[false] >= len(deleted) {
+		t.Errorf("expected only the SkipGC document to keep the deleted value, got sizes %v", sizes)
+	}
+
+	if _, err := NewDocWithOptions(DocOptions{ClientID: 1 << 53}); !errors.Is(err, ErrInvalidClientID) {
+		t.Errorf("expected ErrInvalidClientID, got %v", err)
+	}
+}
+
+func TestCoerceWholeFloatsToLong(t *testing.T) {
+	for _, coerce := range []bool{false, true} {
+		doc, err := NewDocWithOptions(DocOptions{CoerceWholeFloatsToLong: coerce})
+		if err != nil {
+			t.Fatalf("NewDocWithOptions failed: %v", err)
+		}
+		defer doc.Destroy()
+
+		var state map[string]interface{}
+		if err := json.Unmarshal([]byte(`{"whole": 3.0, "fraction": 3.5, "huge": 1e20}`), &state); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if _, err := doc.UpdateToState(state); err != nil {
+			t.Fatalf("UpdateToState failed: %v", err)
+		}
+		if err := doc.Set("/set", 42.0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		flat, err := doc.Flatten()
+		if err != nil {
+			t.Fatalf("Flatten failed: %v", err)
+		}
+		expected := map[string]interface{}{"/whole": 3.0, "/fraction": 3.5, "/huge": 1e20, "/set": 42.0}
+		if coerce {
+			expected["/whole"], expected["/set"] = int64(3), int64(42)
+		}
+		if !reflect.DeepEqual(flat, expected) {
+			t.Errorf("coerce=%v: expected %#v, got %#v", coerce, expected, flat)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// SortOperations reorders patchList into an order that is safe to apply when the
+// array indices in it were all computed against the same document state, as is common
+// for hand-built patches. Replaces run first, at their original indices. Removes follow,
+// highest index first within each array so that no removal shifts another. Adds run
+// last, lowest index first within each array (with "-" after every numeric index), so
+// their indices are final positions in the resulting array. Within removes and adds,
+// operations are grouped by parent path in order of first appearance; those that don't
+// address an array index keep their relative order after the indexed ones.
+func SortOperations(patchList jsonpatch.JSONPatchList) (jsonpatch.JSONPatchList, error) {
+	var replaces, removes, adds []jsonpatch.JSONPatch
+	for _, op := range patchList.List() {
+		switch op.Operation {
+		case "replace":
+			replaces = append(replaces, op)
+		case "remove":
+			removes = append(removes, op)
+		case "add":
+			adds = append(adds, op)
+		default:
+			return jsonpatch.JSONPatchList{}, fmt.Errorf("SortOperations: unsupported operation type '%s'", op.Operation)
+		}
+	}
+	sortByIndex(removes, true)
+	sortByIndex(adds, false)
+
+	ops := append(append(replaces, removes...), adds...)
+	return NewPatchList(ops)
+}
+
+// ApplyOperationsSorted applies patchList after reordering it with SortOperations.
+func (d *Doc) ApplyOperationsSorted(patchList jsonpatch.JSONPatchList) error {
+	sorted, err := SortOperations(patchList)
+	if err != nil {
+		return err
+	}
+	return d.ApplyOperations(sorted)
+}
+
+// sortByIndex sorts ops that share a parent path by the numeric index in their last
+// segment, descending or ascending. Parents stay in order of first appearance, and
+// operations whose last segment is not an index ("-" or a map key) sort after the
+// indexed ones of the same parent.
+func sortByIndex(ops []jsonpatch.JSONPatch, descending bool) {
+	parentRank := map[string]int{}
+	for _, op := range ops {
+		parent, _ := splitLast(op.Path)
+		if _, ok := parentRank[parent]; !ok {
+			parentRank[parent] = len(parentRank)
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		parentI, lastI := splitLast(ops[i].Path)
+		parentJ, lastJ := splitLast(ops[j].Path)
+		if parentI != parentJ {
+			return parentRank[parentI] < parentRank[parentJ]
+		}
+		indexI, errI := strconv.ParseUint(lastI, 10, 32)
+		indexJ, errJ := strconv.ParseUint(lastJ, 10, 32)
+		switch {
+		case errI != nil || errJ != nil:
+			return errI == nil && errJ != nil
+		case descending:
+			return indexI > indexJ
+		default:
+			return indexI < indexJ
+		}
+	})
+}
+
+// splitLast splits a JSON Pointer into its parent pointer and last segment.
+func splitLast(path string) (string, string) {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return "", path
+	}
+	return path[:i], path[i+1:]
+}
@@ -0,0 +1,72 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestApplyOperationsSorted(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{
+		"list": []interface{}{"a", "b", "c", "d"},
+		"name": "x",
+	}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	// Indices refer to the original list; applied in this order the second remove
+	// would address a shifted (or missing) element.
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/list/-", Value: "z"},
+		{Operation: "remove", Path: "/list/1"},
+		{Operation: "add", Path: "/list/0", Value: "first"},
+		{Operation: "remove", Path: "/list/3"},
+		{Operation: "replace", Path: "/list/2", Value: "C"},
+		{Operation: "replace", Path: "/name", Value: "y"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+
+	sorted, err := SortOperations(patch)
+	if err != nil {
+		t.Fatalf("SortOperations failed: %v", err)
+	}
+	var order []string
+	for _, op := range sorted.List() {
+		order = append(order, op.Operation+" "+op.Path)
+	}
+	expectedOrder := []string{
+		"replace /list/2", "replace /name",
+		"remove /list/3", "remove /list/1",
+		"add /list/0", "add /list/-",
+	}
+	if len(order) != len(expectedOrder) {
+		t.Fatalf("expected %v, got %v", expectedOrder, order)
+	}
+	for i := range order {
+		if order[i] != expectedOrder[i] {
+			t.Fatalf("expected %v, got %v", expectedOrder, order)
+		}
+	}
+
+	if err := doc.ApplyOperationsSorted(patch); err != nil {
+		t.Fatalf("ApplyOperationsSorted failed: %v", err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"list": []interface{}{"first", "a", "C", "z"},
+		"name": "y",
+	}
+	if !compareMaps(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+}
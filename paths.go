@@ -0,0 +1,297 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// ReplaceSubtree swaps the object at path for value in a single operation. The existing
+// branch is dropped and value is inserted as a brand-new branch, instead of diffing the
+// two and touching every changed leaf as UpdateToState would. The path must already
+// exist; the empty path replaces the whole document.
+func (d *Doc) ReplaceSubtree(path string, value map[string]interface{}) error {
+	encoded := d.encodeValues(value)
+	if err := d.checkInputLimits(path, encoded); err != nil {
+		return fmt.Errorf("ReplaceSubtree: %w", err)
+	}
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		err := applyOp(txn, rootBranch, jsonpatch.JSONPatch{Operation: "replace", Path: path, Value: encoded})
+		if err != nil {
+			return fmt.Errorf("ReplaceSubtree: %w", err)
+		}
+		return nil
+	})
+}
+
+// valueAt reads the value at path within txn, as GetValueAtPath does.
+func valueAt(txn *C.YTransaction, rootBranch *C.Branch, path string) (interface{}, error) {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(pathSegments) == 0 {
+		return nil, errors.New("path must address a value below the root")
+	}
+	var value interface{}
+	err = readOutputTxn(txn, rootBranch, pathSegments, func(txn *C.YTransaction, output *C.YOutput) error {
+		var err error
+		value, err = outputValue(txn, output, ReadOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Move moves the value at from to path, following JSON Patch "move" semantics: the value
+// is read before anything is mutated, then removed from from, and finally added at path.
+// Array indices in path therefore refer to the array after the removal, so moving the
+// first of three elements to "/list/2" (or "/list/-") places it last. The moved value is
+// re-inserted, so it gets a new CRDT identity.
+func (d *Doc) Move(from, path string) error {
+	if from == path {
+		return nil
+	}
+	if strings.HasPrefix(path, from+"/") {
+		return fmt.Errorf("Move: cannot move '%s' into its own child '%s'", from, path)
+	}
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		value, err := valueAt(txn, rootBranch, from)
+		if err != nil {
+			return fmt.Errorf("Move: failed to read '%s': %w", from, err)
+		}
+		if err := applyOp(txn, rootBranch, jsonpatch.JSONPatch{Operation: "remove", Path: from}); err != nil {
+			return fmt.Errorf("Move: %w", err)
+		}
+		if err := applyOp(txn, rootBranch, jsonpatch.JSONPatch{Operation: "add", Path: path, Value: value}); err != nil {
+			return fmt.Errorf("Move: %w", err)
+		}
+		return nil
+	})
+}
+
+// CopySubtree inserts an independent copy of the value at from at path, in a single
+// transaction, following JSON Patch "copy" semantics for path. The value is materialized
+// as by ToJSON and inserted as brand-new CRDT content, so later edits to either copy,
+// including concurrent ones from peers, never affect the other. Like ToJSON, the copy
+// holds texts as plain strings.
+func (d *Doc) CopySubtree(from, path string) error {
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		value, err := valueAt(txn, rootBranch, from)
+		if err != nil {
+			return fmt.Errorf("CopySubtree: failed to read '%s': %w", from, err)
+		}
+		if err := applyOp(txn, rootBranch, jsonpatch.JSONPatch{Operation: "add", Path: path, Value: value}); err != nil {
+			return fmt.Errorf("CopySubtree: %w", err)
+		}
+		return nil
+	})
+}
+
+// Set stores value at path, adding it if missing and replacing it otherwise. Map keys are
+// inserted or overwritten; an array index replaces the element in place, while "-" or an
+// index equal to the array length appends. Every container on the way to path must
+// already exist. value may be anything buildYInputRecursive accepts; the empty path
+// replaces the whole document and requires a map.
+func (d *Doc) Set(path string, value interface{}) error {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+	value = d.encodeValues(value)
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		if err := d.setTxn(txn, rootBranch, pathSegments, value); err != nil {
+			return fmt.Errorf("Set %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// Upsert is Set, under the name for callers looking for upsert semantics: value is stored
+// at path whether or not something is there yet, so there is no need to check with Exists
+// and pick a JSON Patch "add" or "replace".
+func (d *Doc) Upsert(path string, value interface{}) error {
+	return d.Set(path, value)
+}
+
+// setTxn implements Set and Txn.Set within txn, for a value already converted by
+// encodeValues.
+func (d *Doc) setTxn(txn *C.YTransaction, rootBranch *C.Branch, pathSegments []string, value interface{}) error {
+	if err := d.checkInputLimits(joinPath(pathSegments), value); err != nil {
+		return err
+	}
+	pathSegments, value, err := d.limitWrite(txn, rootBranch, pathSegments, value, false)
+	if err != nil {
+		return err
+	}
+	return setPath(txn, rootBranch, pathSegments, value)
+}
+
+// SetPaths stores every value in updates at its JSON Pointer key, as Set would, in a
+// single write transaction, so peers receive the changes as one update. Paths are
+// applied shallowest first, so a container set in the same call can be filled by
+// deeper paths, and within an array in ascending index order with "-" last, so appends
+// at the array's length land in index order. Indices that replace existing elements
+// don't shift anything, so they never collide. The first failing path aborts the call,
+// but paths already applied stay applied.
+func (d *Doc) SetPaths(updates map[string]interface{}) error {
+	ops := make([]jsonpatch.JSONPatch, 0, len(updates))
+	for path, value := range updates {
+		if _, err := splitPath(path); err != nil {
+			return fmt.Errorf("SetPaths: %w", err)
+		}
+		// Every value is checked before anything is written.
+		value = d.encodeValues(value)
+		if err := d.checkInputLimits(path, value); err != nil {
+			return fmt.Errorf("SetPaths %s: %w", path, err)
+		}
+		ops = append(ops, jsonpatch.JSONPatch{Path: path, Value: value})
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		depthI, depthJ := strings.Count(ops[i].Path, "/"), strings.Count(ops[j].Path, "/")
+		if depthI != depthJ {
+			return depthI < depthJ
+		}
+		return ops[i].Path < ops[j].Path
+	})
+	sortByIndex(ops, false)
+
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		for _, op := range ops {
+			pathSegments, _ := splitPath(op.Path)
+			pathSegments, value, err := d.limitWrite(txn, rootBranch, pathSegments, op.Value, false)
+			if err != nil {
+				return fmt.Errorf("SetPaths %s: %w", op.Path, err)
+			}
+			if err := setPath(txn, rootBranch, pathSegments, value); err != nil {
+				return fmt.Errorf("SetPaths %s: %w", op.Path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// setPath implements Set within an open write transaction.
+func setPath(txn *C.YTransaction, rootBranch *C.Branch, pathSegments []string, value interface{}) error {
+	if len(pathSegments) == 0 {
+		return applyOp(txn, rootBranch, jsonpatch.JSONPatch{Operation: "replace", Path: "", Value: value})
+	}
+
+	parent, keyOrIndex, outputs, err := navigateToParent(txn, rootBranch, pathSegments)
+	if err != nil {
+		return fmt.Errorf("navigation failed: %w", err)
+	}
+	defer destroyOutputs(outputs)
+
+	var allocations []cAllocation
+	defer func() { freeAllocations(allocations) }()
+
+	input, err := buildYInputRecursive(value, &allocations)
+	if err != nil {
+		return fmt.Errorf("failed to build YInput: %w", err)
+	}
+	return setAt(txn, parent, keyOrIndex, &input)
+}
+
+// ToJSONMasked returns only the values at paths, each read individually inside a single
+// read transaction instead of serializing the whole document. Values are converted as
+// ToJSON converts them, so integers stay int64 and binary values []byte. Every value is
+// placed under its path in the result, with intermediate containers always built as
+// objects, so "/items/0/name" yields {"items": {"0": {"name": ...}}}. Paths that don't
+// exist are left out. When one path is a prefix of another, the shorter path's subtree
+// wins.
+func (d *Doc) ToJSONMasked(paths []string) (map[string]interface{}, error) {
+	masks := make([][]string, len(paths))
+	for i, path := range paths {
+		pathSegments, err := splitPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("ToJSONMasked: %w", err)
+		}
+		if len(pathSegments) == 0 {
+			return d.ToJSON()
+		}
+		masks[i] = pathSegments
+	}
+	// Shorter paths first, so a value read for a prefix is already in place when a
+	// longer path inside it comes up.
+	sort.SliceStable(masks, func(i, j int) bool { return len(masks[i]) < len(masks[j]) })
+
+	result := maskNode{}
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		for _, pathSegments := range masks {
+			parent := result.parentFor(pathSegments)
+			if parent == nil {
+				continue
+			}
+			value, err := valueAt(txn, rootBranch, joinPath(pathSegments))
+			if err != nil {
+				// Missing (or not addressable) paths are simply not part of the result.
+				continue
+			}
+			parent[pathSegments[len(pathSegments)-1]] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ToJSONMasked: %w", err)
+	}
+	return result.toMap(), nil
+}
+
+// maskNode is an intermediate object built by ToJSONMasked, as opposed to a map value
+// read from the document.
+type maskNode map[string]interface{}
+
+// parentFor returns the node that should hold the last segment of pathSegments, creating
+// intermediate nodes as needed. It returns nil if a value read from the document already
+// covers pathSegments.
+func (n maskNode) parentFor(pathSegments []string) maskNode {
+	parent := n
+	for _, segment := range pathSegments[:len(pathSegments)-1] {
+		next, exists := parent[segment]
+		if !exists {
+			child := maskNode{}
+			parent[segment] = child
+			parent = child
+			continue
+		}
+		child, ok := next.(maskNode)
+		if !ok {
+			return nil
+		}
+		parent = child
+	}
+	if _, exists := parent[pathSegments[len(pathSegments)-1]]; exists {
+		return nil
+	}
+	return parent
+}
+
+// toMap converts n into plain maps, dropping nodes that ended up empty because none of
+// the paths below them exist.
+func (n maskNode) toMap() map[string]interface{} {
+	result := make(map[string]interface{}, len(n))
+	for key, value := range n {
+		if child, ok := value.(maskNode); ok {
+			childMap := child.toMap()
+			if len(childMap) == 0 {
+				continue
+			}
+			value = childMap
+		}
+		result[key] = value
+	}
+	return result
+}
@@ -0,0 +1,415 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestReplaceSubtree(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	_, err := doc.UpdateToState(map[string]interface{}{
+		"config": map[string]interface{}{
+			"db":  map[string]interface{}{"host": "old", "port": 5432.0},
+			"app": "x",
+		},
+	})
+	if err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	if err := doc.ReplaceSubtree("/config/db", map[string]interface{}{"url": "postgres://new"}); err != nil {
+		t.Fatalf("ReplaceSubtree failed: %v", err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"config": map[string]interface{}{
+			"db":  map[string]interface{}{"url": "postgres://new"},
+			"app": "x",
+		},
+	}
+	if !compareMaps(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+
+	if err := doc.ReplaceSubtree("/config/missing", map[string]interface{}{}); err == nil {
+		t.Error("expected an error replacing a missing path")
+	}
+}
+
+func TestMove(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{
+		"list":  []interface{}{"a", "b", "c"},
+		"other": map[string]interface{}{"k": "v"},
+	}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	checkList := func(expected ...interface{}) {
+		t.Helper()
+		state, err := doc.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON failed: %v", err)
+		}
+		want := map[string]interface{}{"list": expected}
+		if !compareMaps(map[string]interface{}{"list": state["list"]}, want) {
+			t.Errorf("expected list %v, got %v", expected, state["list"])
+		}
+	}
+
+	// The target index is resolved against the array after the removal.
+	if err := doc.Move("/list/0", "/list/2"); err != nil {
+		t.Fatalf("Move first to end failed: %v", err)
+	}
+	checkList("b", "c", "a")
+
+	if err := doc.Move("/list/2", "/list/0"); err != nil {
+		t.Fatalf("Move end to first failed: %v", err)
+	}
+	checkList("a", "b", "c")
+
+	if err := doc.Move("/list/0", "/list/-"); err != nil {
+		t.Fatalf("Move first to append failed: %v", err)
+	}
+	checkList("b", "c", "a")
+
+	if err := doc.Move("/other", "/list/1"); err != nil {
+		t.Fatalf("Move map into array failed: %v", err)
+	}
+	checkList("b", map[string]interface{}{"k": "v"}, "c", "a")
+
+	if err := doc.Move("/list", "/list/0"); err == nil {
+		t.Error("expected an error moving a value into its own child")
+	}
+	if err := doc.Move("/missing", "/x"); err == nil {
+		t.Error("expected an error moving a missing value")
+	}
+}
+
+func TestCopySubtree(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"sections": []interface{}{
+			map[string]interface{}{"title": "intro", "items": []interface{}{"a"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	if err := doc.CopySubtree("/sections/0", "/sections/-"); err != nil {
+		t.Fatalf("CopySubtree failed: %v", err)
+	}
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+
+	// Concurrent edits to each copy stay with that copy.
+	if err := doc.Set("/sections/1/title", "copy"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := peer.Set("/sections/0/items/-", "b"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	syncDocs(t, doc, peer)
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"sections": []interface{}{
+			map[string]interface{}{"title": "intro", "items": []interface{}{"a", "b"}},
+			map[string]interface{}{"title": "copy", "items": []interface{}{"a"}},
+		},
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if err := doc.CopySubtree("/missing", "/x"); err == nil {
+		t.Error("expected an error copying a missing value")
+	}
+}
+
+func TestCopySubtreeKinds(t *testing.T) {
+	source := map[string]interface{}{
+		"n":    1.0,
+		"m":    map[string]interface{}{"k": map[string]interface{}{"deep": "v"}},
+		"list": []interface{}{"a", map[string]interface{}{"b": 2.0}},
+		"big":  int64(9007199254740993),
+		"bin":  []byte{1, 2, 3},
+	}
+	doc, err := NewDocFromJSON(source)
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	for _, key := range []string{"n", "m", "list", "big", "bin"} {
+		if err := doc.CopySubtree("/"+key, "/copy_"+key); err != nil {
+			t.Fatalf("CopySubtree of %s failed: %v", key, err)
+		}
+	}
+	if err := doc.Move("/copy_big", "/moved_big"); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	// Changing a copy leaves its source intact.
+	if err := doc.Set("/copy_m/k/deep", "changed"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.Set("/copy_list/1/b", 3.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"n":         1.0,
+		"m":         map[string]interface{}{"k": map[string]interface{}{"deep": "v"}},
+		"list":      []interface{}{"a", map[string]interface{}{"b": 2.0}},
+		"copy_n":    1.0,
+		"copy_m":    map[string]interface{}{"k": map[string]interface{}{"deep": "changed"}},
+		"copy_list": []interface{}{"a", map[string]interface{}{"b": 3.0}},
+	}
+	// Integers beyond 2^53 and binary values are copied and moved exactly, not through JSON.
+	if got["moved_big"] != int64(9007199254740993) {
+		t.Errorf("expected int64 9007199254740993, got %v (%T)", got["moved_big"], got["moved_big"])
+	}
+	if bin, ok := got["copy_bin"].([]byte); !ok || !bytes.Equal(bin, []byte{1, 2, 3}) {
+		t.Errorf("expected []byte{1, 2, 3}, got %v (%T)", got["copy_bin"], got["copy_bin"])
+	}
+	for _, key := range []string{"big", "bin", "moved_big", "copy_bin"} {
+		delete(got, key)
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// A "copy" operation cannot name its source, so ApplyOperations points to CopySubtree.
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{{Operation: "copy", Path: "/x"}})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err == nil || !strings.Contains(err.Error(), "CopySubtree") {
+		t.Errorf("expected an error pointing to CopySubtree, got %v", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{
+		"user": map[string]interface{}{"name": "old"},
+		"list": []interface{}{"a", "b"},
+	}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	steps := []struct {
+		path  string
+		value interface{}
+	}{
+		{"/user/name", "new"},
+		{"/user/age", 42},
+		{"/user/tags", []string{"x", "y"}},
+		{"/list/0", "A"},
+		{"/list/2", "c"},
+		{"/list/-", map[string]interface{}{"d": true}},
+		{"/count", int64(7)},
+	}
+	for _, step := range steps {
+		if err := doc.Set(step.path, step.value); err != nil {
+			t.Fatalf("Set(%s) failed: %v", step.path, err)
+		}
+	}
+
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"user":  map[string]interface{}{"name": "new", "age": 42.0, "tags": []interface{}{"x", "y"}},
+		"list":  []interface{}{"A", "b", "c", map[string]interface{}{"d": true}},
+		"count": 7.0,
+	}
+	if !compareMaps(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+
+	if err := doc.Set("/missing/child", 1); err == nil {
+		t.Error("expected an error when the parent does not exist")
+	}
+	if err := doc.Set("/list/9", 1); err == nil {
+		t.Error("expected an error for an index past the end of the array")
+	}
+	if err := doc.Set("", "scalar"); err == nil {
+		t.Error("expected an error replacing the root with a non-map")
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"user": map[string]interface{}{"name": "old"},
+		"list": []interface{}{"a"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	for path, value := range map[string]interface{}{
+		"/user/name": "new", // existing key: replaced
+		"/user/age":  42.0,  // missing key: added
+		"/list/0":    "A",   // index in range: replaced
+	} {
+		if err := doc.Upsert(path, value); err != nil {
+			t.Fatalf("Upsert(%s) failed: %v", path, err)
+		}
+	}
+	// An index equal to the length appends.
+	if err := doc.Upsert("/list/1", "b"); err != nil {
+		t.Fatalf("Upsert(/list/1) failed: %v", err)
+	}
+
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"user": map[string]interface{}{"name": "new", "age": 42.0},
+		"list": []interface{}{"A", "b"},
+	}
+	if !compareMaps(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+
+	if err := doc.Upsert("/list/5", "x"); err == nil {
+		t.Error("expected an error for an index past the end of the array")
+	}
+}
+
+func TestSetPaths(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"form": map[string]interface{}{"name": "old"},
+		"list": []interface{}{"a", "b", "c"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	if err := doc.EnableUpdateLog(); err != nil {
+		t.Fatalf("EnableUpdateLog failed: %v", err)
+	}
+
+	err = doc.SetPaths(map[string]interface{}{
+		"/list/-":            "f",
+		"/list/4":            "e",
+		"/list/3":            "d",
+		"/list/0":            "A",
+		"/form/address/city": "Oslo",
+		"/form/address":      map[string]interface{}{"zip": "0150"},
+		"/form/name":         "new",
+	})
+	if err != nil {
+		t.Fatalf("SetPaths failed: %v", err)
+	}
+
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"form": map[string]interface{}{
+			"name":    "new",
+			"address": map[string]interface{}{"zip": "0150", "city": "Oslo"},
+		},
+		"list": []interface{}{"A", "b", "c", "d", "e", "f"},
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if updates, err := doc.UpdateLog(); err != nil || len(updates) != 1 {
+		t.Errorf("expected a single update, got %d (err %v)", len(updates), err)
+	}
+
+	if err := doc.SetPaths(map[string]interface{}{"/missing/key": 1.0}); err == nil {
+		t.Error("expected an error setting below a missing container")
+	}
+}
+
+func TestToJSONMasked(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateToState(map[string]interface{}{
+		"name":    "doc",
+		"secret":  "hidden",
+		"profile": map[string]interface{}{"email": "a@b.c", "phone": "123", "address": map[string]interface{}{"city": "X"}},
+		"items":   []interface{}{map[string]interface{}{"id": "1", "body": "long"}},
+	}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	got, err := doc.ToJSONMasked([]string{"/profile/email", "/name", "/items/0/id", "/profile/address", "/profile/address/city", "/missing", "/profile/missing/deep"})
+	if err != nil {
+		t.Fatalf("ToJSONMasked failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"name": "doc",
+		"profile": map[string]interface{}{
+			"email":   "a@b.c",
+			"address": map[string]interface{}{"city": "X"},
+		},
+		"items": map[string]interface{}{"0": map[string]interface{}{"id": "1"}},
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	all, err := doc.ToJSONMasked([]string{"/name", ""})
+	if err != nil {
+		t.Fatalf("ToJSONMasked with the root path failed: %v", err)
+	}
+	if _, ok := all["secret"]; !ok {
+		t.Error("expected the root path to select the whole document")
+	}
+
+	// Masked values match ToJSON's, including integers beyond 2^53.
+	if err := doc.Set("/profile/id", int64(9007199254740993)); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	full, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	masked, err := doc.ToJSONMasked([]string{"/profile/id"})
+	if err != nil {
+		t.Fatalf("ToJSONMasked failed: %v", err)
+	}
+	want := full["profile"].(map[string]interface{})["id"]
+	if got := masked["profile"].(map[string]interface{})["id"]; got != want {
+		t.Errorf("expected %v (%T) as read by ToJSON, got %v (%T)", want, want, got, got)
+	}
+
+	if _, err := doc.ToJSONMasked([]string{"name"}); err == nil {
+		t.Error("expected an error for a path without a leading slash")
+	}
+}
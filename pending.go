@@ -0,0 +1,50 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"fmt"
+)
+
+// hasPending reports whether the document behind txn holds updates or deletions that
+// could not be integrated yet because the changes they depend on have not arrived.
+func hasPending(txn *C.YTransaction) bool {
+	if update := C.ytransaction_pending_update(txn); update != nil {
+		C.ypending_update_destroy(update)
+		return true
+	}
+	if ds := C.ytransaction_pending_ds(txn); ds != nil {
+		C.ydelete_set_destroy(ds)
+		return true
+	}
+	return false
+}
+
+// HasPendingUpdates reports whether the document is waiting for missing updates. Yrs
+// buffers updates that arrive before the changes they depend on and integrates them
+// automatically once those changes are applied, so a document with pending updates is
+// not yet caught up with its peers.
+func (d *Doc) HasPendingUpdates() (bool, error) {
+	pending := false
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		pending = hasPending(txn)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("HasPendingUpdates: %w", err)
+	}
+	return pending, nil
+}
+
+// ApplyStateVectorPending applies stateData like ApplyUpdate and reports whether
+// the document is left with pending updates afterwards (see HasPendingUpdates).
+func (d *Doc) ApplyStateVectorPending(stateData []byte) (bool, error) {
+	if err := d.ApplyUpdate(stateData); err != nil {
+		return false, err
+	}
+	return d.HasPendingUpdates()
+}
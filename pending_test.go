@@ -0,0 +1,62 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+)
+
+func TestApplyStateVectorPending(t *testing.T) {
+	source := NewDoc()
+	defer source.Destroy()
+
+	if _, err := source.UpdateToState(map[string]interface{}{"a": "first"}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	first, err := source.EncodeFull()
+	if err != nil {
+		t.Fatalf("EncodeFull failed: %v", err)
+	}
+	sv, err := source.stateVector()
+	if err != nil {
+		t.Fatalf("stateVector failed: %v", err)
+	}
+	if _, err := source.UpdateToState(map[string]interface{}{"a": "first", "b": "second"}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	second, err := source.encodeStateDiff(sv)
+	if err != nil {
+		t.Fatalf("encodeStateDiff failed: %v", err)
+	}
+
+	target := NewDoc()
+	defer target.Destroy()
+
+	// The second update depends on the first, so it is buffered.
+	pending, err := target.ApplyStateVectorPending(second)
+	if err != nil {
+		t.Fatalf("ApplyStateVectorPending failed: %v", err)
+	}
+	if !pending {
+		t.Error("expected the out-of-order update to be left pending")
+	}
+	if state, _ := target.ToJSON(); len(state) != 0 {
+		t.Errorf("expected nothing to be integrated yet, got %v", state)
+	}
+
+	pending, err = target.ApplyStateVectorPending(first)
+	if err != nil {
+		t.Fatalf("ApplyStateVectorPending failed: %v", err)
+	}
+	if pending {
+		t.Error("expected no pending updates once the missing update arrived")
+	}
+	state, err := target.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{"a": "first", "b": "second"}
+	if !compareMaps(state, expected) {
+		t.Errorf("expected %v, got %v", expected, state)
+	}
+}
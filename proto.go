@@ -0,0 +1,206 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ToProto resets msg and fills it from the current state of the document, matching keys
+// to fields by their proto name or, failing that, their JSON name. Keys without a
+// matching field and null values are skipped, the way encoding/json skips them when
+// decoding into a struct. Nested maps fill message fields and arrays fill repeated
+// fields; bytes fields take binary values or base64 strings and enum fields take value
+// names or numbers, as in the protobuf JSON mapping. Map fields are not supported yet.
+func (d *Doc) ToProto(msg proto.Message) error {
+	state, err := d.ToJSONWithOptions(ReadOptions{UseNumber: true})
+	if err != nil {
+		return fmt.Errorf("ToProto: %w", err)
+	}
+	proto.Reset(msg)
+	if err := fillMessage(msg.ProtoReflect(), state); err != nil {
+		return fmt.Errorf("ToProto: %w", err)
+	}
+	return nil
+}
+
+// fillMessage sets the fields of m from object.
+func fillMessage(m protoreflect.Message, object map[string]interface{}) error {
+	fields := m.Descriptor().Fields()
+	for key, value := range object {
+		fd := fields.ByName(protoreflect.Name(key))
+		if fd == nil {
+			fd = fields.ByJSONName(key)
+		}
+		if fd == nil || value == nil {
+			continue
+		}
+		if err := fillField(m, fd, value); err != nil {
+			return fmt.Errorf("field %s: %w", fd.Name(), err)
+		}
+	}
+	return nil
+}
+
+// fillField sets fd in m from value, which is not nil.
+func fillField(m protoreflect.Message, fd protoreflect.FieldDescriptor, value interface{}) error {
+	switch {
+	case fd.IsMap():
+		return errors.New("map fields are not supported")
+	case fd.IsList():
+		elems, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected an array for a repeated field, got %T", value)
+		}
+		list := m.Mutable(fd).List()
+		for i, elem := range elems {
+			if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+				object, ok := elem.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("element %d: expected an object, got %T", i, elem)
+				}
+				v := list.NewElement()
+				if err := fillMessage(v.Message(), object); err != nil {
+					return fmt.Errorf("element %d: %w", i, err)
+				}
+				list.Append(v)
+				continue
+			}
+			v, err := scalarValue(fd, elem)
+			if err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+			list.Append(v)
+		}
+		return nil
+	case fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected an object for a message field, got %T", value)
+		}
+		return fillMessage(m.Mutable(fd).Message(), object)
+	default:
+		v, err := scalarValue(fd, value)
+		if err != nil {
+			return err
+		}
+		m.Set(fd, v)
+		return nil
+	}
+}
+
+// scalarValue converts value, as decoded with UseNumber, to the scalar kind of fd.
+func scalarValue(fd protoreflect.FieldDescriptor, value interface{}) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if b, ok := value.(bool); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case protoreflect.StringKind:
+		if s, ok := value.(string); ok {
+			return protoreflect.ValueOfString(s), nil
+		}
+	case protoreflect.BytesKind:
+		if b, ok := value.([]byte); ok {
+			return protoreflect.ValueOfBytes(b), nil
+		}
+		if s, ok := value.(string); ok {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return protoreflect.Value{}, fmt.Errorf("invalid base64 for a bytes field: %w", err)
+			}
+			return protoreflect.ValueOfBytes(b), nil
+		}
+	case protoreflect.EnumKind:
+		switch v := value.(type) {
+		case string:
+			ev := fd.Enum().Values().ByName(protoreflect.Name(v))
+			if ev == nil {
+				return protoreflect.Value{}, fmt.Errorf("unknown value %q for enum %s", v, fd.Enum().FullName())
+			}
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		case json.Number:
+			i, err := intValue(v, math.MinInt32, math.MaxInt32)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if n, ok := value.(json.Number); ok {
+			i, err := intValue(n, math.MinInt32, math.MaxInt32)
+			return protoreflect.ValueOfInt32(int32(i)), err
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if n, ok := value.(json.Number); ok {
+			i, err := intValue(n, math.MinInt64, math.MaxInt64)
+			return protoreflect.ValueOfInt64(i), err
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if n, ok := value.(json.Number); ok {
+			u, err := uintValue(n, math.MaxUint32)
+			return protoreflect.ValueOfUint32(uint32(u)), err
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if n, ok := value.(json.Number); ok {
+			u, err := uintValue(n, math.MaxUint64)
+			return protoreflect.ValueOfUint64(u), err
+		}
+	case protoreflect.FloatKind:
+		if n, ok := value.(json.Number); ok {
+			f, err := n.Float64()
+			if err == nil && math.Abs(f) > math.MaxFloat32 {
+				err = fmt.Errorf("%s overflows a float", n)
+			}
+			return protoreflect.ValueOfFloat32(float32(f)), err
+		}
+	case protoreflect.DoubleKind:
+		if n, ok := value.(json.Number); ok {
+			f, err := n.Float64()
+			return protoreflect.ValueOfFloat64(f), err
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("cannot store %T in a %s field", value, fd.Kind())
+}
+
+// intValue parses n as an integer within [min, max]. Numbers written in float form, like
+// 5.0, are accepted when they are integral.
+func intValue(n json.Number, min, max int64) (int64, error) {
+	i, err := n.Int64()
+	if err != nil {
+		f, ferr := n.Float64()
+		if ferr != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, fmt.Errorf("%s is not an integer", n)
+		}
+		i = int64(f)
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("%d out of range [%d, %d]", i, min, max)
+	}
+	return i, nil
+}
+
+// uintValue parses n as an unsigned integer no greater than max, accepting integral
+// numbers in float form like intValue.
+func uintValue(n json.Number, max uint64) (uint64, error) {
+	u, err := strconv.ParseUint(n.String(), 10, 64)
+	if err != nil {
+		f, ferr := n.Float64()
+		if ferr != nil || f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return 0, fmt.Errorf("%s is not an unsigned integer", n)
+		}
+		u = uint64(f)
+	}
+	if u > max {
+		return 0, fmt.Errorf("%d out of range [0, %d]", u, max)
+	}
+	return u, nil
+}
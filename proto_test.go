@@ -0,0 +1,95 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestToProto(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"name": "Item",
+		"field": []interface{}{
+			map[string]interface{}{
+				"name":    "id",
+				"number":  1,
+				"label":   "LABEL_OPTIONAL",
+				"type":    9,
+				"options": map[string]interface{}{"deprecated": true},
+			},
+		},
+		"reservedName": []interface{}{"old"},
+		"options": map[string]interface{}{
+			"uninterpreted_option": []interface{}{
+				map[string]interface{}{
+					"positive_int_value": 5,
+					"negative_int_value": -3,
+					"double_value":       1.5,
+					"string_value":       "aGk=",
+				},
+			},
+		},
+		"nested_type": nil,
+		"unknown":     "ignored",
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	msg := &descriptorpb.DescriptorProto{Name: proto.String("stale"), ExtensionRange: []*descriptorpb.DescriptorProto_ExtensionRange{{}}}
+	if err := doc.ToProto(msg); err != nil {
+		t.Fatalf("ToProto failed: %v", err)
+	}
+	expected := &descriptorpb.DescriptorProto{
+		Name: proto.String("Item"),
+		Field: []*descriptorpb.FieldDescriptorProto{{
+			Name:    proto.String("id"),
+			Number:  proto.Int32(1),
+			Label:   descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:    descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Options: &descriptorpb.FieldOptions{Deprecated: proto.Bool(true)},
+		}},
+		ReservedName: []string{"old"},
+		Options: &descriptorpb.MessageOptions{
+			UninterpretedOption: []*descriptorpb.UninterpretedOption{{
+				PositiveIntValue: proto.Uint64(5),
+				NegativeIntValue: proto.Int64(-3),
+				DoubleValue:      proto.Float64(1.5),
+				StringValue:      []byte("hi"),
+			}},
+		},
+	}
+	if !proto.Equal(msg, expected) {
+		t.Errorf("expected %v, got %v", expected, msg)
+	}
+}
+
+func TestToProtoErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		state map[string]interface{}
+	}{
+		{"stringForInt", map[string]interface{}{"number": "1"}},
+		{"fractionForInt", map[string]interface{}{"number": 1.5}},
+		{"int32Overflow", map[string]interface{}{"number": 1 << 40}},
+		{"unknownEnum", map[string]interface{}{"label": "LABEL_SOMETIMES"}},
+		{"scalarForMessage", map[string]interface{}{"options": true}},
+		{"objectForScalar", map[string]interface{}{"name": map[string]interface{}{}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := NewDocFromJSON(tc.state)
+			if err != nil {
+				t.Fatalf("NewDocFromJSON failed: %v", err)
+			}
+			defer doc.Destroy()
+			if err := doc.ToProto(&descriptorpb.FieldDescriptorProto{}); err == nil {
+				t.Errorf("expected an error converting %v", tc.state)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// PruneOptions configures PruneEmptyWithOptions.
+type PruneOptions struct {
+	// Keep lists JSON Pointers of containers to keep even when they are empty. A kept
+	// container that holds anything keeps its ancestors as well.
+	Keep []string
+}
+
+// PruneEmpty removes every map and array that is empty, bottom-up, so a container whose
+// only contents were empty containers is removed too. The root itself is never removed.
+// Everything is removed in a single write transaction.
+func (d *Doc) PruneEmpty() error {
+	return d.PruneEmptyWithOptions(PruneOptions{})
+}
+
+// PruneEmptyWithOptions is PruneEmpty with the paths in opts.Keep left in place. Maps and
+// arrays stored as embedded JSON rather than as Yrs types can only be removed as a whole,
+// so they are removed when they are empty but nothing nested in them is pruned.
+func (d *Doc) PruneEmptyWithOptions(opts PruneOptions) error {
+	keep := make(map[string]bool, len(opts.Keep))
+	for _, path := range opts.Keep {
+		if _, err := splitPath(path); err != nil {
+			return fmt.Errorf("PruneEmpty: %w", err)
+		}
+		keep[path] = true
+	}
+	err := d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		pruneChildren(txn, rootBranch, "", keep)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("PruneEmpty: %w", err)
+	}
+	return nil
+}
+
+// pruneChildren removes the empty containers below branch, which is at path.
+func pruneChildren(txn *C.YTransaction, branch *C.Branch, path string, keep map[string]bool) {
+	switch C.ytype_kind(branch) {
+	case C.Y_MAP:
+		// Collect the keys first, as the map can't be changed while iterating over it.
+		var keys []string
+		iter := C.ymap_iter(branch, txn)
+		for entry := C.ymap_iter_next(iter); entry != nil; entry = C.ymap_iter_next(iter) {
+			keys = append(keys, C.GoString(entry.key))
+			C.ymap_entry_destroy(entry)
+		}
+		C.ymap_iter_destroy(iter)
+
+		for _, key := range keys {
+			keyC := C.CString(key)
+			output := C.ymap_get(branch, txn, keyC)
+			if output != nil {
+				if pruneOutput(txn, output, path+"/"+pointerEscaper.Replace(key), keep) {
+					tracef("ymap_remove(%p, %q)", branch, key)
+					C.ymap_remove(branch, txn, keyC)
+				}
+				C.youtput_destroy(output)
+			}
+			C.free(unsafe.Pointer(keyC))
+		}
+	case C.Y_ARRAY:
+		// Walk backwards so removals don't shift the elements still to be visited.
+		for i := int64(C.yarray_len(branch)) - 1; i >= 0; i-- {
+			index := C.uint32_t(i)
+			output := C.yarray_get(branch, txn, index)
+			if output == nil {
+				continue
+			}
+			if pruneOutput(txn, output, fmt.Sprintf("%s/%d", path, i), keep) {
+				tracef("yarray_remove_range(%p, %d)", branch, index)
+				C.yarray_remove_range(branch, txn, index, 1)
+			}
+			C.youtput_destroy(output)
+		}
+	}
+}
+
+// pruneOutput prunes below output, which is at path, and reports whether output itself
+// is an empty container that should be removed.
+func pruneOutput(txn *C.YTransaction, output *C.YOutput, path string, keep map[string]bool) bool {
+	var empty bool
+	switch output.tag {
+	case C.Y_MAP:
+		child := C.youtput_read_ymap(output)
+		pruneChildren(txn, child, path, keep)
+		empty = C.ymap_len(child, txn) == 0
+	case C.Y_ARRAY:
+		child := C.youtput_read_yarray(output)
+		pruneChildren(txn, child, path, keep)
+		empty = C.yarray_len(child) == 0
+	case C.Y_JSON_MAP, C.Y_JSON_ARR:
+		empty = output.len == 0
+	}
+	return empty && !keep[path]
+}
@@ -0,0 +1,57 @@
+//go:build cgo
+
+package autosync
+
+import "testing"
+
+func TestPruneEmpty(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"a": map[string]interface{}{"b": map[string]interface{}{}, "c": []interface{}{}},
+		"d": []interface{}{
+			map[string]interface{}{},
+			[]interface{}{},
+			1,
+			map[string]interface{}{"e": []interface{}{}},
+		},
+		"keep":   map[string]interface{}{},
+		"nested": map[string]interface{}{"k": map[string]interface{}{"inner": map[string]interface{}{}}},
+		"x":      1,
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	if err := doc.PruneEmptyWithOptions(PruneOptions{Keep: []string{"/keep", "/nested/k/inner"}}); err != nil {
+		t.Fatalf("PruneEmptyWithOptions failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"d":      []interface{}{1.0},
+		"keep":   map[string]interface{}{},
+		"nested": map[string]interface{}{"k": map[string]interface{}{"inner": map[string]interface{}{}}},
+		"x":      1.0,
+	}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if err := doc.PruneEmpty(); err != nil {
+		t.Fatalf("PruneEmpty failed: %v", err)
+	}
+	got, err = doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected = map[string]interface{}{"d": []interface{}{1.0}, "x": 1.0}
+	if !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if err := doc.PruneEmptyWithOptions(PruneOptions{Keep: []string{"no-slash"}}); err == nil {
+		t.Error("expected an error for an invalid keep path")
+	}
+}
@@ -0,0 +1,229 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unsafe"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// replaceInPlace applies a "replace" of a map or array with a value of the same kind by
+// updating the existing shared type rather than inserting a new one: keys and elements
+// whose values are unchanged are left alone, nested maps and arrays are updated the same
+// way, and only what differs is written. Untouched values keep their CRDT identity, so
+// the update stays small and concurrent edits to them by other peers survive the merge.
+// It reports whether op was handled; any other operation is left to applyOp.
+func replaceInPlace(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch) (bool, error) {
+	if op.Operation != "replace" {
+		return false, nil
+	}
+	value := toGeneric(op.Value)
+	if !isContainer(value) {
+		return false, nil
+	}
+
+	branch := rootBranch
+	if op.Path != "" {
+		pathSegments, err := splitPath(op.Path)
+		if err != nil {
+			return false, nil // reported by applyOp
+		}
+		parent, keyOrIndex, outputs, err := navigateToParent(txn, rootBranch, pathSegments)
+		if err != nil {
+			return false, nil
+		}
+		defer destroyOutputs(outputs)
+		output, err := getOutput(txn, parent, keyOrIndex, pathSegments[len(pathSegments)-1])
+		if err != nil {
+			return false, nil
+		}
+		defer C.youtput_destroy(output)
+		switch output.tag {
+		case C.Y_MAP:
+			branch = C.youtput_read_ymap(output)
+		case C.Y_ARRAY:
+			branch = C.youtput_read_yarray(output)
+		default:
+			return false, nil
+		}
+	}
+
+	var allocations []cAllocation
+	defer func() { freeAllocations(allocations) }()
+	handled, err := updateBranch(txn, branch, value, &allocations)
+	if err != nil {
+		return true, fmt.Errorf("operation (replace %s): %w", op.Path, err)
+	}
+	return handled, nil
+}
+
+// isContainer reports whether value, in toGeneric form, is a map or an array.
+func isContainer(value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return true
+	}
+	return false
+}
+
+// updateBranch makes the shared map or array branch hold value, in toGeneric form,
+// writing only what differs. It reports false, having written nothing, if value is not
+// of the branch's kind.
+func updateBranch(txn *C.YTransaction, branch *C.Branch, value interface{}, allocations *[]cAllocation) (bool, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if C.ytype_kind(branch) != C.Y_MAP {
+			return false, nil
+		}
+		return true, updateMap(txn, branch, v, allocations)
+	case []interface{}:
+		if C.ytype_kind(branch) != C.Y_ARRAY {
+			return false, nil
+		}
+		return true, updateArray(txn, branch, v, allocations)
+	}
+	return false, nil
+}
+
+// updateMap makes the map branch hold exactly the keys of value.
+func updateMap(txn *C.YTransaction, branch *C.Branch, value map[string]interface{}, allocations *[]cAllocation) error {
+	var stale []string
+	iter := C.ymap_iter(branch, txn)
+	for entry := C.ymap_iter_next(iter); entry != nil; entry = C.ymap_iter_next(iter) {
+		key := C.GoString(entry.key)
+		if _, ok := value[key]; !ok {
+			stale = append(stale, key)
+		}
+		C.ymap_entry_destroy(entry)
+	}
+	C.ymap_iter_destroy(iter)
+	for _, key := range stale {
+		keyC := C.CString(key)
+		tracef("ymap_remove(%p, %q)", branch, key)
+		C.ymap_remove(branch, txn, keyC)
+		C.free(unsafe.Pointer(keyC))
+	}
+
+	for key, elem := range value {
+		if strings.IndexByte(key, 0) >= 0 {
+			return fmt.Errorf("map key %q contains an embedded NUL byte, which cannot be stored", key)
+		}
+		keyC := C.CString(key)
+		err := updateMapKey(txn, branch, keyC, key, elem, allocations)
+		C.free(unsafe.Pointer(keyC))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateMapKey makes key of the map branch hold value.
+func updateMapKey(txn *C.YTransaction, branch *C.Branch, keyC *C.char, key string, value interface{}, allocations *[]cAllocation) error {
+	if existing := C.ymap_get(branch, txn, keyC); existing != nil {
+		done, err := updateOutput(txn, existing, value, allocations)
+		C.youtput_destroy(existing)
+		if done || err != nil {
+			return err
+		}
+	}
+	input, err := buildYInputRecursive(value, allocations)
+	if err != nil {
+		return fmt.Errorf("failed to build YInput for key '%s': %w", key, err)
+	}
+	tracef("ymap_insert(%p, %q)", branch, key)
+	C.ymap_insert(branch, txn, keyC, &input)
+	return nil
+}
+
+// updateArray makes the array branch hold the elements of value, comparing them index by
+// index: the common prefix is updated in place and the rest appended or removed.
+func updateArray(txn *C.YTransaction, branch *C.Branch, value []interface{}, allocations *[]cAllocation) error {
+	n := C.uint32_t(len(value))
+	for i := C.uint32_t(0); i < n && i < C.yarray_len(branch); i++ {
+		existing := C.yarray_get(branch, txn, i)
+		if existing == nil {
+			return fmt.Errorf("failed to get element at index %d", i)
+		}
+		done, err := updateOutput(txn, existing, value[i], allocations)
+		C.youtput_destroy(existing)
+		if err != nil {
+			return fmt.Errorf("index %d: %w", i, err)
+		}
+		if done {
+			continue
+		}
+		input, err := buildYInputRecursive(value[i], allocations)
+		if err != nil {
+			return fmt.Errorf("failed to build YInput for index %d: %w", i, err)
+		}
+		tracef("yarray_remove_range(%p, %d)", branch, i)
+		C.yarray_remove_range(branch, txn, i, 1)
+		tracef("yarray_insert_range(%p, %d)", branch, i)
+		C.yarray_insert_range(branch, txn, i, &input, 1)
+	}
+
+	if length := C.yarray_len(branch); length > n {
+		tracef("yarray_remove_range(%p, %d, %d)", branch, n, length-n)
+		C.yarray_remove_range(branch, txn, n, length-n)
+	} else if length < n {
+		inputs, err := buildYInputs(value[length:], allocations)
+		if err != nil {
+			return err
+		}
+		tracef("yarray_insert_range(%p, %d, %d)", branch, length, n-length)
+		C.yarray_insert_range(branch, txn, length, inputs, n-length)
+	}
+	return nil
+}
+
+// updateOutput brings the existing value output in line with value, reporting whether it
+// did: a shared map or array is updated in place when value is of the same kind, and a
+// plain value already equal to value needs nothing. Otherwise the caller must store value
+// anew.
+func updateOutput(txn *C.YTransaction, output *C.YOutput, value interface{}, allocations *[]cAllocation) (bool, error) {
+	switch output.tag {
+	case C.Y_MAP:
+		return updateBranch(txn, C.youtput_read_ymap(output), value, allocations)
+	case C.Y_ARRAY:
+		return updateBranch(txn, C.youtput_read_yarray(output), value, allocations)
+	case C.Y_JSON_BOOL, C.Y_JSON_NUM, C.Y_JSON_INT, C.Y_JSON_STR, C.Y_JSON_BUF, C.Y_JSON_NULL:
+		stored, err := outputValue(txn, output, ReadOptions{})
+		if err != nil {
+			return false, err
+		}
+		return sameScalar(stored, value), nil
+	}
+	return false, nil
+}
+
+// sameScalar reports whether stored, as read by outputValue, is what value would be
+// stored as. Numbers must agree on being integers or floats as well as on their value.
+func sameScalar(stored, value interface{}) bool {
+	if value == nil || stored == nil {
+		return value == nil && stored == nil
+	}
+	val := reflect.ValueOf(value)
+	switch s := stored.(type) {
+	case int64:
+		switch val.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return val.Int() == s
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return s >= 0 && val.Uint() == uint64(s)
+		}
+		return false
+	case float64:
+		return (val.Kind() == reflect.Float64 || val.Kind() == reflect.Float32) && val.Float() == s
+	}
+	return reflect.DeepEqual(stored, value)
+}
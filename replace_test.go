@@ -0,0 +1,91 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestReplaceInPlace(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"a":    map[string]interface{}{"b": map[string]interface{}{"c": 1.0, "d": "x"}},
+		"list": []interface{}{1.0, 2.0, 3.0},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "replace", Path: "/a", Value: map[string]interface{}{"b": map[string]interface{}{"c": 2.0, "d": "x"}}},
+		{Operation: "replace", Path: "/list", Value: []interface{}{1.0, 5.0, 3.0}},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err != nil {
+		t.Fatalf("ApplyOperations failed: %v", err)
+	}
+
+	// Concurrent edits to values the replace left unchanged survive the merge.
+	if err := peer.Set("/a/b/d", "y"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	peerPatch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/list/-", Value: 4.0},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := peer.ApplyOperations(peerPatch); err != nil {
+		t.Fatalf("ApplyOperations failed: %v", err)
+	}
+
+	if err := doc.ApplyUpdate(mustEncodeFull(t, peer)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	if err := peer.ApplyUpdate(mustEncodeFull(t, doc)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"a":    map[string]interface{}{"b": map[string]interface{}{"c": 2.0, "d": "y"}},
+		"list": []interface{}{1.0, 5.0, 3.0, 4.0},
+	}
+	for name, d := range map[string]*Doc{"doc": doc, "peer": peer} {
+		got, err := d.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+	}
+
+	// A replace with a value of another kind still replaces wholesale.
+	patch, err = NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "replace", Path: "/a", Value: []interface{}{"z"}},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err != nil {
+		t.Fatalf("ApplyOperations failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if a := got["a"]; !reflect.DeepEqual(a, []interface{}{"z"}) {
+		t.Errorf("expected /a to be [z], got %v", a)
+	}
+}
@@ -0,0 +1,114 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"fmt"
+	"reflect"
+)
+
+// Resolver picks the value to keep at a path after a merge changed it. local is the
+// value before the merge and merged is the value the CRDT settled on; the returned
+// value is stored in its place.
+type Resolver func(local, merged interface{}) interface{}
+
+// SetResolver registers r to run on path after every ApplyUpdate, replacing any
+// previous resolver for path; nil removes it. r is only consulted when the value at path
+// existed both before and after the merge and the merge changed it; when the CRDT keeps
+// the local value it is the peer receiving that value that resolves the conflict, so
+// every peer should register the same resolvers. When r returns something other than
+// merged, that value is written back with Set as a local change, which must be sent to
+// peers like any other so that they converge on it too. Resolvers run after the merge
+// has been committed, so a concurrent writer can observe the merged value in between.
+func (d *Doc) SetResolver(path string, r Resolver) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r == nil {
+		delete(d.resolvers, path)
+		return
+	}
+	if d.resolvers == nil {
+		d.resolvers = make(map[string]Resolver)
+	}
+	d.resolvers[path] = r
+}
+
+// ResolveLatest returns a Resolver that keeps whichever of local and merged is an object
+// with the larger number in field, such as an embedded timestamp. Ties and values that
+// are not objects with a numeric field keep merged.
+func ResolveLatest(field string) Resolver {
+	return func(local, merged interface{}) interface{} {
+		localTime, ok := numberField(local, field)
+		if !ok {
+			return merged
+		}
+		if mergedTime, ok := numberField(merged, field); ok && localTime > mergedTime {
+			return local
+		}
+		return merged
+	}
+}
+
+func numberField(value interface{}, field string) (float64, bool) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	number, ok := toFloat(object[field]).(float64)
+	return number, ok
+}
+
+// resolverValues reads the current value at every path that has a resolver. Paths that
+// don't exist are left out.
+func (d *Doc) resolverValues() map[string]interface{} {
+	d.mu.RLock()
+	n := len(d.resolvers)
+	d.mu.RUnlock()
+	if n == 0 {
+		return nil
+	}
+
+	values := make(map[string]interface{})
+	d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		for path := range d.resolvers {
+			if value, err := valueAt(txn, rootBranch, path); err == nil {
+				values[path] = value
+			}
+		}
+		return nil
+	})
+	return values
+}
+
+// runResolvers compares the values at resolved paths against before, as returned by
+// resolverValues ahead of a merge, and writes back whatever the resolvers choose.
+func (d *Doc) runResolvers(before map[string]interface{}) error {
+	if len(before) == 0 {
+		return nil
+	}
+	after := d.resolverValues()
+	d.mu.RLock()
+	resolvers := make(map[string]Resolver, len(d.resolvers))
+	for path, r := range d.resolvers {
+		resolvers[path] = r
+	}
+	d.mu.RUnlock()
+
+	for path, merged := range after {
+		local, ok := before[path]
+		r := resolvers[path]
+		if !ok || r == nil || reflect.DeepEqual(local, merged) {
+			continue
+		}
+		if chosen := r(local, merged); !reflect.DeepEqual(chosen, merged) {
+			if err := d.Set(path, chosen); err != nil {
+				return fmt.Errorf("resolver for %s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+)
+
+func TestResolveLatestOverridesMerge(t *testing.T) {
+	base, err := NewDocFromJSON(map[string]interface{}{
+		"status": map[string]interface{}{"value": "draft", "ts": 1.0},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer base.Destroy()
+	baseUpdate, err := base.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+
+	local, err := NewDocFromStateVector(baseUpdate)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer local.Destroy()
+	local.SetResolver("/status", ResolveLatest("ts"))
+	if err := local.Set("/status", map[string]interface{}{"value": "published", "ts": 20.0}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// The remote edit is older by timestamp but may still win the CRDT merge.
+	remote, err := NewDocFromStateVector(baseUpdate)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer remote.Destroy()
+	if err := remote.Set("/status", map[string]interface{}{"value": "archived", "ts": 10.0}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	remoteUpdate, err := remote.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+
+	// Whichever side the CRDT favours, one of the two merges picks the older value and
+	// the resolver there writes the newer one back, which the other side then receives.
+	remote.SetResolver("/status", ResolveLatest("ts"))
+	if err := local.ApplyStateVector(remoteUpdate); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	localUpdate, err := local.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	if err := remote.ApplyStateVector(localUpdate); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"status": map[string]interface{}{"value": "published", "ts": 20.0},
+	}
+	for name, doc := range map[string]*Doc{"local": local, "remote": remote} {
+		got, err := doc.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON failed: %v", err)
+		}
+		if !compareMaps(got, expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+	}
+}
+
+func TestResolverSkipsUnchangedPaths(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": 1.0, "b": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	calls := 0
+	doc.SetResolver("/a", func(local, merged interface{}) interface{} {
+		calls++
+		return local
+	})
+
+	peer, err := NewDocFromJSON(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer peer.Destroy()
+	if err := peer.Set("/c", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	update, err := peer.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	if err := doc.ApplyStateVector(update); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("expected resolver not to run when /a is unchanged, ran %d times", calls)
+	}
+
+	doc.SetResolver("/a", nil)
+	if len(doc.resolvers) != 0 {
+		t.Errorf("expected nil resolver to unregister the path")
+	}
+}
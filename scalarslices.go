@@ -0,0 +1,67 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unsafe"
+)
+
+// buildScalarSlice builds the YInput for an array from a []int64, []string or []float64,
+// reporting false for any other value. Unlike the general case of buildYInputRecursive,
+// it writes the elements straight into the C array, without boxing each one into an
+// interface{} and recursing, which makes large slices of scalars much cheaper to store.
+// The elements are stored as the general case would store them.
+func buildScalarSlice(value interface{}, allocations *[]cAllocation) (C.YInput, bool, error) {
+	var n int
+	switch v := value.(type) {
+	case []int64:
+		n = len(v)
+	case []string:
+		n = len(v)
+	case []float64:
+		n = len(v)
+	default:
+		return C.YInput{}, false, nil
+	}
+	if n == 0 {
+		return C.YInput{}, false, nil // left to the general case, which handles empty slices
+	}
+
+	cArrayPtr := cAlloc(allocations, uintptr(n)*uintptr(C.sizeof_YInput))
+	if cArrayPtr == nil {
+		return C.YInput{}, true, fmt.Errorf("failed to allocate C array for %d YInputs", n)
+	}
+	inputs := unsafe.Slice((*C.YInput)(cArrayPtr), n)
+	switch v := value.(type) {
+	case []int64:
+		for i, elem := range v {
+			inputs[i] = C.yinput_long(C.int64_t(elem))
+		}
+	case []string:
+		for i, elem := range v {
+			if strings.IndexByte(elem, 0) >= 0 {
+				return C.YInput{}, true, fmt.Errorf("failed processing slice element %d: string value contains an embedded NUL byte, which cannot be stored", i)
+			}
+			cStr := cString(allocations, elem)
+			if cStr == nil {
+				return C.YInput{}, true, fmt.Errorf("failed processing slice element %d: failed to allocate C string", i)
+			}
+			inputs[i] = C.yinput_string(cStr)
+		}
+	case []float64:
+		for i, elem := range v {
+			if math.IsNaN(elem) || math.IsInf(elem, 0) {
+				return C.YInput{}, true, fmt.Errorf("failed processing slice element %d: %w: %v", i, ErrNonFiniteFloat, elem)
+			}
+			inputs[i] = C.yinput_float(C.double(elem))
+		}
+	}
+	return C.yinput_yarray((*C.YInput)(cArrayPtr), C.uint32_t(n)), true, nil
+}
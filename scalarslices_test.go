@@ -0,0 +1,54 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestScalarSlices(t *testing.T) {
+	typed := map[string]interface{}{
+		"ints":    []int64{1, -2, math.MaxInt64},
+		"strings": []string{"a", "", "c"},
+		"floats":  []float64{0.5, 2, -1e300},
+		"empty":   []string{},
+	}
+	generic := map[string]interface{}{
+		"ints":    []interface{}{int64(1), int64(-2), int64(math.MaxInt64)},
+		"strings": []interface{}{"a", "", "c"},
+		"floats":  []interface{}{0.5, 2.0, -1e300},
+		"empty":   []interface{}{},
+	}
+
+	var states []map[string]interface{}
+	for _, state := range []map[string]interface{}{typed, generic} {
+		doc, err := NewDocFromJSON(state)
+		if err != nil {
+			t.Fatalf("NewDocFromJSON failed: %v", err)
+		}
+		got, err := doc.ToJSON()
+		doc.Destroy()
+		if err != nil {
+			t.Fatalf("ToJSON failed: %v", err)
+		}
+		states = append(states, got)
+	}
+	if !reflect.DeepEqual(states[0], generic) {
+		t.Errorf("expected %v, got %v", generic, states[0])
+	}
+	if !reflect.DeepEqual(states[0], states[1]) {
+		t.Errorf("expected typed slices to be stored like []interface{}, got %v and %v", states[0], states[1])
+	}
+
+	doc := NewDoc()
+	defer doc.Destroy()
+	if err := doc.Set("/s", []string{"ok", "bad\x00"}); err == nil {
+		t.Error("expected an error for a string with an embedded NUL byte")
+	}
+	if err := doc.Set("/f", []float64{1, math.NaN()}); !errors.Is(err, ErrNonFiniteFloat) {
+		t.Errorf("expected ErrNonFiniteFloat, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrOutOfScope is returned by ApplyStateVectorScoped when an update changes a path
+// outside the allowed prefixes.
+var ErrOutOfScope = errors.New("update changes a path outside the allowed prefixes")
+
+// ApplyStateVectorScoped applies stateData like ApplyUpdate, but only if every value
+// it changes lies under one of allowedPrefixes. Prefixes are JSON Pointers matched on
+// whole segments, so "/users" allows "/users/1" but not "/usersArchive"; the empty
+// prefix allows everything. The update is first applied to a copy of the document and
+// the copies compared leaf by leaf (see Flatten), so additions, removals and array index
+// shifts are all attributed to the paths they touch. If any change falls outside the
+// prefixes, nothing is applied and the returned error wraps ErrOutOfScope and names the
+// first offending path in sorted order.
+//
+// The check runs against the state at the time of the call; writes made concurrently
+// with it are not considered.
+func (d *Doc) ApplyStateVectorScoped(stateData []byte, allowedPrefixes []string) error {
+	current, err := d.EncodeFull()
+	if err != nil {
+		return fmt.Errorf("ApplyStateVectorScoped: %w", err)
+	}
+	before, err := flattenUpdates(current)
+	if err != nil {
+		return fmt.Errorf("ApplyStateVectorScoped: failed to copy document: %w", err)
+	}
+	after, err := flattenUpdates(current, stateData)
+	if err != nil {
+		return fmt.Errorf("ApplyStateVectorScoped: %w", err)
+	}
+
+	changed := make([]string, 0)
+	for pointer := range changedLeaves(before, after) {
+		changed = append(changed, pointer)
+	}
+	sort.Strings(changed)
+	for _, pointer := range changed {
+		if !underAnyPrefix(pointer, allowedPrefixes) {
+			return fmt.Errorf("ApplyStateVectorScoped: %w: %s", ErrOutOfScope, pointer)
+		}
+	}
+
+	return d.ApplyUpdate(stateData)
+}
+
+// underAnyPrefix reports whether pointer equals one of prefixes or lies below it.
+func underAnyPrefix(pointer string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if prefix == "" || pointer == prefix || strings.HasPrefix(pointer, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplyStateVectorScoped(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"billing":      map[string]interface{}{"plan": "free"},
+		"billingNotes": "n",
+		"profile":      map[string]interface{}{"name": "a"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	base := mustEncodeFull(t, doc)
+
+	updateFrom := func(path string, value interface{}) []byte {
+		t.Helper()
+		peer, err := NewDocFromStateVector(base)
+		if err != nil {
+			t.Fatalf("NewDocFromStateVector failed: %v", err)
+		}
+		defer peer.Destroy()
+		if err := peer.Set(path, value); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		return mustEncodeFull(t, peer)
+	}
+
+	if err := doc.ApplyStateVectorScoped(updateFrom("/billing/plan", "pro"), []string{"/billing"}); err != nil {
+		t.Fatalf("expected an in-scope update to apply, got %v", err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if plan := state["billing"].(map[string]interface{})["plan"]; plan != "pro" {
+		t.Errorf("expected plan=pro, got %v", plan)
+	}
+
+	err = doc.ApplyStateVectorScoped(updateFrom("/billingNotes", "x"), []string{"/billing"})
+	if !errors.Is(err, ErrOutOfScope) {
+		t.Fatalf("expected ErrOutOfScope for a sibling key sharing the prefix, got %v", err)
+	}
+	if state, err = doc.ToJSON(); err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if notes := state["billingNotes"]; notes != "n" {
+		t.Errorf("expected the rejected update not to be applied, got %v", notes)
+	}
+
+	if err := doc.ApplyStateVectorScoped(updateFrom("/profile/name", "b"), []string{""}); err != nil {
+		t.Errorf("expected the empty prefix to allow everything, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"fmt"
+)
+
+// EncodedSize returns the length in bytes of the document's full v1 encoding, what
+// EncodeFull would return, without copying the encoding into Go memory. Use it for size
+// limits and metrics.
+func (d *Doc) EncodedSize() (int, error) {
+	var size int
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		var err error
+		size, err = stateDiffLenTxn(txn, nil)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("EncodedSize: %w", err)
+	}
+	return size, nil
+}
+
+// ItemCount returns the number of values held by the main root and the collections
+// defined with DefineMap or DefineArray: every map entry and array element, counting those
+// of nested maps and arrays as well. A text, or a map or array stored as embedded JSON,
+// counts as a single value. Deleted content is not counted; see TombstoneBytes for that.
+func (d *Doc) ItemCount() (int, error) {
+	count := 0
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		names := []string{d.root()}
+		for name := range d.collections {
+			names = append(names, name)
+		}
+		for _, name := range names {
+			branch := collectionBranch(txn, name)
+			if branch == nil {
+				return fmt.Errorf("collection %q not found", name)
+			}
+			count += branchItemCount(txn, branch)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ItemCount: %w", err)
+	}
+	return count, nil
+}
+
+// branchItemCount returns the number of values below branch, as counted by ItemCount.
+func branchItemCount(txn *C.YTransaction, branch *C.Branch) int {
+	count := 0
+	switch C.ytype_kind(branch) {
+	case C.Y_MAP:
+		iter := C.ymap_iter(branch, txn)
+		for entry := C.ymap_iter_next(iter); entry != nil; entry = C.ymap_iter_next(iter) {
+			count += 1 + outputItemCount(txn, entry.value)
+			C.ymap_entry_destroy(entry)
+		}
+		C.ymap_iter_destroy(iter)
+	case C.Y_ARRAY:
+		iter := C.yarray_iter(branch, txn)
+		for output := C.yarray_iter_next(iter); output != nil; output = C.yarray_iter_next(iter) {
+			count += 1 + outputItemCount(txn, output)
+			C.youtput_destroy(output)
+		}
+		C.yarray_iter_destroy(iter)
+	}
+	return count
+}
+
+// outputItemCount returns the number of values below output, which is nonzero only for
+// shared maps and arrays.
+func outputItemCount(txn *C.YTransaction, output *C.YOutput) int {
+	switch output.tag {
+	case C.Y_MAP:
+		return branchItemCount(txn, C.youtput_read_ymap(output))
+	case C.Y_ARRAY:
+		return branchItemCount(txn, C.youtput_read_yarray(output))
+	}
+	return 0
+}
+
+// TombstoneBytes returns how many bytes of the encoded document (see
+// EncodeStateAsUpdate) are spent on deleted content rather than visible content. It is
+// read from the document's delete set: every deleted range counts the encoded blocks it
+// covers, in proportion to how much of each it covers, and the delete set itself counts
+// as well. Deleted content that Yrs has already garbage collected is cheap, but its
+// tombstones still count. It works for every kind of root, including NewArrayDoc.
+func (d *Doc) TombstoneBytes() (int, error) {
+	update, err := d.encodeStateDiff(nil)
+	if err != nil {
+		return 0, fmt.Errorf("TombstoneBytes: %w", err)
+	}
+	decoded, err := decodeUpdateV1(update)
+	if err != nil {
+		return 0, fmt.Errorf("TombstoneBytes: %w", err)
+	}
+	return decoded.deletedSize(), nil
+}
+
+// sizeWatermark holds the state for SetSizeWatermark.
+type sizeWatermark struct {
+	bytes int
+	fn    func(current int)
+	above bool
+}
+
+// SetSizeWatermark registers fn to be called when the encoded size of the document (the
+// length of EncodeFull) grows to bytes or more. The size is measured after every
+// committed write, which costs a full encoding, so it is only done while a watermark is
+// set. fn fires once per crossing: it is called again only if the document first drops
+// back below bytes, which is rare as deleted content still leaves tombstones behind.
+// Passing a nil fn removes the watermark.
+func (d *Doc) SetSizeWatermark(bytes int, fn func(current int)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.watermark = sizeWatermark{bytes: bytes, fn: fn}
+}
+
+func (d *Doc) checkSizeWatermark() {
+	d.mu.RLock()
+	set := d.watermark.fn != nil
+	d.mu.RUnlock()
+	if !set {
+		return
+	}
+	current, err := d.EncodedSize()
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	fn := d.watermark.fn
+	crossed := false
+	if current < d.watermark.bytes {
+		d.watermark.above = false
+	} else if !d.watermark.above {
+		d.watermark.above = true
+		crossed = true
+	}
+	d.mu.Unlock()
+	if crossed && fn != nil {
+		fn(current)
+	}
+}
@@ -0,0 +1,152 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTombstoneBytes(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	state := map[string]interface{}{"keep": "value"}
+	for i := 0; i < 100; i++ {
+		state[fmt.Sprintf("key_%d", i)] = fmt.Sprintf("some reasonably long value %d", i)
+	}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+
+	before, err := doc.TombstoneBytes()
+	if err != nil {
+		t.Fatalf("TombstoneBytes failed: %v", err)
+	}
+	if before != 0 {
+		t.Errorf("expected no tombstones before anything is deleted, got %d", before)
+	}
+
+	if _, err := doc.UpdateToState(map[string]interface{}{"keep": "value"}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	after, err := doc.TombstoneBytes()
+	if err != nil {
+		t.Fatalf("TombstoneBytes failed: %v", err)
+	}
+	size, err := doc.EncodedSize()
+	if err != nil {
+		t.Fatalf("EncodedSize failed: %v", err)
+	}
+	if after <= before || after >= size {
+		t.Errorf("expected tombstones to take part of the %d bytes after removing keys, got %d", size, after)
+	}
+}
+
+func TestTombstoneBytesArrayRoot(t *testing.T) {
+	doc := NewArrayDoc()
+	defer doc.Destroy()
+
+	if err := doc.SetArray("", []interface{}{"a", "b", "c", "d"}); err != nil {
+		t.Fatalf("SetArray failed: %v", err)
+	}
+	if n, err := doc.TombstoneBytes(); err != nil || n != 0 {
+		t.Fatalf("expected no tombstones, got %d (err %v)", n, err)
+	}
+	if err := doc.ArrayRemove("", 0, 2); err != nil {
+		t.Fatalf("ArrayRemove failed: %v", err)
+	}
+	if n, err := doc.TombstoneBytes(); err != nil || n == 0 {
+		t.Errorf("expected tombstones after removing elements, got %d (err %v)", n, err)
+	}
+}
+
+func TestSetSizeWatermark(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	var calls []int
+	doc.SetSizeWatermark(500, func(current int) {
+		calls = append(calls, current)
+	})
+
+	state := map[string]interface{}{}
+	for i := 0; i < 50; i++ {
+		state[fmt.Sprintf("key_%d", i)] = fmt.Sprintf("value %d", i)
+		if _, err := doc.UpdateToState(state); err != nil {
+			t.Fatalf("UpdateToState failed: %v", err)
+		}
+	}
+
+	if len(calls) != 1 {
+		t.Fatalf("expected the watermark to fire exactly once, got %d calls", len(calls))
+	}
+	if calls[0] < 500 {
+		t.Errorf("expected the reported size to be at least 500, got %d", calls[0])
+	}
+	full, err := doc.EncodeFull()
+	if err != nil {
+		t.Fatalf("EncodeFull failed: %v", err)
+	}
+	if len(full) < 500 {
+		t.Errorf("expected a document above the watermark, got %d bytes", len(full))
+	}
+
+	doc.SetSizeWatermark(0, nil)
+	state["more"] = "data"
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if len(calls) != 1 {
+		t.Errorf("expected no calls after removing the watermark, got %d", len(calls))
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"title": "x", "list": []interface{}{1.0, 2.0}})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	size, err := doc.EncodedSize()
+	if err != nil {
+		t.Fatalf("EncodedSize failed: %v", err)
+	}
+	update, err := doc.EncodeFull()
+	if err != nil {
+		t.Fatalf("EncodeFull failed: %v", err)
+	}
+	if size != len(update) {
+		t.Errorf("expected EncodedSize %d to match EncodeFull, got %d", len(update), size)
+	}
+}
+
+func TestItemCount(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"title": "x",
+		"user":  map[string]interface{}{"name": "alice", "tags": []interface{}{"a", "b"}},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	if err := doc.DefineArray("events"); err != nil {
+		t.Fatalf("DefineArray failed: %v", err)
+	}
+
+	// title, user, user/name, user/tags and its two elements.
+	if n, err := doc.ItemCount(); err != nil || n != 6 {
+		t.Errorf("expected 6 items, got %d, %v", n, err)
+	}
+
+	if err := doc.ArrayAppend("/user/tags", "c"); err != nil {
+		t.Fatalf("ArrayAppend failed: %v", err)
+	}
+	if err := doc.ApplyMergePatch([]byte(`{"title": null}`)); err != nil {
+		t.Fatalf("ApplyMergePatch failed: %v", err)
+	}
+	if n, err := doc.ItemCount(); err != nil || n != 6 {
+		t.Errorf("expected 6 items after adding one and removing one, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build cgo
+
+package autosync
+
+import "fmt"
+
+// StateVectorMap returns the document's state vector (see EncodeStateVector) decoded into
+// the clock of each client it has seen changes from: the number of changes from that
+// client it holds. Comparing the maps of two peers shows which of them is missing whose
+// changes, e.g. when diagnosing why they don't converge. The document's own ID is
+// ClientID; it appears once the document has made a change.
+func (d *Doc) StateVectorMap() (map[uint64]uint32, error) {
+	sv, err := d.stateVector()
+	if err != nil {
+		return nil, fmt.Errorf("StateVectorMap: %w", err)
+	}
+	clocks, err := DecodeStateVector(sv)
+	if err != nil {
+		return nil, fmt.Errorf("StateVectorMap: %w", err)
+	}
+	return clocks, nil
+}
+
+// DecodeStateVector decodes a v1 state vector, as encoded by EncodeStateVector on this or
+// another peer, into the clock of each client, as StateVectorMap returns it.
+func DecodeStateVector(sv []byte) (map[uint64]uint32, error) {
+	r := &updateReader{buf: sv}
+	n := r.uint()
+	clocks := make(map[uint64]uint32)
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		client := r.uint()
+		clocks[client] = uint32(r.uint())
+	}
+	if r.err == nil && r.pos != len(sv) {
+		r.fail(fmt.Errorf("%d trailing bytes", len(sv)-r.pos))
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to decode state vector: %w", r.err)
+	}
+	return clocks, nil
+}
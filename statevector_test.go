@@ -0,0 +1,68 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStateVectorMap(t *testing.T) {
+	doc, err := NewDocWithOptions(DocOptions{ClientID: 3})
+	if err != nil {
+		t.Fatalf("NewDocWithOptions failed: %v", err)
+	}
+	defer doc.Destroy()
+	if doc.ClientID() != 3 {
+		t.Fatalf("expected client ID 3, got %d", doc.ClientID())
+	}
+
+	clocks, err := doc.StateVectorMap()
+	if err != nil {
+		t.Fatalf("StateVectorMap failed: %v", err)
+	}
+	if len(clocks) != 0 {
+		t.Errorf("expected an empty state vector, got %v", clocks)
+	}
+
+	if err := doc.Set("/a", "abc"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	peer, err := NewDocWithOptions(DocOptions{ClientID: 7})
+	if err != nil {
+		t.Fatalf("NewDocWithOptions failed: %v", err)
+	}
+	defer peer.Destroy()
+	if err := peer.ApplyUpdate(mustEncodeFull(t, doc)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	if err := peer.Set("/b", true); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	clocks, err = peer.StateVectorMap()
+	if err != nil {
+		t.Fatalf("StateVectorMap failed: %v", err)
+	}
+	if expected := map[uint64]uint32{3: 1, 7: 1}; !reflect.DeepEqual(clocks, expected) {
+		t.Errorf("expected %v, got %v", expected, clocks)
+	}
+
+	sv, err := doc.EncodeStateVector()
+	if err != nil {
+		t.Fatalf("EncodeStateVector failed: %v", err)
+	}
+	clocks, err = DecodeStateVector(sv)
+	if err != nil {
+		t.Fatalf("DecodeStateVector failed: %v", err)
+	}
+	if expected := map[uint64]uint32{3: 1}; !reflect.DeepEqual(clocks, expected) {
+		t.Errorf("expected %v, got %v", expected, clocks)
+	}
+	if _, err := DecodeStateVector(append(sv, 0)); err == nil {
+		t.Error("expected an error for trailing bytes")
+	}
+	if _, err := DecodeStateVector([]byte{1, 3}); err == nil {
+		t.Error("expected an error for a truncated state vector")
+	}
+}
@@ -0,0 +1,121 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrStickyIndexDestroyed is returned by Resolve after Destroy.
+var ErrStickyIndexDestroyed = errors.New("sticky index is destroyed")
+
+// StickyIndex is a position in an array of a Doc that stays attached to the element it
+// was created at, rather than to a number, so it keeps pointing at the same place as
+// elements are inserted and removed before it, by this peer or concurrently by others.
+// It is safe for concurrent use.
+type StickyIndex struct {
+	doc *Doc
+	pos *C.YStickyIndex
+}
+
+// StickyIndexAt returns a StickyIndex for position index of the array at path: the
+// position of the element now at index or, if index is the array's length, the position
+// just after its last element, which elements appended later are inserted after. Call
+// Destroy when done with it.
+func (d *Doc) StickyIndexAt(path string, index uint32) (*StickyIndex, error) {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("StickyIndexAt %s: %w", path, err)
+	}
+	// Yrs requires a write transaction, though nothing is written, so this opens one
+	// directly rather than through write, which would run the hooks of a write.
+	var pos *C.YStickyIndex
+	err = d.writeQuiet(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		branch, outputs, err := resolveBranch(txn, rootBranch, pathSegments)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+		if C.ytype_kind(branch) != C.Y_ARRAY {
+			return errors.New("path does not address an array")
+		}
+		if length := C.yarray_len(branch); C.uint32_t(index) > length {
+			return indexOutOfBounds(C.uint32_t(index), length)
+		}
+		// Sticks to the element at index or, at the end of the array, to the last one.
+		assoc := C.int8_t(0)
+		if C.uint32_t(index) == C.yarray_len(branch) {
+			assoc = -1
+		}
+		pos = C.ysticky_index_from_index(branch, txn, C.uint32_t(index), assoc)
+		if pos == nil {
+			return errors.New("ysticky_index_from_index returned nil")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("StickyIndexAt %s: %w", path, err)
+	}
+	return &StickyIndex{doc: d, pos: pos}, nil
+}
+
+// writeQuiet runs fn inside a write transaction with the root, like write, for callers
+// that need a write transaction but change nothing. None of the hooks of a write run, and
+// it works on a frozen Doc.
+func (d *Doc) writeQuiet(fn func(txn *C.YTransaction, rootBranch *C.Branch) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.yDoc == nil {
+		return errors.New("document is destroyed")
+	}
+	txn := C.ydoc_write_transaction(d.yDoc, 0, nil)
+	if txn == nil {
+		return fmt.Errorf("failed to create write transaction: %w", ErrTransactionInProgress)
+	}
+	tracef("ydoc_write_transaction() = %p", txn)
+	defer commitTransaction(txn)
+
+	rootBranch := collectionBranch(txn, d.root())
+	if rootBranch == nil {
+		return errors.New("root map not found")
+	}
+	return fn(txn, rootBranch)
+}
+
+// Resolve returns the current index of the position, as moved by the changes made since
+// it was created. If the element it is attached to has been removed, it is the index the
+// element would have.
+func (s *StickyIndex) Resolve() (uint32, error) {
+	var index C.uint32_t
+	err := s.doc.readTxn(func(txn *C.YTransaction) error {
+		if s.pos == nil {
+			return ErrStickyIndexDestroyed
+		}
+		var branch *C.Branch
+		C.ysticky_index_read(s.pos, txn, &branch, &index)
+		if branch == nil {
+			return errors.New("the array of the sticky index no longer exists")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Resolve: %w", err)
+	}
+	return uint32(index), nil
+}
+
+// Destroy frees the underlying Yrs sticky index. It may be called more than once.
+func (s *StickyIndex) Destroy() {
+	s.doc.mu.Lock()
+	defer s.doc.mu.Unlock()
+	if s.pos == nil {
+		return
+	}
+	C.ysticky_index_destroy(s.pos)
+	s.pos = nil
+}
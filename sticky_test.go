@@ -0,0 +1,79 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestStickyIndex(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"list": []interface{}{"a", "b", "c", "d"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+
+	// Anchored at "c".
+	sticky, err := doc.StickyIndexAt("/list", 2)
+	if err != nil {
+		t.Fatalf("StickyIndexAt failed: %v", err)
+	}
+	defer sticky.Destroy()
+	end, err := doc.StickyIndexAt("/list", 4)
+	if err != nil {
+		t.Fatalf("StickyIndexAt failed: %v", err)
+	}
+	defer end.Destroy()
+
+	// A peer inserts two elements ahead of it, and this document removes one.
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/list/0", Value: "x"},
+		{Operation: "add", Path: "/list/0", Value: "y"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := peer.ApplyOperations(patch); err != nil {
+		t.Fatalf("ApplyOperations failed: %v", err)
+	}
+	patch, err = NewPatchList([]jsonpatch.JSONPatch{{Operation: "remove", Path: "/list/1"}})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err != nil {
+		t.Fatalf("ApplyOperations failed: %v", err)
+	}
+	if err := doc.ApplyUpdate(mustEncodeFull(t, peer)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+
+	// The list is now [y x a c d].
+	if index, err := sticky.Resolve(); err != nil || index != 3 {
+		t.Errorf("expected index 3, got %d (err %v)", index, err)
+	}
+	if index, err := end.Resolve(); err != nil || index != 5 {
+		t.Errorf("expected index 5, got %d (err %v)", index, err)
+	}
+
+	if _, err := doc.StickyIndexAt("/list", 6); err == nil {
+		t.Error("expected an error for an index past the end")
+	}
+	if _, err := doc.StickyIndexAt("/list/0", 0); err == nil {
+		t.Error("expected an error for a path that is not an array")
+	}
+	sticky.Destroy()
+	sticky.Destroy()
+	if _, err := sticky.Resolve(); !errors.Is(err, ErrStickyIndexDestroyed) {
+		t.Errorf("expected ErrStickyIndexDestroyed, got %v", err)
+	}
+}
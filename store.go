@@ -0,0 +1,96 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotFound is returned by a Store's LoadUpdate for an id that has never been saved.
+var ErrNotFound = errors.New("document not found")
+
+// Store persists encoded documents by id. LoadUpdate returns the update last passed to
+// SaveUpdate for id, or an error wrapping ErrNotFound if there is none. Implementations
+// must be safe for concurrent use.
+type Store interface {
+	LoadUpdate(id string) ([]byte, error)
+	SaveUpdate(id string, update []byte) error
+}
+
+// LoadDoc loads the document saved under id in store. An id that was never saved yields
+// a new empty Doc, so a document can be opened the same way whether or not it exists.
+// The loaded document matches what is stored, so it starts out clean (see Dirty).
+func LoadDoc(store Store, id string) (*Doc, error) {
+	update, err := store.LoadUpdate(id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("LoadDoc %s: %w", id, err)
+	}
+	doc := NewDoc()
+	if err := doc.ApplyUpdate(update); err != nil {
+		doc.Destroy()
+		return nil, fmt.Errorf("LoadDoc %s: %w", id, err)
+	}
+	doc.MarkClean()
+	return doc, nil
+}
+
+// Save writes the document's full state (see EncodeStateAsUpdate, which also marks it clean)
+// to store under id, replacing what was saved there before.
+func (d *Doc) Save(store Store, id string) error {
+	update, err := d.EncodeStateAsUpdate()
+	if err != nil {
+		return fmt.Errorf("Save %s: %w", id, err)
+	}
+	if err := store.SaveUpdate(id, update); err != nil {
+		return fmt.Errorf("Save %s: %w", id, err)
+	}
+	return nil
+}
+
+// FileStore is a Store keeping each document in its own file in Dir, named after the
+// escaped id. Saves write a temporary file and rename it into place, so a crash never
+// leaves a partially written document behind.
+type FileStore struct {
+	Dir string
+}
+
+func (s FileStore) path(id string) string {
+	return filepath.Join(s.Dir, url.PathEscape(id)+".ydoc")
+}
+
+// LoadUpdate implements Store.
+func (s FileStore) LoadUpdate(id string) ([]byte, error) {
+	update, err := os.ReadFile(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return update, err
+}
+
+// SaveUpdate implements Store.
+func (s FileStore) SaveUpdate(id string, update []byte) error {
+	return writeFileAtomic(s.path(id), update)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path and renames it
+// into place, so a crash never leaves a partially written file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,51 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	store := FileStore{Dir: t.TempDir()}
+	const id = "rooms/42"
+
+	if _, err := store.LoadUpdate(id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	doc, err := LoadDoc(store, id)
+	if err != nil {
+		t.Fatalf("LoadDoc of a missing id failed: %v", err)
+	}
+	defer doc.Destroy()
+	if state, err := doc.ToJSON(); err != nil || len(state) != 0 {
+		t.Fatalf("expected an empty document, got %v (err %v)", state, err)
+	}
+
+	if err := doc.Set("/title", "hello"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.Save(store, id); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if doc.Dirty() {
+		t.Error("expected Save to mark the document clean")
+	}
+
+	loaded, err := LoadDoc(store, id)
+	if err != nil {
+		t.Fatalf("LoadDoc failed: %v", err)
+	}
+	defer loaded.Destroy()
+	if loaded.Dirty() {
+		t.Error("expected a loaded document to start out clean")
+	}
+	got, err := loaded.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if expected := map[string]interface{}{"title": "hello"}; !compareMaps(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,129 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// SubdocInfo describes a subdocument embedded in a Doc.
+type SubdocInfo struct {
+	// GUID uniquely identifies the subdocument across peers.
+	GUID string
+	// ShouldLoad reports whether a load of the subdocument's content was requested.
+	ShouldLoad bool
+	// AutoLoad reports whether peers load the subdocument automatically.
+	AutoLoad bool
+}
+
+// Subdocs lists the subdocuments referenced anywhere in the document, in no particular
+// order. Their content is not read, so this is cheap even for subdocuments that have not
+// been loaded.
+func (d *Doc) Subdocs() ([]SubdocInfo, error) {
+	var subdocs []SubdocInfo
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		var count C.uint32_t
+		docs := C.ytransaction_subdocs(txn, &count)
+		if count == 0 {
+			return nil
+		}
+		if docs == nil {
+			return errors.New("ytransaction_subdocs returned nil")
+		}
+		// The array itself is ours to free; the documents in it are owned by d.
+		defer C.free(unsafe.Pointer(docs))
+
+		for _, subdoc := range unsafe.Slice(docs, count) {
+			guidC := C.ydoc_guid(subdoc)
+			if guidC == nil {
+				return errors.New("ydoc_guid returned nil")
+			}
+			subdocs = append(subdocs, SubdocInfo{
+				GUID:       C.GoString(guidC),
+				ShouldLoad: C.ydoc_should_load(subdoc) != 0,
+				AutoLoad:   C.ydoc_auto_load(subdoc) != 0,
+			})
+			C.ystring_destroy(guidC)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Subdocs: %w", err)
+	}
+	return subdocs, nil
+}
+
+// insertSubdoc stores a new, empty subdocument with the given guid under key in the
+// root map.
+func (d *Doc) insertSubdoc(key, guid string, autoLoad bool) error {
+	guidC := C.CString(guid)
+	defer C.free(unsafe.Pointer(guidC))
+
+	opts := C.yoptions()
+	opts.guid = guidC
+	if autoLoad {
+		opts.auto_load = 1
+	}
+	subdoc := C.ydoc_new_with_options(opts)
+	if subdoc == nil {
+		return errors.New("failed to create subdocument")
+	}
+	// The parent takes its own reference to the subdocument on insert.
+	defer C.ydoc_destroy(subdoc)
+
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		keyC := C.CString(key)
+		defer C.free(unsafe.Pointer(keyC))
+
+		input := C.yinput_ydoc(subdoc)
+		tracef("ymap_insert(%p, %q)", rootBranch, key)
+		C.ymap_insert(rootBranch, txn, keyC, &input)
+		return nil
+	})
+}
+
+// CreateSubdoc stores a new, empty subdocument with a random GUID at path and returns a
+// Doc for its content, which has its own "root" map and is synced on its own: its
+// updates are exchanged with EncodeStateAsUpdate and ApplyUpdate on the returned Doc,
+// while the parent only holds a reference to it, which ToJSON renders as
+// {"guid": ...}. Call Destroy on the returned Doc when done with it; the parent keeps
+// its own reference.
+func (d *Doc) CreateSubdoc(path string) (*Doc, error) {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("CreateSubdoc: %w", err)
+	}
+	subdoc := C.ydoc_new_with_options(C.yoptions())
+	if subdoc == nil {
+		return nil, errors.New("CreateSubdoc: failed to create subdocument")
+	}
+	child := &Doc{yDoc: subdoc}
+	rootKey := C.CString("root")
+	defer C.free(unsafe.Pointer(rootKey))
+	C.ymap(subdoc, rootKey) // create root map
+
+	err = d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		parent, keyOrIndex, outputs, err := navigateToParent(txn, rootBranch, pathSegments)
+		if err != nil {
+			return fmt.Errorf("navigation failed: %w", err)
+		}
+		defer destroyOutputs(outputs)
+
+		input := C.yinput_ydoc(subdoc)
+		return setAt(txn, parent, keyOrIndex, &input)
+	})
+	if err != nil {
+		C.ydoc_destroy(subdoc)
+		return nil, fmt.Errorf("CreateSubdoc %s: %w", path, err)
+	}
+	child.trackDirty()
+	child.guardLeak()
+	return child, nil
+}
@@ -0,0 +1,103 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSubdocs(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	subdocs, err := doc.Subdocs()
+	if err != nil {
+		t.Fatalf("Subdocs failed: %v", err)
+	}
+	if len(subdocs) != 0 {
+		t.Fatalf("expected no subdocuments, got %v", subdocs)
+	}
+
+	if err := doc.insertSubdoc("a", "11111111-1111-4111-8111-111111111111", false); err != nil {
+		t.Fatalf("insertSubdoc failed: %v", err)
+	}
+	if err := doc.insertSubdoc("b", "22222222-2222-4222-8222-222222222222", true); err != nil {
+		t.Fatalf("insertSubdoc failed: %v", err)
+	}
+
+	subdocs, err = doc.Subdocs()
+	if err != nil {
+		t.Fatalf("Subdocs failed: %v", err)
+	}
+	sort.Slice(subdocs, func(i, j int) bool { return subdocs[i].GUID < subdocs[j].GUID })
+	if len(subdocs) != 2 {
+		t.Fatalf("expected 2 subdocuments, got %v", subdocs)
+	}
+	if subdocs[0].GUID != "11111111-1111-4111-8111-111111111111" || subdocs[0].AutoLoad {
+		t.Errorf("unexpected first subdocument: %+v", subdocs[0])
+	}
+	if subdocs[1].GUID != "22222222-2222-4222-8222-222222222222" || !subdocs[1].AutoLoad {
+		t.Errorf("unexpected second subdocument: %+v", subdocs[1])
+	}
+}
+
+func TestCreateSubdoc(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"pages": map[string]interface{}{}})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	page, err := doc.CreateSubdoc("/pages/intro")
+	if err != nil {
+		t.Fatalf("CreateSubdoc failed: %v", err)
+	}
+	defer page.Destroy()
+	if err := page.Set("/title", "Intro"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	subdocs, err := doc.Subdocs()
+	if err != nil {
+		t.Fatalf("Subdocs failed: %v", err)
+	}
+	if len(subdocs) != 1 || subdocs[0].GUID == "" {
+		t.Fatalf("expected one subdocument, got %v", subdocs)
+	}
+
+	// The parent renders the subdocument by its GUID, not its content.
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"pages": map[string]interface{}{"intro": map[string]interface{}{"guid": subdocs[0].GUID}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// The subdocument syncs on its own.
+	update, err := page.EncodeStateAsUpdate()
+	if err != nil {
+		t.Fatalf("EncodeStateAsUpdate failed: %v", err)
+	}
+	remote := NewDoc()
+	defer remote.Destroy()
+	if err := remote.ApplyUpdate(update); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	got, err = remote.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, map[string]interface{}{"title": "Intro"}) {
+		t.Errorf("unexpected subdocument content: %v", got)
+	}
+
+	if _, err := doc.CreateSubdoc("/missing/page"); err == nil {
+		t.Error("expected an error for a missing parent")
+	}
+}
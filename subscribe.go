@@ -0,0 +1,89 @@
+//go:build cgo
+
+package autosync
+
+import "sync"
+
+// subscribeBuffer is the capacity of the channels returned by Subscribe.
+const subscribeBuffer = 64
+
+// ChangeEvent describes a committed write, as delivered by Subscribe.
+type ChangeEvent struct {
+	// Paths are the JSON Pointers of the values the write changed, as passed to the
+	// Observe callbacks.
+	Paths []string
+	// Origin is the origin of the transaction, as passed to the ObserveWithOrigin
+	// callbacks: nil for local writes.
+	Origin []byte
+	// Dropped is the number of events dropped since the previous event was delivered,
+	// because the channel was full.
+	Dropped int
+}
+
+// subscription is the state of a channel returned by Subscribe.
+type subscription struct {
+	mu      sync.Mutex
+	ch      chan ChangeEvent
+	closed  bool
+	dropped int
+}
+
+// Subscribe is ObserveWithOrigin delivering the changes as events on a buffered channel
+// rather than through a callback. Writes never wait for the consumer: when the channel
+// is full, the event is dropped and counted, and the next event delivered reports how
+// many were dropped before it in Dropped, so a consumer that falls behind can tell it
+// needs to resynchronize, e.g. by reading the document anew.
+//
+// The returned unsubscribe stops the events and closes the channel; calling it more than
+// once is harmless. Events dropped since the last delivered one are reported by a final
+// event with no Paths, sent just before the channel is closed, provided the consumer
+// has made room for it by then; otherwise that count is lost.
+//
+// If the document's changes can't be observed, e.g. for a NewBareDoc that has no root
+// yet, where Observe and ObserveWithOrigin return an error, the channel is returned
+// already closed and unsubscribe does nothing.
+func (d *Doc) Subscribe() (events <-chan ChangeEvent, unsubscribe func()) {
+	s := &subscription{ch: make(chan ChangeEvent, subscribeBuffer)}
+	stop, err := d.observe(s.send)
+	if err != nil {
+		close(s.ch)
+		return s.ch, func() {}
+	}
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			stop()
+			s.close()
+		})
+	}
+}
+
+// send delivers an event for a write, or counts it as dropped if the channel is full.
+func (s *subscription) send(changedPaths []string, origin []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// notifyObservers may still call send after unsubscribe has closed the channel.
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- ChangeEvent{Paths: changedPaths, Origin: origin, Dropped: s.dropped}:
+		s.dropped = 0
+	default:
+		s.dropped++
+	}
+}
+
+// close reports any trailing drops, if there is room to, and closes the channel.
+func (s *subscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dropped > 0 {
+		select {
+		case s.ch <- ChangeEvent{Dropped: s.dropped}:
+		default:
+		}
+	}
+	s.closed = true
+	close(s.ch)
+}
@@ -0,0 +1,112 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+
+	events, unsubscribe := doc.Subscribe()
+
+	if err := doc.Set("/a", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := peer.Set("/b", true); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.ApplyUpdateWithOrigin(mustEncodeFull(t, peer), []byte("peerB")); err != nil {
+		t.Fatalf("ApplyUpdateWithOrigin failed: %v", err)
+	}
+	if err := peer.Set("/c", true); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.ApplyUpdate(mustEncodeFull(t, peer)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+
+	expected := []ChangeEvent{
+		{Paths: []string{"/a"}},
+		{Paths: []string{"/b"}, Origin: []byte("peerB")},
+		{Paths: []string{"/c"}, Origin: []byte(remoteOrigin)},
+	}
+	for i, want := range expected {
+		got := <-events
+		if !reflect.DeepEqual(got.Paths, want.Paths) || string(got.Origin) != string(want.Origin) || got.Dropped != 0 {
+			t.Errorf("event %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+
+	// A consumer that falls behind loses events, and learns how many from the next one.
+	for i := 0; i < subscribeBuffer+3; i++ {
+		if err := doc.Set("/n", strconv.Itoa(i)); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	for i := 0; i < subscribeBuffer; i++ {
+		if event := <-events; event.Dropped != 0 {
+			t.Errorf("event %d: expected no drops, got %d", i, event.Dropped)
+		}
+	}
+	if err := doc.Set("/n", "last"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if event := <-events; event.Dropped != 3 {
+		t.Errorf("expected 3 dropped events, got %d", event.Dropped)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if err := doc.Set("/a", 3.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if event, ok := <-events; ok {
+		t.Errorf("expected the channel to be closed, got %+v", event)
+	}
+}
+
+func TestSubscribeTrailingDrops(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	events, unsubscribe := doc.Subscribe()
+	for i := 0; i < subscribeBuffer+2; i++ {
+		if err := doc.Set("/n", strconv.Itoa(i)); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	<-events
+
+	// Drops after the last delivered event are reported on unsubscribe.
+	unsubscribe()
+	var last ChangeEvent
+	count := 1
+	for event := range events {
+		last = event
+		count++
+	}
+	if count != subscribeBuffer+1 || last.Dropped != 2 || last.Paths != nil {
+		t.Errorf("expected %d events ending in one reporting 2 drops, got %d ending in %+v", subscribeBuffer+1, count, last)
+	}
+
+	bare := NewBareDoc()
+	defer bare.Destroy()
+	events, unsubscribe = bare.Subscribe()
+	if event, ok := <-events; ok {
+		t.Errorf("expected a closed channel for a document without a root, got %+v", event)
+	}
+	unsubscribe()
+}
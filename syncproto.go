@@ -0,0 +1,103 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidSyncMessage is returned by DecodeSyncMessage for data that is not a well-formed
+// sync message.
+var ErrInvalidSyncMessage = errors.New("invalid sync message")
+
+// SyncMessageType is the kind of a y-protocols sync message.
+type SyncMessageType uint64
+
+const (
+	// SyncStep1 carries the state vector of the sender, which the receiver answers with
+	// a SyncStep2 holding EncodeDiff of it.
+	SyncStep1 SyncMessageType = 0
+	// SyncStep2 carries the update the sender's SyncStep1 was missing.
+	SyncStep2 SyncMessageType = 1
+	// SyncUpdate carries an update made after the handshake.
+	SyncUpdate SyncMessageType = 2
+)
+
+// messageSync is the y-websocket message type of sync messages, which comes before the
+// sync message type. Awareness and other messages have other types.
+const messageSync = 0
+
+// SyncMessage is a decoded y-protocols sync message. Payload is a state vector for
+// SyncStep1 and a v1 update otherwise.
+type SyncMessage struct {
+	Type    SyncMessageType
+	Payload []byte
+}
+
+// EncodeSyncStep1 frames the state vector sv, from EncodeStateVector, as the first
+// message of the y-protocols sync handshake, in the form y-websocket sends and expects:
+// the sync message type, the step, and sv prefixed with its length, each as a varint.
+func EncodeSyncStep1(sv []byte) []byte {
+	return encodeSyncMessage(SyncStep1, sv)
+}
+
+// EncodeSyncStep2 frames update, the EncodeDiff answering a peer's SyncStep1, as the
+// second message of the sync handshake.
+func EncodeSyncStep2(update []byte) []byte {
+	return encodeSyncMessage(SyncStep2, update)
+}
+
+// EncodeUpdateMessage frames update, such as one passed to an OnUpdateDebounced callback, as a sync
+// message broadcasting a change to peers that have completed the handshake.
+func EncodeUpdateMessage(update []byte) []byte {
+	return encodeSyncMessage(SyncUpdate, update)
+}
+
+func encodeSyncMessage(kind SyncMessageType, payload []byte) []byte {
+	msg := make([]byte, 0, 2+binary.MaxVarintLen64+len(payload))
+	msg = binary.AppendUvarint(msg, messageSync)
+	msg = binary.AppendUvarint(msg, uint64(kind))
+	msg = binary.AppendUvarint(msg, uint64(len(payload)))
+	return append(msg, payload...)
+}
+
+// DecodeSyncMessage decodes a message encoded by EncodeSyncStep1, EncodeSyncStep2 or
+// EncodeUpdateMessage, or received from a y-websocket peer. Messages other than sync
+// messages, such as awareness updates, and truncated messages are rejected with an
+// error wrapping ErrInvalidSyncMessage. The payload aliases data.
+func DecodeSyncMessage(data []byte) (SyncMessage, error) {
+	pos := 0
+	next := func(what string) (uint64, error) {
+		v, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return 0, fmt.Errorf("DecodeSyncMessage: %w: truncated %s", ErrInvalidSyncMessage, what)
+		}
+		pos += n
+		return v, nil
+	}
+
+	messageType, err := next("message type")
+	if err != nil {
+		return SyncMessage{}, err
+	}
+	if messageType != messageSync {
+		return SyncMessage{}, fmt.Errorf("DecodeSyncMessage: %w: message type %d is not a sync message", ErrInvalidSyncMessage, messageType)
+	}
+	kind, err := next("sync message type")
+	if err != nil {
+		return SyncMessage{}, err
+	}
+	if kind > uint64(SyncUpdate) {
+		return SyncMessage{}, fmt.Errorf("DecodeSyncMessage: %w: unknown sync message type %d", ErrInvalidSyncMessage, kind)
+	}
+	length, err := next("payload length")
+	if err != nil {
+		return SyncMessage{}, err
+	}
+	if length > uint64(len(data)-pos) {
+		return SyncMessage{}, fmt.Errorf("DecodeSyncMessage: %w: truncated payload: %d of %d bytes", ErrInvalidSyncMessage, len(data)-pos, length)
+	}
+	return SyncMessage{Type: SyncMessageType(kind), Payload: data[pos : pos+int(length)]}, nil
+}
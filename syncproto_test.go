@@ -0,0 +1,56 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSyncMessages(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	if err := doc.Set("/greeting", "hello"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	sv, err := doc.EncodeStateVector()
+	if err != nil {
+		t.Fatalf("EncodeStateVector failed: %v", err)
+	}
+	update, err := doc.EncodeStateAsUpdate()
+	if err != nil {
+		t.Fatalf("EncodeStateAsUpdate failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		msg      []byte
+		expected SyncMessage
+	}{
+		{EncodeSyncStep1(sv), SyncMessage{Type: SyncStep1, Payload: sv}},
+		{EncodeSyncStep2(update), SyncMessage{Type: SyncStep2, Payload: update}},
+		{EncodeUpdateMessage(update), SyncMessage{Type: SyncUpdate, Payload: update}},
+	} {
+		got, err := DecodeSyncMessage(tc.msg)
+		if err != nil {
+			t.Fatalf("DecodeSyncMessage failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("expected %v, got %v", tc.expected, got)
+		}
+	}
+
+	// The framing matches y-websocket: messageSync, the step, then the length-prefixed
+	// payload.
+	if got, expected := EncodeSyncStep1([]byte{1, 2}), []byte{0, 0, 2, 1, 2}; !bytes.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	msg := EncodeSyncStep2(update)
+	for _, data := range [][]byte{nil, msg[:1], msg[:2], msg[:len(msg)-1], {1, 0}, {0, 7, 0}} {
+		if _, err := DecodeSyncMessage(data); !errors.Is(err, ErrInvalidSyncMessage) {
+			t.Errorf("DecodeSyncMessage(%v): expected ErrInvalidSyncMessage, got %v", data, err)
+		}
+	}
+}
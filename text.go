@@ -0,0 +1,233 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// TextEdit is a single edit applied to a YText by ApplyTextDelta: Delete characters
+// are removed starting at Index, then Insert is inserted at Index. Offsets are counted
+// in UTF-8 bytes, the unit Yrs uses for documents created by NewDoc, and must fall on
+// character boundaries; use ApplyTextDeltaUTF16 for offsets coming from JavaScript.
+type TextEdit struct {
+	Index  int
+	Delete int
+	Insert string
+}
+
+// resolveText navigates to the YText at path. The returned outputs back the branch
+// pointer and must be destroyed by the caller once the branch is no longer used.
+func resolveText(txn *C.YTransaction, rootBranch *C.Branch, path string) (*C.Branch, []*C.YOutput, error) {
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pathSegments) == 0 {
+		return nil, nil, errors.New("the document root is not a text")
+	}
+	branch, outputs, err := resolveBranch(txn, rootBranch, pathSegments)
+	if err != nil {
+		return nil, nil, err
+	}
+	if kind := C.ytype_kind(branch); kind != C.Y_TEXT {
+		destroyOutputs(outputs)
+		return nil, nil, fmt.Errorf("value at '%s' is not a text (kind %d)", path, kind)
+	}
+	return branch, outputs, nil
+}
+
+// SetText stores text at path as a collaborative YText, replacing any existing value.
+// Unlike plain string values, a YText can then be edited incrementally with
+// ApplyTextDelta so that concurrent character edits from several peers merge. ToJSON
+// renders it as a plain string.
+func (d *Doc) SetText(path string, text string) error {
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		pathSegments, err := splitPath(path)
+		if err != nil {
+			return fmt.Errorf("SetText %s: %w", path, err)
+		}
+		parent, keyOrIndex, outputs, err := navigateToParent(txn, rootBranch, pathSegments)
+		if err != nil {
+			return fmt.Errorf("SetText %s: navigation failed: %w", path, err)
+		}
+		defer destroyOutputs(outputs)
+
+		if strings.IndexByte(text, 0) >= 0 {
+			return fmt.Errorf("SetText %s: text contains an embedded NUL byte, which cannot be stored", path)
+		}
+		textC := C.CString(text)
+		if textC == nil {
+			return fmt.Errorf("SetText %s: failed to allocate C string for text", path)
+		}
+		defer C.free(unsafe.Pointer(textC))
+
+		input := C.yinput_ytext(textC)
+		if err := setAt(txn, parent, keyOrIndex, &input); err != nil {
+			return fmt.Errorf("SetText %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// ApplyTextDelta applies edits, in order, to the YText at path within one transaction.
+// Each edit's Index is interpreted against the text as left by the previous edits.
+func (d *Doc) ApplyTextDelta(path string, edits []TextEdit) error {
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		text, outputs, err := resolveText(txn, rootBranch, path)
+		if err != nil {
+			return fmt.Errorf("ApplyTextDelta %s: %w", path, err)
+		}
+		defer destroyOutputs(outputs)
+
+		for i, edit := range edits {
+			if err := applyTextEdit(txn, text, edit); err != nil {
+				return fmt.Errorf("ApplyTextDelta %s: edit %d: %w", path, i, err)
+			}
+		}
+		return nil
+	})
+}
+
+// TextLenUTF16 returns the length of the YText at path in UTF-16 code units, the unit
+// JavaScript strings and browser selections use.
+func (d *Doc) TextLenUTF16(path string) (int, error) {
+	n := 0
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		text, outputs, err := resolveText(txn, rootBranch, path)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+
+		n = len(utf16.Encode([]rune(textString(txn, text))))
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("TextLenUTF16 %s: %w", path, err)
+	}
+	return n, nil
+}
+
+// ApplyTextDeltaUTF16 is ApplyTextDelta with Index and Delete counted in UTF-16 code
+// units, as sent by JavaScript clients, rather than in UTF-8 bytes. Offsets that fall
+// inside a surrogate pair are rejected.
+func (d *Doc) ApplyTextDeltaUTF16(path string, edits []TextEdit) error {
+	return d.write(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		text, outputs, err := resolveText(txn, rootBranch, path)
+		if err != nil {
+			return fmt.Errorf("ApplyTextDeltaUTF16 %s: %w", path, err)
+		}
+		defer destroyOutputs(outputs)
+
+		for i, edit := range edits {
+			current := textString(txn, text)
+			start, err := utf16ToByteOffset(current, edit.Index)
+			if err != nil {
+				return fmt.Errorf("ApplyTextDeltaUTF16 %s: edit %d: index: %w", path, i, err)
+			}
+			end, err := utf16ToByteOffset(current[start:], edit.Delete)
+			if err != nil {
+				return fmt.Errorf("ApplyTextDeltaUTF16 %s: edit %d: delete: %w", path, i, err)
+			}
+			byteEdit := TextEdit{Index: start, Delete: end, Insert: edit.Insert}
+			if err := applyTextEdit(txn, text, byteEdit); err != nil {
+				return fmt.Errorf("ApplyTextDeltaUTF16 %s: edit %d: %w", path, i, err)
+			}
+		}
+		return nil
+	})
+}
+
+// textString returns the current content of text.
+func textString(txn *C.YTransaction, text *C.Branch) string {
+	s := C.ytext_string(text, txn)
+	if s == nil {
+		return ""
+	}
+	defer C.ystring_destroy(s)
+	return C.GoString(s)
+}
+
+// utf16ToByteOffset converts an offset of n UTF-16 code units into s to a byte offset.
+func utf16ToByteOffset(s string, n int) (int, error) {
+	if n < 0 {
+		return 0, fmt.Errorf("offset %d is negative", n)
+	}
+	units := 0
+	for i, r := range s {
+		if units == n {
+			return i, nil
+		}
+		if units > n {
+			return 0, fmt.Errorf("offset %d splits a surrogate pair", n)
+		}
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+	}
+	if units == n {
+		return len(s), nil
+	}
+	if units > n {
+		return 0, fmt.Errorf("offset %d splits a surrogate pair", n)
+	}
+	return 0, fmt.Errorf("offset %d out of bounds for text (UTF-16 len %d)", n, units)
+}
+
+// runeBoundary reports whether byte offset i of s falls between characters.
+func runeBoundary(s string, i int) bool {
+	return i == len(s) || utf8.RuneStart(s[i])
+}
+
+// applyTextEdit performs a single TextEdit, validating its bounds first since Yrs
+// panics on out-of-range text offsets.
+func applyTextEdit(txn *C.YTransaction, text *C.Branch, edit TextEdit) error {
+	if strings.IndexByte(edit.Insert, 0) >= 0 {
+		return errors.New("inserted text contains an embedded NUL byte, which cannot be stored")
+	}
+	textLen := int(C.ytext_len(text, txn))
+	if edit.Index < 0 || edit.Index > textLen {
+		return fmt.Errorf("index %d out of bounds for text (len %d)", edit.Index, textLen)
+	}
+	// Index is in range, so this can't overflow as Index+Delete could.
+	if edit.Delete < 0 || edit.Delete > textLen-edit.Index {
+		return fmt.Errorf("delete of %d at index %d out of bounds for text (len %d)", edit.Delete, edit.Index, textLen)
+	}
+	// Yrs does not check that byte offsets fall between characters, so an offset inside
+	// a multi-byte character would split it.
+	current := textString(txn, text)
+	if !runeBoundary(current, edit.Index) {
+		return fmt.Errorf("index %d splits a multi-byte character", edit.Index)
+	}
+	if !runeBoundary(current, edit.Index+edit.Delete) {
+		return fmt.Errorf("delete of %d at index %d splits a multi-byte character", edit.Delete, edit.Index)
+	}
+
+	if edit.Delete > 0 {
+		tracef("ytext_remove_range(%p, %d, %d)", text, edit.Index, edit.Delete)
+		C.ytext_remove_range(text, txn, C.uint32_t(edit.Index), C.uint32_t(edit.Delete))
+	}
+	if edit.Insert != "" {
+		insertC := C.CString(edit.Insert)
+		if insertC == nil {
+			return errors.New("failed to allocate C string for inserted text")
+		}
+		defer C.free(unsafe.Pointer(insertC))
+		tracef("ytext_insert(%p, %d, %q)", text, edit.Index, edit.Insert)
+		C.ytext_insert(text, txn, C.uint32_t(edit.Index), insertC, nil)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"math"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestApplyTextDeltaMergesConcurrentEdits(t *testing.T) {
+	docA := NewDoc()
+	defer docA.Destroy()
+	if err := docA.SetText("/body", "hello world"); err != nil {
+		t.Fatalf("SetText failed: %v", err)
+	}
+
+	update, err := docA.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	docB, err := NewDocFromStateVector(update)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer docB.Destroy()
+
+	// Concurrent edits at different positions of the same text.
+	if err := docA.ApplyTextDelta("/body", []TextEdit{{Index: 0, Delete: 5, Insert: "howdy"}}); err != nil {
+		t.Fatalf("ApplyTextDelta on A failed: %v", err)
+	}
+	if err := docB.ApplyTextDelta("/body", []TextEdit{{Index: 11, Insert: "!"}}); err != nil {
+		t.Fatalf("ApplyTextDelta on B failed: %v", err)
+	}
+
+	updateA, _ := docA.GetStateVector()
+	updateB, _ := docB.GetStateVector()
+	if err := docA.ApplyStateVector(updateB); err != nil {
+		t.Fatalf("applying B to A failed: %v", err)
+	}
+	if err := docB.ApplyStateVector(updateA); err != nil {
+		t.Fatalf("applying A to B failed: %v", err)
+	}
+
+	for name, doc := range map[string]*Doc{"A": docA, "B": docB} {
+		state, err := doc.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON on %s failed: %v", name, err)
+		}
+		if state["body"] != "howdy world!" {
+			t.Errorf("doc %s: expected merged text %q, got %v", name, "howdy world!", state["body"])
+		}
+	}
+}
+
+func TestApplyTextDeltaOutOfBounds(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	if err := doc.SetText("/body", "abc"); err != nil {
+		t.Fatalf("SetText failed: %v", err)
+	}
+	if err := doc.ApplyTextDelta("/body", []TextEdit{{Index: 4, Insert: "x"}}); err == nil {
+		t.Error("expected an error inserting past the end of the text")
+	}
+	if err := doc.ApplyTextDelta("/body", []TextEdit{{Index: 2, Delete: 2}}); err == nil {
+		t.Error("expected an error deleting past the end of the text")
+	}
+	for _, edit := range []TextEdit{{Index: 1, Delete: math.MaxInt}, {Index: math.MaxInt, Insert: "x"}} {
+		if err := doc.ApplyTextDelta("/body", []TextEdit{edit}); err == nil {
+			t.Errorf("expected an error for %+v", edit)
+		}
+	}
+}
+
+func TestApplyTextDeltaMidRune(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	if err := doc.SetText("/body", "héllo"); err != nil {
+		t.Fatalf("SetText failed: %v", err)
+	}
+	// "é" occupies bytes 1 and 2.
+	for _, edit := range []TextEdit{
+		{Index: 2, Insert: "X"},
+		{Index: 1, Delete: 1},
+		{Index: 2, Delete: 1},
+	} {
+		if err := doc.ApplyTextDelta("/body", []TextEdit{edit}); err == nil {
+			t.Errorf("expected an error for %+v splitting a character", edit)
+		}
+	}
+	if err := doc.ApplyTextDelta("/body", []TextEdit{{Index: 1, Delete: 2, Insert: "e"}}); err != nil {
+		t.Fatalf("ApplyTextDelta failed: %v", err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if state["body"] != "hello" {
+		t.Errorf("expected %q, got %q", "hello", state["body"])
+	}
+}
+
+func TestReplaceTextWithScalarAndBack(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	if err := doc.SetText("/body", "draft"); err != nil {
+		t.Fatalf("SetText failed: %v", err)
+	}
+	update, err := doc.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	peer, err := NewDocFromStateVector(update)
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+
+	// Replace the text with a plain value while the peer keeps editing the old text.
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{{Operation: "replace", Path: "/body", Value: 42.0}})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if err := doc.ApplyOperations(patch); err != nil {
+		t.Fatalf("ApplyOperations replace failed: %v", err)
+	}
+	if err := doc.ApplyTextDelta("/body", []TextEdit{{Index: 0, Insert: "x"}}); err == nil {
+		t.Error("expected ApplyTextDelta to fail once the text has been replaced")
+	}
+	if err := peer.ApplyTextDelta("/body", []TextEdit{{Index: 5, Insert: "!"}}); err != nil {
+		t.Fatalf("ApplyTextDelta on peer failed: %v", err)
+	}
+
+	syncDocs(t, doc, peer)
+	for name, d := range map[string]*Doc{"doc": doc, "peer": peer} {
+		state, err := d.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON on %s failed: %v", name, err)
+		}
+		if state["body"] != 42.0 {
+			t.Errorf("%s: expected the replacement to win over edits to the removed text, got %v", name, state["body"])
+		}
+	}
+
+	// And back: a scalar replaced by a new text is editable again on both sides.
+	if err := doc.SetText("/body", "final"); err != nil {
+		t.Fatalf("SetText over a scalar failed: %v", err)
+	}
+	syncDocs(t, doc, peer)
+	if err := peer.ApplyTextDelta("/body", []TextEdit{{Index: 5, Insert: "!"}}); err != nil {
+		t.Fatalf("ApplyTextDelta on the new text failed: %v", err)
+	}
+	syncDocs(t, doc, peer)
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if state["body"] != "final!" {
+		t.Errorf("expected %q, got %v", "final!", state["body"])
+	}
+}
+
+// syncDocs exchanges full updates between a and b so both hold the merged state.
+func syncDocs(t *testing.T, a, b *Doc) {
+	t.Helper()
+	updateA := mustEncodeFull(t, a)
+	updateB := mustEncodeFull(t, b)
+	if err := a.ApplyStateVector(updateB); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+	if err := b.ApplyStateVector(updateA); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+}
+
+func TestApplyTextDeltaUTF16(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	if err := doc.SetText("/t", "a😀é"); err != nil {
+		t.Fatalf("SetText failed: %v", err)
+	}
+
+	n, err := doc.TextLenUTF16("/t")
+	if err != nil {
+		t.Fatalf("TextLenUTF16 failed: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("expected a UTF-16 length of 4, got %d", n)
+	}
+
+	// Replace the emoji (two code units) and insert after "é", as a browser would report.
+	edits := []TextEdit{{Index: 1, Delete: 2, Insert: "b"}, {Index: 3, Insert: "!"}}
+	if err := doc.ApplyTextDeltaUTF16("/t", edits); err != nil {
+		t.Fatalf("ApplyTextDeltaUTF16 failed: %v", err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if state["t"] != "abé!" {
+		t.Errorf("expected %q, got %q", "abé!", state["t"])
+	}
+
+	if err := doc.SetText("/t", "😀"); err != nil {
+		t.Fatalf("SetText failed: %v", err)
+	}
+	if err := doc.ApplyTextDeltaUTF16("/t", []TextEdit{{Index: 1, Insert: "x"}}); err == nil {
+		t.Error("expected an error for an index inside a surrogate pair")
+	}
+	if err := doc.ApplyTextDeltaUTF16("/t", []TextEdit{{Index: 3}}); err == nil {
+		t.Error("expected an error for an index past the end")
+	}
+}
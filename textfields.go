@@ -0,0 +1,122 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// RegisterTextField marks the map value at path, a JSON Pointer, as a collaborative text
+// field. Strings stored there through ApplyOperations (and so UpdateToState) are kept
+// in a YText, as SetText does, whether the operation addresses path itself or stores a
+// larger value containing it. Once the field holds a YText, "add" and "replace"
+// operations on path apply only the changed range to it, so concurrent edits by several
+// peers merge character by character rather than the last writer winning. ToJSON still
+// renders the field as a plain string. Every peer editing the field should register it.
+func (d *Doc) RegisterTextField(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.textFields == nil {
+		d.textFields = make(map[string]bool)
+	}
+	d.textFields[path] = true
+}
+
+// editTextField applies op as a text edit if it stores a string in a registered text
+// field that already holds a YText, and reports whether it did. The caller must hold
+// d.mu for writing.
+func (d *Doc) editTextField(txn *C.YTransaction, rootBranch *C.Branch, op jsonpatch.JSONPatch) (bool, error) {
+	text, isString := op.Value.(string)
+	if !d.textFields[op.Path] || !isString || (op.Operation != "add" && op.Operation != "replace") {
+		return false, nil
+	}
+	_, _, value, outputs := textFieldAt(txn, rootBranch, op.Path)
+	defer destroyOutputs(outputs)
+	if value == nil || value.tag != C.Y_TEXT {
+		return false, nil
+	}
+	branch := C.youtput_read_ytext(value)
+	if err := applyTextEdit(txn, branch, textEditFor(textString(txn, branch), text)); err != nil {
+		return true, fmt.Errorf("operation (%s %s): %w", op.Operation, op.Path, err)
+	}
+	return true, nil
+}
+
+// promoteTextFields replaces the plain strings in registered text fields at or below
+// path with YTexts holding the same text. The caller must hold d.mu for writing.
+func (d *Doc) promoteTextFields(txn *C.YTransaction, rootBranch *C.Branch, path string) {
+	for field := range d.textFields {
+		if field != path && !strings.HasPrefix(field, path+"/") {
+			continue
+		}
+		parent, key, value, outputs := textFieldAt(txn, rootBranch, field)
+		if value != nil && value.tag == C.Y_JSON_STR {
+			keyC := C.CString(key)
+			input := C.yinput_ytext(C.youtput_read_string(value))
+			tracef("ymap_insert(%p, %q)", parent, key)
+			C.ymap_insert(parent, txn, keyC, &input)
+			C.free(unsafe.Pointer(keyC))
+		}
+		destroyOutputs(outputs)
+	}
+}
+
+// textFieldAt looks up the text field at path, which must be a key in a map, returning
+// the map, the key and its value, or a nil value if there is none. The outputs back the
+// returned pointers and must be destroyed by the caller.
+func textFieldAt(txn *C.YTransaction, rootBranch *C.Branch, path string) (*C.Branch, string, *C.YOutput, []*C.YOutput) {
+	pathSegments, err := splitPath(path)
+	if err != nil || len(pathSegments) == 0 {
+		return nil, "", nil, nil
+	}
+	parent, keyOrIndex, outputs, err := navigateToParent(txn, rootBranch, pathSegments)
+	if err != nil {
+		return nil, "", nil, nil
+	}
+	key, ok := keyOrIndex.(string)
+	if !ok || C.ytype_kind(parent) != C.Y_MAP {
+		return nil, "", nil, outputs
+	}
+	keyC := C.CString(key)
+	defer C.free(unsafe.Pointer(keyC))
+	value := C.ymap_get(parent, txn, keyC)
+	if value != nil {
+		outputs = append(outputs, value)
+	}
+	return parent, key, value, outputs
+}
+
+// textEditFor returns the single edit turning from into to, replacing everything between
+// their common prefix and common suffix. Offsets are in bytes and never split a UTF-8
+// sequence.
+func textEditFor(from, to string) TextEdit {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	for prefix > 0 && prefix < len(from) && !utf8.RuneStart(from[prefix]) {
+		prefix--
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	for suffix > 0 && !utf8.RuneStart(from[len(from)-suffix]) {
+		suffix--
+	}
+	return TextEdit{
+		Index:  prefix,
+		Delete: len(from) - prefix - suffix,
+		Insert: to[prefix : len(to)-suffix],
+	}
+}
@@ -0,0 +1,62 @@
+//go:build cgo
+
+package autosync
+
+import "testing"
+
+func TestTextEditFor(t *testing.T) {
+	testCases := []struct {
+		from, to string
+		want     TextEdit
+	}{
+		{"", "abc", TextEdit{Index: 0, Delete: 0, Insert: "abc"}},
+		{"abc", "", TextEdit{Index: 0, Delete: 3, Insert: ""}},
+		{"hello world", "hello brave world", TextEdit{Index: 6, Delete: 0, Insert: "brave "}},
+		{"aaa", "aa", TextEdit{Index: 2, Delete: 1, Insert: ""}},
+		{"same", "same", TextEdit{Index: 4, Delete: 0, Insert: ""}},
+		// é (c3 a9) and è (c3 a8) share their first byte, which must not be split.
+		{"café", "cafè", TextEdit{Index: 3, Delete: 2, Insert: "è"}},
+		// ą (c4 85) and Ņ (c5 85) share their last byte.
+		{"xąy", "xŅy", TextEdit{Index: 1, Delete: 2, Insert: "Ņ"}},
+	}
+	for _, tc := range testCases {
+		if got := textEditFor(tc.from, tc.to); got != tc.want {
+			t.Errorf("textEditFor(%q, %q) = %+v, expected %+v", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestRegisterTextFieldMergesConcurrentEdits(t *testing.T) {
+	a := NewDoc()
+	defer a.Destroy()
+	b := NewDoc()
+	defer b.Destroy()
+	a.RegisterTextField("/body")
+	b.RegisterTextField("/body")
+
+	if _, err := a.UpdateToState(map[string]interface{}{"body": "hello world"}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if kind, err := a.KindAt("/body"); err != nil || kind != KindText {
+		t.Fatalf("expected /body to be stored as text, got %v (err %v)", kind, err)
+	}
+	syncDocs(t, a, b)
+
+	if _, err := a.UpdateToState(map[string]interface{}{"body": "hello brave world"}); err != nil {
+		t.Fatalf("UpdateToState on a failed: %v", err)
+	}
+	if _, err := b.UpdateToState(map[string]interface{}{"body": "hello world!"}); err != nil {
+		t.Fatalf("UpdateToState on b failed: %v", err)
+	}
+	syncDocs(t, a, b)
+
+	for name, doc := range map[string]*Doc{"a": a, "b": b} {
+		got, err := doc.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON on %s failed: %v", name, err)
+		}
+		if expected := map[string]interface{}{"body": "hello brave world!"}; !compareMaps(got, expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+	}
+}
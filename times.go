@@ -0,0 +1,70 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"fmt"
+	"time"
+)
+
+// TimeEncoding selects how a Doc stores time.Time values, which Yrs has no type for.
+type TimeEncoding int32
+
+const (
+	// TimeRFC3339 stores a time.Time as an RFC 3339 string with as many fraction digits
+	// as it needs, keeping its UTC offset. It is the default.
+	TimeRFC3339 TimeEncoding = iota
+	// TimeUnixMillis stores a time.Time as an int64 count of milliseconds since the Unix
+	// epoch. Finer precision and the location are dropped.
+	TimeUnixMillis
+)
+
+// SetTimeEncoding sets how time.Time values written to the document from now on are
+// stored. Times already stored are left as they are. Either way ToJSON reads them back
+// as what was stored, a string or an int64; GetTime restores a time.Time.
+func (d *Doc) SetTimeEncoding(enc TimeEncoding) {
+	d.timeEncoding.Store(int32(enc))
+}
+
+// GetTime reads the time.Time stored at path, as either encoding of SetTimeEncoding. A
+// time stored as Unix milliseconds comes back in the local time zone.
+func (d *Doc) GetTime(path string) (time.Time, error) {
+	var t time.Time
+	err := d.readOutput(path, func(txn *C.YTransaction, output *C.YOutput) error {
+		value, err := outputValue(txn, output, ReadOptions{})
+		if err != nil {
+			return err
+		}
+		switch v := value.(type) {
+		case string:
+			t, err = time.Parse(time.RFC3339Nano, v)
+			return err
+		case int64:
+			t = time.UnixMilli(v)
+			return nil
+		}
+		return fmt.Errorf("value is %T, not a time", value)
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("GetTime %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// timeString returns the RFC 3339 string a time.Time (or a non-nil pointer to one) is
+// stored as by default. ok is false for any other value.
+func timeString(value interface{}) (s string, ok bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano), true
+	case *time.Time:
+		if v != nil {
+			return v.Format(time.RFC3339Nano), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,86 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeValues(t *testing.T) {
+	times := map[string]time.Time{
+		"zero":  {},
+		"utc":   time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC),
+		"local": time.Date(2024, 3, 1, 18, 0, 45, 500000000, time.FixedZone("IST", 5*3600+1800)),
+	}
+	state := map[string]interface{}{}
+	for key, tm := range times {
+		state[key] = tm
+	}
+	state["list"] = []interface{}{times["utc"]}
+
+	doc := NewDoc()
+	defer doc.Destroy()
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	got, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	for key, tm := range times {
+		if got[key] != tm.Format(time.RFC3339Nano) {
+			t.Errorf("%s: expected the RFC 3339 string %q, got %v", key, tm.Format(time.RFC3339Nano), got[key])
+		}
+		back, err := doc.GetTime("/" + key)
+		if err != nil {
+			t.Fatalf("GetTime failed: %v", err)
+		}
+		if !back.Equal(tm) || back.Format(time.RFC3339Nano) != tm.Format(time.RFC3339Nano) {
+			t.Errorf("%s: expected %v, got %v", key, tm, back)
+		}
+	}
+	// The stored strings match, so applying the same state again changes nothing.
+	patch, err := doc.UpdateToState(state)
+	if err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if !patch.Empty() {
+		t.Errorf("expected no changes, got %v", patch.List())
+	}
+
+	doc.SetTimeEncoding(TimeUnixMillis)
+	local := times["local"]
+	if err := doc.Set("/local", &local); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := doc.UpdateToState(state); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	got, err = doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	for key, tm := range times {
+		if got[key] != tm.UnixMilli() {
+			t.Errorf("%s: expected %d milliseconds, got %v", key, tm.UnixMilli(), got[key])
+		}
+		back, err := doc.GetTime("/" + key)
+		if err != nil {
+			t.Fatalf("GetTime failed: %v", err)
+		}
+		if !back.Equal(tm.Truncate(time.Millisecond)) {
+			t.Errorf("%s: expected %v, got %v", key, tm.Truncate(time.Millisecond), back)
+		}
+	}
+	if list := got["list"].([]interface{}); list[0] != times["utc"].UnixMilli() {
+		t.Errorf("expected the time in the array as milliseconds, got %v", list[0])
+	}
+	patch, err = doc.UpdateToState(state)
+	if err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if !patch.Empty() {
+		t.Errorf("expected no changes, got %v", patch.List())
+	}
+}
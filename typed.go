@@ -0,0 +1,153 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// ErrDuplicateJSONKey is returned by Encode for a struct with several fields that
+// encoding/json would write under the same key, in which case it silently drops them all.
+var ErrDuplicateJSONKey = errors.New("struct fields share a JSON key")
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// Decode decodes the current state of the document into v, which must be a non-nil
+// pointer, using encoding/json, so struct tags and field matching follow its usual
+// rules. It is the non-generic form of ReadInto.
+func (d *Doc) Decode(v interface{}) error {
+	raw, err := d.ToJSONBytes()
+	if err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("Decode: failed to unmarshal JSON from YDoc into %T: %w", v, err)
+	}
+	return nil
+}
+
+// Encode updates the document to match v, a struct or map that encodes to a JSON object
+// with encoding/json, following its struct tags, and returns the applied patch as
+// UpdateToState does. Integers are kept exact rather than passing through float64. A
+// struct with several fields encoded under the same key, which encoding/json would
+// silently drop, fails with an error wrapping ErrDuplicateJSONKey that names them.
+func (d *Doc) Encode(v interface{}) (jsonpatch.JSONPatchList, error) {
+	if err := checkJSONKeys(reflect.TypeOf(v), map[reflect.Type]bool{}); err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("Encode: %w", err)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("Encode: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var state map[string]interface{}
+	if err := decoder.Decode(&state); err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("Encode: %T must encode to a JSON object: %w", v, err)
+	}
+	if state == nil {
+		return jsonpatch.JSONPatchList{}, errors.New("Encode: value must encode to a JSON object")
+	}
+	newState, _ := resolveNumbers(state).(map[string]interface{})
+	patch, err := d.UpdateToState(newState)
+	if err != nil {
+		return jsonpatch.JSONPatchList{}, fmt.Errorf("Encode: %w", err)
+	}
+	return patch, nil
+}
+
+// checkJSONKeys reports the first struct type reachable from t that has several fields
+// encoding/json would encode under the same key: fields at the shallowest embedding depth
+// for the key, unless exactly one of them is tagged, which then wins.
+func checkJSONKeys(t reflect.Type, seen map[reflect.Type]bool) error {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	seen[t] = true
+	if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return nil
+	}
+
+	fields := jsonFields(t)
+	byName := make(map[string][]jsonField)
+	var names []string
+	for _, field := range fields {
+		if _, ok := byName[field.name]; !ok {
+			names = append(names, field.name)
+		}
+		byName[field.name] = append(byName[field.name], field)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var shallowest []jsonField
+		tagged := 0
+		for _, field := range byName[name] {
+			if len(shallowest) > 0 && field.depth > shallowest[0].depth {
+				continue
+			}
+			if len(shallowest) > 0 && field.depth < shallowest[0].depth {
+				shallowest, tagged = nil, 0
+			}
+			shallowest = append(shallowest, field)
+			if field.tagged {
+				tagged++
+			}
+		}
+		if len(shallowest) < 2 || tagged == 1 {
+			continue
+		}
+		goNames := make([]string, 0, len(shallowest))
+		for _, field := range shallowest {
+			if tagged == 0 || field.tagged {
+				goNames = append(goNames, field.goName)
+			}
+		}
+		last := len(goNames) - 1
+		list, quantifier := strings.Join(goNames[:last], ", ")+" and "+goNames[last], "both"
+		if len(goNames) > 2 {
+			quantifier = "all"
+		}
+		return fmt.Errorf("%w: fields %s of %s %s map to %q", ErrDuplicateJSONKey, list, t, quantifier, name)
+	}
+
+	for _, field := range fields {
+		if err := checkJSONKeys(field.typ, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveNumbers replaces, in place, the json.Number values in value with int64 where
+// they are integers that fit and float64 otherwise.
+func resolveNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = resolveNumbers(elem)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = resolveNumbers(elem)
+		}
+	}
+	return value
+}
@@ -0,0 +1,135 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type typedProfile struct {
+	Name    string            `json:"name"`
+	Visits  int64             `json:"visits"`
+	Score   float64           `json:"score"`
+	Tags    []string          `json:"tags"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	private string
+}
+
+func TestEncodeDecode(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	in := typedProfile{
+		Name:    "kim",
+		Visits:  1<<53 + 1,
+		Score:   2.5,
+		Tags:    []string{"a", "b"},
+		private: "not stored",
+	}
+	if _, err := doc.Encode(in); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if state["visits"] != int64(1<<53+1) {
+		t.Errorf("expected visits to be stored exactly, got %#v", state["visits"])
+	}
+	if _, ok := state["labels"]; ok {
+		t.Errorf("expected struct tags to be respected, got %v", state)
+	}
+
+	var out typedProfile
+	if err := doc.Decode(&out); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	in.private = ""
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("expected %+v, got %+v", in, out)
+	}
+
+	// Encoding the same value again changes nothing.
+	patch, err := doc.Encode(in)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !patch.Empty() {
+		t.Errorf("expected no changes, got %v", patch.List())
+	}
+
+	if _, err := doc.Encode([]int{1}); err == nil {
+		t.Error("expected an error encoding a value that isn't a JSON object")
+	}
+	if err := doc.Decode(out); err == nil {
+		t.Error("expected an error decoding into a non-pointer")
+	}
+}
+
+// typedContact and typedAccount both promote a field encoded as "Email" into
+// typedUser.
+type typedContact struct {
+	Email string
+}
+
+type typedAccount struct {
+	Email string
+	Admin bool `json:"admin"`
+}
+
+type typedUser struct {
+	typedContact
+	typedAccount
+	Name string `json:"name"`
+}
+
+// typedOverride's own Email field hides the promoted one, as encoding/json intends.
+type typedOverride struct {
+	typedContact
+	Email string
+}
+
+// typedLogin promotes a third "Email" into typedMember, next to those of typedContact
+// and typedAccount.
+type typedLogin struct {
+	Email string
+}
+
+type typedMember struct {
+	typedContact
+	typedAccount
+	typedLogin
+}
+
+type typedTeam struct {
+	Members []typedUser `json:"members"`
+}
+
+func TestEncodeDuplicateKeys(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	_, err := doc.Encode(typedTeam{Members: []typedUser{{Name: "kim"}}})
+	if !errors.Is(err, ErrDuplicateJSONKey) {
+		t.Fatalf("expected ErrDuplicateJSONKey, got %v", err)
+	}
+	want := `Encode: struct fields share a JSON key: fields typedContact.Email and typedAccount.Email of autosync.typedUser both map to "Email"`
+	if err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+
+	_, err = doc.Encode(typedMember{})
+	want = `Encode: struct fields share a JSON key: fields typedContact.Email, typedAccount.Email and typedLogin.Email of autosync.typedMember all map to "Email"`
+	if err == nil || err.Error() != want {
+		t.Errorf("expected %q, got %v", want, err)
+	}
+	if state, err := doc.ToJSON(); err != nil || len(state) != 0 {
+		t.Errorf("expected nothing to be stored, got %v (err %v)", state, err)
+	}
+
+	if _, err := doc.Encode(typedOverride{Email: "a@b.c"}); err != nil {
+		t.Errorf("expected a shallower field to take the key, got %v", err)
+	}
+}
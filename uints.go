@@ -0,0 +1,54 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"fmt"
+	"strconv"
+)
+
+// LargeUintEncoding selects how a Doc stores uint64 values above math.MaxInt64, which
+// don't fit the signed 64-bit integers of Yrs.
+type LargeUintEncoding int32
+
+const (
+	// LargeUintError rejects such values with an error. It is the default.
+	LargeUintError LargeUintEncoding = iota
+	// LargeUintSigned stores the int64 with the same bits, so the value reads back from
+	// ToJSON as a negative number. Smaller values sort and compare differently from it.
+	LargeUintSigned
+	// LargeUintString stores the value's decimal string.
+	LargeUintString
+)
+
+// SetLargeUintEncoding sets how uint64 values above math.MaxInt64 written to the
+// document from now on are stored. Values up to math.MaxInt64 are always stored as
+// integers. GetUint64 reads either encoding back.
+func (d *Doc) SetLargeUintEncoding(enc LargeUintEncoding) {
+	d.largeUints.Store(int32(enc))
+}
+
+// GetUint64 reads the uint64 stored at path. An integer is read back with its bits
+// reinterpreted, so a value stored as LargeUintSigned comes back as it was written; a
+// string is parsed as a decimal, as stored by LargeUintString.
+func (d *Doc) GetUint64(path string) (uint64, error) {
+	var value uint64
+	err := d.readOutput(path, func(txn *C.YTransaction, output *C.YOutput) error {
+		if output.tag == C.Y_JSON_STR {
+			var err error
+			value, err = strconv.ParseUint(C.GoString(C.youtput_read_string(output)), 10, 64)
+			return err
+		}
+		n, err := outputInt64(output)
+		value = uint64(n)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("GetUint64 %s: %w", path, err)
+	}
+	return value, nil
+}
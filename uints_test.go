@@ -0,0 +1,54 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLargeUintEncoding(t *testing.T) {
+	const fingerprint = uint64(0xFFFFFFFFFFFFFFFF)
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if err := doc.Set("/hash", fingerprint); err == nil {
+		t.Fatal("expected an error storing a uint64 above MaxInt64 by default")
+	}
+
+	for _, tc := range []struct {
+		enc    LargeUintEncoding
+		stored interface{}
+	}{
+		{LargeUintSigned, int64(-1)},
+		{LargeUintString, "18446744073709551615"},
+	} {
+		doc.SetLargeUintEncoding(tc.enc)
+		state := map[string]interface{}{
+			"hash":   fingerprint,
+			"hashes": []interface{}{uint64(math.MaxInt64 + 1), uint64(7)},
+		}
+		if _, err := doc.UpdateToState(state); err != nil {
+			t.Fatalf("UpdateToState failed: %v", err)
+		}
+		got, err := doc.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON failed: %v", err)
+		}
+		if got["hash"] != tc.stored {
+			t.Errorf("encoding %d: expected %#v to be stored, got %#v", tc.enc, tc.stored, got["hash"])
+		}
+		if hashes := got["hashes"].([]interface{}); hashes[1] != int64(7) {
+			t.Errorf("encoding %d: expected a small uint64 to stay an integer, got %#v", tc.enc, hashes[1])
+		}
+		for path, want := range map[string]uint64{"/hash": fingerprint, "/hashes/0": math.MaxInt64 + 1, "/hashes/1": 7} {
+			value, err := doc.GetUint64(path)
+			if err != nil {
+				t.Fatalf("GetUint64 %s failed: %v", path, err)
+			}
+			if value != want {
+				t.Errorf("encoding %d: %s: expected %d, got %d", tc.enc, path, want, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// ErrNothingToUndo is returned by Undo when the undo stack is empty.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// ApplyOperationsUndoable applies patchList like ApplyOperations and, on success, pushes
+// its inverse onto the Doc's undo stack for Undo. The inverse is computed by diffing the
+// document state from before and after the patch, so it restores exactly the previous
+// JSON state rather than replaying per-operation inverses. Numbers are restored as read
+// by ToJSON, so integers stay int64 and floats float64.
+func (d *Doc) ApplyOperationsUndoable(patchList jsonpatch.JSONPatchList) error {
+	before, err := d.ToJSON()
+	if err != nil {
+		return fmt.Errorf("ApplyOperationsUndoable: failed to read state: %w", err)
+	}
+	if err := d.ApplyOperations(patchList); err != nil {
+		return err
+	}
+	after, err := d.ToJSON()
+	if err != nil {
+		return fmt.Errorf("ApplyOperationsUndoable: failed to read state: %w", err)
+	}
+	inverse, err := diffStates(before, after)
+	if err != nil {
+		return fmt.Errorf("ApplyOperationsUndoable: failed to compute inverse: %w", err)
+	}
+	d.undoStack = append(d.undoStack, inverse)
+	return nil
+}
+
+// Undo reverts the most recent ApplyOperationsUndoable by applying its inverse, returning
+// ErrNothingToUndo if there is none. The inverse is relative to the state right after
+// that call, so changes made since by other means may be overwritten or make it fail; on
+// failure the inverse stays on the stack.
+func (d *Doc) Undo() error {
+	if len(d.undoStack) == 0 {
+		return ErrNothingToUndo
+	}
+	inverse := d.undoStack[len(d.undoStack)-1]
+	if err := d.ApplyOperations(inverse); err != nil {
+		return fmt.Errorf("Undo: %w", err)
+	}
+	d.undoStack = d.undoStack[:len(d.undoStack)-1]
+	return nil
+}
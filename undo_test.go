@@ -0,0 +1,64 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestUndo(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"title": "v0",
+		"list":  []interface{}{"a", "b"},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	states := []map[string]interface{}{}
+	record := func() {
+		state, err := doc.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON failed: %v", err)
+		}
+		states = append(states, state)
+	}
+	record()
+
+	steps := [][]jsonpatch.JSONPatch{
+		{{Operation: "replace", Path: "/title", Value: "v1"}},
+		{{Operation: "add", Path: "/list/-", Value: "c"}, {Operation: "add", Path: "/extra", Value: map[string]interface{}{"k": 1}}},
+		{{Operation: "remove", Path: "/list/0"}, {Operation: "remove", Path: "/title"}},
+	}
+	for i, ops := range steps {
+		patch, err := NewPatchList(ops)
+		if err != nil {
+			t.Fatalf("NewPatchList failed: %v", err)
+		}
+		if err := doc.ApplyOperationsUndoable(patch); err != nil {
+			t.Fatalf("step %d: ApplyOperationsUndoable failed: %v", i, err)
+		}
+		record()
+	}
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		if err := doc.Undo(); err != nil {
+			t.Fatalf("Undo of step %d failed: %v", i, err)
+		}
+		state, err := doc.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON failed: %v", err)
+		}
+		if !compareMaps(state, states[i]) {
+			t.Errorf("after undoing step %d: expected %v, got %v", i, states[i], state)
+		}
+	}
+
+	if err := doc.Undo(); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("expected ErrNothingToUndo, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUndoManagerDestroyed is returned by the methods of an UndoManager after Destroy.
+var ErrUndoManagerDestroyed = errors.New("undo manager is destroyed")
+
+// remoteOrigin is the transaction origin of updates applied with ApplyUpdate. An
+// UndoManager only records transactions without an origin, which are the local writes.
+const remoteOrigin = "autosync/remote"
+
+// UndoManager undoes and redoes local changes to a set of root types using the Yrs undo
+// manager. Unlike ApplyOperationsUndoable it records every local write to the tracked
+// roots, however it was made, and reverts it at the CRDT level, so undoing a change
+// keeps the changes made by other peers. Updates received with ApplyUpdate are not
+// recorded. Each write is its own undo step. It is safe for concurrent use.
+type UndoManager struct {
+	doc *Doc
+	mgr *C.YUndoManager
+}
+
+// NewUndoManager returns an UndoManager tracking the root types named by trackedRoots,
+// which may include the main root ("root", or the root name of a document loaded with
+// LoadYDoc) and collections defined with DefineMap or DefineArray. With no names it
+// tracks the main root. Only changes made after this call can be undone. Call Destroy
+// when done with it.
+func (d *Doc) NewUndoManager(trackedRoots []string) (*UndoManager, error) {
+	if len(trackedRoots) == 0 {
+		trackedRoots = []string{d.root()}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.yDoc == nil {
+		return nil, errors.New("NewUndoManager: document is destroyed")
+	}
+
+	txn := C.ydoc_read_transaction(d.yDoc)
+	if txn == nil {
+		return nil, fmt.Errorf("NewUndoManager: failed to create read transaction: %w", ErrTransactionInProgress)
+	}
+	branches := make([]*C.Branch, len(trackedRoots))
+	for i, name := range trackedRoots {
+		branches[i] = collectionBranch(txn, name)
+	}
+	commitTransaction(txn)
+	for i, branch := range branches {
+		if branch == nil {
+			return nil, fmt.Errorf("NewUndoManager: %w %q", ErrUnknownCollection, trackedRoots[i])
+		}
+	}
+
+	options := C.YUndoManagerOptions{capture_timeout_millis: 0}
+	mgr := C.yundo_manager(d.yDoc, &options)
+	if mgr == nil {
+		return nil, errors.New("NewUndoManager: yundo_manager returned nil")
+	}
+	for _, branch := range branches {
+		C.yundo_manager_add_scope(mgr, branch)
+	}
+	return &UndoManager{doc: d, mgr: mgr}, nil
+}
+
+// Undo reverts the most recent change to the tracked roots that has not been undone,
+// reporting whether there was one.
+func (m *UndoManager) Undo() (bool, error) {
+	done, err := m.run(func(mgr *C.YUndoManager) C.uint8_t { return C.yundo_manager_undo(mgr) })
+	if err != nil {
+		return false, fmt.Errorf("Undo: %w", err)
+	}
+	return done, nil
+}
+
+// Redo reapplies the change most recently reverted by Undo, reporting whether there was
+// one. Any new change to the tracked roots clears the changes available to Redo.
+func (m *UndoManager) Redo() (bool, error) {
+	done, err := m.run(func(mgr *C.YUndoManager) C.uint8_t { return C.yundo_manager_redo(mgr) })
+	if err != nil {
+		return false, fmt.Errorf("Redo: %w", err)
+	}
+	return done, nil
+}
+
+// run calls fn, which performs an undo or redo in its own write transaction, with the
+// Doc locked for writing, and runs the hooks of a write once it returns.
+func (m *UndoManager) run(fn func(mgr *C.YUndoManager) C.uint8_t) (bool, error) {
+	d := m.doc
+	if d.frozen.Load() {
+		return false, ErrFrozen
+	}
+	defer d.refreshBindings()
+	defer d.checkSizeWatermark()
+	defer d.checkEmptyState()
+	var changes txnChanges
+	defer func() { d.notifyKeyWatchers(changes) }()
+	defer func() { d.notifyObservers(changes) }()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Runs once the transaction below has been committed, before the lock is released.
+	defer func() { changes = d.takeChanges() }()
+	if m.mgr == nil {
+		return false, ErrUndoManagerDestroyed
+	}
+	if d.yDoc == nil {
+		return false, errors.New("document is destroyed")
+	}
+	d.setOrigin(nil)
+	return fn(m.mgr) == C.Y_TRUE, nil
+}
+
+// Destroy frees the underlying Yrs undo manager. It may be called before or after the
+// Doc is destroyed, and more than once.
+func (m *UndoManager) Destroy() {
+	m.doc.mu.Lock()
+	defer m.doc.mu.Unlock()
+	if m.mgr == nil {
+		return
+	}
+	C.yundo_manager_destroy(m.mgr)
+	m.mgr = nil
+}
@@ -0,0 +1,81 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUndoManager(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+	if err := doc.DefineArray("log"); err != nil {
+		t.Fatalf("DefineArray failed: %v", err)
+	}
+	if _, err := doc.NewUndoManager([]string{"missing"}); !errors.Is(err, ErrUnknownCollection) {
+		t.Errorf("expected ErrUnknownCollection, got %v", err)
+	}
+	mgr, err := doc.NewUndoManager([]string{"root", "log"})
+	if err != nil {
+		t.Fatalf("NewUndoManager failed: %v", err)
+	}
+	defer mgr.Destroy()
+
+	if done, err := mgr.Undo(); err != nil || done {
+		t.Errorf("expected nothing to undo, got %v, %v", done, err)
+	}
+	if err := doc.Set("/color", "red"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if done, err := mgr.Undo(); err != nil || !done {
+		t.Fatalf("expected Undo to revert the Set, got %v, %v", done, err)
+	}
+	state, err := doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if _, ok := state["color"]; ok {
+		t.Errorf("expected the key to be gone after Undo, got %v", state)
+	}
+
+	if done, err := mgr.Redo(); err != nil || !done {
+		t.Fatalf("expected Redo to reapply the Set, got %v, %v", done, err)
+	}
+	state, err = doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if state["color"] != "red" {
+		t.Errorf("expected the key to be back after Redo, got %v", state)
+	}
+
+	// Changes received from a peer are not the local user's to undo.
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+	if err := peer.Set("/size", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.ApplyUpdate(mustEncodeFull(t, peer)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	if done, err := mgr.Undo(); err != nil || !done {
+		t.Fatalf("expected Undo to revert the redone Set, got %v, %v", done, err)
+	}
+	state, err = doc.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if _, ok := state["color"]; ok || state["size"] != 2.0 {
+		t.Errorf("expected only the local change to be undone, got %v", state)
+	}
+
+	mgr.Destroy()
+	mgr.Destroy()
+	if _, err := mgr.Undo(); !errors.Is(err, ErrUndoManagerDestroyed) {
+		t.Errorf("expected ErrUndoManagerDestroyed, got %v", err)
+	}
+}
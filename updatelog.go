@@ -0,0 +1,90 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdint.h>
+
+extern void goUpdateLogCallback(void*, uint32_t, char*);
+*/
+import "C"
+import (
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+// ErrUpdateLogDisabled is returned by UpdateLog for documents that never called
+// EnableUpdateLog.
+var ErrUpdateLogDisabled = errors.New("update log is not enabled for this document")
+
+// updateLogs maps the *C.YDoc passed to goUpdateLogCallback back to its Doc, since Go
+// pointers can't be handed to C as callback state.
+var updateLogs sync.Map
+
+// updateLog holds the updates observed on a Doc once EnableUpdateLog has been called.
+type updateLog struct {
+	sub     *C.YSubscription
+	updates [][]byte
+}
+
+// EnableUpdateLog starts retaining every update committed to the document, both local
+// changes and updates applied with ApplyUpdate, so they can be read back with
+// UpdateLog. Updates committed before the call are not captured, so call it right after
+// NewDoc to record the complete history. Calling it again has no effect.
+func (d *Doc) EnableUpdateLog() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.updates != nil {
+		return nil
+	}
+	sub := C.ydoc_observe_updates_v1(d.yDoc, unsafe.Pointer(d.yDoc), (*[0]byte)(C.goUpdateLogCallback))
+	if sub == nil {
+		return errors.New("EnableUpdateLog: ydoc_observe_updates_v1 returned nil")
+	}
+	d.updates = &updateLog{sub: sub}
+	updateLogs.Store(unsafe.Pointer(d.yDoc), d)
+	return nil
+}
+
+// UpdateLog returns the v1 updates committed since EnableUpdateLog, oldest first.
+// Applying them in order to an empty document reproduces the changes made since then.
+func (d *Doc) UpdateLog() ([][]byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.updates == nil {
+		return nil, ErrUpdateLogDisabled
+	}
+	updates := make([][]byte, len(d.updates.updates))
+	copy(updates, d.updates.updates)
+	return updates, nil
+}
+
+// disableUpdateLog stops observing updates. The caller must hold d.mu.
+func (d *Doc) disableUpdateLog() {
+	if d.updates == nil {
+		return
+	}
+	C.yunobserve(d.updates.sub)
+	updateLogs.Delete(unsafe.Pointer(d.yDoc))
+	d.updates = nil
+}
+
+// goUpdateLogCallback is called by Yrs while a transaction commits, which only happens
+// while the committing goroutine holds d.mu for writing.
+//
+//export goUpdateLogCallback
+func goUpdateLogCallback(state unsafe.Pointer, length C.uint32_t, data *C.char) {
+	value, ok := updateLogs.Load(state)
+	if !ok {
+		return
+	}
+	d := value.(*Doc)
+	if d.updates == nil {
+		return
+	}
+	d.updates.updates = append(d.updates.updates, C.GoBytes(unsafe.Pointer(data), C.int(length)))
+}
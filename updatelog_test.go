@@ -0,0 +1,61 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUpdateLog(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	if _, err := doc.UpdateLog(); !errors.Is(err, ErrUpdateLogDisabled) {
+		t.Fatalf("expected ErrUpdateLogDisabled, got %v", err)
+	}
+	if err := doc.EnableUpdateLog(); err != nil {
+		t.Fatalf("EnableUpdateLog failed: %v", err)
+	}
+
+	if _, err := doc.UpdateToState(map[string]interface{}{"a": 1, "list": []interface{}{"x"}}); err != nil {
+		t.Fatalf("UpdateToState failed: %v", err)
+	}
+	if err := doc.Set("/a", 2); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	peer, err := NewDocFromJSON(map[string]interface{}{"remote": true})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer peer.Destroy()
+	remote, err := peer.GetStateVector()
+	if err != nil {
+		t.Fatalf("GetStateVector failed: %v", err)
+	}
+	if err := doc.ApplyStateVector(remote); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+
+	updates, err := doc.UpdateLog()
+	if err != nil {
+		t.Fatalf("UpdateLog failed: %v", err)
+	}
+	if len(updates) != 3 {
+		t.Fatalf("expected 3 updates, got %d", len(updates))
+	}
+
+	replay := NewDoc()
+	defer replay.Destroy()
+	for i, update := range updates {
+		if err := replay.ApplyStateVector(update); err != nil {
+			t.Fatalf("replaying update %d failed: %v", i, err)
+		}
+	}
+	expected, _ := doc.ToJSON()
+	got, _ := replay.ToJSON()
+	if !compareMaps(got, expected) {
+		t.Errorf("replayed document %v does not match %v", got, expected)
+	}
+}
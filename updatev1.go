@@ -0,0 +1,334 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+)
+
+// blockID identifies a block in a Yrs document: the client that created it and the clock
+// of its first element.
+type blockID struct {
+	client uint64
+	clock  uint32
+}
+
+// decodedBlock is the metadata of one block of a v1 update. Blocks whose parent was not
+// written explicitly (because they have an origin to copy it from) have an empty
+// parentName and a nil parentID; see resolveParent.
+type decodedBlock struct {
+	id          blockID
+	length      uint32
+	item        bool // false for GC and skip blocks
+	originLeft  *blockID
+	originRight *blockID
+	parentName  string
+	parentID    *blockID
+	parentSub   string
+	content     byte // the content type ref, e.g. 4 for strings
+	size        int  // bytes taken by the block in the update
+	orphan      bool // no parent could be resolved
+	resolving   bool
+}
+
+// decodedUpdate is the block metadata and delete set of a v1 update, enough to locate
+// the items behind a value but not to rebuild their content.
+type decodedUpdate struct {
+	blocks  map[uint64][]decodedBlock // per client, in clock order
+	deletes map[uint64][][2]uint32    // per client, [clock, length) ranges
+	// deleteSetSize is the number of bytes taken by the delete set in the update.
+	deleteSetSize int
+}
+
+// decodeUpdateV1 parses the block structure of a Yrs/Yjs v1 update.
+func decodeUpdateV1(update []byte) (*decodedUpdate, error) {
+	r := &updateReader{buf: update}
+	u := &decodedUpdate{
+		blocks:  make(map[uint64][]decodedBlock),
+		deletes: make(map[uint64][][2]uint32),
+	}
+
+	clients := r.uint()
+	for i := uint64(0); i < clients && r.err == nil; i++ {
+		count := r.uint()
+		client := r.uint()
+		clock := uint32(r.uint())
+		for j := uint64(0); j < count && r.err == nil; j++ {
+			start := r.pos
+			block := r.block(blockID{client: client, clock: clock})
+			block.size = r.pos - start
+			clock += block.length
+			u.blocks[client] = append(u.blocks[client], block)
+		}
+	}
+
+	deleteSetStart := r.pos
+	clients = r.uint()
+	for i := uint64(0); i < clients && r.err == nil; i++ {
+		client := r.uint()
+		ranges := r.uint()
+		for j := uint64(0); j < ranges && r.err == nil; j++ {
+			clock := uint32(r.uint())
+			length := uint32(r.uint())
+			u.deletes[client] = append(u.deletes[client], [2]uint32{clock, length})
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to decode update: %w", r.err)
+	}
+	u.deleteSetSize = r.pos - deleteSetStart
+	return u, nil
+}
+
+// deletedSize returns the number of bytes of the update spent on deleted content: for
+// every range of the delete set, the share of each block it covers, plus the delete set
+// itself.
+func (u *decodedUpdate) deletedSize() int {
+	if len(u.deletes) == 0 {
+		return 0
+	}
+	size := u.deleteSetSize
+	for client, ranges := range u.deletes {
+		blocks := u.blocks[client]
+		for _, rng := range ranges {
+			start, end := rng[0], rng[0]+rng[1]
+			i := sort.Search(len(blocks), func(i int) bool {
+				return blocks[i].id.clock+blocks[i].length > start
+			})
+			for ; i < len(blocks) && blocks[i].id.clock < end; i++ {
+				block := blocks[i]
+				if block.length == 0 {
+					continue
+				}
+				from, to := max(start, block.id.clock), min(end, block.id.clock+block.length)
+				size += block.size * int(to-from) / int(block.length)
+			}
+		}
+	}
+	return size
+}
+
+// find returns the block containing id, or nil if the update has none.
+func (u *decodedUpdate) find(id blockID) *decodedBlock {
+	blocks := u.blocks[id.client]
+	i := sort.Search(len(blocks), func(i int) bool {
+		return blocks[i].id.clock+blocks[i].length > id.clock
+	})
+	if i == len(blocks) || blocks[i].id.clock > id.clock {
+		return nil
+	}
+	return &blocks[i]
+}
+
+// deleted reports whether the element at id is in the delete set.
+func (u *decodedUpdate) deleted(id blockID) bool {
+	for _, rng := range u.deletes[id.client] {
+		if id.clock >= rng[0] && id.clock < rng[0]+rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveParent fills in the parent and parentSub of block from its origins, as Yrs does
+// when integrating it, and reports whether it has one. Blocks whose origins don't lead
+// to a parent, e.g. because it was garbage collected, have none. Results are stored in
+// the blocks along the way, so resolving every block of an update stays linear.
+func (u *decodedUpdate) resolveParent(block *decodedBlock) bool {
+	if block.parentName != "" || block.parentID != nil {
+		return true
+	}
+	if !block.item || block.orphan || block.resolving {
+		return false
+	}
+	origin := block.originLeft
+	if origin == nil {
+		origin = block.originRight
+	}
+	if origin == nil {
+		return false
+	}
+	from := u.find(*origin)
+	if from == nil {
+		return false
+	}
+	// Guards against origin cycles in a malformed update.
+	block.resolving = true
+	ok := u.resolveParent(from)
+	block.resolving = false
+	if !ok {
+		block.orphan = true
+		return false
+	}
+	block.parentName, block.parentID, block.parentSub = from.parentName, from.parentID, from.parentSub
+	return true
+}
+
+// updateReader reads the lib0 encoding used by v1 updates. The first error is kept in err
+// and makes every later read return zero values.
+type updateReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *updateReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *updateReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos >= len(r.buf) {
+		r.fail(errors.New("unexpected end of update"))
+		return 0
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *updateReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)-r.pos) {
+		r.fail(errors.New("unexpected end of update"))
+		return nil
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b
+}
+
+func (r *updateReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.fail(errors.New("invalid varint"))
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+// int skips a lib0 signed varint, whose first byte carries a sign bit.
+func (r *updateReader) int() {
+	if r.byte()&0x80 == 0 {
+		return
+	}
+	for r.err == nil && r.byte()&0x80 != 0 {
+	}
+}
+
+func (r *updateReader) string() string {
+	return string(r.bytes(r.uint()))
+}
+
+func (r *updateReader) id() *blockID {
+	client := r.uint()
+	return &blockID{client: client, clock: uint32(r.uint())}
+}
+
+// any skips a value in lib0's Any encoding.
+func (r *updateReader) any() {
+	switch tag := r.byte(); tag {
+	case 127, 126, 121, 120: // undefined, null, false, true
+	case 125:
+		r.int()
+	case 124:
+		r.bytes(4)
+	case 123, 122: // float64, bigint
+		r.bytes(8)
+	case 119:
+		r.string()
+	case 118:
+		for n := r.uint(); n > 0 && r.err == nil; n-- {
+			r.string()
+			r.any()
+		}
+	case 117:
+		for n := r.uint(); n > 0 && r.err == nil; n-- {
+			r.any()
+		}
+	case 116:
+		r.bytes(r.uint())
+	default:
+		r.fail(fmt.Errorf("unknown value tag %d", tag))
+	}
+}
+
+// block reads the block starting at id, skipping over its content.
+func (r *updateReader) block(id blockID) decodedBlock {
+	info := r.byte()
+	block := decodedBlock{id: id, length: 1, content: info & 0x1f}
+	switch block.content {
+	case 0, 10: // GC, skip
+		block.length = uint32(r.uint())
+		return block
+	}
+
+	block.item = true
+	if info&0x80 != 0 {
+		block.originLeft = r.id()
+	}
+	if info&0x40 != 0 {
+		block.originRight = r.id()
+	}
+	if info&0xc0 == 0 {
+		if r.uint() == 1 {
+			block.parentName = r.string()
+		} else {
+			block.parentID = r.id()
+		}
+		if info&0x20 != 0 {
+			block.parentSub = r.string()
+		}
+	}
+
+	switch ref := block.content; ref {
+	case 1: // deleted
+		block.length = uint32(r.uint())
+	case 2: // JSON
+		block.length = uint32(r.uint())
+		for i := uint32(0); i < block.length && r.err == nil; i++ {
+			r.string()
+		}
+	case 3: // binary
+		r.bytes(r.uint())
+	case 4: // string, whose length is counted in UTF-16 code units
+		block.length = uint32(len(utf16.Encode([]rune(r.string()))))
+	case 5: // embed
+		r.string()
+	case 6: // format
+		r.string()
+		r.string()
+	case 7: // type
+		switch r.uint() {
+		case 3, 5: // XML element, XML hook
+			r.string()
+		case 7:
+			r.fail(errors.New("weak link types are not supported"))
+		}
+	case 8: // any
+		block.length = uint32(r.uint())
+		for i := uint32(0); i < block.length && r.err == nil; i++ {
+			r.any()
+		}
+	case 9: // subdocument
+		r.string()
+		r.any()
+	default:
+		r.fail(fmt.Errorf("unsupported content type %d", ref))
+	}
+	return block
+}
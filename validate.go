@@ -0,0 +1,38 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"fmt"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+// ValidateOperations reports every operation of patchList that ApplyOperations would
+// reject, instead of only the first, without changing the document. The patch is
+// applied to a scratch copy of the document, skipping the operations that fail, so each
+// operation is checked against the state left by the valid ones before it; an error in
+// an early operation can therefore also make later ones fail. Each error is prefixed
+// with the operation's index in patchList. ValidateOperations returns nil if the whole
+// patch applies.
+func (d *Doc) ValidateOperations(patchList jsonpatch.JSONPatchList) []error {
+	var errs []error
+	err := d.read(func(txn *C.YTransaction, _ *C.Branch) error {
+		return d.dryRun(txn, func(txn *C.YTransaction, rootBranch *C.Branch) error {
+			for i, op := range patchList.List() {
+				if err := d.applyPatchOp(txn, rootBranch, op, ApplyOptions{}); err != nil {
+					errs = append(errs, fmt.Errorf("ValidateOperations: operation %d: %w", i, err))
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return []error{fmt.Errorf("ValidateOperations: %w", err)}
+	}
+	return errs
+}
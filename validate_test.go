@@ -0,0 +1,57 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/snorwin/jsonpatch"
+)
+
+func TestValidateOperations(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"name":  "a",
+		"items": []interface{}{"x"},
+		"meta":  map[string]interface{}{},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+	before := mustEncodeFull(t, doc)
+
+	patch, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/items/1", Value: "y"},
+		{Operation: "remove", Path: "/items/5"},
+		{Operation: "replace", Path: "/meta/missing/deep", Value: 1.0},
+		{Operation: "add", Path: "/name/child", Value: true},
+		{Operation: "replace", Path: "/items/1", Value: "z"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	errs := doc.ValidateOperations(patch)
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", errs)
+	}
+	for i, index := range []string{"operation 1:", "operation 2:", "operation 3:"} {
+		if !strings.Contains(errs[i].Error(), index) {
+			t.Errorf("expected error %d to name %q, got %v", i, index, errs[i])
+		}
+	}
+	if after := mustEncodeFull(t, doc); string(after) != string(before) {
+		t.Error("expected ValidateOperations to leave the document unchanged")
+	}
+
+	valid, err := NewPatchList([]jsonpatch.JSONPatch{
+		{Operation: "add", Path: "/items/1", Value: "y"},
+		{Operation: "replace", Path: "/items/1", Value: "z"},
+	})
+	if err != nil {
+		t.Fatalf("NewPatchList failed: %v", err)
+	}
+	if errs := doc.ValidateOperations(valid); errs != nil {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+}
@@ -0,0 +1,13 @@
+package autosync
+
+// yrsVersion is the version of the linked libyrs. libyrs does not expose its version
+// through the C API, so it is injected at build time (see the Makefile):
+//
+//	go build -ldflags "-X github.com/ProlificLabs/autosync.yrsVersion=0.21.0"
+var yrsVersion = "unknown"
+
+// LibVersion returns the version of the Yrs library this package was built against,
+// or "unknown" if it was not recorded at build time.
+func LibVersion() string {
+	return yrsVersion
+}
@@ -0,0 +1,117 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+)
+
+// errViewClosed is returned by the methods of a ReadView used after its View returned.
+var errViewClosed = errors.New("read view is closed: ReadView used outside of its View")
+
+// ReadView reads the document through the single read transaction of a View, so that
+// everything read through it reflects the same state of the document. Its methods work
+// like the Doc methods of the same names. A ReadView is only valid inside the function
+// passed to View and must not be shared with other goroutines.
+type ReadView struct {
+	doc        *Doc
+	txn        *C.YTransaction
+	rootBranch *C.Branch
+}
+
+// View calls fn with a ReadView backed by one read transaction, which is committed once
+// fn returns. Writes to the Doc wait until then, so several reads made through the view,
+// such as ToJSON and EncodeStateVector, agree with each other.
+//
+// The Doc is locked for reading while fn runs, so fn must not write to the Doc, which
+// would deadlock.
+func (d *Doc) View(fn func(v *ReadView) error) error {
+	v := &ReadView{doc: d}
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		v.txn, v.rootBranch = txn, rootBranch
+		defer func() { v.txn, v.rootBranch = nil, nil }()
+		return fn(v)
+	})
+	if err != nil {
+		return fmt.Errorf("View: %w", err)
+	}
+	return nil
+}
+
+// ToJSON returns the document, as Doc.ToJSON does.
+func (v *ReadView) ToJSON() (map[string]interface{}, error) {
+	if v.txn == nil {
+		return nil, fmt.Errorf("ToJSON: %w", errViewClosed)
+	}
+	if v.doc.arrayRoot {
+		return nil, errors.New("ToJSON: document root is an array")
+	}
+	value, err := branchValue(v.txn, v.rootBranch, ReadOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("ToJSON: %w", err)
+	}
+	result, _ := value.(map[string]interface{})
+	if result == nil {
+		return make(map[string]interface{}), nil
+	}
+	return result, nil
+}
+
+// GetValueAtPath reads the value at path, as Doc.GetValueAtPath does.
+func (v *ReadView) GetValueAtPath(path string) (interface{}, error) {
+	if v.txn == nil {
+		return nil, fmt.Errorf("GetValueAtPath: %w", errViewClosed)
+	}
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("GetValueAtPath: %w", err)
+	}
+	var value interface{}
+	if len(pathSegments) == 0 {
+		value, err = branchValue(v.txn, v.rootBranch, ReadOptions{})
+	} else {
+		err = readOutputTxn(v.txn, v.rootBranch, pathSegments, func(txn *C.YTransaction, output *C.YOutput) error {
+			var err error
+			value, err = outputValue(txn, output, ReadOptions{})
+			return err
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetValueAtPath %s: %w", path, err)
+	}
+	return value, nil
+}
+
+// Keys returns the sorted keys of the map at path, as Doc.Keys does.
+func (v *ReadView) Keys(path string) ([]string, error) {
+	if v.txn == nil {
+		return nil, fmt.Errorf("Keys: %w", errViewClosed)
+	}
+	pathSegments, err := splitPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("Keys: %w", err)
+	}
+	keys, err := branchKeys(v.txn, v.rootBranch, pathSegments)
+	if err != nil {
+		return nil, fmt.Errorf("Keys %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// EncodeStateVector returns the state vector of the document, as Doc.EncodeStateVector
+// does.
+func (v *ReadView) EncodeStateVector() ([]byte, error) {
+	if v.txn == nil {
+		return nil, fmt.Errorf("EncodeStateVector: %w", errViewClosed)
+	}
+	sv, err := stateVectorTxn(v.txn)
+	if err != nil {
+		return nil, fmt.Errorf("EncodeStateVector: %w", err)
+	}
+	return sv, nil
+}
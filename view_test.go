@@ -0,0 +1,98 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestView(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"user": map[string]interface{}{"name": "alice", "age": 30.0},
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	written := make(chan struct{})
+	var leaked *ReadView
+	err = doc.View(func(v *ReadView) error {
+		leaked = v
+		before, err := v.ToJSON()
+		if err != nil {
+			return err
+		}
+		// A write started during the view waits for it to end.
+		go func() {
+			if err := doc.Set("/user/name", "bob"); err != nil {
+				t.Errorf("Set failed: %v", err)
+			}
+			close(written)
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		after, err := v.ToJSON()
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(before, after) {
+			t.Errorf("expected the view to stay consistent, got %v then %v", before, after)
+		}
+		name, err := v.GetValueAtPath("/user/name")
+		if err != nil {
+			return err
+		}
+		if name != "alice" {
+			t.Errorf("expected alice, got %v", name)
+		}
+		keys, err := v.Keys("/user")
+		if err != nil {
+			return err
+		}
+		if expected := []string{"age", "name"}; !reflect.DeepEqual(keys, expected) {
+			t.Errorf("expected keys %v, got %v", expected, keys)
+		}
+		sv, err := v.EncodeStateVector()
+		if err != nil {
+			return err
+		}
+		if len(sv) == 0 {
+			t.Error("expected a state vector")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View failed: %v", err)
+	}
+	<-written
+
+	if _, err := leaked.ToJSON(); !errors.Is(err, errViewClosed) {
+		t.Errorf("expected errViewClosed after the view, got %v", err)
+	}
+
+	// The state vector read inside a view matches the document it read.
+	var sv []byte
+	if err := doc.View(func(v *ReadView) error {
+		sv, err = v.EncodeStateVector()
+		return err
+	}); err != nil {
+		t.Fatalf("View failed: %v", err)
+	}
+	expected, err := doc.EncodeStateVector()
+	if err != nil {
+		t.Fatalf("EncodeStateVector failed: %v", err)
+	}
+	if !bytes.Equal(sv, expected) {
+		t.Errorf("expected state vector %v, got %v", expected, sv)
+	}
+
+	sentinel := errors.New("stop")
+	if err := doc.View(func(*ReadView) error { return sentinel }); !errors.Is(err, sentinel) {
+		t.Errorf("expected the error of fn, got %v", err)
+	}
+}
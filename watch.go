@@ -0,0 +1,239 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+extern void goWatchCallback(void*, uint32_t, YEvent*);
+
+// Defined in observe.go.
+YPathSegment* eventPath(YEvent* event, uint32_t* len);
+YEventKeyChange* mapEventKeys(const YMapEvent* event, uint32_t* len);
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// watchDocs maps the *C.YDoc passed to goWatchCallback back to its Doc, like updateLogs
+// does for the update log.
+var watchDocs sync.Map
+
+// keyWatch is a callback registered with WatchKey.
+type keyWatch struct {
+	key string
+	fn  func(newValue interface{})
+}
+
+// keyWatchers holds the state for WatchKey and Observe, which share one subscription.
+// changed collects the top-level keys touched by the transaction being committed, and
+// paths the changed paths, along with origin, the origin of that transaction, until
+// takeChanges hands them over for notifyKeyWatchers and notifyObservers.
+type keyWatchers struct {
+	mu        sync.Mutex
+	sub       *C.YSubscription
+	watches   []*keyWatch
+	changed   map[string]bool
+	observers []*pathObserver
+	paths     map[string]bool
+	origin    []byte
+}
+
+// WatchKey registers fn to be called with the new value of the top-level key whenever a
+// committed write, local or applied with ApplyUpdate, adds, replaces or removes
+// it, or changes anything nested inside it. fn receives the value as ToJSON would
+// decode it, or nil if the key was removed. Writes to other keys don't call fn, and the
+// document is not serialized to find out what changed: Yrs reports the touched keys
+// while committing.
+//
+// fn runs synchronously on the goroutine that made the write, once the write has been
+// committed and the Doc's lock released, so it may use the Doc. The returned stop
+// unregisters fn; calling it more than once is harmless.
+func (d *Doc) WatchKey(key string, fn func(newValue interface{})) (stop func(), err error) {
+	if err := d.observeKeys(); err != nil {
+		return nil, fmt.Errorf("WatchKey %q: %w", key, err)
+	}
+	w := &keyWatch{key: key, fn: fn}
+	d.watchers.mu.Lock()
+	d.watchers.watches = append(d.watchers.watches, w)
+	d.watchers.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.watchers.mu.Lock()
+			defer d.watchers.mu.Unlock()
+			for i, other := range d.watchers.watches {
+				if other == w {
+					d.watchers.watches = append(d.watchers.watches[:i], d.watchers.watches[i+1:]...)
+					break
+				}
+			}
+		})
+	}, nil
+}
+
+// observeKeys subscribes to deep events on the root on first use. The subscription stays
+// in place until Destroy.
+func (d *Doc) observeKeys() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.watchers.sub != nil {
+		return nil
+	}
+	rootKey := C.CString(d.root())
+	defer C.free(unsafe.Pointer(rootKey))
+
+	txn := C.ydoc_read_transaction(d.yDoc)
+	if txn == nil {
+		return fmt.Errorf("failed to create read transaction: %w", ErrTransactionInProgress)
+	}
+	rootBranch := C.ytype_get(txn, rootKey)
+	commitTransaction(txn)
+	if rootBranch == nil {
+		return errors.New("root map not found")
+	}
+
+	sub := C.yobserve_deep(rootBranch, unsafe.Pointer(d.yDoc), (*[0]byte)(C.goWatchCallback))
+	if sub == nil {
+		return errors.New("yobserve_deep returned nil")
+	}
+	d.watchers.sub = sub
+	watchDocs.Store(unsafe.Pointer(d.yDoc), d)
+	return nil
+}
+
+// stopWatching removes the subscription made by observeKeys. The caller must hold d.mu.
+func (d *Doc) stopWatching() {
+	if d.watchers.sub == nil {
+		return
+	}
+	C.yunobserve(d.watchers.sub)
+	watchDocs.Delete(unsafe.Pointer(d.yDoc))
+	d.watchers.sub = nil
+}
+
+// txnChanges is what one committed transaction changed, as collected by goWatchCallback.
+type txnChanges struct {
+	keys   map[string]bool
+	paths  map[string]bool
+	origin []byte
+}
+
+// takeChanges returns and resets the changes collected for the transaction just
+// committed. The caller must still hold d.mu for writing, so that the changes of
+// concurrent writes, which are reported after the lock is released, don't mix.
+func (d *Doc) takeChanges() txnChanges {
+	d.watchers.mu.Lock()
+	defer d.watchers.mu.Unlock()
+	changes := txnChanges{keys: d.watchers.changed, paths: d.watchers.paths, origin: d.watchers.origin}
+	d.watchers.changed = nil
+	d.watchers.paths = nil
+	d.watchers.origin = nil
+	return changes
+}
+
+// notifyKeyWatchers calls the watchers of every key in changes. It must be called
+// without holding d.mu.
+func (d *Doc) notifyKeyWatchers(changes txnChanges) {
+	changed := changes.keys
+	d.watchers.mu.Lock()
+	var due []*keyWatch
+	for _, w := range d.watchers.watches {
+		if changed[w.key] {
+			due = append(due, w)
+		}
+	}
+	d.watchers.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	values := make(map[string]interface{}, len(changed))
+	err := d.read(func(txn *C.YTransaction, rootBranch *C.Branch) error {
+		for key := range changed {
+			keyC := C.CString(key)
+			output := C.ymap_get(rootBranch, txn, keyC)
+			C.free(unsafe.Pointer(keyC))
+			if output == nil {
+				continue // removed
+			}
+			value, err := outputValue(txn, output, ReadOptions{})
+			C.youtput_destroy(output)
+			if err != nil {
+				return fmt.Errorf("failed to read value of %q: %w", key, err)
+			}
+			values[key] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	for _, w := range due {
+		w.fn(values[w.key])
+	}
+}
+
+// goWatchCallback is called by Yrs with the events of a transaction as it commits, which
+// only happens while the committing goroutine holds d.mu for writing. Events on nested
+// types carry a path from the root, whose first segment is the top-level key; events on
+// the root itself list the keys they changed.
+//
+//export goWatchCallback
+func goWatchCallback(state unsafe.Pointer, count C.uint32_t, events *C.YEvent) {
+	value, ok := watchDocs.Load(state)
+	if !ok {
+		return
+	}
+	d := value.(*Doc)
+
+	d.watchers.mu.Lock()
+	defer d.watchers.mu.Unlock()
+	mark := func(key *C.char) {
+		if d.watchers.changed == nil {
+			d.watchers.changed = make(map[string]bool)
+		}
+		d.watchers.changed[C.GoString(key)] = true
+	}
+
+	// Unions are opaque byte arrays in Go, so their fields are read through pointers into
+	// the C memory, which unlike Go copies is suitably aligned.
+	eventSlice := unsafe.Slice(events, count)
+	for i := range eventSlice {
+		event := &eventSlice[i]
+		content := unsafe.Pointer(&event.content)
+		if event.tag != C.Y_MAP && event.tag != C.Y_ARRAY && event.tag != C.Y_TEXT {
+			continue
+		}
+		var pathLen C.uint32_t
+		path := C.eventPath(event, &pathLen)
+
+		d.watchers.collectPaths(event.tag, content, unsafe.Slice(path, pathLen))
+		if pathLen > 0 {
+			first := &unsafe.Slice(path, pathLen)[0]
+			if first.tag == C.Y_EVENT_PATH_KEY {
+				mark(*(**C.char)(unsafe.Pointer(&first.value)))
+			}
+		} else if event.tag == C.Y_MAP {
+			var keysLen C.uint32_t
+			keys := C.mapEventKeys((*C.YMapEvent)(content), &keysLen)
+			for _, change := range unsafe.Slice(keys, keysLen) {
+				mark(change.key)
+			}
+			if keys != nil {
+				C.yevent_keys_destroy(keys, keysLen)
+			}
+		}
+		if path != nil {
+			C.ypath_destroy(path, pathLen)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWatchKey(t *testing.T) {
+	doc, err := NewDocFromJSON(map[string]interface{}{
+		"status": "draft",
+		"meta":   map[string]interface{}{"n": 1.0},
+		"other":  "x",
+	})
+	if err != nil {
+		t.Fatalf("NewDocFromJSON failed: %v", err)
+	}
+	defer doc.Destroy()
+
+	var statuses, metas []interface{}
+	stop, err := doc.WatchKey("status", func(v interface{}) { statuses = append(statuses, v) })
+	if err != nil {
+		t.Fatalf("WatchKey failed: %v", err)
+	}
+	if _, err := doc.WatchKey("meta", func(v interface{}) { metas = append(metas, v) }); err != nil {
+		t.Fatalf("WatchKey failed: %v", err)
+	}
+
+	if err := doc.Set("/other", "y"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.Set("/status", "published"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := doc.Set("/meta/n", 2.0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	peer, err := NewDocFromStateVector(mustEncodeFull(t, doc))
+	if err != nil {
+		t.Fatalf("NewDocFromStateVector failed: %v", err)
+	}
+	defer peer.Destroy()
+	if _, err := peer.UpdateToStateJSON([]byte(`{"meta": {"n": 2}, "other": "y"}`)); err != nil {
+		t.Fatalf("UpdateToStateJSON failed: %v", err)
+	}
+	if err := doc.ApplyStateVector(mustEncodeFull(t, peer)); err != nil {
+		t.Fatalf("ApplyStateVector failed: %v", err)
+	}
+
+	if expected := []interface{}{"published", nil}; !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected status values %v, got %v", expected, statuses)
+	}
+	if expected := []interface{}{map[string]interface{}{"n": 2.0}}; !reflect.DeepEqual(metas, expected) {
+		t.Errorf("expected meta values %v, got %v", expected, metas)
+	}
+
+	// Values are read as ToJSON reads them, so integers arrive as int64, not float64.
+	var count interface{}
+	if _, err := doc.WatchKey("count", func(v interface{}) { count = v }); err != nil {
+		t.Fatalf("WatchKey failed: %v", err)
+	}
+	if err := doc.Set("/count", 9007199254740993); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if n, ok := count.(int64); !ok || n != 9007199254740993 {
+		t.Errorf("expected count int64(9007199254740993), got %T(%v)", count, count)
+	}
+
+	stop()
+	if err := doc.Set("/status", "again"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Errorf("expected no calls after stop, got %v", statuses)
+	}
+}
@@ -0,0 +1,365 @@
+//go:build cgo
+
+package autosync
+
+/*
+#include <libyrs.h>
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// XMLFragment is a root XML fragment defined with DefineXMLFragment, the document model
+// of rich-text editors built on Yjs such as ProseMirror and Tiptap. It holds a sequence
+// of XML elements and text nodes.
+type XMLFragment struct {
+	doc  *Doc
+	name string
+}
+
+// XMLElement is an element of an XMLFragment, as returned by InsertElement. It addresses
+// the element by its position: the index of each of its ancestors among their siblings,
+// from the fragment down. Inserting or removing an earlier sibling of the element or of
+// an ancestor, locally or by a peer, makes it address another node, so look elements up
+// again after such changes rather than keeping them.
+type XMLElement struct {
+	doc      *Doc
+	fragment string
+	indices  []uint32
+}
+
+// DefineXMLFragment defines a root XML fragment called name and returns it. Like the
+// collections defined with DefineMap and DefineArray, the fragment lives alongside the
+// document's main root, is synced with it, and must be defined by every peer before it
+// is read. Defining a fragment again returns it anew.
+func (d *Doc) DefineXMLFragment(name string) (*XMLFragment, error) {
+	if name == "" || strings.IndexByte(name, 0) >= 0 {
+		return nil, errors.New("DefineXMLFragment: name must be non-empty and free of NUL bytes")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if name == d.root() {
+		return nil, fmt.Errorf("DefineXMLFragment %q: name is taken by the document's main root", name)
+	}
+	if kind, ok := d.collections[name]; ok {
+		return nil, fmt.Errorf("DefineXMLFragment %q: already defined as %s", name, kind)
+	}
+	if _, ok := d.xmlFragments[name]; !ok {
+		nameC := C.CString(name)
+		defer C.free(unsafe.Pointer(nameC))
+		C.yxmlfragment(d.yDoc, nameC)
+		if d.xmlFragments == nil {
+			d.xmlFragments = make(map[string]struct{})
+		}
+		d.xmlFragments[name] = struct{}{}
+	}
+	return &XMLFragment{doc: d, name: name}, nil
+}
+
+// InsertElement inserts an empty element with the given tag as the child at index,
+// between 0 and Len, and returns it.
+func (f *XMLFragment) InsertElement(index int, tag string) (*XMLElement, error) {
+	e, err := insertXMLElement(f.doc, f.name, nil, index, tag)
+	if err != nil {
+		return nil, fmt.Errorf("InsertElement: %w", err)
+	}
+	return e, nil
+}
+
+// InsertText inserts a text node holding text as the child at index, between 0 and Len.
+func (f *XMLFragment) InsertText(index int, text string) error {
+	if err := insertXMLText(f.doc, f.name, nil, index, text); err != nil {
+		return fmt.Errorf("InsertText: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of children of the fragment.
+func (f *XMLFragment) Len() (int, error) {
+	n, err := xmlChildLen(f.doc, f.name, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Len: %w", err)
+	}
+	return n, nil
+}
+
+// ToXMLString renders the children of the fragment as XML, without padding, e.g.
+// `<p class="lead">sample text</p>`.
+func (f *XMLFragment) ToXMLString() (string, error) {
+	var result string
+	err := f.doc.readTxn(func(txn *C.YTransaction) error {
+		branch, outputs, err := resolveXMLNode(txn, f.name, nil)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+
+		var b strings.Builder
+		for i := C.uint32_t(0); i < C.yxmlelem_child_len(branch, txn); i++ {
+			child := (*C.YOutput)(unsafe.Pointer(C.yxmlelem_get(branch, txn, i)))
+			if child == nil {
+				return fmt.Errorf("failed to get child %d", i)
+			}
+			var s *C.char
+			switch child.tag {
+			case C.Y_XML_ELEM:
+				s = C.yxmlelem_string(C.youtput_read_yxmlelem(child), txn)
+			case C.Y_XML_TEXT:
+				s = C.yxmltext_string(C.youtput_read_yxmltext(child), txn)
+			}
+			C.youtput_destroy(child)
+			if s == nil {
+				return fmt.Errorf("failed to render child %d", i)
+			}
+			b.WriteString(C.GoString(s))
+			C.ystring_destroy(s)
+		}
+		result = b.String()
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("ToXMLString: %w", err)
+	}
+	return result, nil
+}
+
+// InsertElement inserts an empty element with the given tag as the child of e at index,
+// between 0 and Len, and returns it.
+func (e *XMLElement) InsertElement(index int, tag string) (*XMLElement, error) {
+	child, err := insertXMLElement(e.doc, e.fragment, e.indices, index, tag)
+	if err != nil {
+		return nil, fmt.Errorf("InsertElement: %w", err)
+	}
+	return child, nil
+}
+
+// InsertText inserts a text node holding text as the child of e at index, between 0 and
+// Len.
+func (e *XMLElement) InsertText(index int, text string) error {
+	if err := insertXMLText(e.doc, e.fragment, e.indices, index, text); err != nil {
+		return fmt.Errorf("InsertText: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of children of e.
+func (e *XMLElement) Len() (int, error) {
+	n, err := xmlChildLen(e.doc, e.fragment, e.indices)
+	if err != nil {
+		return 0, fmt.Errorf("Len: %w", err)
+	}
+	return n, nil
+}
+
+// Tag returns the tag name of e.
+func (e *XMLElement) Tag() (string, error) {
+	var tag string
+	err := e.doc.readTxn(func(txn *C.YTransaction) error {
+		branch, outputs, err := resolveXMLNode(txn, e.fragment, e.indices)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+
+		tagC := C.yxmlelem_tag(branch)
+		if tagC == nil {
+			return errors.New("yxmlelem_tag returned nil")
+		}
+		defer C.ystring_destroy(tagC)
+		tag = C.GoString(tagC)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Tag: %w", err)
+	}
+	return tag, nil
+}
+
+// SetAttribute sets the attribute name of e to value, replacing any previous value.
+func (e *XMLElement) SetAttribute(name, value string) error {
+	if strings.IndexByte(name, 0) >= 0 || strings.IndexByte(value, 0) >= 0 {
+		return errors.New("SetAttribute: attribute name and value must be free of NUL bytes")
+	}
+	err := e.doc.write(func(txn *C.YTransaction, _ *C.Branch) error {
+		branch, outputs, err := resolveXMLNode(txn, e.fragment, e.indices)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+
+		nameC := C.CString(name)
+		defer C.free(unsafe.Pointer(nameC))
+		valueC := C.CString(value)
+		defer C.free(unsafe.Pointer(valueC))
+		tracef("yxmlelem_insert_attr(%p, %q)", branch, name)
+		C.yxmlelem_insert_attr(branch, txn, nameC, valueC)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("SetAttribute %s: %w", name, err)
+	}
+	return nil
+}
+
+// Attribute returns the value of the attribute name of e, and whether it is set.
+func (e *XMLElement) Attribute(name string) (string, bool, error) {
+	var value string
+	found := false
+	err := e.doc.readTxn(func(txn *C.YTransaction) error {
+		branch, outputs, err := resolveXMLNode(txn, e.fragment, e.indices)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+
+		nameC := C.CString(name)
+		defer C.free(unsafe.Pointer(nameC))
+		valueC := C.yxmlelem_get_attr(branch, txn, nameC)
+		if valueC == nil {
+			return nil
+		}
+		defer C.ystring_destroy(valueC)
+		value, found = C.GoString(valueC), true
+		return nil
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("Attribute %s: %w", name, err)
+	}
+	return value, found, nil
+}
+
+// ToXMLString renders e, with its attributes and children, as XML without padding, e.g.
+// `<p class="lead">sample text</p>`.
+func (e *XMLElement) ToXMLString() (string, error) {
+	var result string
+	err := e.doc.readTxn(func(txn *C.YTransaction) error {
+		branch, outputs, err := resolveXMLNode(txn, e.fragment, e.indices)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+
+		s := C.yxmlelem_string(branch, txn)
+		if s == nil {
+			return errors.New("yxmlelem_string returned nil")
+		}
+		defer C.ystring_destroy(s)
+		result = C.GoString(s)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("ToXMLString: %w", err)
+	}
+	return result, nil
+}
+
+// resolveXMLNode returns the branch of the fragment called fragment, or of the element
+// below it at indices. The outputs holding the branch must be destroyed once it is no
+// longer used.
+func resolveXMLNode(txn *C.YTransaction, fragment string, indices []uint32) (*C.Branch, []*C.YOutput, error) {
+	branch := collectionBranch(txn, fragment)
+	if branch == nil || C.ytype_kind(branch) != C.Y_XML_FRAG {
+		return nil, nil, fmt.Errorf("XML fragment %q not found", fragment)
+	}
+	var outputs []*C.YOutput
+	for depth, index := range indices {
+		output := (*C.YOutput)(unsafe.Pointer(C.yxmlelem_get(branch, txn, C.uint32_t(index))))
+		if output == nil {
+			destroyOutputs(outputs)
+			return nil, nil, fmt.Errorf("element %v no longer exists", indices[:depth+1])
+		}
+		outputs = append(outputs, output)
+		if output.tag != C.Y_XML_ELEM {
+			destroyOutputs(outputs)
+			return nil, nil, fmt.Errorf("node %v is no longer an element", indices[:depth+1])
+		}
+		branch = C.youtput_read_yxmlelem(output)
+	}
+	return branch, outputs, nil
+}
+
+// insertXMLElement implements InsertElement for the node at indices of fragment.
+func insertXMLElement(d *Doc, fragment string, indices []uint32, index int, tag string) (*XMLElement, error) {
+	if tag == "" || strings.IndexByte(tag, 0) >= 0 {
+		return nil, errors.New("tag must be non-empty and free of NUL bytes")
+	}
+	err := d.write(func(txn *C.YTransaction, _ *C.Branch) error {
+		branch, outputs, err := resolveXMLNode(txn, fragment, indices)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+		if err := checkXMLIndex(txn, branch, index); err != nil {
+			return err
+		}
+
+		tagC := C.CString(tag)
+		defer C.free(unsafe.Pointer(tagC))
+		tracef("yxmlelem_insert_elem(%p, %d, %q)", branch, index, tag)
+		C.yxmlelem_insert_elem(branch, txn, C.uint32_t(index), tagC)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	childIndices := append(append([]uint32(nil), indices...), uint32(index))
+	return &XMLElement{doc: d, fragment: fragment, indices: childIndices}, nil
+}
+
+// insertXMLText implements InsertText for the node at indices of fragment.
+func insertXMLText(d *Doc, fragment string, indices []uint32, index int, text string) error {
+	if strings.IndexByte(text, 0) >= 0 {
+		return errors.New("text contains an embedded NUL byte, which cannot be stored")
+	}
+	return d.write(func(txn *C.YTransaction, _ *C.Branch) error {
+		branch, outputs, err := resolveXMLNode(txn, fragment, indices)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+		if err := checkXMLIndex(txn, branch, index); err != nil {
+			return err
+		}
+
+		tracef("yxmlelem_insert_text(%p, %d)", branch, index)
+		textBranch := C.yxmlelem_insert_text(branch, txn, C.uint32_t(index))
+		if textBranch == nil {
+			return errors.New("yxmlelem_insert_text returned nil")
+		}
+		textC := C.CString(text)
+		defer C.free(unsafe.Pointer(textC))
+		C.yxmltext_insert(textBranch, txn, 0, textC, nil)
+		return nil
+	})
+}
+
+// xmlChildLen implements Len for the node at indices of fragment.
+func xmlChildLen(d *Doc, fragment string, indices []uint32) (int, error) {
+	n := 0
+	err := d.readTxn(func(txn *C.YTransaction) error {
+		branch, outputs, err := resolveXMLNode(txn, fragment, indices)
+		if err != nil {
+			return err
+		}
+		defer destroyOutputs(outputs)
+		n = int(C.yxmlelem_child_len(branch, txn))
+		return nil
+	})
+	return n, err
+}
+
+// checkXMLIndex checks that index is a valid insert position among the children of
+// branch, as Yrs panics on any other.
+func checkXMLIndex(txn *C.YTransaction, branch *C.Branch, index int) error {
+	length := C.yxmlelem_child_len(branch, txn)
+	if index < 0 || index > int(length) {
+		return fmt.Errorf("child index %d out of bounds (len %d)", index, length)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+//go:build cgo
+
+package autosync
+
+import (
+	"testing"
+)
+
+func TestXMLFragment(t *testing.T) {
+	doc := NewDoc()
+	defer doc.Destroy()
+
+	fragment, err := doc.DefineXMLFragment("prosemirror")
+	if err != nil {
+		t.Fatalf("DefineXMLFragment failed: %v", err)
+	}
+	p, err := fragment.InsertElement(0, "p")
+	if err != nil {
+		t.Fatalf("InsertElement failed: %v", err)
+	}
+	if err := p.SetAttribute("class", "lead"); err != nil {
+		t.Fatalf("SetAttribute failed: %v", err)
+	}
+	if err := p.InsertText(0, "hello "); err != nil {
+		t.Fatalf("InsertText failed: %v", err)
+	}
+	strong, err := p.InsertElement(1, "strong")
+	if err != nil {
+		t.Fatalf("InsertElement failed: %v", err)
+	}
+	if err := strong.InsertText(0, "world"); err != nil {
+		t.Fatalf("InsertText failed: %v", err)
+	}
+	if err := fragment.InsertText(1, "tail"); err != nil {
+		t.Fatalf("InsertText failed: %v", err)
+	}
+
+	expected := `<p class="lead">hello <strong>world</strong></p>tail`
+	got, err := fragment.ToXMLString()
+	if err != nil {
+		t.Fatalf("ToXMLString failed: %v", err)
+	}
+	if got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if got, err := strong.ToXMLString(); err != nil || got != "<strong>world</strong>" {
+		t.Errorf("expected <strong>world</strong>, got %q (%v)", got, err)
+	}
+	if tag, err := strong.Tag(); err != nil || tag != "strong" {
+		t.Errorf("expected tag strong, got %q (%v)", tag, err)
+	}
+	if value, ok, err := p.Attribute("class"); err != nil || !ok || value != "lead" {
+		t.Errorf("expected class lead, got %q %v (%v)", value, ok, err)
+	}
+	if n, err := p.Len(); err != nil || n != 2 {
+		t.Errorf("expected 2 children, got %d (%v)", n, err)
+	}
+	if _, err := p.InsertElement(3, "em"); err == nil {
+		t.Error("expected an error for an index past the end")
+	}
+
+	// The fragment syncs to a peer that defines it too, and leaves the main root alone.
+	peer := NewDoc()
+	defer peer.Destroy()
+	peerFragment, err := peer.DefineXMLFragment("prosemirror")
+	if err != nil {
+		t.Fatalf("DefineXMLFragment failed: %v", err)
+	}
+	if err := peer.ApplyUpdate(mustEncodeFull(t, doc)); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	if got, err := peerFragment.ToXMLString(); err != nil || got != expected {
+		t.Errorf("expected peer to render %s, got %s (%v)", expected, got, err)
+	}
+	state, err := peer.ToJSON()
+	if err != nil || len(state) != 0 {
+		t.Errorf("expected an empty main root, got %v (%v)", state, err)
+	}
+
+	if err := doc.DefineMap("prosemirror"); err == nil {
+		t.Error("expected DefineMap to reject the name of an XML fragment")
+	}
+}